@@ -3,9 +3,11 @@
 //
 // Usage:
 //
-//	granite2gguf -input /path/to/model/dir -output model.gguf
+//	granite2gguf -input /path/to/model/dir -output model.gguf [-outtype f16]
 //
 // The input directory must contain config.json and model.safetensors.
+// Tensors are written as F32 unless -outtype selects F16 or BF16, in which
+// case normalization tensors stay F32 unless -cast-norms is given.
 package main
 
 import (
@@ -20,12 +22,15 @@ import (
 	"strings"
 
 	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 	"github.com/zerfoo/zonnx/safetensors"
 )
 
 func main() {
 	inputDir := flag.String("input", "", "Path to model directory containing config.json and model.safetensors")
 	outputPath := flag.String("output", "", "Path for the output GGUF file (default: <input>/model.gguf)")
+	outType := flag.String("outtype", "f32", "Output type for float tensors: f32, f16 or bf16")
+	castNorms := flag.Bool("cast-norms", false, "Also cast normalization tensors to -outtype instead of keeping them f32")
 	flag.Parse()
 
 	if *inputDir == "" {
@@ -38,7 +43,20 @@ func main() {
 		*outputPath = filepath.Join(*inputDir, "model.gguf")
 	}
 
-	if err := convert(*inputDir, *outputPath); err != nil {
+	opts := convertOptions{castNorms: *castNorms}
+	switch strings.ToLower(*outType) {
+	case "f32":
+		opts.outType = sharedgguf.TypeF32
+	case "f16":
+		opts.outType = sharedgguf.TypeF16
+	case "bf16":
+		opts.outType = sharedgguf.TypeBF16
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unsupported -outtype %q: want f32, f16 or bf16\n", *outType)
+		os.Exit(1)
+	}
+
+	if err := convert(*inputDir, *outputPath, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -179,7 +197,18 @@ func mapGraniteTensorName(name string) string {
 	return name
 }
 
-func convert(inputDir, outputPath string) error {
+// convertOptions selects the output type of the converted tensors.
+type convertOptions struct {
+	outType   int  // TypeF32, TypeF16 or TypeBF16
+	castNorms bool // cast normalization tensors too instead of keeping F32
+}
+
+func convert(inputDir, outputPath string, opts convertOptions) error {
+	fileType, ok := quantize.FloatFileType(opts.outType)
+	if !ok {
+		return fmt.Errorf("unsupported output type %d: want F32, F16 or BF16", opts.outType)
+	}
+
 	gc, err := parseConfig(inputDir)
 	if err != nil {
 		return err
@@ -209,15 +238,31 @@ func convert(inputDir, outputPath string) error {
 
 	// Write metadata.
 	writeMetadata(w, gc)
+	w.AddMetadataUint32("general.file_type", fileType)
 
 	// Sort tensor names for deterministic output.
 	names := sf.TensorNames()
 	sort.Strings(names)
 
-	// Read and write each tensor as F32.
+	// FP8 checkpoints give the block size of their 2-D scale grids.
+	var fp8Block []int
+	if qc, ok := gc.raw["quantization_config"].(map[string]interface{}); ok {
+		if bs, ok := qc["weight_block_size"].([]interface{}); ok {
+			for _, v := range bs {
+				n, _ := v.(float64)
+				fp8Block = append(fp8Block, int(n))
+			}
+		}
+	}
+
+	// Read and write each tensor as opts.outType. FP8 tensors are multiplied
+	// by their scale tensors; the scales and FP8 input scales are not written.
 	for _, name := range names {
+		if sf.IsFP8Companion(name) {
+			continue
+		}
 		info, _ := sf.TensorInfo(name)
-		floats, err := sf.ReadFloat32(name)
+		floats, err := sf.ReadScaledFloat32(name, fp8Block)
 		if err != nil {
 			return fmt.Errorf("read tensor %q: %w", name, err)
 		}
@@ -228,7 +273,12 @@ func convert(inputDir, outputPath string) error {
 		}
 
 		ggufName := mapGraniteTensorName(name)
-		w.AddTensorF32(ggufName, shape, floats)
+		typ := quantize.CastType(ggufName, opts.outType, opts.castNorms)
+		data, err := quantize.EncodeFloats(typ, floats)
+		if err != nil {
+			return fmt.Errorf("encode tensor %q: %w", name, err)
+		}
+		w.AddTensor(ggufName, typ, shape, data)
 	}
 
 	if err := w.Write(outFile); err != nil {
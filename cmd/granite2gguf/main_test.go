@@ -7,6 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 )
 
 // writeSafetensors creates a minimal SafeTensors file with the given tensors.
@@ -145,7 +150,7 @@ func TestConvertTTM(t *testing.T) {
 
 	// Convert.
 	outputPath := filepath.Join(dir, "model.gguf")
-	if err := convert(dir, outputPath); err != nil {
+	if err := convert(dir, outputPath, convertOptions{}); err != nil {
 		t.Fatalf("convert: %v", err)
 	}
 
@@ -284,7 +289,7 @@ func TestConvertFlowState(t *testing.T) {
 	writeSafetensors(t, filepath.Join(dir, "model.safetensors"), tensors, shapes)
 
 	outputPath := filepath.Join(dir, "model.gguf")
-	if err := convert(dir, outputPath); err != nil {
+	if err := convert(dir, outputPath, convertOptions{}); err != nil {
 		t.Fatalf("convert: %v", err)
 	}
 
@@ -353,7 +358,7 @@ func TestConvertTSPulse(t *testing.T) {
 	writeSafetensors(t, filepath.Join(dir, "model.safetensors"), tensors, shapes)
 
 	outputPath := filepath.Join(dir, "model.gguf")
-	if err := convert(dir, outputPath); err != nil {
+	if err := convert(dir, outputPath, convertOptions{}); err != nil {
 		t.Fatalf("convert: %v", err)
 	}
 
@@ -411,3 +416,118 @@ func TestMapGraniteTensorName(t *testing.T) {
 		}
 	}
 }
+
+func TestConvertOutType(t *testing.T) {
+	dir := t.TempDir()
+	configData, _ := json.Marshal(map[string]interface{}{"model_type": "ttm"})
+	os.WriteFile(filepath.Join(dir, "config.json"), configData, 0o644)
+
+	tensors := map[string][]float32{
+		"backbone.mixer_layers.0.mlp.fc1.weight": {1.0, -2.5, 1e-6, 65504},
+		"backbone.mixer_layers.0.norm.weight":    {1.0, 0.1},
+	}
+	shapes := map[string][]int{
+		"backbone.mixer_layers.0.mlp.fc1.weight": {2, 2},
+		"backbone.mixer_layers.0.norm.weight":    {2},
+	}
+	writeSafetensors(t, filepath.Join(dir, "model.safetensors"), tensors, shapes)
+
+	tests := []struct {
+		name     string
+		opts     convertOptions
+		wantFC1  int
+		wantNorm int
+		wantFT   uint32
+	}{
+		{"f32", convertOptions{}, sharedgguf.TypeF32, sharedgguf.TypeF32, 0},
+		{"f16", convertOptions{outType: sharedgguf.TypeF16}, sharedgguf.TypeF16, sharedgguf.TypeF32, 1},
+		{"bf16 with norms", convertOptions{outType: sharedgguf.TypeBF16, castNorms: true}, sharedgguf.TypeBF16, sharedgguf.TypeBF16, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath := filepath.Join(dir, tt.name+".gguf")
+			if err := convert(dir, outputPath, tt.opts); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			gf, err := gguf.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			want := map[string]int{"blk.0.mlp.fc1.weight": tt.wantFC1, "blk.0.norm.weight": tt.wantNorm}
+			for i := range gf.Tensors {
+				got := &gf.Tensors[i]
+				if got.Type != want[got.Name] {
+					t.Errorf("%s type = %d, want %d", got.Name, got.Type, want[got.Name])
+				}
+				if got.Name == "blk.0.mlp.fc1.weight" {
+					wantData, _ := quantize.EncodeFloats(tt.wantFC1, tensors["backbone.mixer_layers.0.mlp.fc1.weight"])
+					if string(got.Data) != string(wantData) {
+						t.Errorf("%s data = %x, want %x", got.Name, got.Data, wantData)
+					}
+				}
+			}
+			if e, ok := gf.Lookup("general.file_type"); !ok || e.Value != tt.wantFT {
+				t.Errorf("general.file_type = %v, want %d", e.Value, tt.wantFT)
+			}
+		})
+	}
+
+	if err := convert(dir, filepath.Join(dir, "q8.gguf"), convertOptions{outType: sharedgguf.TypeQ8_0}); err == nil {
+		t.Error("expected error for a block-quantized output type")
+	}
+}
+
+func TestConvertFP8(t *testing.T) {
+	dir := t.TempDir()
+	configData, _ := json.Marshal(map[string]interface{}{"model_type": "ttm"})
+	os.WriteFile(filepath.Join(dir, "config.json"), configData, 0o644)
+
+	// An F8_E4M3 weight (0x38 = 1, 0x40 = 2, 0xC8 = -4) with a per-tensor
+	// scale and the static activation scale of its module.
+	scale := binary.LittleEndian.AppendUint32(nil, math.Float32bits(0.5))
+	tensors := []struct {
+		name, dtype string
+		shape       []int
+		data        []byte
+	}{
+		{"backbone.mixer_layers.0.mlp.fc1.input_scale", "F32", []int{}, scale},
+		{"backbone.mixer_layers.0.mlp.fc1.weight", "F8_E4M3", []int{2, 2}, []byte{0x38, 0x40, 0xC8, 0x38}},
+		{"backbone.mixer_layers.0.mlp.fc1.weight_scale", "F32", []int{}, scale},
+	}
+	header := make(map[string]interface{})
+	var data []byte
+	for _, st := range tensors {
+		header[st.name] = map[string]interface{}{
+			"dtype":        st.dtype,
+			"shape":        st.shape,
+			"data_offsets": []int{len(data), len(data) + len(st.data)},
+		}
+		data = append(data, st.data...)
+	}
+	headerBytes, _ := json.Marshal(header)
+	file := binary.LittleEndian.AppendUint64(nil, uint64(len(headerBytes)))
+	file = append(append(file, headerBytes...), data...)
+	if err := os.WriteFile(filepath.Join(dir, "model.safetensors"), file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(dir, "model.gguf")
+	if err := convert(dir, outputPath, convertOptions{}); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gf, err := gguf.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if len(gf.Tensors) != 1 || gf.Tensors[0].Name != "blk.0.mlp.fc1.weight" {
+		var names []string
+		for _, got := range gf.Tensors {
+			names = append(names, got.Name)
+		}
+		t.Fatalf("tensors = %v, want [blk.0.mlp.fc1.weight]", names)
+	}
+	wantData, _ := quantize.EncodeFloats(sharedgguf.TypeF32, []float32{0.5, 1, -2, 0.5})
+	if got := gf.Tensors[0].Data; string(got) != string(wantData) {
+		t.Errorf("data = %x, want %x", got, wantData)
+	}
+}
@@ -0,0 +1,305 @@
+package main_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"maps"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/zerfoo/zonnx/internal/onnx"
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"google.golang.org/protobuf/proto"
+)
+
+// buildZonnx builds the zonnx executable into a temporary directory and
+// returns its path.
+func buildZonnx(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "zonnx")
+	cmd := exec.Command("go", "build", "-o", path, "./cmd/zonnx")
+	cmd.Dir = filepath.Join("..", "..")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to build zonnx executable: %v", err)
+	}
+	return path
+}
+
+// onnxInitializer is a float32 ONNX initializer.
+type onnxInitializer struct {
+	name string
+	dims []int64
+	vals []float32
+}
+
+// writeONNXModel writes a model holding only the given initializers, and
+// config.json next to it if config is not empty, and returns the model path.
+func writeONNXModel(t *testing.T, inits []onnxInitializer, config string) string {
+	t.Helper()
+	graph := &onnx.GraphProto{}
+	for _, in := range inits {
+		raw := make([]byte, len(in.vals)*4)
+		for i, v := range in.vals {
+			binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(v))
+		}
+		name, dt := in.name, int32(onnx.TensorProto_FLOAT)
+		graph.Initializer = append(graph.Initializer, &onnx.TensorProto{Name: &name, DataType: &dt, Dims: in.dims, RawData: raw})
+	}
+	version := int64(21)
+	data, err := proto.Marshal(&onnx.ModelProto{OpsetImport: []*onnx.OperatorSetIdProto{{Version: &version}}, Graph: graph})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	dir := t.TempDir()
+	if config != "" {
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "model.onnx")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runConvert runs zonnx convert on input with args and returns the
+// converted file.
+func runConvert(t *testing.T, zonnx, input string, args ...string) *gguf.File {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out.gguf")
+	args = append(append([]string{"convert", "--output", out}, args...), input)
+	if output, err := exec.Command(zonnx, args...).CombinedOutput(); err != nil {
+		t.Fatalf("convert %v: %v\nOutput: %s", args, err, output)
+	}
+	f, err := gguf.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// checkValues decodes tensor name of f and compares it with want, allowing
+// an absolute error of tol.
+func checkValues(t *testing.T, f *gguf.File, name string, typ int, want []float32, tol float64) {
+	t.Helper()
+	for i := range f.Tensors {
+		tensor := &f.Tensors[i]
+		if tensor.Name != name {
+			continue
+		}
+		if tensor.Type != typ {
+			t.Errorf("%s type = %d, want %d", name, tensor.Type, typ)
+		}
+		got, err := quantize.DequantizeTensor(tensor)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s has %d values, want %d", name, len(got), len(want))
+		}
+		for j := range want {
+			if math.Abs(float64(got[j]-want[j])) > tol {
+				t.Fatalf("%s[%d] = %v, want %v", name, j, got[j], want[j])
+			}
+		}
+		return
+	}
+	t.Errorf("missing tensor %s", name)
+}
+
+func TestConvertQuantizeGPT2(t *testing.T) {
+	zonnx := buildZonnx(t)
+
+	// A Conv1D weight is stored [in, out]; the GGUF tensor is its transpose,
+	// quantized along the input dimension.
+	const in, out = 32, 96
+	conv := make([]float32, in*out)
+	want := make([]float32, in*out)
+	for i := 0; i < in; i++ {
+		for j := 0; j < out; j++ {
+			v := float32((i*7+j*3)%17 - 8)
+			conv[i*out+j] = v
+			want[j*in+i] = v
+		}
+	}
+	model := writeONNXModel(t, []onnxInitializer{
+		{name: "h.0.attn.c_attn.weight", dims: []int64{in, out}, vals: conv},
+		{name: "h.0.ln_1.weight", dims: []int64{in}, vals: make([]float32, in)},
+	}, `{"n_embd": 32, "n_head": 4}`)
+
+	f := runConvert(t, zonnx, model, "--arch", "gpt2", "--quantize", "q4_0")
+	checkValues(t, f, "blk.0.attn_qkv.weight", sharedgguf.TypeQ4_0, want, 1)
+	checkValues(t, f, "blk.0.attn_norm.weight", sharedgguf.TypeF32, make([]float32, in), 0)
+	if e, ok := f.Lookup("general.file_type"); !ok || e.Value != uint32(2) {
+		t.Errorf("general.file_type = %v (present %v), want 2 (Q4_0)", e.Value, ok)
+	}
+}
+
+func TestConvertQuantRuleGemma(t *testing.T) {
+	zonnx := buildZonnx(t)
+
+	// Gemma's norm offset runs on the source values, so rules can quantize
+	// the tensors it changes. The embedding is written unscaled, with no
+	// separate output head.
+	const hidden, vocab = 32, 4
+	embd := make([]float32, vocab*hidden)
+	for i := range embd {
+		embd[i] = float32(i%9-4) / 4
+	}
+	norm := make([]float32, hidden)
+	offset := make([]float32, hidden)
+	for i := range norm {
+		norm[i] = float32(i) / hidden
+		offset[i] = norm[i] + 1
+	}
+	model := writeONNXModel(t, []onnxInitializer{
+		{name: "model.embed_tokens.weight", dims: []int64{vocab, hidden}, vals: embd},
+		{name: "model.layers.0.input_layernorm.weight", dims: []int64{hidden}, vals: norm},
+	}, `{"hidden_size": 32, "num_attention_heads": 4}`)
+
+	f := runConvert(t, zonnx, model, "--arch", "gemma", "--quant-rule", "token_embd=q8_0", "--quant-rule", "_norm=q8_0")
+	checkValues(t, f, "token_embd.weight", sharedgguf.TypeQ8_0, embd, 0.05)
+	for _, tensor := range f.Tensors {
+		if tensor.Name == "output.weight" {
+			t.Error("output.weight was written for Gemma's tied head")
+		}
+	}
+	checkValues(t, f, "blk.0.attn_norm.weight", sharedgguf.TypeQ8_0, offset, 0.01)
+}
+
+// writeSafetensorsModel writes config and F32 tensors of the given shapes,
+// filled with small values, as a safetensors model directory.
+func writeSafetensorsModel(t *testing.T, config string, shapes map[string][]int64) string {
+	t.Helper()
+	header := map[string]interface{}{}
+	var data []byte
+	for _, name := range slices.Sorted(maps.Keys(shapes)) {
+		n := int64(1)
+		for _, d := range shapes[name] {
+			n *= d
+		}
+		start := len(data)
+		for i := range n {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(i%7-3)/8))
+		}
+		header[name] = map[string]interface{}{"dtype": "F32", "shape": shapes[name], "data_offsets": []int{start, len(data)}}
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := binary.LittleEndian.AppendUint64(nil, uint64(len(h)))
+	file = append(append(file, h...), data...)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "model.safetensors"), file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestConvertQuantRuleOutType(t *testing.T) {
+	zonnx := buildZonnx(t)
+
+	// One rule-quantized matrix among larger ones cast to F16: the file type
+	// follows the cast.
+	dir := writeSafetensorsModel(t, `{"architectures": ["LlamaForCausalLM"], "hidden_size": 32}`, map[string][]int64{
+		"model.layers.0.mlp.down_proj.weight": {32, 32},
+		"model.layers.0.mlp.gate_proj.weight": {64, 32},
+		"model.layers.0.mlp.up_proj.weight":   {64, 32},
+	})
+	f := runConvert(t, zonnx, dir, "--format", "safetensors", "--arch", "llama", "--quant-rule", "ffn_down=q8_0", "--outtype", "f16")
+	for name, want := range map[string]int{"blk.0.ffn_down.weight": sharedgguf.TypeQ8_0, "blk.0.ffn_up.weight": sharedgguf.TypeF16} {
+		for _, tensor := range f.Tensors {
+			if tensor.Name == name && tensor.Type != want {
+				t.Errorf("%s type = %d, want %d", name, tensor.Type, want)
+			}
+		}
+	}
+	if e, ok := f.Lookup("general.file_type"); !ok || e.Value != uint32(1) {
+		t.Errorf("general.file_type = %v (present %v), want 1 (MOSTLY_F16)", e.Value, ok)
+	}
+}
+
+func TestConvertQuantizeGGMLLayout(t *testing.T) {
+	zonnx := buildZonnx(t)
+
+	// Value j of a block differs from value 2j for every j > 0, mostly by
+	// several steps, so the layout that pairs adjacent values in a byte
+	// decodes well outside the Q4_0 error of about half a step.
+	const rows, cols = 32, 32
+	vals := make([]float32, rows*cols)
+	for i := range vals {
+		vals[i] = float32((i*5)%16 - 8)
+	}
+	model := writeONNXModel(t, []onnxInitializer{
+		{name: "model.layers.0.mlp.down_proj.weight", dims: []int64{rows, cols}, vals: vals},
+	}, "")
+
+	f := runConvert(t, zonnx, model, "--arch", "llama", "--quantize", "q4_0")
+	// DequantizeTensor reads Q4_0 in the ggml layout.
+	checkValues(t, f, "blk.0.ffn_down.weight", sharedgguf.TypeQ4_0, vals, 1)
+}
+
+func TestConvertRepackNBits(t *testing.T) {
+	zonnx := buildZonnx(t)
+
+	// A 4-bit MatMulNBits weight with N=2 outputs and K=32 inputs in one
+	// block per row, packed two values per byte, low nibble first.
+	const n, k = 2, 32
+	scales := []float32{0.5, 0.25}
+	var packed []byte
+	want := make([]float32, n*k)
+	for row := range n {
+		for j := 0; j < k; j += 2 {
+			lo, hi := (j+3*row)%16, (j+1+3*row)%16
+			packed = append(packed, byte(lo|hi<<4))
+			want[row*k+j] = float32(lo-8) * scales[row]
+			want[row*k+j+1] = float32(hi-8) * scales[row]
+		}
+	}
+	raw := make([]byte, 0, 4*len(scales))
+	for _, s := range scales {
+		raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(s))
+	}
+	str := func(s string) *string { return &s }
+	i32 := func(v int32) *int32 { return &v }
+	attr := func(name string, v int64) *onnx.AttributeProto {
+		typ := onnx.AttributeProto_INT
+		return &onnx.AttributeProto{Name: str(name), Type: &typ, I: &v}
+	}
+	version := int64(21)
+	data, err := proto.Marshal(&onnx.ModelProto{
+		OpsetImport: []*onnx.OperatorSetIdProto{{Version: &version}},
+		Graph: &onnx.GraphProto{
+			Initializer: []*onnx.TensorProto{
+				{Name: str("w"), DataType: i32(int32(onnx.TensorProto_UINT8)), Dims: []int64{n, 1, k / 2}, RawData: packed},
+				{Name: str("s"), DataType: i32(int32(onnx.TensorProto_FLOAT)), Dims: []int64{n}, RawData: raw},
+			},
+			Node: []*onnx.NodeProto{{
+				Name: str("matmul"), OpType: str("MatMulNBits"), Input: []string{"x", "w", "s"}, Output: []string{"y"},
+				Attribute: []*onnx.AttributeProto{attr("K", k), attr("N", n), attr("bits", 4), attr("block_size", 32)},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := filepath.Join(t.TempDir(), "model.onnx")
+	if err := os.WriteFile(model, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := runConvert(t, zonnx, model, "--arch", "llama", "--repack-nbits")
+	checkValues(t, f, "w_repacked", sharedgguf.TypeQ4_0, want, 0)
+}
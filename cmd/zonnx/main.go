@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings" // Added for strings.ToLower
 
 	"encoding/json"
@@ -49,6 +52,10 @@ func main() {
 	// 	handleInspectZMF()
 	case "convert":
 		handleConvert()
+	case "quantize":
+		handleQuantize()
+	case "dequantize":
+		handleDequantize()
 	case "download": // Add new case for download command
 		handleDownload()
 	default:
@@ -173,22 +180,22 @@ func handleInspect() {
 func handleConvert() {
 	convertCmd := flag.NewFlagSet("convert", flag.ExitOnError)
 	outputFile := convertCmd.String("output", "", "Path for the output GGUF file. (optional)")
-	quantizeFlag := convertCmd.String("quantize", "", "Quantize weights during conversion (q4_0 or q8_0)")
-	archFlag := convertCmd.String("arch", "llama", "Model architecture name for GGUF metadata")
+	quantizeFlag := convertCmd.String("quantize", "", "Quantize weights during conversion (q4_0, q4_1, q5_0, q5_1, q8_0, q2_k, q3_k, q4_k, q5_k, q6_k, or a mixed preset: q3_k_s, q3_k_m, q4_k_s, q4_k_m, q5_k_s, q5_k_m)")
+	archFlag := convertCmd.String("arch", "", "Model architecture name for GGUF metadata (default: detected from config.json or tensor names)")
+	archFile := convertCmd.String("arch-file", "", "Architecture definition (YAML or JSON) mapping tensor names and config keys; sets --arch to its name unless given")
 	formatFlag := convertCmd.String("format", "onnx", "Input format: onnx or safetensors")
-
-	// Normalize aliases for stdlib flag parsing (accept --flag as an alias for -flag)
-	rawArgs := os.Args[2:]
-	normalizedArgs := make([]string, 0, len(rawArgs))
-	for _, a := range rawArgs {
-		if strings.HasPrefix(a, "--") && !strings.HasPrefix(a, "---") {
-			normalizedArgs = append(normalizedArgs, "-"+strings.TrimPrefix(a, "--"))
-			continue
-		}
-		normalizedArgs = append(normalizedArgs, a)
-	}
-
-	if err := convertCmd.Parse(normalizedArgs); err != nil {
+	var quantRules ruleFlags
+	convertCmd.Var(&quantRules, "quant-rule", "Per-tensor quantization override 'regex=type' (repeatable; type may also be f16 or f32)")
+	quantRuleFile := convertCmd.String("quant-rule-file", "", "File of quantization override rules, one 'regex=type' per line")
+	quantReport := convertCmd.String("quant-report", "", "Write a per-tensor quantization error report to this file ('.json' for JSON, '-' for a table on stdout)")
+	imatrixFile := convertCmd.String("imatrix", "", "Importance matrix file (llama.cpp imatrix format) to weight Q4_0 and K-quant scales")
+	jobs := convertCmd.Int("jobs", 0, "Number of tensors to quantize in parallel (0 uses all CPUs)")
+	fp8Type := convertCmd.String("fp8-type", "bf16", "Type FP8 safetensors weights are decoded to: bf16, f16 or f32")
+	outType := convertCmd.String("outtype", "", "Cast all float tensors to f32, f16 or bf16 (default: keep each tensor's type)")
+	castNorms := convertCmd.Bool("cast-norms", false, "With --outtype, also cast normalization tensors instead of keeping them f32")
+	repackNBits := convertCmd.Bool("repack-nbits", false, "Read ONNX MatMulNBits weights as Q4_0, Q4_1 or Q8_0 blocks without dequantizing them")
+
+	if err := convertCmd.Parse(normalizeFlagArgs(os.Args[2:])); err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing flags for convert command: %v\n", err)
 		os.Exit(1)
 	}
@@ -205,11 +212,75 @@ func handleConvert() {
 		*outputFile = filepath.Join(filepath.Dir(inputFile), base+".gguf")
 	}
 
+	if *archFile != "" {
+		a, err := gguf.LoadArchitecture(*archFile)
+		handleErr(err)
+		handleErr(gguf.RegisterArchitecture(a))
+		if !flagSet(convertCmd, "arch") {
+			*archFlag = a.Name
+		}
+	}
+
+	// Command-line rules come first so they win over the rules file.
+	rules, err := parseQuantRules(quantRules, *quantRuleFile)
+	handleErr(err)
+
+	var castType int
+	if *outType != "" {
+		castType, err = parseFloatType(*outType)
+		handleErr(err)
+	}
+	quantizing := *quantizeFlag != "" || len(rules) > 0
+
 	// Safetensors path: inputFile is a directory containing config.json + model.safetensors.
 	if strings.ToLower(*formatFlag) == "safetensors" {
+		typ, err := parseFloatType(*fp8Type)
+		handleErr(err)
+		opts := converter.SafetensorsOptions{FP8Type: typ}
+		// When quantizing, the tensors left in float are cast afterwards so
+		// the quantizer reads the source values.
+		if *outType != "" && !quantizing {
+			opts.Cast, opts.OutType, opts.CastNorms = true, castType, *castNorms
+		}
 		fmt.Printf("Converting safetensors model from: %s\n", inputFile)
-		err := converter.ConvertSafetensorsToGGUF(inputFile, *outputFile, *archFlag)
+		if *archFlag == "" {
+			d, err := converter.DetectArchitecture(inputFile)
+			handleErr(err)
+			printDetection(d)
+			*archFlag = d.Name
+		}
+		err = converter.ConvertSafetensorsToGGUFWithOptions(inputFile, *outputFile, *archFlag, opts)
 		handleErr(err)
+
+		// Quantization runs on the written file, so FP8 and other float
+		// weights can be stored as block-quantized types.
+		if quantizing {
+			qt := quantize.QuantType(strings.ToLower(*quantizeFlag))
+			opts := quantize.Options{Type: qt, Rules: rules, Jobs: *jobs}
+			if *imatrixFile != "" {
+				opts.Imatrix, err = quantize.LoadImatrix(*imatrixFile)
+				handleErr(err)
+			}
+			if *quantReport != "" {
+				opts.Report = &quantize.Report{}
+			}
+			f, err := gguf.ReadFile(*outputFile)
+			handleErr(err)
+			if err := quantize.GGUF(f, opts); err != nil {
+				handleErr(fmt.Errorf("quantization failed: %w", err))
+			}
+			if opts.Report != nil {
+				handleErr(writeQuantReport(opts.Report, *quantReport))
+			}
+			if *outType != "" {
+				handleErr(quantize.CastGGUF(f, castType, *castNorms))
+				f.Metadata = quantize.SetFileType(f.Metadata, f.Tensors, qt)
+			}
+			handleErr(writeGGUFFile(f, *outputFile))
+			if qt != "" {
+				fmt.Printf("Quantized weights to %s\n", qt)
+			}
+		}
 		fmt.Printf("Successfully converted and saved GGUF model to: %s\n", *outputFile)
 		return
 	}
@@ -217,63 +288,345 @@ func handleConvert() {
 	fmt.Printf("Converting ONNX model from: %s\n", inputFile)
 
 	// Load ONNX model via importer and convert to ZMF for tensor processing.
-	zmfModel, err := importer.ConvertOnnxToZmf(inputFile)
+	zmfModel, err := importer.ConvertOnnxToZmfWithOptions(inputFile, importer.Options{RepackNBits: *repackNBits})
 	handleErr(err)
 
-	// Apply quantization if requested (operates on ZMF intermediate).
-	if *quantizeFlag != "" {
-		qt := quantize.QuantType(strings.ToLower(*quantizeFlag))
-		if err := quantize.Model(zmfModel, qt); err != nil {
+	config := extractONNXConfig(inputFile)
+	names := make([]string, 0, len(zmfModel.Graph.Parameters))
+	for name := range zmfModel.Graph.Parameters {
+		names = append(names, name)
+	}
+	if *archFlag == "" {
+		d, err := gguf.DetectArchitecture(config, names)
+		handleErr(err)
+		printDetection(d)
+		*archFlag = d.Name
+	}
+
+	// Build the GGUF tensors from the converted model. The architecture's
+	// transforms run on the source data, before any quantization, so they
+	// see float values laid out as the model stores them.
+	archDef, _ := gguf.LookupArchitecture(*archFlag)
+	f := &gguf.File{Metadata: gguf.MapMetadata(*archFlag, config)}
+	experts, err := converter.NewExpertStacker(archDef, names)
+	handleErr(err)
+	mapped := make([]string, len(names))
+	for i, name := range names {
+		mapped[i] = gguf.MapArchTensorName(*archFlag, name)
+	}
+	concat, err := converter.NewTensorConcatenator(archDef, config, mapped)
+	handleErr(err)
+	var kept []gguf.Tensor
+	for name, p := range zmfModel.Graph.Parameters {
+		shape := make([]int, len(p.Shape))
+		for i, d := range p.Shape {
+			shape[i] = int(d)
+		}
+		typ, ok := ggufFloatType(p.Dtype)
+		if !ok {
+			typ = zmfDtypeToGGUF(p.Dtype)
+		}
+		t := gguf.Tensor{Name: gguf.MapArchTensorName(*archFlag, name), Type: typ, Shape: shape, Data: quantize.GGMLData(p)}
+		stacked, isExpert, err := experts.Add(name, t)
+		handleErr(err)
+		if isExpert {
+			if stacked == nil {
+				continue // more experts to come
+			}
+			t = *stacked
+		}
+		parts, err := converter.SplitTensor(archDef, config, t)
+		handleErr(err)
+		for _, t := range parts {
+			k, err := converter.TransformTensor(archDef, config, &t)
+			if errors.Is(err, converter.ErrDropTensor) {
+				continue
+			}
+			handleErr(err)
+			kept = append(kept, k...)
+			joined, isPart, err := concat.Add(t)
+			handleErr(err)
+			if isPart {
+				if joined == nil {
+					continue // more parts to come
+				}
+				t = *joined
+			}
+			f.Tensors = append(f.Tensors, t)
+		}
+	}
+	// Untransformed copies, such as a tied output head, unless the model has
+	// its own.
+	for _, t := range kept {
+		if !slices.ContainsFunc(f.Tensors, func(x gguf.Tensor) bool { return x.Name == t.Name }) {
+			f.Tensors = append(f.Tensors, t)
+		}
+	}
+
+	qt := quantize.QuantType(strings.ToLower(*quantizeFlag))
+	if quantizing {
+		opts := quantize.Options{Type: qt, Rules: rules, Jobs: *jobs}
+		if *imatrixFile != "" {
+			opts.Imatrix, err = quantize.LoadImatrix(*imatrixFile)
+			handleErr(err)
+		}
+		if *quantReport != "" {
+			opts.Report = &quantize.Report{}
+		}
+		if err := quantizeFloatTensors(f, opts); err != nil {
 			handleErr(fmt.Errorf("quantization failed: %w", err))
 		}
-		fmt.Printf("Quantized weights to %s\n", qt)
+		if opts.Report != nil {
+			handleErr(writeQuantReport(opts.Report, *quantReport))
+		}
+		if qt != "" {
+			fmt.Printf("Quantized weights to %s\n", qt)
+		}
+		if len(rules) > 0 {
+			fmt.Printf("Applied %d quantization override rule(s)\n", len(rules))
+		}
 	}
+	if *outType != "" {
+		handleErr(quantize.CastGGUF(f, castType, *castNorms))
+	}
+
+	// general.file_type is taken from the tensors as written.
+	f.Metadata = quantize.SetFileType(f.Metadata, f.Tensors, qt)
+	handleErr(writeGGUFFile(f, *outputFile))
 
-	// Ensure parent directories exist for the output path.
-	if dir := filepath.Dir(*outputFile); dir != "." {
-		if mkErr := os.MkdirAll(dir, 0o755); mkErr != nil {
-			handleErr(mkErr)
+	fmt.Printf("Successfully converted and saved GGUF model to: %s\n", *outputFile)
+}
+
+// quantizeFloatTensors quantizes the float tensors of f with opts. Tensors
+// the source model already stores quantized, such as those imported from
+// QDQ or MatMulNBits nodes, keep their data.
+func quantizeFloatTensors(f *gguf.File, opts quantize.Options) error {
+	sub := &gguf.File{}
+	var index []int
+	for i, t := range f.Tensors {
+		if isFloatType(t.Type) {
+			sub.Tensors = append(sub.Tensors, t)
+			index = append(index, i)
 		}
 	}
+	if err := quantize.GGUF(sub, opts); err != nil {
+		return err
+	}
+	for j, i := range index {
+		f.Tensors[i] = sub.Tensors[j]
+	}
+	return nil
+}
 
-	outFile, err := os.Create(*outputFile)
+func handleQuantize() {
+	quantizeCmd := flag.NewFlagSet("quantize", flag.ExitOnError)
+	typeFlag := quantizeCmd.String("type", "", "Target type (q4_0, q4_1, q5_0, q5_1, q8_0, q2_k, q3_k, q4_k, q5_k, q6_k, f16, or a mixed preset: q3_k_s, q3_k_m, q4_k_s, q4_k_m, q5_k_s, q5_k_m)")
+	var quantRules ruleFlags
+	quantizeCmd.Var(&quantRules, "quant-rule", "Per-tensor quantization override 'regex=type' (repeatable; type may also be f16 or f32)")
+	quantRuleFile := quantizeCmd.String("quant-rule-file", "", "File of quantization override rules, one 'regex=type' per line")
+	quantReport := quantizeCmd.String("quant-report", "", "Write a per-tensor quantization error report to this file ('.json' for JSON, '-' for a table on stdout)")
+	imatrixFile := quantizeCmd.String("imatrix", "", "Importance matrix file (llama.cpp imatrix format) to weight Q4_0 and K-quant scales")
+	jobs := quantizeCmd.Int("jobs", 0, "Number of tensors to quantize in parallel (0 uses all CPUs)")
+
+	args, err := parseInterspersed(quantizeCmd, normalizeFlagArgs(os.Args[2:]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags for quantize command: %v\n", err)
+		os.Exit(1)
+	}
+	if len(args) != 2 || (*typeFlag == "" && len(quantRules) == 0 && *quantRuleFile == "") {
+		fmt.Println("Error: 'quantize' requires <input.gguf> <output.gguf> and --type.")
+		quantizeCmd.Usage()
+		os.Exit(1)
+	}
+	inputFile, outputFile := args[0], args[1]
+
+	rules, err := parseQuantRules(quantRules, *quantRuleFile)
 	handleErr(err)
-	defer func() {
-		if cerr := outFile.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "Error closing output file: %v\n", cerr)
-		}
-	}()
+	qt := quantize.QuantType(strings.ToLower(*typeFlag))
+	opts := quantize.Options{Type: qt, Rules: rules, Jobs: *jobs}
+	if *imatrixFile != "" {
+		opts.Imatrix, err = quantize.LoadImatrix(*imatrixFile)
+		handleErr(err)
+	}
+	if *quantReport != "" {
+		opts.Report = &quantize.Report{}
+	}
+
+	fmt.Printf("Requantizing GGUF model from: %s\n", inputFile)
+	f, err := gguf.ReadFile(inputFile)
+	handleErr(err)
+	if err := quantize.GGUF(f, opts); err != nil {
+		handleErr(fmt.Errorf("quantization failed: %w", err))
+	}
+	if opts.Report != nil {
+		handleErr(writeQuantReport(opts.Report, *quantReport))
+	}
 
-	w := sharedgguf.NewWriter()
+	handleErr(writeGGUFFile(f, outputFile))
 
-	// Write GGUF metadata from ONNX model properties.
-	config := extractONNXConfig(inputFile)
-	for _, entry := range gguf.MapMetadata(*archFlag, config) {
-		switch entry.Type {
-		case sharedgguf.MetaTypeString:
-			w.AddMetadataString(entry.Key, entry.Value.(string))
-		case sharedgguf.MetaTypeUint32:
-			w.AddMetadataUint32(entry.Key, entry.Value.(uint32))
-		case sharedgguf.MetaTypeFloat32:
-			w.AddMetadataFloat32(entry.Key, entry.Value.(float32))
+	fmt.Printf("Successfully quantized and saved GGUF model to: %s\n", outputFile)
+}
+
+func handleDequantize() {
+	dequantizeCmd := flag.NewFlagSet("dequantize", flag.ExitOnError)
+	typeFlag := dequantizeCmd.String("type", "f32", "Output type for quantized tensors: f32, f16 or bf16")
+
+	args, err := parseInterspersed(dequantizeCmd, normalizeFlagArgs(os.Args[2:]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags for dequantize command: %v\n", err)
+		os.Exit(1)
+	}
+	if len(args) != 2 {
+		fmt.Println("Error: 'dequantize' requires <input.gguf> <output.gguf>.")
+		dequantizeCmd.Usage()
+		os.Exit(1)
+	}
+	inputFile, outputFile := args[0], args[1]
+
+	typ, err := parseFloatType(*typeFlag)
+	handleErr(err)
+
+	fmt.Printf("Dequantizing GGUF model from: %s\n", inputFile)
+	f, err := gguf.ReadFile(inputFile)
+	handleErr(err)
+	handleErr(quantize.DequantizeGGUF(f, typ))
+	handleErr(writeGGUFFile(f, outputFile))
+
+	fmt.Printf("Successfully dequantized and saved GGUF model to: %s\n", outputFile)
+}
+
+// parseFloatType returns the GGUF type named by f32, f16 or bf16.
+func parseFloatType(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "f32":
+		return sharedgguf.TypeF32, nil
+	case "f16":
+		return sharedgguf.TypeF16, nil
+	case "bf16":
+		return sharedgguf.TypeBF16, nil
+	}
+	return 0, fmt.Errorf("unsupported float type %q: want f32, f16 or bf16", s)
+}
+
+// writeGGUFFile encodes f and writes it to path, creating parent directories.
+func writeGGUFFile(f *gguf.File, path string) error {
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		return fmt.Errorf("encode gguf: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
 		}
 	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
 
-	// Write tensors from the converted model.
-	for name, t := range zmfModel.Graph.Parameters {
-		ggufName := gguf.MapTensorName(name)
-		dtype := zmfDtypeToGGUF(t.Dtype)
-		shape := make([]int, len(t.Shape))
-		for i, d := range t.Shape {
-			shape[i] = int(d)
+// normalizeFlagArgs rewrites --flag as -flag so both spellings are accepted.
+func normalizeFlagArgs(rawArgs []string) []string {
+	normalizedArgs := make([]string, 0, len(rawArgs))
+	for _, a := range rawArgs {
+		if strings.HasPrefix(a, "--") && !strings.HasPrefix(a, "---") {
+			normalizedArgs = append(normalizedArgs, "-"+strings.TrimPrefix(a, "--"))
+			continue
 		}
-		w.AddTensor(ggufName, dtype, shape, t.Data)
+		normalizedArgs = append(normalizedArgs, a)
 	}
+	return normalizedArgs
+}
 
-	err = w.Write(outFile)
-	handleErr(err)
+// parseInterspersed parses args with fs, allowing flags before, between and
+// after positional arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-	fmt.Printf("Successfully converted and saved GGUF model to: %s\n", *outputFile)
+// printDetection reports the detected architecture and the evidence for it.
+func printDetection(d gguf.Detection) {
+	fmt.Printf("Architecture: %s (%s)\n", d.Name, d.Reason)
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// ruleFlags collects repeated --quant-rule values.
+type ruleFlags []string
+
+func (r *ruleFlags) String() string { return strings.Join(*r, ",") }
+
+func (r *ruleFlags) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+// parseQuantRules parses --quant-rule values followed by the rules in path,
+// if any.
+func parseQuantRules(flags []string, path string) ([]quantize.Rule, error) {
+	var rules []quantize.Rule
+	for _, f := range flags {
+		r, err := quantize.ParseRule(f)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	if path == "" {
+		return rules, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open quantization rules: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	fileRules, err := quantize.ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return append(rules, fileRules...), nil
+}
+
+// writeQuantReport writes report to path as JSON when path ends in ".json",
+// or as a table otherwise. A path of "-" prints the table to stdout.
+func writeQuantReport(report *quantize.Report, path string) error {
+	if path == "-" {
+		return report.WriteTable(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create quantization report: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = report.WriteJSON(f)
+	} else {
+		err = report.WriteTable(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write quantization report: %w", err)
+	}
+	fmt.Printf("Wrote quantization report to %s\n", path)
+	return nil
 }
 
 // extractONNXConfig reads a config.json from the same directory as the ONNX model.
@@ -291,6 +644,30 @@ func extractONNXConfig(modelPath string) map[string]interface{} {
 	return config
 }
 
+// ggufFloatType returns the GGUF type of a floating-point ZMF dtype.
+func ggufFloatType(dtype zmf.Tensor_DataType) (int, bool) {
+	switch dtype {
+	case zmf.Tensor_FLOAT32:
+		return sharedgguf.TypeF32, true
+	case zmf.Tensor_FLOAT16:
+		return sharedgguf.TypeF16, true
+	case zmf.Tensor_BFLOAT16:
+		return sharedgguf.TypeBF16, true
+	case zmf.Tensor_FLOAT64:
+		return gguf.TypeF64, true
+	}
+	return 0, false
+}
+
+// isFloatType reports whether typ is a floating-point GGUF type.
+func isFloatType(typ int) bool {
+	switch typ {
+	case sharedgguf.TypeF32, sharedgguf.TypeF16, sharedgguf.TypeBF16, gguf.TypeF64:
+		return true
+	}
+	return false
+}
+
 // zmfDtypeToGGUF maps ZMF tensor data types to GGUF dtype constants.
 func zmfDtypeToGGUF(dtype zmf.Tensor_DataType) int {
 	switch dtype {
@@ -304,6 +681,22 @@ func zmfDtypeToGGUF(dtype zmf.Tensor_DataType) int {
 		return sharedgguf.TypeQ4_0
 	case zmf.Tensor_Q8_0:
 		return sharedgguf.TypeQ8_0
+	case quantize.DtypeQ4_1:
+		return gguf.TypeQ4_1
+	case quantize.DtypeQ5_0:
+		return gguf.TypeQ5_0
+	case quantize.DtypeQ5_1:
+		return gguf.TypeQ5_1
+	case quantize.DtypeQ2_K:
+		return gguf.TypeQ2_K
+	case quantize.DtypeQ3_K:
+		return gguf.TypeQ3_K
+	case quantize.DtypeQ4_K:
+		return gguf.TypeQ4_K
+	case quantize.DtypeQ5_K:
+		return gguf.TypeQ5_K
+	case quantize.DtypeQ6_K:
+		return gguf.TypeQ6_K
 	default:
 		return sharedgguf.TypeF32
 	}
@@ -357,7 +750,9 @@ func printUsage() {
 	fmt.Println("  export <input-file.zmf> [-output <output-file.onnx>]")
 	fmt.Println("  inspect <input-file> [--type <onnx|zmf>] [--pretty]")
 	// fmt.Println("  inspect-zmf <input-file.zmf>") // Removed inspect-zmf usage
-	fmt.Println("  convert <input> [-output <output-file.gguf>] [--arch <architecture>] [--format <onnx|safetensors>] [--quantize <q4_0|q8_0>]")
+	fmt.Println("  convert <input> [-output <output-file.gguf>] [--arch <architecture> (default: detected)] [--arch-file <file.yaml|file.json>] [--format <onnx|safetensors>] [--quantize <q4_0|q4_1|q5_0|q5_1|q8_0|q2_k|q3_k|q4_k|q5_k|q6_k|q4_k_m|...>] [--quant-rule <regex=type>]... [--quant-rule-file <file>] [--quant-report <file|->] [--imatrix <file>] [--jobs <n>] [--fp8-type <bf16|f16|f32>] [--outtype <f32|f16|bf16>] [--cast-norms] [--repack-nbits]")
+	fmt.Println("  quantize <input.gguf> <output.gguf> --type <q4_0|q8_0|q4_k|q4_k_m|...> [--quant-rule <regex=type>]... [--quant-rule-file <file>] [--imatrix <file>] [--quant-report <file|->] [--jobs <n>]")
+	fmt.Println("  dequantize <input.gguf> <output.gguf> [--type <f32|f16|bf16>]")
 	fmt.Println("  download --model <huggingface-model-id> [--output <output-directory>] [--api-key <your-api-key> | HF_API_KEY=<your-api-key>]")
 }
 
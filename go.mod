@@ -7,4 +7,5 @@ require (
 	github.com/zerfoo/zmf v0.4.0
 	github.com/zerfoo/ztensor v0.6.0
 	google.golang.org/protobuf v1.36.8
+	gopkg.in/yaml.v3 v3.0.1
 )
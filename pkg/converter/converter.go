@@ -8,8 +8,10 @@ import (
 	"path/filepath"
 	"strconv"
 
+	"github.com/zerfoo/float16"
 	"github.com/zerfoo/zmf"
 	"github.com/zerfoo/zonnx/internal/onnx"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 )
 
 // ONNXToZMF converts an ONNX model to the ZMF format.
@@ -19,6 +21,21 @@ func ONNXToZMF(model *onnx.ModelProto) (*zmf.Model, error) {
 
 // ONNXToZMFWithPath converts an ONNX model to the ZMF format with support for external data files.
 func ONNXToZMFWithPath(model *onnx.ModelProto, modelPath string) (*zmf.Model, error) {
+	return ONNXToZMFWithOptions(model, modelPath, Options{})
+}
+
+// Options configures ONNXToZMFWithOptions.
+type Options struct {
+	// RepackNBits stores 4-bit MatMulNBits weights as Q4_0 or Q4_1 blocks
+	// and 8-bit weights as Q8_0 blocks instead of expanding them to
+	// float32, when their block layout allows it. The packed uint8
+	// initializers they replace are dropped. RepackMatMulNBits does the
+	// same for a single node.
+	RepackNBits bool
+}
+
+// ONNXToZMFWithOptions is like ONNXToZMFWithPath but applies opts.
+func ONNXToZMFWithOptions(model *onnx.ModelProto, modelPath string, opts Options) (*zmf.Model, error) {
 	onnxGraph := model.GetGraph()
 	if onnxGraph == nil {
 		return nil, fmt.Errorf("model graph is nil")
@@ -79,6 +96,10 @@ func ONNXToZMFWithPath(model *onnx.ModelProto, modelPath string) (*zmf.Model, er
 		zmfModel.Metadata.OpsetVersion = model.GetOpsetImport()[0].GetVersion()
 	}
 
+	// consumed holds initializers whose data was folded into a repacked
+	// weight and so is not copied to the ZMF parameters.
+	consumed := make(map[string]bool)
+
 	for _, onnxNode := range onnxGraph.GetNode() {
 		switch onnxNode.GetOpType() {
 		case "Constant":
@@ -100,12 +121,18 @@ func ONNXToZMFWithPath(model *onnx.ModelProto, modelPath string) (*zmf.Model, er
 			}
 
 		case "MatMulNBits":
-			// Dequantize 4-bit quantized weights to float32 at import time and emit a
-			// standard MatMul node.  This avoids needing a specialised runtime kernel.
-			zmfNode, err := convertMatMulNBits(onnxNode, initializers, zmfModel.Graph.Parameters, modelPath)
+			// Dequantize 4-bit quantized weights to float32 at import time (or repack
+			// them into Q4 blocks) and emit a standard MatMul node.  This avoids
+			// needing a specialised runtime kernel.
+			zmfNode, err := convertMatMulNBits(onnxNode, initializers, zmfModel.Graph.Parameters, modelPath, opts)
 			if err != nil {
 				return nil, fmt.Errorf("failed to convert MatMulNBits node '%s': %w", onnxNode.GetName(), err)
 			}
+			if _, ok := zmfNode.Attributes["transB"]; ok {
+				for _, in := range onnxNode.GetInput()[1:] {
+					consumed[in] = true
+				}
+			}
 			zmfModel.Graph.Nodes = append(zmfModel.Graph.Nodes, zmfNode)
 
 		default:
@@ -118,6 +145,9 @@ func ONNXToZMFWithPath(model *onnx.ModelProto, modelPath string) (*zmf.Model, er
 	}
 
 	for name, onnxTensor := range initializers {
+		if consumed[name] {
+			continue
+		}
 		dtype := onnx.TensorProto_DataType(onnxTensor.GetDataType())
 		switch dtype {
 		case onnx.TensorProto_FLOAT, onnx.TensorProto_FLOAT16, onnx.TensorProto_BFLOAT16, onnx.TensorProto_DOUBLE,
@@ -148,18 +178,91 @@ func extractConstantTensor(node *onnx.NodeProto, modelPath string) (*zmf.Tensor,
 //
 // ONNX MatMulNBits computes:  Y = A @ dequantize(B).T
 //   - A: float [batch, M, K]
-//   - B: uint8 [N, ceil(K/block_size), block_size*bits/8]
-//   - scales: float32 [N * ceil(K/block_size)]  (one scale per block)
-//   - zero_points (optional): uint8, 4-bit packed
+//   - B: uint8 [N, ceil(K/block_size), ceil(block_size*bits/8)], bits 2, 3, 4 or 8
+//   - scales: float32 or float16 [N * ceil(K/block_size)]  (one scale per block)
+//   - zero_points (optional): uint8 [N * ceil(ceil(K/block_size)*bits/8)], packed
+//     like B with each row padded to a whole byte, or float like scales
+//   - g_idx (optional): int32 [K], the block of each column (act-order)
 //
 // We dequantize B to a float32 [K, N] matrix so that a standard
 // MatMul(A, B_dequant) produces the correct [batch, M, N] output.
+//
+// With opts.RepackNBits, 4-bit and 8-bit weights whose blocks split into whole
+// 32-value GGUF blocks are instead stored as an [N, K] tensor named
+// "<B>_repacked", consumed by a MatMul node with transB=1: Q4_0 for 4-bit
+// weights with the default zero point, Q4_1 for other 4-bit weights and Q8_0
+// for 8-bit weights with the default zero point. Other weights fall back to
+// float32.
 func convertMatMulNBits(
 	node *onnx.NodeProto,
 	initializers map[string]*onnx.TensorProto,
 	params map[string]*zmf.Tensor,
 	_ string, // modelPath reserved for future external-data support
+	opts Options,
 ) (*zmf.Node, error) {
+	w, err := readMatMulNBits(node, initializers)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RepackNBits {
+		if zmfNode, ok := repackedMatMul(node, w, params); ok {
+			return zmfNode, nil
+		}
+	}
+
+	weightName := node.GetInput()[1]
+	dequantName := weightName + "_dequant"
+	params[dequantName] = w.dequantizeTransposed()
+	return matMulNode(node, dequantName), nil
+}
+
+// RepackMatMulNBits converts a MatMulNBits node the way Options.RepackNBits
+// does, for readers other than ONNXToZMFWithOptions. It adds the repacked
+// weight to params and returns a MatMul node with transB=1 that consumes it.
+// ok is false, and params is unchanged, when the weight cannot be repacked;
+// the caller then keeps the node as it is.
+func RepackMatMulNBits(
+	node *onnx.NodeProto,
+	initializers map[string]*onnx.TensorProto,
+	params map[string]*zmf.Tensor,
+) (zmfNode *zmf.Node, ok bool, err error) {
+	w, err := readMatMulNBits(node, initializers)
+	if err != nil {
+		return nil, false, err
+	}
+	zmfNode, ok = repackedMatMul(node, w, params)
+	return zmfNode, ok, nil
+}
+
+// repackedMatMul stores w as "<B>_repacked" in params and returns the MatMul
+// node that consumes it with transB=1, or false when w cannot be repacked.
+func repackedMatMul(node *onnx.NodeProto, w *nbitsWeight, params map[string]*zmf.Tensor) (*zmf.Node, bool) {
+	packed, ok := w.repack()
+	if !ok {
+		return nil, false
+	}
+	packedName := node.GetInput()[1] + "_repacked"
+	params[packedName] = packed
+	zmfNode := matMulNode(node, packedName)
+	zmfNode.Attributes["transB"] = &zmf.Attribute{Value: &zmf.Attribute_I{I: 1}}
+	return zmfNode, true
+}
+
+// matMulNode returns a standard MatMul ZMF node that multiplies the
+// activation of a MatMulNBits node by weight.
+func matMulNode(node *onnx.NodeProto, weight string) *zmf.Node {
+	return &zmf.Node{
+		Name:       node.GetName(),
+		OpType:     "MatMul",
+		Inputs:     []string{node.GetInput()[0], weight},
+		Outputs:    node.GetOutput(),
+		Attributes: make(map[string]*zmf.Attribute),
+	}
+}
+
+// readMatMulNBits reads the attributes and initializers of a MatMulNBits
+// node.
+func readMatMulNBits(node *onnx.NodeProto, initializers map[string]*onnx.TensorProto) (*nbitsWeight, error) {
 	// Read operator attributes.
 	var K, N, bits, blockSize int
 	for _, attr := range node.GetAttribute() {
@@ -183,7 +286,6 @@ func convertMatMulNBits(
 	if len(inputs) < 3 {
 		return nil, fmt.Errorf("MatMulNBits node '%s' requires at least 3 inputs, got %d", node.GetName(), len(inputs))
 	}
-	activationName := inputs[0]
 	weightName := inputs[1]
 	scaleName := inputs[2]
 
@@ -196,118 +298,295 @@ func convertMatMulNBits(
 		return nil, fmt.Errorf("MatMulNBits scale initializer '%s' not found", scaleName)
 	}
 
-	var zpTensor *onnx.TensorProto
+	var zpTensor, gIdxTensor *onnx.TensorProto
 	if len(inputs) >= 4 && inputs[3] != "" {
-		zpTensor = initializers[inputs[3]]
+		if zpTensor, ok = initializers[inputs[3]]; !ok {
+			return nil, fmt.Errorf("MatMulNBits zero point initializer '%s' not found", inputs[3])
+		}
+	}
+	if len(inputs) >= 5 && inputs[4] != "" {
+		if gIdxTensor, ok = initializers[inputs[4]]; !ok {
+			return nil, fmt.Errorf("MatMulNBits g_idx initializer '%s' not found", inputs[4])
+		}
 	}
 
-	dequantTensor, err := dequantizeNBits(weightTensor, scaleTensor, zpTensor, N, K, bits, blockSize)
+	w, err := readNBitsWeight(weightTensor, scaleTensor, zpTensor, gIdxTensor, N, K, bits, blockSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dequantize MatMulNBits '%s': %w", node.GetName(), err)
 	}
+	return w, nil
+}
 
-	dequantName := weightName + "_dequant"
-	params[dequantName] = dequantTensor
-
-	// Build a standard MatMul ZMF node.
-	zmfNode := &zmf.Node{
-		Name:       node.GetName(),
-		OpType:     "MatMul",
-		Outputs:    node.GetOutput(),
-		Inputs:     []string{activationName, dequantName},
-		Attributes: make(map[string]*zmf.Attribute),
-	}
-	return zmfNode, nil
+// nbitsWeight is the packed weight of a MatMulNBits node with its scales,
+// zero points and group indices.
+type nbitsWeight struct {
+	N, K, bits, blockSize  int
+	kBlocks, bytesPerBlock int
+	data                   []byte    // [N, kBlocks, bytesPerBlock]
+	scales                 []float32 // [N * kBlocks]
+	zeroPoints             []float32 // [N * kBlocks]
+	gIdx                   []int32   // [K], or nil
 }
 
-// dequantizeNBits dequantizes packed N-bit quantized weights to float32 [K, N].
-// The result is stored transposed ([K, N]) so it can be used directly in A @ B.
-func dequantizeNBits(
+// readNBitsWeight validates and decodes the inputs of a MatMulNBits node.
+// zpTensor and gIdxTensor may be nil.
+func readNBitsWeight(
 	weightTensor *onnx.TensorProto,
 	scaleTensor *onnx.TensorProto,
 	zpTensor *onnx.TensorProto,
+	gIdxTensor *onnx.TensorProto,
 	N, K, bits, blockSize int,
-) (*zmf.Tensor, error) {
-	if bits != 4 {
-		return nil, fmt.Errorf("only 4-bit quantization is currently supported, got %d bits", bits)
+) (*nbitsWeight, error) {
+	switch bits {
+	case 2, 3, 4, 8:
+	default:
+		return nil, fmt.Errorf("unsupported MatMulNBits bit width %d (want 2, 3, 4 or 8)", bits)
 	}
 
-	kBlocks := (K + blockSize - 1) / blockSize
-	bytesPerBlock := blockSize * bits / 8 // = blockSize/2 for 4-bit
+	w := &nbitsWeight{N: N, K: K, bits: bits, blockSize: blockSize}
+	w.kBlocks = (K + blockSize - 1) / blockSize
+	w.bytesPerBlock = (blockSize*bits + 7) / 8
 
-	weightData := weightTensor.GetRawData()
-	if len(weightData) != N*kBlocks*bytesPerBlock {
+	w.data = weightTensor.GetRawData()
+	if len(w.data) != N*w.kBlocks*w.bytesPerBlock {
 		return nil, fmt.Errorf("weight data length mismatch: expected %d, got %d",
-			N*kBlocks*bytesPerBlock, len(weightData))
+			N*w.kBlocks*w.bytesPerBlock, len(w.data))
 	}
 
-	scaleData := scaleTensor.GetRawData()
-	if len(scaleData) != N*kBlocks*4 {
-		// scales may also be stored as FloatData
-		if len(scaleTensor.GetFloatData()) == N*kBlocks {
-			scaleData = make([]byte, N*kBlocks*4)
-			for i, v := range scaleTensor.GetFloatData() {
-				binary.LittleEndian.PutUint32(scaleData[i*4:], math.Float32bits(v))
-			}
-		} else {
-			return nil, fmt.Errorf("scale data length mismatch: expected %d bytes, got %d",
-				N*kBlocks*4, len(scaleData))
+	scales, err := nbitsScales(scaleTensor, N*w.kBlocks)
+	if err != nil {
+		return nil, err
+	}
+	w.scales = scales
+
+	w.zeroPoints, err = nbitsZeroPoints(zpTensor, N, w.kBlocks, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	if gIdxTensor != nil {
+		w.gIdx, err = nbitsGroupIndex(gIdxTensor, K, w.kBlocks)
+		if err != nil {
+			return nil, err
 		}
 	}
+	return w, nil
+}
 
-	// Dequantize: output [N, K] then transpose to [K, N].
-	dequant := make([]float32, N*K)
-	for n := 0; n < N; n++ {
-		for kblk := 0; kblk < kBlocks; kblk++ {
-			scaleOffset := (n*kBlocks + kblk) * 4
-			scale := math.Float32frombits(binary.LittleEndian.Uint32(scaleData[scaleOffset : scaleOffset+4]))
-
-			// Default zero point for symmetric 4-bit is 8.
-			var zp float32 = 8.0
-			if zpTensor != nil {
-				zpData := zpTensor.GetRawData()
-				zpIdx := n*kBlocks + kblk
-				if len(zpData) > zpIdx/2 {
-					if zpIdx%2 == 0 {
-						zp = float32(zpData[zpIdx/2] & 0xF)
-					} else {
-						zp = float32((zpData[zpIdx/2] >> 4) & 0xF)
-					}
-				}
-			}
+// nbitsScales returns the count block scales of a MatMulNBits node, stored as
+// float32 raw data, float32 FloatData or float16 raw data.
+func nbitsScales(t *onnx.TensorProto, count int) ([]float32, error) {
+	raw := t.GetRawData()
+	scales := make([]float32, count)
+	switch {
+	case onnx.TensorProto_DataType(t.GetDataType()) == onnx.TensorProto_FLOAT16 && len(raw) == count*2:
+		for i := range scales {
+			scales[i] = float16.Float16(binary.LittleEndian.Uint16(raw[i*2:])).ToFloat32()
+		}
+	case len(raw) == count*4:
+		for i := range scales {
+			scales[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+		}
+	case len(t.GetFloatData()) == count:
+		// scales may also be stored as FloatData
+		copy(scales, t.GetFloatData())
+	default:
+		return nil, fmt.Errorf("scale data length mismatch: expected %d bytes, got %d",
+			count*4, len(raw))
+	}
+	return scales, nil
+}
 
-			for byteIdx := 0; byteIdx < bytesPerBlock; byteIdx++ {
-				wByte := weightData[n*kBlocks*bytesPerBlock+kblk*bytesPerBlock+byteIdx]
-				kLo := kblk*blockSize + byteIdx*2
-				kHi := kLo + 1
-				if kLo < K {
-					dequant[n*K+kLo] = scale * (float32(wByte&0xF) - zp)
-				}
-				if kHi < K {
-					dequant[n*K+kHi] = scale * (float32((wByte>>4)&0xF) - zp)
-				}
-			}
+// nbitsZeroPoints returns the zero point of each of the kBlocks blocks of the
+// N rows. Packed uint8 zero points hold one bits-wide value per block, low
+// bits first, with each row padded to a whole byte. Float zero points are
+// stored like the scales. Without a tensor every zero point is 2^(bits-1).
+func nbitsZeroPoints(t *onnx.TensorProto, N, kBlocks, bits int) ([]float32, error) {
+	count := N * kBlocks
+	if t != nil && onnx.TensorProto_DataType(t.GetDataType()) != onnx.TensorProto_UINT8 {
+		return nbitsScales(t, count)
+	}
+	zps := make([]float32, count)
+	if t == nil {
+		for i := range zps {
+			zps[i] = float32(int(1) << (bits - 1))
+		}
+		return zps, nil
+	}
+	raw := t.GetRawData()
+	rowBytes := (kBlocks*bits + 7) / 8
+	if len(raw) != N*rowBytes {
+		return nil, fmt.Errorf("zero point data length mismatch: expected %d, got %d", N*rowBytes, len(raw))
+	}
+	for n := range N {
+		for kblk := range kBlocks {
+			zps[n*kBlocks+kblk] = float32(unpackBits(raw[n*rowBytes:], kblk, bits))
 		}
 	}
+	return zps, nil
+}
 
-	// Transpose from [N, K] to [K, N] so MatMul(A[..., K], W[K, N]) = [..., N].
-	transposed := make([]float32, K*N)
-	for n := 0; n < N; n++ {
-		for k := 0; k < K; k++ {
-			transposed[k*N+n] = dequant[n*K+k]
+// nbitsGroupIndex returns the g_idx input of a MatMulNBits node, which gives
+// the block whose scale and zero point apply to each of the K columns.
+func nbitsGroupIndex(t *onnx.TensorProto, K, kBlocks int) ([]int32, error) {
+	gIdx := t.GetInt32Data()
+	if raw := t.GetRawData(); len(raw) > 0 {
+		gIdx = make([]int32, len(raw)/4)
+		for i := range gIdx {
+			gIdx[i] = int32(binary.LittleEndian.Uint32(raw[i*4:]))
+		}
+	}
+	if len(gIdx) != K {
+		return nil, fmt.Errorf("g_idx length mismatch: expected %d, got %d", K, len(gIdx))
+	}
+	for k, g := range gIdx {
+		if g < 0 || int(g) >= kBlocks {
+			return nil, fmt.Errorf("g_idx[%d] = %d out of range [0, %d)", k, g, kBlocks)
 		}
 	}
+	return gIdx, nil
+}
+
+// unpackBits returns the i-th bits-wide value of data, packed low bits first.
+func unpackBits(data []byte, i, bits int) byte {
+	off := i * bits
+	v := uint16(data[off/8])
+	if off%8+bits > 8 {
+		v |= uint16(data[off/8+1]) << 8
+	}
+	return byte(v>>(off%8)) & (1<<bits - 1)
+}
+
+// group returns the block whose scale and zero point apply to column k.
+func (w *nbitsWeight) group(k int) int {
+	if w.gIdx != nil {
+		return int(w.gIdx[k])
+	}
+	return k / w.blockSize
+}
 
+// level returns the quantized value of element k of row n.
+func (w *nbitsWeight) level(n, k int) byte {
+	kblk, i := k/w.blockSize, k%w.blockSize
+	return unpackBits(w.data[n*w.kBlocks*w.bytesPerBlock+kblk*w.bytesPerBlock:], i, w.bits)
+}
+
+// value returns the dequantized element k of row n.
+func (w *nbitsWeight) value(n, k int) float32 {
+	g := n*w.kBlocks + w.group(k)
+	return w.scales[g] * (float32(w.level(n, k)) - w.zeroPoints[g])
+}
+
+// dequantizeTransposed expands the weight to a float32 [K, N] tensor.
+func (w *nbitsWeight) dequantizeTransposed() *zmf.Tensor {
+	N, K := w.N, w.K
+
+	// Dequantize row n of B straight into column n of the [K, N] result so
+	// MatMul(A[..., K], W[K, N]) = [..., N].
 	rawData := make([]byte, K*N*4)
-	for i, v := range transposed {
-		binary.LittleEndian.PutUint32(rawData[i*4:], math.Float32bits(v))
+	for n := 0; n < N; n++ {
+		for k := 0; k < K; k++ {
+			binary.LittleEndian.PutUint32(rawData[(k*N+n)*4:], math.Float32bits(w.value(n, k)))
+		}
 	}
 
 	return &zmf.Tensor{
 		Dtype: zmf.Tensor_FLOAT32,
 		Shape: []int64{int64(K), int64(N)},
 		Data:  rawData,
-	}, nil
+	}
+}
+
+// ggufBlock is the number of values in a Q4_0, Q4_1 or Q8_0 block.
+const ggufBlock = 32
+
+// repack re-encodes the weight as [N, K] Q4_0, Q4_1 or Q8_0 blocks without
+// requantizing. Every 32 values must share a MatMulNBits block, so it reports
+// false unless block_size and K are multiples of 32 and g_idx, if present,
+// maps columns to blocks in order. 2- and 3-bit weights and 8-bit weights with
+// zero points other than 128 have no matching block type.
+//
+// The levels are copied exactly, but the result is only near-lossless: ggml
+// blocks hold fp16 scales, so the Q4_0 scale and the Q4_1 scale and minimum
+// (-scale*zero_point) are rounded to float16 here, and the Q8_0 scale when
+// quantize.GGMLData writes the block. Each value may move by a relative 2^-11
+// of those terms.
+func (w *nbitsWeight) repack() (*zmf.Tensor, bool) {
+	if w.blockSize%ggufBlock != 0 || w.K%ggufBlock != 0 {
+		return nil, false
+	}
+	for k, g := range w.gIdx {
+		if int(g) != k/w.blockSize {
+			return nil, false
+		}
+	}
+	symmetric := true
+	for _, zp := range w.zeroPoints {
+		if zp != float32(int(1)<<(w.bits-1)) {
+			symmetric = false
+		}
+	}
+
+	subBlocks := w.K / ggufBlock
+	t := &zmf.Tensor{Shape: []int64{int64(w.N), int64(w.K)}}
+	switch {
+	case w.bits == 4 && symmetric:
+		// Q4_0 as written by pkg/quantize: fp16 scale, then values 2i and
+		// 2i+1 in the low and high nibble of byte i, as in MatMulNBits.
+		const size = 2 + ggufBlock/2
+		t.Dtype = zmf.Tensor_Q4_0
+		t.Data = make([]byte, w.N*subBlocks*size)
+		for n := range w.N {
+			for s := range subBlocks {
+				k := s * ggufBlock
+				kblk := k / w.blockSize
+				out := t.Data[(n*subBlocks+s)*size:]
+				binary.LittleEndian.PutUint16(out, float16.FromFloat32(w.scales[n*w.kBlocks+kblk]).Bits())
+				src := n*w.kBlocks*w.bytesPerBlock + kblk*w.bytesPerBlock + (k%w.blockSize)/2
+				copy(out[2:size], w.data[src:])
+			}
+		}
+		return t, true
+
+	case w.bits == 4:
+		// Q4_1 in the ggml layout: fp16 scale d and minimum m, then values j
+		// and j+16 in the low and high nibble of byte j. d*q + m = scale*(q - zp).
+		const size = 4 + ggufBlock/2
+		t.Dtype = quantize.DtypeQ4_1
+		t.Data = make([]byte, w.N*subBlocks*size)
+		for n := range w.N {
+			for s := range subBlocks {
+				k := s * ggufBlock
+				g := n*w.kBlocks + k/w.blockSize
+				out := t.Data[(n*subBlocks+s)*size:]
+				binary.LittleEndian.PutUint16(out, float16.FromFloat32(w.scales[g]).Bits())
+				binary.LittleEndian.PutUint16(out[2:], float16.FromFloat32(-w.scales[g]*w.zeroPoints[g]).Bits())
+				for j := range ggufBlock / 2 {
+					out[4+j] = w.level(n, k+j) | w.level(n, k+j+ggufBlock/2)<<4
+				}
+			}
+		}
+		return t, true
+
+	case w.bits == 8 && symmetric:
+		// Q8_0 as written by pkg/quantize: float32 scale, then the 32 values
+		// as int8 q - 128.
+		const size = 4 + ggufBlock
+		t.Dtype = zmf.Tensor_Q8_0
+		t.Data = make([]byte, w.N*subBlocks*size)
+		for n := range w.N {
+			for s := range subBlocks {
+				k := s * ggufBlock
+				out := t.Data[(n*subBlocks+s)*size:]
+				binary.LittleEndian.PutUint32(out, math.Float32bits(w.scales[n*w.kBlocks+k/w.blockSize]))
+				for j := range ggufBlock {
+					out[4+j] = w.level(n, k+j) ^ 0x80
+				}
+			}
+		}
+		return t, true
+	}
+	return nil, false
 }
 
 // convertNode converts an ONNX node to a ZMF node, promoting constant integer
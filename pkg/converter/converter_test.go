@@ -7,6 +7,8 @@ import (
 
 	"github.com/zerfoo/zmf"
 	"github.com/zerfoo/zonnx/internal/onnx"
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 )
 
 // --- convertAttribute tests ---
@@ -310,6 +312,418 @@ func TestONNXToZMF_MatMulNBits_DequantizedToMatMul(t *testing.T) {
 	}
 }
 
+// nbitsSpec describes a single-node MatMulNBits model with weights of shape
+// [N, K]. levels holds the N*K quantized values row by row and scales one
+// scale per block. zeroPoints (packed as uint8) or floatZeroPoints hold one
+// zero point per block when set, and gIdx the block of each column.
+type nbitsSpec struct {
+	N, K, bits, blockSize int
+	levels                []byte
+	scales                []float32
+	zeroPoints            []byte
+	floatZeroPoints       []float32
+	gIdx                  []int32
+}
+
+func (s nbitsSpec) kBlocks() int { return (s.K + s.blockSize - 1) / s.blockSize }
+
+// packBits packs bits-wide values low bits first into n bytes.
+func packBits(vals []byte, bits, n int) []byte {
+	out := make([]byte, n)
+	for i, v := range vals {
+		for b := 0; b < bits; b++ {
+			if v&(1<<b) != 0 {
+				bit := i*bits + b
+				out[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return out
+}
+
+func (s nbitsSpec) model() *onnx.ModelProto {
+	kBlocks := s.kBlocks()
+	blobSize := (s.blockSize*s.bits + 7) / 8
+	var weightBytes []byte
+	for n := 0; n < s.N; n++ {
+		for b := 0; b < kBlocks; b++ {
+			block := make([]byte, s.blockSize)
+			copy(block, s.levels[n*s.K+b*s.blockSize:min(n*s.K+(b+1)*s.blockSize, (n+1)*s.K)])
+			weightBytes = append(weightBytes, packBits(block, s.bits, blobSize)...)
+		}
+	}
+	initializers := []*onnx.TensorProto{
+		{
+			Name:     strPtr("weight_q"),
+			DataType: protoInt32(int32(onnx.TensorProto_UINT8)),
+			Dims:     []int64{int64(s.N), int64(kBlocks), int64(blobSize)},
+			RawData:  weightBytes,
+		},
+		float32Initializer("scales", s.scales),
+	}
+	inputs := []string{"activation", "weight_q", "scales"}
+	switch {
+	case s.zeroPoints != nil:
+		// Each row of packed zero points is padded to a whole byte.
+		rowBytes := (kBlocks*s.bits + 7) / 8
+		var zpBytes []byte
+		for n := 0; n < s.N; n++ {
+			zpBytes = append(zpBytes, packBits(s.zeroPoints[n*kBlocks:(n+1)*kBlocks], s.bits, rowBytes)...)
+		}
+		initializers = append(initializers, &onnx.TensorProto{
+			Name:     strPtr("zero_points"),
+			DataType: protoInt32(int32(onnx.TensorProto_UINT8)),
+			Dims:     []int64{int64(len(zpBytes))},
+			RawData:  zpBytes,
+		})
+		inputs = append(inputs, "zero_points")
+	case s.floatZeroPoints != nil:
+		initializers = append(initializers, float32Initializer("zero_points", s.floatZeroPoints))
+		inputs = append(inputs, "zero_points")
+	}
+	if s.gIdx != nil {
+		if len(inputs) == 3 {
+			inputs = append(inputs, "")
+		}
+		initializers = append(initializers, &onnx.TensorProto{
+			Name:      strPtr("g_idx"),
+			DataType:  protoInt32(int32(onnx.TensorProto_INT32)),
+			Dims:      []int64{int64(len(s.gIdx))},
+			Int32Data: s.gIdx,
+		})
+		inputs = append(inputs, "g_idx")
+	}
+	return &onnx.ModelProto{
+		Graph: &onnx.GraphProto{
+			Initializer: initializers,
+			Node: []*onnx.NodeProto{
+				{
+					Name:   strPtr("matmul_nbits"),
+					OpType: strPtr("MatMulNBits"),
+					Input:  inputs,
+					Output: []string{"matmul_out"},
+					Attribute: []*onnx.AttributeProto{
+						intAttr("K", int64(s.K)),
+						intAttr("N", int64(s.N)),
+						intAttr("bits", int64(s.bits)),
+						intAttr("block_size", int64(s.blockSize)),
+					},
+				},
+			},
+			Input: []*onnx.ValueInfoProto{
+				valueInfo("activation", onnx.TensorProto_FLOAT, []int64{1, int64(s.K)}),
+			},
+			Output: []*onnx.ValueInfoProto{
+				valueInfo("matmul_out", onnx.TensorProto_FLOAT, []int64{1, int64(s.N)}),
+			},
+		},
+	}
+}
+
+// reference dequantizes the weights to [N, K] straight from the spec.
+func (s nbitsSpec) reference() []float32 {
+	out := make([]float32, s.N*s.K)
+	for n := 0; n < s.N; n++ {
+		for k := 0; k < s.K; k++ {
+			g := k / s.blockSize
+			if s.gIdx != nil {
+				g = int(s.gIdx[k])
+			}
+			i := n*s.kBlocks() + g
+			zp := float32(int(1) << (s.bits - 1))
+			switch {
+			case s.zeroPoints != nil:
+				zp = float32(s.zeroPoints[i])
+			case s.floatZeroPoints != nil:
+				zp = s.floatZeroPoints[i]
+			}
+			out[n*s.K+k] = s.scales[i] * (float32(s.levels[n*s.K+k]) - zp)
+		}
+	}
+	return out
+}
+
+// newNBitsSpec fills an nbitsSpec with deterministic levels and scales that
+// are exact in float16.
+func newNBitsSpec(N, K, bits, blockSize int) nbitsSpec {
+	s := nbitsSpec{N: N, K: K, bits: bits, blockSize: blockSize}
+	s.levels = make([]byte, N*K)
+	for i := range s.levels {
+		s.levels[i] = byte((i*7 + i/5) % (1 << bits))
+	}
+	s.scales = make([]float32, N*s.kBlocks())
+	for i := range s.scales {
+		s.scales[i] = float32(i+1) * 0.125
+	}
+	return s
+}
+
+func (s nbitsSpec) withZeroPoints() nbitsSpec {
+	s.zeroPoints = make([]byte, len(s.scales))
+	for i := range s.zeroPoints {
+		s.zeroPoints[i] = byte((3 + i*5) % (1 << s.bits))
+	}
+	return s
+}
+
+func (s nbitsSpec) withFloatZeroPoints() nbitsSpec {
+	s.floatZeroPoints = make([]float32, len(s.scales))
+	for i := range s.floatZeroPoints {
+		s.floatZeroPoints[i] = float32(i%5) + 0.5
+	}
+	return s
+}
+
+// withActOrder assigns columns to blocks out of order, as GPTQ act-order
+// exports do.
+func (s nbitsSpec) withActOrder() nbitsSpec {
+	s.gIdx = make([]int32, s.K)
+	for k := range s.gIdx {
+		s.gIdx[k] = int32((k * 3) % s.kBlocks())
+	}
+	return s
+}
+
+// dequantParam returns the [K, N] float32 weight_q_dequant parameter as [N, K].
+func dequantParam(t *testing.T, m *zmf.Model, N, K int) []float32 {
+	t.Helper()
+	p := m.Graph.Parameters["weight_q_dequant"]
+	if p == nil {
+		t.Fatal("expected dequantized parameter 'weight_q_dequant'")
+	}
+	if len(p.Shape) != 2 || p.Shape[0] != int64(K) || p.Shape[1] != int64(N) {
+		t.Fatalf("shape = %v, want [%d %d]", p.Shape, K, N)
+	}
+	out := make([]float32, N*K)
+	for n := 0; n < N; n++ {
+		for k := 0; k < K; k++ {
+			out[n*K+k] = math.Float32frombits(binary.LittleEndian.Uint32(p.Data[(k*N+n)*4:]))
+		}
+	}
+	return out
+}
+
+func TestONNXToZMF_MatMulNBits_Dequantize(t *testing.T) {
+	tests := []struct {
+		name string
+		spec nbitsSpec
+	}{
+		{name: "2-bit", spec: newNBitsSpec(3, 64, 2, 16)},
+		{name: "2-bit zero points", spec: newNBitsSpec(3, 64, 2, 16).withZeroPoints()},
+		{name: "2-bit float zero points", spec: newNBitsSpec(3, 64, 2, 16).withFloatZeroPoints()},
+		{name: "2-bit act-order", spec: newNBitsSpec(3, 64, 2, 16).withZeroPoints().withActOrder()},
+		{name: "3-bit", spec: newNBitsSpec(3, 96, 3, 32)},
+		{name: "3-bit zero points", spec: newNBitsSpec(3, 96, 3, 32).withZeroPoints()},
+		{name: "3-bit float zero points", spec: newNBitsSpec(3, 96, 3, 32).withFloatZeroPoints()},
+		{name: "3-bit act-order", spec: newNBitsSpec(3, 96, 3, 32).withActOrder()},
+		{name: "4-bit", spec: newNBitsSpec(2, 48, 4, 16)},
+		{name: "4-bit zero points odd blocks", spec: newNBitsSpec(2, 48, 4, 16).withZeroPoints()},
+		{name: "4-bit float zero points", spec: newNBitsSpec(2, 48, 4, 16).withFloatZeroPoints()},
+		{name: "4-bit act-order", spec: newNBitsSpec(2, 48, 4, 16).withZeroPoints().withActOrder()},
+		{name: "4-bit partial last block", spec: newNBitsSpec(2, 40, 4, 16).withZeroPoints()},
+		{name: "8-bit", spec: newNBitsSpec(2, 64, 8, 32)},
+		{name: "8-bit zero points", spec: newNBitsSpec(2, 64, 8, 32).withZeroPoints()},
+		{name: "8-bit float zero points", spec: newNBitsSpec(2, 64, 8, 32).withFloatZeroPoints()},
+		{name: "8-bit act-order", spec: newNBitsSpec(2, 64, 8, 32).withZeroPoints().withActOrder()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ONNXToZMF(tt.spec.model())
+			if err != nil {
+				t.Fatalf("ONNXToZMF failed: %v", err)
+			}
+			got := dequantParam(t, m, tt.spec.N, tt.spec.K)
+			want := tt.spec.reference()
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("value [%d,%d] = %v, want %v", i/tt.spec.K, i%tt.spec.K, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestONNXToZMF_MatMulNBits_InvalidInputs(t *testing.T) {
+	badGIdx := newNBitsSpec(2, 32, 4, 16)
+	badGIdx.gIdx = make([]int32, 32)
+	badGIdx.gIdx[5] = 2
+
+	shortGIdx := newNBitsSpec(2, 32, 4, 16)
+	shortGIdx.gIdx = make([]int32, 31)
+
+	// 4-bit packed data declared as 5 bits.
+	fiveBit := newNBitsSpec(2, 32, 4, 16).model()
+	fiveBit.Graph.Node[0].Attribute[2] = intAttr("bits", 5)
+
+	tests := []struct {
+		name  string
+		model *onnx.ModelProto
+	}{
+		{name: "5-bit", model: fiveBit},
+		{name: "g_idx out of range", model: badGIdx.model()},
+		{name: "g_idx length", model: shortGIdx.model()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ONNXToZMF(tt.model); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestONNXToZMF_MatMulNBits_Repack(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      nbitsSpec
+		wantDtype zmf.Tensor_DataType
+		wantQT    quantize.QuantType
+	}{
+		{name: "4-bit symmetric block 32", spec: newNBitsSpec(3, 64, 4, 32), wantDtype: zmf.Tensor_Q4_0, wantQT: quantize.Q4_0},
+		{name: "4-bit symmetric block 64", spec: newNBitsSpec(3, 128, 4, 64), wantDtype: zmf.Tensor_Q4_0, wantQT: quantize.Q4_0},
+		{name: "4-bit zero points block 32", spec: newNBitsSpec(3, 64, 4, 32).withZeroPoints(), wantDtype: quantize.DtypeQ4_1, wantQT: quantize.Q4_1},
+		{name: "4-bit zero points block 128", spec: newNBitsSpec(3, 256, 4, 128).withZeroPoints(), wantDtype: quantize.DtypeQ4_1, wantQT: quantize.Q4_1},
+		{name: "4-bit float zero points", spec: newNBitsSpec(3, 64, 4, 32).withFloatZeroPoints(), wantDtype: quantize.DtypeQ4_1, wantQT: quantize.Q4_1},
+		{name: "8-bit symmetric block 32", spec: newNBitsSpec(3, 64, 8, 32), wantDtype: zmf.Tensor_Q8_0, wantQT: quantize.Q8_0},
+		{name: "8-bit symmetric block 64", spec: newNBitsSpec(3, 128, 8, 64), wantDtype: zmf.Tensor_Q8_0, wantQT: quantize.Q8_0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			N, K := tt.spec.N, tt.spec.K
+			got, err := ONNXToZMFWithOptions(tt.spec.model(), "", Options{RepackNBits: true})
+			if err != nil {
+				t.Fatalf("ONNXToZMFWithOptions failed: %v", err)
+			}
+
+			packed := got.Graph.Parameters["weight_q_repacked"]
+			if packed == nil {
+				t.Fatal("expected repacked parameter 'weight_q_repacked'")
+			}
+			if packed.Dtype != tt.wantDtype {
+				t.Errorf("dtype = %v, want %v", packed.Dtype, tt.wantDtype)
+			}
+			if len(packed.Shape) != 2 || packed.Shape[0] != int64(N) || packed.Shape[1] != int64(K) {
+				t.Errorf("shape = %v, want [%d %d]", packed.Shape, N, K)
+			}
+			for _, name := range []string{"weight_q", "weight_q_dequant", "scales", "zero_points"} {
+				if got.Graph.Parameters[name] != nil {
+					t.Errorf("unexpected parameter %q", name)
+				}
+			}
+
+			node := got.Graph.Nodes[0]
+			if node.OpType != "MatMul" {
+				t.Errorf("op type = %q, want MatMul", node.OpType)
+			}
+			if len(node.Inputs) != 2 || node.Inputs[1] != "weight_q_repacked" {
+				t.Errorf("inputs = %v, want [activation weight_q_repacked]", node.Inputs)
+			}
+			if a := node.Attributes["transB"]; a == nil || a.GetI() != 1 {
+				t.Errorf("transB = %v, want 1", a)
+			}
+
+			vals, err := quantize.Dequantize(tt.wantQT, packed.Data, N*K)
+			if err != nil {
+				t.Fatalf("Dequantize failed: %v", err)
+			}
+			want := tt.spec.reference()
+			for i := range want {
+				if vals[i] != want[i] {
+					t.Fatalf("value [%d,%d] = %v, want %v", i/K, i%K, vals[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestONNXToZMF_MatMulNBits_RepackRounding(t *testing.T) {
+	// Scales that are not exact in float16 move each value by at most a
+	// relative 2^-11 of scale*level and scale*zero_point once the blocks are
+	// written in the ggml layout.
+	inexact := func(s nbitsSpec) nbitsSpec {
+		for i := range s.scales {
+			s.scales[i] = float32(i+1) * 0.1 / 3
+		}
+		return s
+	}
+	tests := []struct {
+		name string
+		spec nbitsSpec
+	}{
+		{name: "Q4_0", spec: inexact(newNBitsSpec(3, 64, 4, 32))},
+		{name: "Q4_1", spec: inexact(newNBitsSpec(3, 64, 4, 32).withZeroPoints())},
+		{name: "Q8_0", spec: inexact(newNBitsSpec(3, 64, 8, 32))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.spec
+			got, err := ONNXToZMFWithOptions(s.model(), "", Options{RepackNBits: true})
+			if err != nil {
+				t.Fatalf("ONNXToZMFWithOptions failed: %v", err)
+			}
+			packed := got.Graph.Parameters["weight_q_repacked"]
+			if packed == nil {
+				t.Fatal("expected repacked parameter 'weight_q_repacked'")
+			}
+			typ, ok := quantize.GGUFType(packed.Dtype)
+			if !ok {
+				t.Fatalf("no GGUF type for %v", packed.Dtype)
+			}
+			vals, err := quantize.DequantizeTensor(&gguf.Tensor{Type: typ, Shape: []int{s.N, s.K}, Data: quantize.GGMLData(packed)})
+			if err != nil {
+				t.Fatalf("DequantizeTensor failed: %v", err)
+			}
+			want := s.reference()
+			for i := range want {
+				g := i/s.K*s.kBlocks() + i%s.K/s.blockSize
+				zp := 1 << (s.bits - 1)
+				if s.zeroPoints != nil {
+					zp = int(s.zeroPoints[g])
+				}
+				tol := s.scales[g] * float32(int(s.levels[i])+zp) / 2048 * 1.001
+				if d := math.Abs(float64(vals[i] - want[i])); d > float64(tol) {
+					t.Fatalf("value [%d,%d] = %v, want %v within %v", i/s.K, i%s.K, vals[i], want[i], tol)
+				}
+			}
+		})
+	}
+}
+
+func TestONNXToZMF_MatMulNBits_RepackFallback(t *testing.T) {
+	tests := []struct {
+		name string
+		spec nbitsSpec
+	}{
+		// block_size 16 does not fill a 32-value GGUF block.
+		{name: "4-bit block 16", spec: newNBitsSpec(2, 32, 4, 16)},
+		{name: "4-bit act-order", spec: newNBitsSpec(2, 64, 4, 32).withActOrder()},
+		{name: "2-bit", spec: newNBitsSpec(2, 64, 2, 32)},
+		{name: "3-bit", spec: newNBitsSpec(2, 64, 3, 32)},
+		{name: "8-bit zero points", spec: newNBitsSpec(2, 64, 8, 32).withZeroPoints()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ONNXToZMFWithOptions(tt.spec.model(), "", Options{RepackNBits: true})
+			if err != nil {
+				t.Fatalf("ONNXToZMFWithOptions failed: %v", err)
+			}
+			if got.Graph.Parameters["weight_q_repacked"] != nil {
+				t.Error("unexpected repacked parameter")
+			}
+			if _, ok := got.Graph.Nodes[0].Attributes["transB"]; ok {
+				t.Error("unexpected transB attribute on fallback MatMul")
+			}
+			vals := dequantParam(t, got, tt.spec.N, tt.spec.K)
+			want := tt.spec.reference()
+			for i := range want {
+				if vals[i] != want[i] {
+					t.Fatalf("value [%d,%d] = %v, want %v", i/tt.spec.K, i%tt.spec.K, vals[i], want[i])
+				}
+			}
+		})
+	}
+}
+
 // --- E38 operator conversion tests ---
 
 func TestONNXToZMF_Softmax_AxisAttribute(t *testing.T) {
@@ -0,0 +1,45 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// DetectArchitecture infers the architecture of a HuggingFace model
+// directory from its config.json and the tensor names of model.safetensors,
+// as gguf.DetectArchitecture does. A missing config.json leaves only the
+// tensor names to go by.
+func DetectArchitecture(inputDir string) (gguf.Detection, error) {
+	var config map[string]interface{}
+	configData, err := os.ReadFile(filepath.Join(inputDir, "config.json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(configData, &config); err != nil {
+			return gguf.Detection{}, fmt.Errorf("parse config.json: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return gguf.Detection{}, fmt.Errorf("read config.json: %w", err)
+	}
+
+	sf, err := openSafetensors(filepath.Join(inputDir, "model.safetensors"))
+	if err != nil {
+		return gguf.Detection{}, err
+	}
+	defer sf.Close()
+	return gguf.DetectArchitecture(config, sf.names())
+}
+
+// names returns the tensor names of sf in sorted order.
+func (sf *safetensorsFile) names() []string {
+	names := make([]string, 0, len(sf.Tensors))
+	for name := range sf.Tensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
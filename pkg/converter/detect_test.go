@@ -0,0 +1,56 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+func TestDetectArchitecture(t *testing.T) {
+	bertTensors := []rawTensor{
+		{name: "bert.embeddings.word_embeddings.weight", dtype: dtypeF32, shape: []uint64{2, 2}, data: f32Bytes([]float32{1, 2, 3, 4})},
+		{name: "bert.encoder.layer.0.output.dense.bias", dtype: dtypeF32, shape: []uint64{2}, data: f32Bytes([]float32{1, 2})},
+	}
+
+	dir := writeModelDir(t, map[string]interface{}{"model_type": "bert", "hidden_size": 2, "layer_norm_eps": 1e-12}, bertTensors)
+	d, err := DetectArchitecture(dir)
+	if err != nil || d.Name != "bert" {
+		t.Errorf("with config: got %+v, %v, want bert", d, err)
+	}
+
+	// Without config.json the tensor names decide.
+	if err := os.Remove(filepath.Join(dir, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+	d, err = DetectArchitecture(dir)
+	if err != nil || d.Name != "bert" {
+		t.Errorf("from tensor names: got %+v, %v, want bert", d, err)
+	}
+
+	// An empty arch is detected during conversion.
+	dir = writeModelDir(t, map[string]interface{}{"architectures": []string{"BertModel"}, "layer_norm_eps": 1e-12}, bertTensors)
+	outPath := filepath.Join(dir, "out.gguf")
+	if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gf, err := gguf.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := gf.Lookup("general.architecture"); e.Value != "bert" {
+		t.Errorf("general.architecture = %v, want bert", e.Value)
+	}
+	if _, ok := gf.Lookup("bert.attention.layer_norm_epsilon"); !ok {
+		t.Error("missing bert.attention.layer_norm_epsilon")
+	}
+
+	dir = writeModelDir(t, map[string]interface{}{"architectures": []string{"BertModel", "LlamaForCausalLM"}}, bertTensors)
+	if err := ConvertSafetensorsToGGUF(dir, filepath.Join(dir, "out.gguf"), ""); err == nil {
+		t.Error("expected error for conflicting architectures")
+	}
+	if _, err := DetectArchitecture(t.TempDir()); err == nil {
+		t.Error("expected error for a directory without model.safetensors")
+	}
+}
@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// ExpertStacker stacks the per-expert weights of mixture-of-experts layers
+// into one 3-D tensor per layer and projection, [experts, rows, columns], as
+// llama.cpp stores them in ffn_gate_exps, ffn_up_exps and ffn_down_exps.
+// Expert tensors may arrive in any order; each stack is returned once its
+// last expert has been added.
+type ExpertStacker struct {
+	a     *gguf.Architecture
+	want  map[string]int
+	parts map[string][]*gguf.Tensor
+}
+
+// NewExpertStacker prepares to stack the expert tensors among names, the
+// HuggingFace names of a model's tensors, that the expert pattern of a maps.
+// Each stack must have experts numbered from 0 without gaps. A nil a stacks
+// nothing.
+func NewExpertStacker(a *gguf.Architecture, names []string) (*ExpertStacker, error) {
+	s := &ExpertStacker{a: a, want: map[string]int{}, parts: map[string][]*gguf.Tensor{}}
+	if a == nil {
+		return s, nil
+	}
+	experts := map[string][]int{}
+	for _, name := range names {
+		if stacked, expert, ok := a.MapExpertName(name); ok {
+			experts[stacked] = append(experts[stacked], expert)
+		}
+	}
+	for stacked, ids := range experts {
+		slices.Sort(ids)
+		for i, id := range ids {
+			if id != i {
+				return nil, fmt.Errorf("%s: experts %v are not numbered 0 to %d", stacked, ids, len(ids)-1)
+			}
+		}
+		s.want[stacked] = len(ids)
+	}
+	return s, nil
+}
+
+// Add takes the converted tensor t of the source tensor name. It reports
+// false if name is not an expert tensor, which is then written as it is.
+// Otherwise t is held, and once every expert of its stack has been added the
+// stacked tensor is returned. All experts of a stack must have the same type
+// and shape.
+func (s *ExpertStacker) Add(name string, t gguf.Tensor) (*gguf.Tensor, bool, error) {
+	if s.a == nil {
+		return nil, false, nil
+	}
+	stacked, expert, ok := s.a.MapExpertName(name)
+	if !ok {
+		return nil, false, nil
+	}
+	parts := s.parts[stacked]
+	if parts == nil {
+		parts = make([]*gguf.Tensor, s.want[stacked])
+		s.parts[stacked] = parts
+	}
+	if expert >= len(parts) || parts[expert] != nil {
+		return nil, true, fmt.Errorf("%s: unexpected expert %d", stacked, expert)
+	}
+	parts[expert] = &t
+	for _, p := range parts {
+		if p == nil {
+			return nil, true, nil
+		}
+	}
+	delete(s.parts, stacked)
+
+	first := parts[0]
+	out := &gguf.Tensor{Name: stacked, Type: first.Type, Shape: append([]int{len(parts)}, first.Shape...)}
+	out.Data = make([]byte, 0, len(parts)*len(first.Data))
+	for i, p := range parts {
+		if p.Type != first.Type || !slices.Equal(p.Shape, first.Shape) || len(p.Data) != len(first.Data) {
+			return nil, true, fmt.Errorf("%s: expert %d is type %d shape %v, expert 0 type %d shape %v", stacked, i, p.Type, p.Shape, first.Type, first.Shape)
+		}
+		out.Data = append(out.Data, p.Data...)
+	}
+	return out, true, nil
+}
@@ -0,0 +1,203 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+)
+
+// expertWeight returns the values of a [2, 3] expert weight that identify
+// the expert and projection.
+func expertWeight(expert, proj int) []float32 {
+	vals := make([]float32, 6)
+	for i := range vals {
+		vals[i] = float32(100*expert + 10*proj + i)
+	}
+	return vals
+}
+
+func TestExpertStacker(t *testing.T) {
+	llama, _ := gguf.LookupArchitecture("llama")
+	name := func(e int) string { return fmt.Sprintf("model.layers.0.block_sparse_moe.experts.%d.w1.weight", e) }
+	part := func(e int) gguf.Tensor {
+		return gguf.Tensor{Name: name(e), Type: sharedgguf.TypeF32, Shape: []int{2, 3}, Data: f32Bytes(expertWeight(e, 1))}
+	}
+
+	s, err := NewExpertStacker(llama, []string{name(2), name(0), name(1), "model.norm.weight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, isExpert, err := s.Add("model.norm.weight", gguf.Tensor{}); isExpert || err != nil {
+		t.Errorf("model.norm.weight: expert %v, err %v", isExpert, err)
+	}
+	for _, e := range []int{2, 0} {
+		if stacked, isExpert, err := s.Add(name(e), part(e)); stacked != nil || !isExpert || err != nil {
+			t.Fatalf("expert %d: got %v, %v, %v before the last expert", e, stacked, isExpert, err)
+		}
+	}
+	if _, _, err := s.Add(name(0), part(0)); err == nil {
+		t.Error("expected error for a repeated expert")
+	}
+	stacked, _, err := s.Add(name(1), part(1))
+	if err != nil || stacked == nil {
+		t.Fatalf("last expert: %v, %v", stacked, err)
+	}
+	if stacked.Name != "blk.0.ffn_gate_exps.weight" || !slices.Equal(stacked.Shape, []int{3, 2, 3}) {
+		t.Errorf("stacked %s %v, want blk.0.ffn_gate_exps.weight [3 2 3]", stacked.Name, stacked.Shape)
+	}
+	vals, err := quantize.DequantizeTensor(stacked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := slices.Concat(expertWeight(0, 1), expertWeight(1, 1), expertWeight(2, 1)); !slices.Equal(vals, want) {
+		t.Errorf("stacked values = %v, want %v", vals, want)
+	}
+
+	if _, err := NewExpertStacker(llama, []string{name(0), name(2)}); err == nil || !strings.Contains(err.Error(), "not numbered") {
+		t.Errorf("gap in experts: err = %v", err)
+	}
+	s, _ = NewExpertStacker(llama, []string{name(0), name(1)})
+	s.Add(name(0), part(0))
+	odd := part(1)
+	odd.Shape, odd.Data = []int{3, 2}, f32Bytes(expertWeight(1, 1))
+	if _, _, err := s.Add(name(1), odd); err == nil {
+		t.Error("expected error for experts of different shapes")
+	}
+	if _, isExpert, _ := (&ExpertStacker{}).Add(name(0), part(0)); isExpert {
+		t.Error("a stacker without architecture stacked a tensor")
+	}
+}
+
+func TestConvertSafetensorsToGGUF_MoE(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		expert  string // per-expert name format: layer, expert, projection
+		projs   [3]string
+		extra   map[string]string // source → GGUF names of the other tensors
+		wantArc string
+		wantMD  map[string]uint32
+	}{
+		{
+			name:    "mixtral",
+			config:  map[string]interface{}{"model_type": "mixtral", "num_local_experts": 2, "num_experts_per_tok": 2, "num_attention_heads": 1},
+			expert:  "model.layers.%d.block_sparse_moe.experts.%d.%s.weight",
+			projs:   [3]string{"w1", "w3", "w2"},
+			extra:   map[string]string{"model.layers.0.block_sparse_moe.gate.weight": "blk.0.ffn_gate_inp.weight"},
+			wantArc: "llama",
+			wantMD:  map[string]uint32{"llama.expert_count": 2, "llama.expert_used_count": 2},
+		},
+		{
+			name: "qwen2 moe",
+			config: map[string]interface{}{
+				"architectures": []string{"Qwen2MoeForCausalLM"}, "num_experts": 2, "num_experts_per_tok": 1,
+				"moe_intermediate_size": 2, "shared_expert_intermediate_size": 4,
+			},
+			expert: "model.layers.%d.mlp.experts.%d.%s_proj.weight",
+			projs:  [3]string{"gate", "up", "down"},
+			extra: map[string]string{
+				"model.layers.0.mlp.gate.weight":                    "blk.0.ffn_gate_inp.weight",
+				"model.layers.0.mlp.shared_expert.gate_proj.weight": "blk.0.ffn_gate_shexp.weight",
+				"model.layers.0.mlp.shared_expert.up_proj.weight":   "blk.0.ffn_up_shexp.weight",
+				"model.layers.0.mlp.shared_expert.down_proj.weight": "blk.0.ffn_down_shexp.weight",
+				"model.layers.0.mlp.shared_expert_gate.weight":      "blk.0.ffn_gate_inp_shexp.weight",
+				"model.layers.0.self_attn.q_proj.bias":              "blk.0.attn_q.bias",
+			},
+			wantArc: "qwen2moe",
+			wantMD: map[string]uint32{
+				"qwen2moe.expert_count": 2, "qwen2moe.expert_used_count": 1,
+				"qwen2moe.expert_feed_forward_length": 2, "qwen2moe.expert_shared_feed_forward_length": 4,
+			},
+		},
+	}
+	stackedNames := [3]string{"ffn_gate_exps", "ffn_up_exps", "ffn_down_exps"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tensors []rawTensor
+			for layer := range 2 {
+				for e := range 2 {
+					for p, proj := range tt.projs {
+						tensors = append(tensors, rawTensor{
+							name: fmt.Sprintf(tt.expert, layer, e, proj), dtype: dtypeF32, shape: []uint64{2, 3},
+							data: f32Bytes(expertWeight(10*layer+e, p)),
+						})
+					}
+				}
+			}
+			for name := range tt.extra {
+				tensors = append(tensors, rawTensor{name: name, dtype: dtypeF32, shape: []uint64{2}, data: f32Bytes([]float32{1, 2})})
+			}
+			dir := writeModelDir(t, tt.config, tensors)
+			outPath := filepath.Join(dir, "out.gguf")
+			if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			gf, err := gguf.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]bool{}
+			for _, g := range tt.extra {
+				want[g] = true
+			}
+			for layer := range 2 {
+				for p, s := range stackedNames {
+					want[fmt.Sprintf("blk.%d.%s.weight", layer, s)] = true
+					got := findTensor(gf, fmt.Sprintf("blk.%d.%s.weight", layer, s))
+					if got == nil {
+						t.Fatalf("missing blk.%d.%s.weight", layer, s)
+					}
+					if !slices.Equal(got.Shape, []int{2, 2, 3}) {
+						t.Errorf("%s shape = %v, want [2 2 3]", got.Name, got.Shape)
+					}
+					vals, err := quantize.DequantizeTensor(got)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if w := slices.Concat(expertWeight(10*layer, p), expertWeight(10*layer+1, p)); !slices.Equal(vals, w) {
+						t.Errorf("%s = %v, want %v", got.Name, vals, w)
+					}
+				}
+			}
+			if len(gf.Tensors) != len(want) {
+				for i := range gf.Tensors {
+					if !want[gf.Tensors[i].Name] {
+						t.Errorf("unexpected tensor %q", gf.Tensors[i].Name)
+					}
+				}
+				t.Fatalf("got %d tensors, want %d", len(gf.Tensors), len(want))
+			}
+			for name := range want {
+				if findTensor(gf, name) == nil {
+					t.Errorf("missing tensor %q", name)
+				}
+			}
+
+			if e, _ := gf.Lookup("general.architecture"); e.Value != tt.wantArc {
+				t.Errorf("general.architecture = %v, want %s", e.Value, tt.wantArc)
+			}
+			for key, v := range tt.wantMD {
+				if e, ok := gf.Lookup(key); !ok || e.Value != v {
+					t.Errorf("%s = %v (present %v), want %d", key, e.Value, ok, v)
+				}
+			}
+		})
+	}
+}
+
+// findTensor returns the tensor of f named name, or nil.
+func findTensor(f *gguf.File, name string) *gguf.Tensor {
+	for i := range f.Tensors {
+		if f.Tensors[i].Name == name {
+			return &f.Tensors[i]
+		}
+	}
+	return nil
+}
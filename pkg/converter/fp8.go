@@ -0,0 +1,58 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/zerfoo/zonnx/pkg/quantize"
+	"github.com/zerfoo/zonnx/safetensors"
+)
+
+// fp8Formats maps the SafeTensors FP8 dtypes to their encodings.
+var fp8Formats = map[safetensorsDtype]quantize.FP8Format{
+	dtypeF8E4M3: quantize.FP8E4M3FN,
+	dtypeF8E5M2: quantize.FP8E5M2,
+}
+
+// fp8Scales returns safetensors.FP8Companions for the tensors of sf.
+func fp8Scales(sf *safetensorsFile) (scales map[string]string, companions map[string]bool) {
+	dtypes := make(map[string]string, len(sf.Tensors))
+	for name, info := range sf.Tensors {
+		dtypes[name] = string(info.Dtype)
+	}
+	return safetensors.FP8Companions(dtypes)
+}
+
+// readFP8 decodes the FP8 tensor name and multiplies it by the scale tensor
+// scaleName, if any. block is the [rows, cols] block size of 2-D scale
+// grids; when nil it is inferred from the grid shape.
+func (sf *safetensorsFile) readFP8(name, scaleName string, block []int) ([]float32, error) {
+	info := sf.Tensors[name]
+	data, err := sf.ReadTensorData(name)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := quantize.DecodeFP8(fp8Formats[info.Dtype], data)
+	if err != nil {
+		return nil, fmt.Errorf("tensor %q: %w", name, err)
+	}
+	if scaleName == "" {
+		return vals, nil
+	}
+	scales, scaleShape, err := sf.readFloats(scaleName)
+	if err != nil {
+		return nil, err
+	}
+	if err := quantize.ApplyScales(vals, intShape(info.Shape), scales, intShape(scaleShape), block); err != nil {
+		return nil, fmt.Errorf("tensor %q: %w", name, err)
+	}
+	return vals, nil
+}
+
+// intShape converts a SafeTensors shape to []int.
+func intShape(shape []uint64) []int {
+	out := make([]int, len(shape))
+	for i, d := range shape {
+		out[i] = int(d)
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package converter
+
+import (
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"testing"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+)
+
+func f32Bytes(vals []float32) []byte {
+	out := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(out[i*4:], math.Float32bits(v))
+	}
+	return out
+}
+
+func TestConvertSafetensorsToGGUF_FP8(t *testing.T) {
+	const (
+		rows, cols = 4, 8
+		name       = "model.layers.0.mlp.down_proj.weight"
+	)
+	// Finite FP8 bytes of both signs; 0x7F and 0x7C and up are NaN or
+	// infinite in E4M3 and E5M2.
+	fp8 := make([]byte, rows*cols)
+	for i := range fp8 {
+		fp8[i] = byte(i*5%0x70) | byte(i%2)<<7
+	}
+
+	tests := []struct {
+		name       string
+		dtype      safetensorsDtype
+		suffix     string
+		scales     []float32
+		scaleShape []uint64
+		block      []int // weight_block_size; nil omits quantization_config
+		opts       *SafetensorsOptions
+		wantType   int
+	}{
+		{name: "e4m3 unscaled", dtype: dtypeF8E4M3, wantType: sharedgguf.TypeBF16},
+		{name: "e5m2 unscaled", dtype: dtypeF8E5M2, wantType: sharedgguf.TypeBF16},
+		{name: "per-tensor scale_inv", dtype: dtypeF8E4M3, suffix: "_scale_inv", scales: []float32{0.5}, scaleShape: []uint64{}, wantType: sharedgguf.TypeBF16},
+		{name: "per-row scale", dtype: dtypeF8E4M3, suffix: "_scale", scales: []float32{1, 2, 0.25, 4}, scaleShape: []uint64{rows, 1}, wantType: sharedgguf.TypeBF16},
+		{
+			name: "block scale_inv", dtype: dtypeF8E4M3, suffix: "_scale_inv", scales: []float32{1, 2, 4, 8}, scaleShape: []uint64{2, 2},
+			block: []int{2, 4}, wantType: sharedgguf.TypeBF16,
+		},
+		{
+			name: "inferred blocks f16", dtype: dtypeF8E4M3, suffix: "_scale_inv", scales: []float32{0.5, 2, 4, 0.125}, scaleShape: []uint64{2, 2},
+			opts: &SafetensorsOptions{FP8Type: sharedgguf.TypeF16}, wantType: sharedgguf.TypeF16,
+		},
+		{
+			name: "e5m2 f32", dtype: dtypeF8E5M2, suffix: "_scale", scales: []float32{3}, scaleShape: []uint64{1},
+			opts: &SafetensorsOptions{FP8Type: sharedgguf.TypeF32}, wantType: sharedgguf.TypeF32,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensors := []rawTensor{{name: name, dtype: tt.dtype, shape: []uint64{rows, cols}, data: fp8}}
+			if tt.suffix != "" {
+				tensors = append(tensors, rawTensor{name: name + tt.suffix, dtype: dtypeF32, shape: tt.scaleShape, data: f32Bytes(tt.scales)})
+			}
+			tensors = append(tensors, rawTensor{name: "model.layers.0.mlp.down_proj.input_scale", dtype: dtypeF32, shape: []uint64{}, data: f32Bytes([]float32{1})})
+			config := map[string]interface{}{"hidden_size": 8}
+			if tt.block != nil {
+				config["quantization_config"] = map[string]interface{}{"quant_method": "fp8", "weight_block_size": tt.block}
+			}
+			dir := writeModelDir(t, config, tensors)
+			outPath := filepath.Join(dir, "out.gguf")
+			var err error
+			if tt.opts != nil {
+				err = ConvertSafetensorsToGGUFWithOptions(dir, outPath, "llama", *tt.opts)
+			} else {
+				err = ConvertSafetensorsToGGUF(dir, outPath, "llama")
+			}
+			if err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+
+			gf, err := gguf.ReadFile(outPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			if len(gf.Tensors) != 1 {
+				t.Fatalf("got %d tensors, want only the weight", len(gf.Tensors))
+			}
+			got := &gf.Tensors[0]
+			if got.Name != gguf.MapTensorName(name) {
+				t.Errorf("name = %q, want %q", got.Name, gguf.MapTensorName(name))
+			}
+			if got.Type != tt.wantType {
+				t.Errorf("type = %d, want %d", got.Type, tt.wantType)
+			}
+			vals, err := quantize.DequantizeTensor(got)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			format := quantize.FP8E4M3FN
+			if tt.dtype == dtypeF8E5M2 {
+				format = quantize.FP8E5M2
+			}
+			want, err := quantize.DecodeFP8(format, fp8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.scales != nil {
+				scaleShape := intShape(tt.scaleShape)
+				if err := quantize.ApplyScales(want, []int{rows, cols}, tt.scales, scaleShape, tt.block); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// FP8 values times powers of two are exact in every target type.
+			for i := range want {
+				if vals[i] != want[i] {
+					t.Fatalf("value [%d,%d] = %v, want %v", i/cols, i%cols, vals[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConvertSafetensorsToGGUF_FP8BadScale(t *testing.T) {
+	const name = "model.layers.0.mlp.up_proj.weight"
+	tensors := []rawTensor{
+		{name: name, dtype: dtypeF8E4M3, shape: []uint64{4, 4}, data: make([]byte, 16)},
+		{name: name + "_scale_inv", dtype: dtypeF32, shape: []uint64{3, 3}, data: f32Bytes(make([]float32, 9))},
+	}
+	config := map[string]interface{}{"quantization_config": map[string]interface{}{"quant_method": "fp8", "weight_block_size": []int{2, 2}}}
+	dir := writeModelDir(t, config, tensors)
+	if err := ConvertSafetensorsToGGUF(dir, filepath.Join(dir, "out.gguf"), "llama"); err == nil {
+		t.Error("expected error for a scale grid that does not tile the weight")
+	}
+	dir = writeModelDir(t, map[string]interface{}{}, tensors[:1])
+	if err := ConvertSafetensorsToGGUFWithOptions(dir, filepath.Join(dir, "out.gguf"), "llama", SafetensorsOptions{FP8Type: sharedgguf.TypeQ8_0}); err == nil {
+		t.Error("expected error for a non-float FP8 target type")
+	}
+}
@@ -0,0 +1,203 @@
+package converter
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// SplitTensor cuts t by rows into the parts of the first split transform of
+// architecture definition a that matches t.Name and is enabled by config, as
+// Phi-3's fused query, key and value projection into attn_q, attn_k and
+// attn_v. The row counts are read from config (see gguf.TensorPart). The
+// parts share t's data. Otherwise, and for a nil a, SplitTensor returns t
+// alone.
+//
+// Rows are cut as whole runs of bytes, so block-quantized tensors split
+// wherever their rows are whole blocks.
+func SplitTensor(a *gguf.Architecture, config map[string]interface{}, t gguf.Tensor) ([]gguf.Tensor, error) {
+	tr := findTransform(a, config, gguf.OpSplit, t.Name)
+	if tr == nil {
+		return []gguf.Tensor{t}, nil
+	}
+	if len(t.Shape) == 0 || t.Shape[0] == 0 || len(t.Data)%t.Shape[0] != 0 {
+		return nil, fmt.Errorf("tensor %q: split: shape %v does not split into rows of %d bytes", t.Name, t.Shape, len(t.Data))
+	}
+	rowBytes := len(t.Data) / t.Shape[0]
+	counts := make([]int, len(tr.Parts))
+	rest, total := -1, 0
+	for i, p := range tr.Parts {
+		n, given, err := partRows(config, p)
+		if err != nil {
+			return nil, fmt.Errorf("tensor %q: split: %w", t.Name, err)
+		}
+		if !given {
+			rest = i
+		}
+		counts[i] = n
+		total += n
+	}
+	if rest >= 0 && total < t.Shape[0] {
+		counts[rest] = t.Shape[0] - total
+		total = t.Shape[0]
+	}
+	if total != t.Shape[0] || slices.Contains(counts, 0) {
+		return nil, fmt.Errorf("tensor %q: split: %d rows do not split into %v", t.Name, t.Shape[0], counts)
+	}
+
+	parts := make([]gguf.Tensor, len(tr.Parts))
+	start := 0
+	for i, p := range tr.Parts {
+		end := start + counts[i]*rowBytes
+		parts[i] = gguf.Tensor{
+			Name:  tr.Expand(t.Name, p.Name),
+			Type:  t.Type,
+			Shape: append([]int{counts[i]}, t.Shape[1:]...),
+			Data:  t.Data[start:end],
+		}
+		start = end
+	}
+	return parts, nil
+}
+
+// TensorConcatenator joins tensors by rows as the concat transforms of an
+// architecture definition declare, as attn_q, attn_k and attn_v into a fused
+// attn_qkv for runtimes that read one. Parts may arrive in any order; each
+// joined tensor is returned once its last part has been added.
+type TensorConcatenator struct {
+	a      *gguf.Architecture
+	config map[string]interface{}
+	parts  map[string][]*gguf.Tensor
+}
+
+// NewTensorConcatenator prepares to join the tensors among names, the GGUF
+// names of a model's tensors before any split, that the concat transforms of
+// a enabled by config match. Every part of a joined tensor must be present
+// once split transforms are applied. A nil a joins nothing.
+func NewTensorConcatenator(a *gguf.Architecture, config map[string]interface{}, names []string) (*TensorConcatenator, error) {
+	c := &TensorConcatenator{a: a, config: config, parts: map[string][]*gguf.Tensor{}}
+	if a == nil {
+		return c, nil
+	}
+	var all []string
+	for _, name := range names {
+		if tr := findTransform(a, config, gguf.OpSplit, name); tr != nil {
+			for _, p := range tr.Parts {
+				all = append(all, tr.Expand(name, p.Name))
+			}
+			continue
+		}
+		all = append(all, name)
+	}
+	for _, name := range all {
+		tr, _ := c.find(name)
+		if tr == nil {
+			continue
+		}
+		for _, p := range tr.Parts {
+			if part := tr.Expand(name, p.Name); !slices.Contains(all, part) {
+				return nil, fmt.Errorf("%s: missing part %s of %s", tr.Expand(name, tr.Into), part, name)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Add takes the converted tensor t. It reports false if t is not a part of a
+// concat transform, and is then written as it is. Otherwise t is held, and
+// once every part has been added the joined tensor is returned. All parts
+// must have the same type and the same shape apart from their rows.
+func (c *TensorConcatenator) Add(t gguf.Tensor) (*gguf.Tensor, bool, error) {
+	tr, i := c.find(t.Name)
+	if tr == nil {
+		return nil, false, nil
+	}
+	into := tr.Expand(t.Name, tr.Into)
+	if n, given, err := partRows(c.config, tr.Parts[i]); err != nil {
+		return nil, true, fmt.Errorf("%s: %w", into, err)
+	} else if given && (len(t.Shape) == 0 || t.Shape[0] != n) {
+		return nil, true, fmt.Errorf("%s: part %s has shape %v, want %d rows", into, t.Name, t.Shape, n)
+	}
+	parts := c.parts[into]
+	if parts == nil {
+		parts = make([]*gguf.Tensor, len(tr.Parts))
+		c.parts[into] = parts
+	}
+	if parts[i] != nil {
+		return nil, true, fmt.Errorf("%s: repeated part %s", into, t.Name)
+	}
+	parts[i] = &t
+	for _, p := range parts {
+		if p == nil {
+			return nil, true, nil
+		}
+	}
+	delete(c.parts, into)
+
+	first := parts[0]
+	out := &gguf.Tensor{Name: into, Type: first.Type}
+	rows := 0
+	for _, p := range parts {
+		if p.Type != first.Type || len(p.Shape) == 0 || !slices.Equal(p.Shape[1:], first.Shape[1:]) {
+			return nil, true, fmt.Errorf("%s: part %s is type %d shape %v, part %s type %d shape %v", into, p.Name, p.Type, p.Shape, first.Name, first.Type, first.Shape)
+		}
+		rows += p.Shape[0]
+		out.Data = append(out.Data, p.Data...)
+	}
+	out.Shape = append([]int{rows}, first.Shape[1:]...)
+	return out, true, nil
+}
+
+// find returns the concat transform that the tensor named name is a part of
+// and the index of that part, or nil.
+func (c *TensorConcatenator) find(name string) (*gguf.TensorTransform, int) {
+	if c.a == nil {
+		return nil, 0
+	}
+	for i := range c.a.Transforms {
+		tr := &c.a.Transforms[i]
+		if tr.Op != gguf.OpConcat || !tr.Matches(name) || !tr.Enabled(c.config) {
+			continue
+		}
+		for j, p := range tr.Parts {
+			if tr.Expand(name, p.Name) == name {
+				return tr, j
+			}
+		}
+	}
+	return nil, 0
+}
+
+// findTransform returns the first transform of a with operation op that
+// matches name and is enabled by config, or nil.
+func findTransform(a *gguf.Architecture, config map[string]interface{}, op, name string) *gguf.TensorTransform {
+	if a == nil {
+		return nil
+	}
+	for i := range a.Transforms {
+		tr := &a.Transforms[i]
+		if tr.Op == op && tr.Matches(name) && tr.Enabled(config) {
+			return tr
+		}
+	}
+	return nil
+}
+
+// partRows returns the row count of p from config, and false if p gives no
+// rows or heads.
+func partRows(config map[string]interface{}, p gguf.TensorPart) (int, bool, error) {
+	switch {
+	case len(p.Rows) > 0:
+		n, err := configInt(config, p.Rows)
+		return n, true, err
+	case len(p.Heads) > 0:
+		heads, err := configInt(config, p.Heads)
+		if err != nil {
+			return 0, true, err
+		}
+		dim, err := gguf.HeadDim(config)
+		return heads * dim, true, err
+	}
+	return 0, false, nil
+}
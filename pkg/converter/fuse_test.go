@@ -0,0 +1,221 @@
+package converter
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+)
+
+// fusedYAML splits fused query, key and value projections and joins
+// separate gate and up projections, the way a runtime reading Phi-3 with
+// separate attention projections would want them.
+const fusedYAML = `
+name: fusedtest
+layer_pattern: '^fused\.layers\.(\d+)\.(.+)$'
+layer_suffixes:
+  qkv_proj.weight: attn_qkv.weight
+  qkv_proj.bias: attn_qkv.bias
+  gate_proj.weight: ffn_gate.weight
+  up_proj.weight: ffn_up.weight
+transforms:
+  - tensors: '^blk\.(\d+)\.attn_qkv\.(weight|bias)$'
+    op: split
+    parts:
+      - {name: 'blk.$1.attn_q.$2', heads: [num_attention_heads]}
+      - {name: 'blk.$1.attn_k.$2', heads: [num_key_value_heads, num_attention_heads]}
+      - {name: 'blk.$1.attn_v.$2'}
+  - tensors: '^blk\.(\d+)\.ffn_(gate|up)\.weight$'
+    op: concat
+    into: 'blk.$1.ffn_up.weight'
+    parts:
+      - {name: 'blk.$1.ffn_gate.weight', rows: [intermediate_size]}
+      - {name: 'blk.$1.ffn_up.weight', rows: [intermediate_size]}
+  - {tensors: '^blk\.\d+\.attn_q\.weight$', op: scale, value: 2}
+`
+
+// fusedConfig has 4 heads of dim 2 and 1 key-value head, so a fused
+// projection has 8 query rows and 2 each of key and value.
+var fusedConfig = map[string]interface{}{
+	"hidden_size": 8.0, "num_attention_heads": 4.0, "num_key_value_heads": 1.0, "intermediate_size": 3.0,
+}
+
+func TestSplitTensor(t *testing.T) {
+	a, err := gguf.ParseArchitecture([]byte(fusedYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qkv := gguf.Tensor{Name: "blk.0.attn_qkv.weight", Type: sharedgguf.TypeF32, Shape: []int{12, 2}, Data: f32Bytes(rows(12))}
+	parts, err := SplitTensor(a, fusedConfig, qkv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name  string
+		order []float32
+	}{
+		{"blk.0.attn_q.weight", []float32{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"blk.0.attn_k.weight", []float32{8, 9}},
+		{"blk.0.attn_v.weight", []float32{10, 11}},
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("got %d parts, want %d", len(parts), len(want))
+	}
+	for i, w := range want {
+		if parts[i].Name != w.name || !slices.Equal(parts[i].Shape, []int{len(w.order), 2}) {
+			t.Errorf("part %d = %s %v, want %s [%d 2]", i, parts[i].Name, parts[i].Shape, w.name, len(w.order))
+		}
+		if got := rowOrder(t, &parts[i]); !slices.Equal(got, w.order) {
+			t.Errorf("%s rows = %v, want %v", w.name, got, w.order)
+		}
+	}
+
+	bias := gguf.Tensor{Name: "blk.1.attn_qkv.bias", Type: sharedgguf.TypeF32, Shape: []int{12}, Data: f32Bytes(rows(6))}
+	if parts, err := SplitTensor(a, fusedConfig, bias); err != nil || parts[2].Name != "blk.1.attn_v.bias" || !slices.Equal(parts[2].Shape, []int{2}) {
+		t.Errorf("bias: %v, %v", parts, err)
+	}
+	short := qkv
+	short.Shape, short.Data = []int{10, 2}, f32Bytes(rows(10))
+	if _, err := SplitTensor(a, fusedConfig, short); err == nil || !strings.Contains(err.Error(), "do not split") {
+		t.Errorf("too few rows: err = %v", err)
+	}
+	if _, err := SplitTensor(a, map[string]interface{}{"num_attention_heads": 4.0}, qkv); err == nil {
+		t.Error("expected error without hidden_size or head_dim")
+	}
+	other := gguf.Tensor{Name: "blk.0.attn_q.weight", Shape: []int{2}}
+	if parts, err := SplitTensor(a, fusedConfig, other); err != nil || len(parts) != 1 || parts[0].Name != other.Name {
+		t.Errorf("unmatched tensor: %v, %v", parts, err)
+	}
+}
+
+func TestTensorConcatenator(t *testing.T) {
+	a, err := gguf.ParseArchitecture([]byte(fusedYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part := func(name string, first int) gguf.Tensor {
+		vals := rows(first + 3)[2*first:]
+		return gguf.Tensor{Name: name, Type: sharedgguf.TypeF32, Shape: []int{3, 2}, Data: f32Bytes(vals)}
+	}
+
+	c, err := NewTensorConcatenator(a, fusedConfig, []string{"blk.0.ffn_gate.weight", "blk.0.ffn_up.weight", "blk.0.attn_qkv.weight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, isPart, err := c.Add(gguf.Tensor{Name: "blk.0.attn_q.weight"}); isPart || err != nil {
+		t.Errorf("attn_q: part %v, err %v", isPart, err)
+	}
+	if joined, isPart, err := c.Add(part("blk.0.ffn_up.weight", 3)); joined != nil || !isPart || err != nil {
+		t.Fatalf("up: got %v, %v, %v before the last part", joined, isPart, err)
+	}
+	if _, _, err := c.Add(part("blk.0.ffn_up.weight", 3)); err == nil {
+		t.Error("expected error for a repeated part")
+	}
+	joined, _, err := c.Add(part("blk.0.ffn_gate.weight", 0))
+	if err != nil || joined == nil {
+		t.Fatalf("gate: %v, %v", joined, err)
+	}
+	if joined.Name != "blk.0.ffn_up.weight" || !slices.Equal(joined.Shape, []int{6, 2}) {
+		t.Errorf("joined %s %v, want blk.0.ffn_up.weight [6 2]", joined.Name, joined.Shape)
+	}
+	if got := rowOrder(t, joined); !slices.Equal(got, []float32{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("joined rows = %v, want gate rows then up rows", got)
+	}
+
+	if _, err := NewTensorConcatenator(a, fusedConfig, []string{"blk.0.ffn_gate.weight"}); err == nil || !strings.Contains(err.Error(), "missing part blk.0.ffn_up.weight") {
+		t.Errorf("missing part: err = %v", err)
+	}
+	c, _ = NewTensorConcatenator(a, fusedConfig, nil)
+	wide := gguf.Tensor{Name: "blk.0.ffn_gate.weight", Type: sharedgguf.TypeF32, Shape: []int{4, 2}, Data: f32Bytes(rows(4))}
+	if _, _, err := c.Add(wide); err == nil || !strings.Contains(err.Error(), "want 3 rows") {
+		t.Errorf("wrong row count: err = %v", err)
+	}
+}
+
+func TestConvertSafetensorsToGGUF_Fused(t *testing.T) {
+	a, err := gguf.ParseArchitecture([]byte(fusedYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gguf.RegisterArchitecture(a); err != nil {
+		t.Fatal(err)
+	}
+	tensors := []rawTensor{
+		{name: "fused.layers.0.qkv_proj.weight", dtype: dtypeF32, shape: []uint64{12, 2}, data: f32Bytes(rows(12))},
+		{name: "fused.layers.0.qkv_proj.bias", dtype: dtypeF32, shape: []uint64{12}, data: f32Bytes(rows(6))},
+		{name: "fused.layers.0.up_proj.weight", dtype: dtypeF32, shape: []uint64{3, 2}, data: f32Bytes(rows(6)[6:])},
+		{name: "fused.layers.0.gate_proj.weight", dtype: dtypeF32, shape: []uint64{3, 2}, data: f32Bytes(rows(3))},
+	}
+	dir := writeModelDir(t, fusedConfig, tensors)
+	outPath := filepath.Join(dir, "out.gguf")
+	if err := ConvertSafetensorsToGGUF(dir, outPath, "fusedtest"); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gf, err := gguf.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{
+		"blk.0.attn_q.weight": {8, 2}, "blk.0.attn_k.weight": {2, 2}, "blk.0.attn_v.weight": {2, 2},
+		"blk.0.attn_q.bias": {8}, "blk.0.attn_k.bias": {2}, "blk.0.attn_v.bias": {2},
+		"blk.0.ffn_up.weight": {6, 2},
+	}
+	if len(gf.Tensors) != len(want) {
+		t.Errorf("got %d tensors, want %d", len(gf.Tensors), len(want))
+	}
+	for name, shape := range want {
+		got := findTensor(gf, name)
+		if got == nil {
+			t.Errorf("missing %s", name)
+			continue
+		}
+		if !slices.Equal(got.Shape, shape) {
+			t.Errorf("%s shape = %v, want %v", name, got.Shape, shape)
+		}
+	}
+	// Transforms see the split parts.
+	if q := findTensor(gf, "blk.0.attn_q.weight"); q != nil {
+		if got := rowOrder(t, q); !slices.Equal(got, []float32{0, 2, 4, 6, 8, 10, 12, 14}) {
+			t.Errorf("scaled attn_q rows = %v", got)
+		}
+	}
+	if up := findTensor(gf, "blk.0.ffn_up.weight"); up != nil {
+		if got := rowOrder(t, up); !slices.Equal(got, []float32{0, 1, 2, 3, 4, 5}) {
+			t.Errorf("joined ffn_up rows = %v, want gate rows then up rows", got)
+		}
+	}
+}
+
+func TestConvertSafetensorsToGGUF_Phi3(t *testing.T) {
+	config := map[string]interface{}{
+		"architectures": []string{"Phi3ForCausalLM"}, "hidden_size": 8, "num_attention_heads": 4,
+		"num_key_value_heads": 4, "intermediate_size": 3, "sliding_window": 2047,
+	}
+	tensors := []rawTensor{
+		{name: "model.layers.0.self_attn.qkv_proj.weight", dtype: dtypeF32, shape: []uint64{24, 2}, data: f32Bytes(rows(24))},
+		{name: "model.layers.0.mlp.gate_up_proj.weight", dtype: dtypeF32, shape: []uint64{6, 2}, data: f32Bytes(rows(6))},
+	}
+	dir := writeModelDir(t, config, tensors)
+	outPath := filepath.Join(dir, "out.gguf")
+	if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gf, err := gguf.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := gf.Lookup("general.architecture"); e.Value != "phi3" {
+		t.Errorf("general.architecture = %v, want phi3", e.Value)
+	}
+	for name, rows := range map[string]int{"blk.0.attn_qkv.weight": 24, "blk.0.ffn_up.weight": 6} {
+		if got := findTensor(gf, name); got == nil || !slices.Equal(got.Shape, []int{rows, 2}) {
+			t.Errorf("%s = %v, want fused [%d 2]", name, got, rows)
+		}
+	}
+	if e, ok := gf.Lookup("phi3.attention.sliding_window"); !ok || e.Value != uint32(2047) {
+		t.Errorf("phi3.attention.sliding_window = %v (present %v), want 2047", e.Value, ok)
+	}
+}
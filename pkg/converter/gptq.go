@@ -0,0 +1,305 @@
+package converter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/zerfoo/float16"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+)
+
+// quantConfig is the quantization_config section of the config.json of a
+// GPTQ, AWQ or FP8 checkpoint.
+type quantConfig struct {
+	Method     string `json:"quant_method"`
+	Bits       int    `json:"bits"`
+	WBit       int    `json:"w_bit"` // AWQ
+	GroupSize  int    `json:"group_size"`
+	QGroupSize int    `json:"q_group_size"` // AWQ
+	// Format is "gptq" (zero points stored minus one) or "gptq_v2".
+	Format string `json:"checkpoint_format"`
+	// Version is the AWQ kernel layout; only "gemm" is supported.
+	Version string `json:"version"`
+	// WeightBlockSize is the [rows, cols] block each FP8 weight_scale_inv
+	// value covers.
+	WeightBlockSize []int `json:"weight_block_size"`
+}
+
+// readQuantConfig returns the quantization_config of config, or nil if it
+// has none.
+func readQuantConfig(config map[string]interface{}) (*quantConfig, error) {
+	raw, ok := config["quantization_config"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("quantization_config: %w", err)
+	}
+	var qc quantConfig
+	if err := json.Unmarshal(data, &qc); err != nil {
+		return nil, fmt.Errorf("quantization_config: %w", err)
+	}
+	qc.Method = strings.ToLower(qc.Method)
+	if qc.Bits == 0 {
+		qc.Bits = qc.WBit
+	}
+	if qc.GroupSize == 0 {
+		qc.GroupSize = qc.QGroupSize
+	}
+
+	switch qc.Method {
+	case "gptq":
+		switch qc.Bits {
+		case 2, 3, 4, 8:
+		default:
+			return nil, fmt.Errorf("unsupported GPTQ bit width %d", qc.Bits)
+		}
+	case "awq":
+		if qc.Bits != 4 {
+			return nil, fmt.Errorf("unsupported AWQ bit width %d", qc.Bits)
+		}
+		if v := strings.ToLower(qc.Version); v != "" && v != "gemm" {
+			return nil, fmt.Errorf("unsupported AWQ version %q", qc.Version)
+		}
+	case "fp8", "compressed-tensors":
+		// FP8 weights are recognized by their dtype.
+		if len(qc.WeightBlockSize) != 0 && len(qc.WeightBlockSize) != 2 {
+			return nil, fmt.Errorf("weight_block_size %v: want [rows, cols]", qc.WeightBlockSize)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported quant_method %q", qc.Method)
+	}
+	return &qc, nil
+}
+
+// quantizedParts are the tensors that together store one GPTQ or AWQ weight.
+var quantizedParts = map[string]bool{"qweight": true, "qzeros": true, "scales": true, "g_idx": true}
+
+// splitQuantizedName splits a tensor name into the module prefix and, if it
+// is part of a GPTQ or AWQ weight, that part's suffix.
+func splitQuantizedName(name string) (prefix, part string, ok bool) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 || !quantizedParts[name[i+1:]] {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// awqOrder gives, for each of the 8 columns packed in an AWQ int32, the
+// nibble that holds it.
+var awqOrder = [8]int{0, 4, 1, 5, 2, 6, 3, 7}
+
+// loadQuantizedWeight reads the GPTQ or AWQ weight of the module prefix as
+// an [out, in] nbitsWeight.
+func loadQuantizedWeight(sf *safetensorsFile, prefix string, qc *quantConfig) (*nbitsWeight, error) {
+	qweight, qwShape, err := sf.readInt32s(prefix + ".qweight")
+	if err != nil {
+		return nil, err
+	}
+	qzeros, qzShape, err := sf.readInt32s(prefix + ".qzeros")
+	if err != nil {
+		return nil, err
+	}
+	scales, sShape, err := sf.readFloats(prefix + ".scales")
+	if err != nil {
+		return nil, err
+	}
+	if len(qwShape) != 2 || len(qzShape) != 2 || len(sShape) != 2 {
+		return nil, fmt.Errorf("%s: qweight, qzeros and scales must be 2-D", prefix)
+	}
+	bits := qc.Bits
+	groups, N := int(sShape[0]), int(sShape[1])
+
+	// word returns the r-th int32 of column c of a [rows, cols] tensor.
+	word := func(data []int32, cols, c int) func(int) uint32 {
+		return func(r int) uint32 { return uint32(data[r*cols+c]) }
+	}
+
+	var K int
+	var level func(n, k int) byte
+	zeros := make([]float32, N*groups) // [groups, N]
+	switch qc.Method {
+	case "gptq":
+		// qweight packs the input dimension: column n is a little-endian
+		// bit stream over the rows. qzeros packs the output dimension.
+		K = int(qwShape[0]) * 32 / bits
+		if int(qwShape[1]) != N || int(qzShape[0]) != groups {
+			return nil, fmt.Errorf("%s: qweight %v, qzeros %v and scales %v disagree", prefix, qwShape, qzShape, sShape)
+		}
+		qzCols := int(qzShape[1])
+		if qzCols*32 < N*bits {
+			return nil, fmt.Errorf("%s: qzeros %v too small for %d columns", prefix, qzShape, N)
+		}
+		level = func(n, k int) byte { return streamBits(word(qweight, N, n), k, bits) }
+		offset := float32(1) // v1 checkpoints store zero points minus one
+		if strings.ToLower(qc.Format) == "gptq_v2" {
+			offset = 0
+		}
+		for g := range groups {
+			row := func(i int) uint32 { return uint32(qzeros[g*qzCols+i]) }
+			for n := range N {
+				zeros[g*N+n] = float32(streamBits(row, n, bits)) + offset
+			}
+		}
+
+	case "awq":
+		// qweight [in, out/8] and qzeros [groups, out/8] both pack the
+		// output dimension in awqOrder.
+		K = int(qwShape[0])
+		cols := int(qwShape[1])
+		if cols*8 != N || int(qzShape[0]) != groups || int(qzShape[1]) != cols {
+			return nil, fmt.Errorf("%s: qweight %v, qzeros %v and scales %v disagree", prefix, qwShape, qzShape, sShape)
+		}
+		nibble := func(v int32, n int) byte { return byte(uint32(v)>>(4*awqOrder[n%8])) & 0xF }
+		level = func(n, k int) byte { return nibble(qweight[k*cols+n/8], n) }
+		for g := range groups {
+			for n := range N {
+				zeros[g*N+n] = float32(nibble(qzeros[g*cols+n/8], n))
+			}
+		}
+	}
+
+	groupSize := qc.GroupSize
+	if groupSize <= 0 {
+		groupSize = K // one group per output channel
+	}
+	if (K+groupSize-1)/groupSize != groups {
+		return nil, fmt.Errorf("%s: %d groups of %d for %d inputs, want %d", prefix, groups, groupSize, K, (K+groupSize-1)/groupSize)
+	}
+
+	var gIdx []int32
+	if _, ok := sf.Tensors[prefix+".g_idx"]; ok && qc.Method == "gptq" {
+		if gIdx, _, err = sf.readInt32s(prefix + ".g_idx"); err != nil {
+			return nil, err
+		}
+		if len(gIdx) != K {
+			return nil, fmt.Errorf("%s: g_idx has %d entries, want %d", prefix, len(gIdx), K)
+		}
+		for k, g := range gIdx {
+			if g < 0 || int(g) >= groups {
+				return nil, fmt.Errorf("%s: g_idx[%d] = %d out of range [0, %d)", prefix, k, g, groups)
+			}
+		}
+	}
+
+	// nbitsWeight keeps the scales and zero points of each output row
+	// together.
+	w := newNBitsWeight(N, K, bits, groupSize, level)
+	w.scales = make([]float32, N*groups)
+	w.zeroPoints = make([]float32, N*groups)
+	for g := range groups {
+		for n := range N {
+			w.scales[n*groups+g] = scales[g*N+n]
+			w.zeroPoints[n*groups+g] = zeros[g*N+n]
+		}
+	}
+	w.gIdx = gIdx
+	return w, nil
+}
+
+// newNBitsWeight packs the levels of an [N, K] weight into the MatMulNBits
+// layout, low bits first within blocks of blockSize values.
+func newNBitsWeight(N, K, bits, blockSize int, level func(n, k int) byte) *nbitsWeight {
+	w := &nbitsWeight{N: N, K: K, bits: bits, blockSize: blockSize}
+	w.kBlocks = (K + blockSize - 1) / blockSize
+	w.bytesPerBlock = (blockSize*bits + 7) / 8
+	w.data = make([]byte, N*w.kBlocks*w.bytesPerBlock)
+	for n := range N {
+		for k := range K {
+			block := w.data[(n*w.kBlocks+k/blockSize)*w.bytesPerBlock:]
+			off := (k % blockSize) * bits
+			v := uint16(level(n, k)) << (off % 8)
+			block[off/8] |= byte(v)
+			if off%8+bits > 8 {
+				block[off/8+1] |= byte(v >> 8)
+			}
+		}
+	}
+	return w
+}
+
+// streamBits returns the i-th bits-wide value of the little-endian bit
+// stream formed by the words word(0), word(1), ...
+func streamBits(word func(int) uint32, i, bits int) byte {
+	off := i * bits
+	v := uint64(word(off/32)) >> (off % 32)
+	if off%32+bits > 32 {
+		v |= uint64(word(off/32+1)) << (32 - off%32)
+	}
+	return byte(v & (1<<bits - 1))
+}
+
+// nbitsGGUF returns the GGUF type and data of w: Q4_0, Q4_1 or Q8_0 blocks
+// when its layout allows a repack, float32 otherwise.
+func nbitsGGUF(w *nbitsWeight) (int, []byte) {
+	if t, ok := w.repack(); ok {
+		if typ, ok := quantize.GGUFType(t.Dtype); ok {
+			return typ, quantize.GGMLData(t)
+		}
+	}
+	data := make([]byte, w.N*w.K*4)
+	for n := range w.N {
+		for k := range w.K {
+			binary.LittleEndian.PutUint32(data[(n*w.K+k)*4:], math.Float32bits(w.value(n, k)))
+		}
+	}
+	return sharedgguf.TypeF32, data
+}
+
+// readInt32s reads an I32 tensor.
+func (sf *safetensorsFile) readInt32s(name string) ([]int32, []uint64, error) {
+	info, ok := sf.Tensors[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("tensor %q not found", name)
+	}
+	if info.Dtype != dtypeI32 {
+		return nil, nil, fmt.Errorf("tensor %q: expected I32, got %s", name, info.Dtype)
+	}
+	data, err := sf.ReadTensorData(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	vals := make([]int32, len(data)/4)
+	for i := range vals {
+		vals[i] = int32(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vals, info.Shape, nil
+}
+
+// readFloats reads an F32, F16 or BF16 tensor as float32.
+func (sf *safetensorsFile) readFloats(name string) ([]float32, []uint64, error) {
+	info, ok := sf.Tensors[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("tensor %q not found", name)
+	}
+	data, err := sf.ReadTensorData(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var vals []float32
+	switch info.Dtype {
+	case dtypeF32:
+		vals = make([]float32, len(data)/4)
+		for i := range vals {
+			vals[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+		}
+	case dtypeF16:
+		vals = make([]float32, len(data)/2)
+		for i := range vals {
+			vals[i] = float16.Float16(binary.LittleEndian.Uint16(data[i*2:])).ToFloat32()
+		}
+	case dtypeBF16:
+		vals = make([]float32, len(data)/2)
+		for i := range vals {
+			vals[i] = float16.BFloat16FromBits(binary.LittleEndian.Uint16(data[i*2:])).ToFloat32()
+		}
+	default:
+		return nil, nil, fmt.Errorf("tensor %q: expected a float dtype, got %s", name, info.Dtype)
+	}
+	return vals, info.Shape, nil
+}
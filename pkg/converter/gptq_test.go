@@ -0,0 +1,371 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zerfoo/float16"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+)
+
+// rawTensor is a safetensors entry with preencoded data.
+type rawTensor struct {
+	name  string
+	dtype safetensorsDtype
+	shape []uint64
+	data  []byte
+}
+
+// buildRawSafetensors creates a safetensors file from preencoded tensors.
+func buildRawSafetensors(t *testing.T, tensors []rawTensor) []byte {
+	t.Helper()
+	var dataBuf bytes.Buffer
+	header := make(map[string]safetensorsTensorInfo, len(tensors))
+	for _, rt := range tensors {
+		start := uint64(dataBuf.Len())
+		dataBuf.Write(rt.data)
+		header[rt.name] = safetensorsTensorInfo{
+			Dtype:       rt.dtype,
+			Shape:       rt.shape,
+			DataOffsets: [2]uint64{start, uint64(dataBuf.Len())},
+		}
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, uint64(len(headerJSON))); err != nil {
+		t.Fatalf("write header length: %v", err)
+	}
+	buf.Write(headerJSON)
+	buf.Write(dataBuf.Bytes())
+	return buf.Bytes()
+}
+
+func int32Bytes(vals []uint32) []byte {
+	out := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(out[i*4:], v)
+	}
+	return out
+}
+
+func f16Bytes(vals []float32) []byte {
+	out := make([]byte, len(vals)*2)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint16(out[i*2:], float16.FromFloat32(v).Bits())
+	}
+	return out
+}
+
+// setStreamBits stores v at position i of a little-endian bit stream of
+// bits-wide values, where word(r) addresses the r-th word of the stream.
+func setStreamBits(word func(r int) *uint32, i, bits int, v uint32) {
+	for b := 0; b < bits; b++ {
+		if v&(1<<b) != 0 {
+			off := i*bits + b
+			*word(off / 32) |= 1 << (off % 32)
+		}
+	}
+}
+
+// quantFixture is a synthetic [out, in] quantized linear weight.
+type quantFixture struct {
+	method    string
+	format    string
+	bits      int
+	groupSize int // -1 for one group per output channel
+	out, in   int
+	sym       bool
+	actOrder  bool
+
+	levels []uint32  // [in, out]
+	scales []float32 // [groups, out]
+	zeros  []uint32  // [groups, out]
+	gIdx   []int32   // [in]
+}
+
+func (f *quantFixture) groups() int {
+	if f.groupSize <= 0 {
+		return 1
+	}
+	return (f.in + f.groupSize - 1) / f.groupSize
+}
+
+func (f *quantFixture) fill() {
+	maxq := uint32(1)<<f.bits - 1
+	f.levels = make([]uint32, f.in*f.out)
+	for i := range f.levels {
+		f.levels[i] = uint32(i*7+i/3) & maxq
+	}
+	g := f.groups()
+	f.scales = make([]float32, g*f.out)
+	f.zeros = make([]uint32, g*f.out)
+	for i := range f.scales {
+		f.scales[i] = float32(i%5+1) / 8
+		switch {
+		case f.sym:
+			f.zeros[i] = 1 << (f.bits - 1)
+		case f.method == "gptq" && f.format != "gptq_v2":
+			// v1 checkpoints store the zero point minus one, so it cannot be 0.
+			f.zeros[i] = 1 + uint32(i*3)%maxq
+		default:
+			f.zeros[i] = uint32(i*3) % (maxq + 1)
+		}
+	}
+	f.gIdx = make([]int32, f.in)
+	for k := range f.gIdx {
+		switch {
+		case f.actOrder:
+			f.gIdx[k] = int32((k * 5) % g)
+		case f.groupSize > 0:
+			f.gIdx[k] = int32(k / f.groupSize)
+		}
+	}
+}
+
+// reference returns the dequantized [out, in] weight.
+func (f *quantFixture) reference() []float32 {
+	out := make([]float32, f.out*f.in)
+	for n := 0; n < f.out; n++ {
+		for k := 0; k < f.in; k++ {
+			g := int(f.gIdx[k])
+			out[n*f.in+k] = f.scales[g*f.out+n] * (float32(f.levels[k*f.out+n]) - float32(f.zeros[g*f.out+n]))
+		}
+	}
+	return out
+}
+
+// tensors encodes the weight as GPTQ or AWQ tensors of module prefix.
+func (f *quantFixture) tensors(prefix string) []rawTensor {
+	g := f.groups()
+	var qweight, qzeros []uint32
+	var qwShape, qzShape []uint64
+	switch f.method {
+	case "gptq":
+		rows := f.in * f.bits / 32
+		qweight = make([]uint32, rows*f.out)
+		for n := 0; n < f.out; n++ {
+			word := func(r int) *uint32 { return &qweight[r*f.out+n] }
+			for k := 0; k < f.in; k++ {
+				setStreamBits(word, k, f.bits, f.levels[k*f.out+n])
+			}
+		}
+		zcols := (f.out*f.bits + 31) / 32
+		qzeros = make([]uint32, g*zcols)
+		for gi := 0; gi < g; gi++ {
+			word := func(r int) *uint32 { return &qzeros[gi*zcols+r] }
+			for n := 0; n < f.out; n++ {
+				z := f.zeros[gi*f.out+n]
+				if f.format != "gptq_v2" {
+					z--
+				}
+				setStreamBits(word, n, f.bits, z&(1<<f.bits-1))
+			}
+		}
+		qwShape, qzShape = []uint64{uint64(rows), uint64(f.out)}, []uint64{uint64(g), uint64(zcols)}
+	case "awq":
+		cols := f.out / 8
+		qweight = make([]uint32, f.in*cols)
+		qzeros = make([]uint32, g*cols)
+		for n := 0; n < f.out; n++ {
+			shift := 4 * awqOrder[n%8]
+			for k := 0; k < f.in; k++ {
+				qweight[k*cols+n/8] |= f.levels[k*f.out+n] << shift
+			}
+			for gi := 0; gi < g; gi++ {
+				qzeros[gi*cols+n/8] |= f.zeros[gi*f.out+n] << shift
+			}
+		}
+		qwShape, qzShape = []uint64{uint64(f.in), uint64(cols)}, []uint64{uint64(g), uint64(cols)}
+	}
+	tensors := []rawTensor{
+		{prefix + ".qweight", dtypeI32, qwShape, int32Bytes(qweight)},
+		{prefix + ".qzeros", dtypeI32, qzShape, int32Bytes(qzeros)},
+		{prefix + ".scales", dtypeF16, []uint64{uint64(g), uint64(f.out)}, f16Bytes(f.scales)},
+	}
+	if f.method == "gptq" {
+		gIdx := make([]uint32, len(f.gIdx))
+		for i, v := range f.gIdx {
+			gIdx[i] = uint32(v)
+		}
+		tensors = append(tensors, rawTensor{prefix + ".g_idx", dtypeI32, []uint64{uint64(f.in)}, int32Bytes(gIdx)})
+	}
+	return tensors
+}
+
+func (f *quantFixture) config() map[string]interface{} {
+	qc := map[string]interface{}{"quant_method": f.method, "bits": f.bits, "group_size": f.groupSize}
+	if f.format != "" {
+		qc["checkpoint_format"] = f.format
+	}
+	if f.method == "awq" {
+		qc = map[string]interface{}{"quant_method": "awq", "w_bit": f.bits, "q_group_size": f.groupSize, "version": "GEMM"}
+	}
+	return map[string]interface{}{
+		"hidden_size":         f.in,
+		"num_hidden_layers":   1,
+		"num_attention_heads": 1,
+		"quantization_config": qc,
+	}
+}
+
+// writeModelDir writes config.json and model.safetensors into a new
+// directory and returns it.
+func writeModelDir(t *testing.T, config map[string]interface{}, tensors []rawTensor) string {
+	t.Helper()
+	dir := t.TempDir()
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), configJSON, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "model.safetensors"), buildRawSafetensors(t, tensors), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestConvertSafetensorsToGGUF_GPTQAndAWQ(t *testing.T) {
+	const prefix = "model.layers.0.mlp.up_proj"
+	tests := []struct {
+		name     string
+		fixture  quantFixture
+		wantType int
+	}{
+		{"gptq 4-bit sym", quantFixture{method: "gptq", bits: 4, groupSize: 32, sym: true}, sharedgguf.TypeQ4_0},
+		{"gptq 4-bit asym", quantFixture{method: "gptq", bits: 4, groupSize: 32}, gguf.TypeQ4_1},
+		{"gptq 4-bit per-channel", quantFixture{method: "gptq", bits: 4, groupSize: -1}, gguf.TypeQ4_1},
+		{"gptq_v2 4-bit", quantFixture{method: "gptq", format: "gptq_v2", bits: 4, groupSize: 64}, gguf.TypeQ4_1},
+		{"gptq 4-bit act-order", quantFixture{method: "gptq", bits: 4, groupSize: 32, actOrder: true}, sharedgguf.TypeF32},
+		{"gptq 8-bit sym", quantFixture{method: "gptq", bits: 8, groupSize: 32, sym: true}, sharedgguf.TypeQ8_0},
+		{"gptq 8-bit asym", quantFixture{method: "gptq", bits: 8, groupSize: 32}, sharedgguf.TypeF32},
+		{"gptq 3-bit", quantFixture{method: "gptq", bits: 3, groupSize: 32}, sharedgguf.TypeF32},
+		{"gptq 2-bit", quantFixture{method: "gptq", bits: 2, groupSize: 16}, sharedgguf.TypeF32},
+		{"awq 4-bit", quantFixture{method: "awq", bits: 4, groupSize: 32}, gguf.TypeQ4_1},
+		{"awq 4-bit sym", quantFixture{method: "awq", bits: 4, groupSize: 64, sym: true}, sharedgguf.TypeQ4_0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fixture
+			f.out, f.in = 16, 128
+			f.fill()
+
+			tensors := append(f.tensors(prefix), rawTensor{
+				name: "model.norm.weight", dtype: dtypeF16, shape: []uint64{uint64(f.in)},
+				data: f16Bytes(make([]float32, f.in)),
+			})
+			dir := writeModelDir(t, f.config(), tensors)
+			outPath := filepath.Join(dir, "out.gguf")
+			if err := ConvertSafetensorsToGGUF(dir, outPath, "llama"); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+
+			gf, err := gguf.ReadFile(outPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			if len(gf.Tensors) != 2 {
+				t.Errorf("got %d tensors, want 2", len(gf.Tensors))
+			}
+			wantName := gguf.MapTensorName(prefix + ".weight")
+			var got *gguf.Tensor
+			for i := range gf.Tensors {
+				if strings.Contains(gf.Tensors[i].Name, "qweight") || strings.Contains(gf.Tensors[i].Name, "qzeros") {
+					t.Errorf("unexpected tensor %q", gf.Tensors[i].Name)
+				}
+				if gf.Tensors[i].Name == wantName {
+					got = &gf.Tensors[i]
+				}
+			}
+			if got == nil {
+				t.Fatalf("missing tensor %q", wantName)
+			}
+			if got.Type != tt.wantType {
+				t.Errorf("type = %d, want %d", got.Type, tt.wantType)
+			}
+			if e, _ := gf.Lookup("general.file_type"); e.Value != gguf.FileType([]gguf.Tensor{*got}) {
+				t.Errorf("general.file_type = %v, want that of type %d", e.Value, tt.wantType)
+			}
+			if _, ok := gf.Lookup("general.quantization_version"); ok != gguf.IsQuantized(tt.wantType) {
+				t.Errorf("general.quantization_version present = %v, want %v", ok, !ok)
+			}
+			if len(got.Shape) != 2 || got.Shape[0] != f.out || got.Shape[1] != f.in {
+				t.Errorf("shape = %v, want [%d %d]", got.Shape, f.out, f.in)
+			}
+			vals, err := quantize.DequantizeTensor(got)
+			if err != nil {
+				t.Fatalf("dequantize: %v", err)
+			}
+			want := f.reference()
+			for i := range want {
+				if vals[i] != want[i] {
+					t.Fatalf("value [%d,%d] = %v, want %v", i/f.in, i%f.in, vals[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadQuantConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		qc      map[string]interface{}
+		want    *quantConfig
+		wantErr bool
+	}{
+		{name: "none"},
+		{
+			name: "gptq",
+			qc:   map[string]interface{}{"quant_method": "gptq", "bits": 4, "group_size": 128, "desc_act": true},
+			want: &quantConfig{Method: "gptq", Bits: 4, GroupSize: 128},
+		},
+		{
+			name: "awq",
+			qc:   map[string]interface{}{"quant_method": "AWQ", "w_bit": 4, "q_group_size": 64, "version": "gemm"},
+			want: &quantConfig{Method: "awq", Bits: 4, WBit: 4, GroupSize: 64, QGroupSize: 64, Version: "gemm"},
+		},
+		{name: "awq gemv", qc: map[string]interface{}{"quant_method": "awq", "bits": 4, "version": "gemv"}, wantErr: true},
+		{name: "awq 8-bit", qc: map[string]interface{}{"quant_method": "awq", "bits": 8}, wantErr: true},
+		{name: "gptq 5-bit", qc: map[string]interface{}{"quant_method": "gptq", "bits": 5}, wantErr: true},
+		{
+			name: "fp8",
+			qc:   map[string]interface{}{"quant_method": "fp8", "fmt": "e4m3", "weight_block_size": []int{128, 128}},
+			want: &quantConfig{Method: "fp8", WeightBlockSize: []int{128, 128}},
+		},
+		{name: "fp8 bad block", qc: map[string]interface{}{"quant_method": "fp8", "weight_block_size": []int{128}}, wantErr: true},
+		{name: "unknown method", qc: map[string]interface{}{"quant_method": "bitsandbytes"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{}
+			if tt.qc != nil {
+				config["quantization_config"] = tt.qc
+			}
+			got, err := readQuantConfig(config)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,14 +3,17 @@ package converter
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strings"
 
 	sharedgguf "github.com/zerfoo/ztensor/gguf"
 	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 )
 
 // safetensorsDtype represents a data type in the safetensors format.
@@ -20,6 +23,10 @@ const (
 	dtypeF32  safetensorsDtype = "F32"
 	dtypeF16  safetensorsDtype = "F16"
 	dtypeBF16 safetensorsDtype = "BF16"
+	dtypeI32  safetensorsDtype = "I32"
+
+	dtypeF8E4M3 safetensorsDtype = "F8_E4M3"
+	dtypeF8E5M2 safetensorsDtype = "F8_E5M2"
 )
 
 // safetensorsTensorInfo describes a single tensor in the safetensors header.
@@ -131,10 +138,56 @@ func safetensorsDtypeToGGUF(dtype safetensorsDtype) (int, error) {
 	}
 }
 
+// SafetensorsOptions configures ConvertSafetensorsToGGUFWithOptions.
+type SafetensorsOptions struct {
+	// FP8Type is the GGUF type FP8 tensors are decoded to: TypeF32,
+	// TypeF16 or TypeBF16.
+	FP8Type int
+	// Cast, when set, stores every float tensor, FP8 included, as OutType
+	// (TypeF32, TypeF16 or TypeBF16).
+	// Block-quantized GPTQ and AWQ weights are left as they are.
+	Cast    bool
+	OutType int
+	// CastNorms also casts normalization tensors, which otherwise stay F32.
+	CastNorms bool
+}
+
 // ConvertSafetensorsToGGUF reads a HuggingFace model directory containing
 // config.json and model.safetensors, maps tensor names and metadata using
-// the existing GGUF mapping functions, and writes a GGUF file.
+// the existing GGUF mapping functions, and writes a GGUF file. FP8 tensors
+// are written as BF16.
 func ConvertSafetensorsToGGUF(inputDir, outputPath, arch string) error {
+	return ConvertSafetensorsToGGUFWithOptions(inputDir, outputPath, arch, SafetensorsOptions{FP8Type: sharedgguf.TypeBF16})
+}
+
+// ConvertSafetensorsToGGUFWithOptions is like ConvertSafetensorsToGGUF but
+// lets the caller choose the output type of FP8 and other float tensors.
+//
+// GPTQ and AWQ checkpoints, recognized by the quantization_config of
+// config.json, store each linear weight as <module>.qweight, .qzeros,
+// .scales and optionally .g_idx. These are written as one <module>.weight
+// tensor: Q4_0, Q4_1 or Q8_0 blocks when the groups map onto 32-value blocks,
+// dequantized float32 otherwise.
+//
+// F8_E4M3 and F8_E5M2 tensors are decoded and multiplied by their
+// <name>_scale_inv or <name>_scale companion, which holds one scale, one per
+// row, or one per block of the weight_block_size given in the
+// quantization_config. The scales and static activation scales are not
+// written.
+//
+// The per-expert weights of mixture-of-experts layers are stacked into one
+// tensor per layer and projection (see ExpertStacker). Fused tensors are
+// split, and separate ones joined, as the definition's split and concat
+// transforms declare (see SplitTensor and TensorConcatenator).
+//
+// An empty arch is detected from config.json and the tensor names (see
+// DetectArchitecture). The transforms of the architecture's definition, such
+// as llama's rotary permutation of the query and key weights, are applied
+// with parameters from config.json (see TransformTensor).
+//
+// general.file_type is set from the types of the written tensors, and
+// general.quantization_version is added when any is block-quantized.
+func ConvertSafetensorsToGGUFWithOptions(inputDir, outputPath, arch string, opts SafetensorsOptions) error {
 	// Read config.json.
 	configPath := filepath.Join(inputDir, "config.json")
 	configData, err := os.ReadFile(configPath)
@@ -146,6 +199,11 @@ func ConvertSafetensorsToGGUF(inputDir, outputPath, arch string) error {
 		return fmt.Errorf("parse config.json: %w", err)
 	}
 
+	qc, err := readQuantConfig(config)
+	if err != nil {
+		return err
+	}
+
 	// Find safetensors file.
 	stPath := filepath.Join(inputDir, "model.safetensors")
 	if _, err := os.Stat(stPath); err != nil {
@@ -158,6 +216,15 @@ func ConvertSafetensorsToGGUF(inputDir, outputPath, arch string) error {
 	}
 	defer sf.Close()
 
+	if arch == "" {
+		d, err := gguf.DetectArchitecture(config, sf.names())
+		if err != nil {
+			return err
+		}
+		arch = d.Name
+	}
+	archDef, _ := gguf.LookupArchitecture(arch)
+
 	// Create output file.
 	if dir := filepath.Dir(outputPath); dir != "." {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -170,49 +237,170 @@ func ConvertSafetensorsToGGUF(inputDir, outputPath, arch string) error {
 	}
 	defer outFile.Close()
 
-	w := sharedgguf.NewWriter()
-
-	// Write metadata.
-	for _, entry := range gguf.MapMetadata(arch, config) {
-		switch entry.Type {
-		case sharedgguf.MetaTypeString:
-			w.AddMetadataString(entry.Key, entry.Value.(string))
-		case sharedgguf.MetaTypeUint32:
-			w.AddMetadataUint32(entry.Key, entry.Value.(uint32))
-		case sharedgguf.MetaTypeFloat32:
-			w.AddMetadataFloat32(entry.Key, entry.Value.(float32))
-		}
+	// gguf.File rather than the shared writer, which has no boolean
+	// metadata.
+	out := &gguf.File{}
+
+	if _, ok := quantize.FloatFileType(opts.OutType); opts.Cast && !ok {
+		return fmt.Errorf("unsupported output type %d: want F32, F16 or BF16", opts.OutType)
 	}
 
 	// Sort tensor names for deterministic output.
-	names := make([]string, 0, len(sf.Tensors))
-	for name := range sf.Tensors {
-		names = append(names, name)
+	names := sf.names()
+
+	// Modules whose weight is stored GPTQ or AWQ quantized.
+	quantized := make(map[string]bool)
+	if qc != nil && (qc.Method == "gptq" || qc.Method == "awq") {
+		for _, name := range names {
+			if prefix, ok := strings.CutSuffix(name, ".qweight"); ok {
+				quantized[prefix] = true
+			}
+		}
+	}
+
+	fp8Scale, fp8Companions := fp8Scales(sf)
+	var fp8Block []int
+	if qc != nil {
+		fp8Block = qc.WeightBlockSize
+	}
+
+	// Source weight names, with GPTQ and AWQ modules as <module>.weight, for
+	// finding the mixture-of-experts weights to stack.
+	weightNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if prefix, part, ok := splitQuantizedName(name); ok && quantized[prefix] {
+			if part == "qweight" {
+				weightNames = append(weightNames, prefix+".weight")
+			}
+			continue
+		}
+		weightNames = append(weightNames, name)
+	}
+	experts, err := NewExpertStacker(archDef, weightNames)
+	if err != nil {
+		return err
+	}
+	mapped := make([]string, len(weightNames))
+	for i, name := range weightNames {
+		mapped[i] = gguf.MapArchTensorName(arch, name)
+	}
+	concat, err := NewTensorConcatenator(archDef, config, mapped)
+	if err != nil {
+		return err
 	}
-	sort.Strings(names)
 
-	// Write tensors.
+	writeTensor := func(t gguf.Tensor) error {
+		if opts.Cast && (t.Type == sharedgguf.TypeF32 || t.Type == sharedgguf.TypeF16 || t.Type == sharedgguf.TypeBF16) {
+			to := quantize.CastType(t.Name, opts.OutType, opts.CastNorms)
+			data, err := quantize.CastFloats(t.Type, t.Data, t.Elements(), to)
+			if err != nil {
+				return fmt.Errorf("tensor %q: %w", t.Name, err)
+			}
+			t.Type, t.Data = to, data
+		}
+		out.Tensors = append(out.Tensors, t)
+		return nil
+	}
+	var kept []gguf.Tensor
 	for _, name := range names {
+		if fp8Companions[name] {
+			continue
+		}
+		var (
+			src      string // name of the weight in the checkpoint
+			ggufName string
+			typ      int
+			shape    []int
+			data     []byte
+		)
 		info := sf.Tensors[name]
-		ggufDtype, err := safetensorsDtypeToGGUF(info.Dtype)
-		if err != nil {
-			return fmt.Errorf("tensor %q: %w", name, err)
+		if prefix, part, ok := splitQuantizedName(name); ok && quantized[prefix] {
+			if part != "qweight" {
+				continue
+			}
+			qw, err := loadQuantizedWeight(sf, prefix, qc)
+			if err != nil {
+				return err
+			}
+			src, shape = prefix+".weight", []int{qw.N, qw.K}
+			ggufName = gguf.MapArchTensorName(arch, src)
+			typ, data = nbitsGGUF(qw)
+		} else if _, ok := fp8Formats[info.Dtype]; ok {
+			vals, err := sf.readFP8(name, fp8Scale[name], fp8Block)
+			if err != nil {
+				return err
+			}
+			src, shape, typ = name, intShape(info.Shape), opts.FP8Type
+			ggufName = gguf.MapArchTensorName(arch, src)
+			if opts.Cast {
+				typ = quantize.CastType(ggufName, opts.OutType, opts.CastNorms)
+			}
+			if data, err = quantize.EncodeFloats(typ, vals); err != nil {
+				return fmt.Errorf("tensor %q: %w", name, err)
+			}
+		} else {
+			if typ, err = safetensorsDtypeToGGUF(info.Dtype); err != nil {
+				return fmt.Errorf("tensor %q: %w", name, err)
+			}
+			if data, err = sf.ReadTensorData(name); err != nil {
+				return err
+			}
+			src, shape = name, intShape(info.Shape)
+			ggufName = gguf.MapArchTensorName(arch, src)
 		}
 
-		data, err := sf.ReadTensorData(name)
+		t := gguf.Tensor{Name: ggufName, Type: typ, Shape: shape, Data: data}
+		stacked, isExpert, err := experts.Add(src, t)
 		if err != nil {
 			return err
 		}
-
-		ggufName := gguf.MapTensorName(name)
-		shape := make([]int, len(info.Shape))
-		for i, d := range info.Shape {
-			shape[i] = int(d)
+		if isExpert {
+			if stacked == nil {
+				continue // more experts to come
+			}
+			t = *stacked
+		}
+		parts, err := SplitTensor(archDef, config, t)
+		if err != nil {
+			return err
+		}
+		for _, t := range parts {
+			k, err := TransformTensor(archDef, config, &t)
+			if errors.Is(err, ErrDropTensor) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			kept = append(kept, k...)
+			joined, isPart, err := concat.Add(t)
+			if err != nil {
+				return err
+			}
+			if isPart {
+				if joined == nil {
+					continue // more parts to come
+				}
+				t = *joined
+			}
+			if err := writeTensor(t); err != nil {
+				return err
+			}
+		}
+	}
+	// Untransformed copies, such as a tied output head, unless the model
+	// has its own.
+	for _, t := range kept {
+		if !slices.ContainsFunc(out.Tensors, func(x gguf.Tensor) bool { return x.Name == t.Name }) {
+			if err := writeTensor(t); err != nil {
+				return err
+			}
 		}
-		w.AddTensor(ggufName, ggufDtype, shape, data)
 	}
 
-	if err := w.Write(outFile); err != nil {
+	out.Metadata = gguf.MapMetadata(arch, config)
+	out.SetFileType()
+	if err := out.Write(outFile); err != nil {
 		return fmt.Errorf("write GGUF: %w", err)
 	}
 
@@ -7,6 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/zerfoo/float16"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 )
 
 // buildSafetensors creates a minimal safetensors file in memory.
@@ -233,3 +238,110 @@ func TestParseSafetensorsHeader_SkipsMetadata(t *testing.T) {
 		t.Error("__metadata__ should be skipped")
 	}
 }
+
+func TestConvertSafetensorsToGGUF_OutType(t *testing.T) {
+	weights := []float32{1, -2.5, 1e-6, 3.14159, 65504, 7e-8}
+	bf16 := make([]byte, len(weights)*2)
+	for i, v := range weights {
+		binary.LittleEndian.PutUint16(bf16[i*2:], float16.BFloat16FromFloat32(v).Bits())
+	}
+	tensors := []rawTensor{
+		{name: "model.layers.0.mlp.up_proj.weight", dtype: dtypeF32, shape: []uint64{2, 3}, data: f32Bytes(weights)},
+		{name: "model.layers.0.mlp.down_proj.weight", dtype: dtypeBF16, shape: []uint64{3, 2}, data: bf16},
+		{name: "model.layers.0.post_attention_layernorm.weight", dtype: dtypeF16, shape: []uint64{6}, data: f16Bytes(weights)},
+		{name: "model.layers.0.self_attn.q_proj.weight", dtype: dtypeF8E4M3, shape: []uint64{2, 2}, data: []byte{0x38, 0xC0, 0x01, 0x7E}},
+	}
+
+	tests := []struct {
+		name      string
+		opts      SafetensorsOptions
+		wantTypes map[string]int
+		wantFT    uint32
+	}{
+		{
+			name:   "f16",
+			opts:   SafetensorsOptions{Cast: true, OutType: sharedgguf.TypeF16},
+			wantFT: 1,
+			wantTypes: map[string]int{
+				"blk.0.ffn_up.weight": sharedgguf.TypeF16, "blk.0.ffn_down.weight": sharedgguf.TypeF16,
+				"blk.0.ffn_norm.weight": sharedgguf.TypeF32, "blk.0.attn_q.weight": sharedgguf.TypeF16,
+			},
+		},
+		{
+			name:   "bf16 with norms",
+			opts:   SafetensorsOptions{Cast: true, OutType: sharedgguf.TypeBF16, CastNorms: true},
+			wantFT: 32,
+			wantTypes: map[string]int{
+				"blk.0.ffn_up.weight": sharedgguf.TypeBF16, "blk.0.ffn_down.weight": sharedgguf.TypeBF16,
+				"blk.0.ffn_norm.weight": sharedgguf.TypeBF16, "blk.0.attn_q.weight": sharedgguf.TypeBF16,
+			},
+		},
+		{
+			name:   "f32",
+			opts:   SafetensorsOptions{Cast: true, OutType: sharedgguf.TypeF32},
+			wantFT: 0,
+			wantTypes: map[string]int{
+				"blk.0.ffn_up.weight": sharedgguf.TypeF32, "blk.0.ffn_down.weight": sharedgguf.TypeF32,
+				"blk.0.ffn_norm.weight": sharedgguf.TypeF32, "blk.0.attn_q.weight": sharedgguf.TypeF32,
+			},
+		},
+		{
+			name:   "no cast",
+			opts:   SafetensorsOptions{FP8Type: sharedgguf.TypeBF16},
+			wantFT: 32, // BF16 holds most weight values
+			wantTypes: map[string]int{
+				"blk.0.ffn_up.weight": sharedgguf.TypeF32, "blk.0.ffn_down.weight": sharedgguf.TypeBF16,
+				"blk.0.ffn_norm.weight": sharedgguf.TypeF16, "blk.0.attn_q.weight": sharedgguf.TypeBF16,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModelDir(t, map[string]interface{}{"hidden_size": 2, "num_attention_heads": 1}, tensors)
+			outPath := filepath.Join(dir, "out.gguf")
+			if err := ConvertSafetensorsToGGUFWithOptions(dir, outPath, "llama", tt.opts); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			gf, err := gguf.ReadFile(outPath)
+			if err != nil {
+				t.Fatalf("read output: %v", err)
+			}
+			if len(gf.Tensors) != len(tt.wantTypes) {
+				t.Fatalf("got %d tensors, want %d", len(gf.Tensors), len(tt.wantTypes))
+			}
+			for i := range gf.Tensors {
+				got := &gf.Tensors[i]
+				want, ok := tt.wantTypes[got.Name]
+				if !ok {
+					t.Fatalf("unexpected tensor %q", got.Name)
+				}
+				if got.Type != want {
+					t.Errorf("%s type = %d, want %d", got.Name, got.Type, want)
+				}
+				// Each value is the source value rounded once to the output type.
+				vals, err := quantize.DequantizeTensor(got)
+				if err != nil {
+					t.Fatalf("decode %s: %v", got.Name, err)
+				}
+				if got.Name == "blk.0.ffn_up.weight" {
+					want, _ := quantize.EncodeFloats(got.Type, weights)
+					if !bytes.Equal(got.Data, want) {
+						t.Errorf("%s data = %x, want %x", got.Name, got.Data, want)
+					}
+				}
+				if got.Name == "blk.0.attn_q.weight" && (vals[0] != 1 || vals[1] != -2 || vals[3] != 448) {
+					t.Errorf("%s = %v, want [1 -2 2^-9 448]", got.Name, vals)
+				}
+			}
+			e, ok := gf.Lookup("general.file_type")
+			if !ok || e.Value != tt.wantFT {
+				t.Errorf("general.file_type = %v, want %d", e.Value, tt.wantFT)
+			}
+		})
+	}
+
+	dir := writeModelDir(t, map[string]interface{}{}, tensors[:1])
+	if err := ConvertSafetensorsToGGUFWithOptions(dir, filepath.Join(dir, "out.gguf"), "llama", SafetensorsOptions{Cast: true, OutType: sharedgguf.TypeQ4_0}); err == nil {
+		t.Error("expected error for a block-quantized output type")
+	}
+}
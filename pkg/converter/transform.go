@@ -0,0 +1,223 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+)
+
+// ErrDropTensor is returned by TransformTensor for a tensor that a drop
+// transform leaves out of the output.
+var ErrDropTensor = errors.New("tensor dropped by architecture transform")
+
+// TransformTensor applies the transforms of architecture definition a whose
+// pattern matches t.Name, reading their parameters from config. t holds the
+// mapped GGUF name, type, shape and data of a tensor about to be written; its
+// Type and Data are replaced, never modified in place. A nil a does nothing.
+//
+// Row reorderings such as rope_permute and ungroup_qkv move whole rows of
+// bytes, so they also apply to block-quantized tensors whose rows are whole
+// blocks. transpose moves single values and needs a type without blocks. add and scale
+// need F32, F16 or BF16 data; add stores its result as F32, since a half
+// precision type would lose most of the bits of a small offset.
+//
+// Split and concat transforms are left to SplitTensor and
+// TensorConcatenator.
+//
+// A drop transform makes TransformTensor return ErrDropTensor; the tensor is
+// then not written.
+//
+// For each applied transform with a keep name, a copy of the tensor as it was
+// before that transform is returned under that name. Callers write the
+// copies whose names the model does not already have.
+func TransformTensor(a *gguf.Architecture, config map[string]interface{}, t *gguf.Tensor) ([]gguf.Tensor, error) {
+	if a == nil {
+		return nil, nil
+	}
+	var kept []gguf.Tensor
+	for i := range a.Transforms {
+		tr := &a.Transforms[i]
+		if tr.Op == gguf.OpSplit || tr.Op == gguf.OpConcat || !tr.Matches(t.Name) || !tr.Enabled(config) {
+			continue // split and concat are applied by SplitTensor and TensorConcatenator
+		}
+		if tr.Keep != "" {
+			kept = append(kept, gguf.Tensor{Name: tr.Keep, Type: t.Type, Shape: t.Shape, Data: t.Data})
+		}
+		var err error
+		switch tr.Op {
+		case gguf.OpRopePermute:
+			var heads int
+			if heads, err = configInt(config, tr.Heads); err == nil {
+				t.Data, err = ropePermute(t.Data, t.Shape, heads)
+			}
+		case gguf.OpAdd:
+			v := float32(tr.Value)
+			err = mapValues(t, sharedgguf.TypeF32, func(x float32) float32 { return x + v })
+		case gguf.OpScale:
+			factor := tr.Value
+			if tr.SqrtOf != "" {
+				var n int
+				if n, err = configInt(config, []string{tr.SqrtOf}); err != nil {
+					break
+				}
+				factor = math.Sqrt(float64(n))
+			}
+			f := float32(factor)
+			err = mapValues(t, t.Type, func(x float32) float32 { return x * f })
+		case gguf.OpDrop:
+			return nil, ErrDropTensor
+		case gguf.OpTranspose:
+			t.Data, t.Shape, err = transpose(t.Data, t.Shape, t.Type)
+		case gguf.OpUngroupQKV:
+			var heads, kvHeads int
+			if heads, err = configInt(config, tr.Heads); err != nil {
+				break
+			}
+			kvHeads = heads
+			if len(tr.KVHeads) > 0 {
+				if kvHeads, err = configInt(config, tr.KVHeads); err != nil {
+					break
+				}
+			}
+			t.Data, err = ungroupQKV(t.Data, t.Shape, heads, kvHeads)
+		default:
+			err = fmt.Errorf("unknown op")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tensor %q: %s: %w", t.Name, tr.Op, err)
+		}
+	}
+	return kept, nil
+}
+
+// mapValues replaces every value v of the float tensor t with f(v), stored
+// as typ.
+func mapValues(t *gguf.Tensor, typ int, f func(float32) float32) error {
+	switch t.Type {
+	case sharedgguf.TypeF32, sharedgguf.TypeF16, sharedgguf.TypeBF16:
+	default:
+		return fmt.Errorf("tensor type %d is not F32, F16 or BF16; the source model stores it quantized", t.Type)
+	}
+	vals, err := quantize.DequantizeTensor(t)
+	if err != nil {
+		return err
+	}
+	for i, v := range vals {
+		vals[i] = f(v)
+	}
+	data, err := quantize.EncodeFloats(typ, vals)
+	if err != nil {
+		return err
+	}
+	t.Type, t.Data = typ, data
+	return nil
+}
+
+// configInt returns the positive integer value of the first of keys present
+// in config.
+func configInt(config map[string]interface{}, keys []string) (int, error) {
+	for _, k := range keys {
+		v, ok := config[k]
+		if !ok {
+			continue
+		}
+		f, ok := v.(float64)
+		if !ok || f < 1 || f != float64(int(f)) {
+			return 0, fmt.Errorf("config.json %s = %v is not a positive integer", k, v)
+		}
+		return int(f), nil
+	}
+	return 0, fmt.Errorf("config.json has none of %v", keys)
+}
+
+// ropePermute reorders the rows of a query or key projection with heads
+// heads from the HuggingFace rotary layout to llama.cpp's. Within each head
+// of dim rows, row j*dim/2+i (half j of rotary pair i) moves to row 2*i+j.
+// This is llama.cpp's reshape(heads, 2, dim/2, ...).swapaxes(1, 2).
+func ropePermute(data []byte, shape []int, heads int) ([]byte, error) {
+	if len(shape) == 0 {
+		return nil, fmt.Errorf("scalar tensor")
+	}
+	rows := shape[0]
+	if rows%(2*heads) != 0 {
+		return nil, fmt.Errorf("%d rows do not split into %d heads of rotary pairs", rows, heads)
+	}
+	if len(data)%rows != 0 {
+		return nil, fmt.Errorf("%d bytes do not split into %d rows", len(data), rows)
+	}
+	rowBytes := len(data) / rows
+	dim := rows / heads
+	half := dim / 2
+	out := make([]byte, len(data))
+	for h := 0; h < heads; h++ {
+		for j := 0; j < 2; j++ {
+			for i := 0; i < half; i++ {
+				src := (h*dim + j*half + i) * rowBytes
+				dst := (h*dim + 2*i + j) * rowBytes
+				copy(out[dst:dst+rowBytes], data[src:src+rowBytes])
+			}
+		}
+	}
+	return out, nil
+}
+
+// ungroupQKV reorders the rows of a fused query, key and value projection
+// stored as kvHeads groups, each of heads/kvHeads query heads followed by a
+// key and a value head, into the query heads, then the key heads, then the
+// value heads. This is llama.cpp's conversion of Falcon's query_key_value;
+// with kvHeads equal to heads it is GPT-NeoX's.
+func ungroupQKV(data []byte, shape []int, heads, kvHeads int) ([]byte, error) {
+	if len(shape) == 0 {
+		return nil, fmt.Errorf("scalar tensor")
+	}
+	rows := shape[0]
+	if heads%kvHeads != 0 {
+		return nil, fmt.Errorf("%d query heads do not split into %d key-value groups", heads, kvHeads)
+	}
+	if rows%(heads+2*kvHeads) != 0 {
+		return nil, fmt.Errorf("%d rows do not split into %d query and %d key-value heads", rows, heads, kvHeads)
+	}
+	if len(data)%rows != 0 {
+		return nil, fmt.Errorf("%d bytes do not split into %d rows", len(data), rows)
+	}
+	headBytes := len(data) / rows * (rows / (heads + 2*kvHeads))
+	per := heads / kvHeads
+	out := make([]byte, len(data))
+	for g := 0; g < kvHeads; g++ {
+		src := g * (per + 2) * headBytes
+		copy(out[g*per*headBytes:], data[src:src+per*headBytes])
+		src += per * headBytes
+		copy(out[(heads+g)*headBytes:], data[src:src+headBytes])
+		src += headBytes
+		copy(out[(heads+kvHeads+g)*headBytes:], data[src:src+headBytes])
+	}
+	return out, nil
+}
+
+// transpose swaps the rows and columns of a 2-D tensor of type typ, which
+// must store single values rather than blocks, and returns the new data and
+// shape.
+func transpose(data []byte, shape []int, typ int) ([]byte, []int, error) {
+	if len(shape) != 2 {
+		return nil, nil, fmt.Errorf("shape %v is not 2-D", shape)
+	}
+	size, err := gguf.TensorSize(typ, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, cols := shape[0], shape[1]
+	if len(data) != rows*cols*size {
+		return nil, nil, fmt.Errorf("%d bytes do not hold %v values of %d bytes", len(data), shape, size)
+	}
+	out := make([]byte, len(data))
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			copy(out[(c*rows+r)*size:(c*rows+r+1)*size], data[(r*cols+c)*size:])
+		}
+	}
+	return out, []int{cols, rows}, nil
+}
@@ -0,0 +1,532 @@
+package converter
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+)
+
+// rows returns an F32 matrix of n rows of width 2 whose row r holds r.
+func rows(n int) []float32 {
+	vals := make([]float32, 2*n)
+	for r := range n {
+		vals[2*r], vals[2*r+1] = float32(r), float32(r)
+	}
+	return vals
+}
+
+// rowOrder returns the first value of each row of an F32 matrix of width 2.
+func rowOrder(t *testing.T, tensor *gguf.Tensor) []float32 {
+	t.Helper()
+	vals, err := quantize.DequantizeTensor(tensor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []float32
+	for i := 0; i < len(vals); i += 2 {
+		order = append(order, vals[i])
+	}
+	return order
+}
+
+func TestRopePermute(t *testing.T) {
+	// Two heads of dim 4: rows 0,1 hold the first halves of head 0's
+	// rotary pairs and rows 2,3 the second halves.
+	data := f32Bytes(rows(8))
+	got, err := ropePermute(data, []int{8, 2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := rowOrder(t, &gguf.Tensor{Type: sharedgguf.TypeF32, Shape: []int{8, 2}, Data: got})
+	if want := []float32{0, 2, 1, 3, 4, 6, 5, 7}; !slices.Equal(order, want) {
+		t.Errorf("row order = %v, want %v", order, want)
+	}
+	if bytes.Equal(data, got) || rowOrder(t, &gguf.Tensor{Type: sharedgguf.TypeF32, Shape: []int{8, 2}, Data: data})[1] != 1 {
+		t.Error("input data was modified or not permuted")
+	}
+
+	if _, err := ropePermute(data, []int{8, 2}, 3); err == nil {
+		t.Error("expected error for rows not divisible into heads")
+	}
+	if _, err := ropePermute(data[:len(data)-1], []int{8, 2}, 2); err == nil {
+		t.Error("expected error for ragged rows")
+	}
+}
+
+func TestTransformTensor(t *testing.T) {
+	llama, _ := gguf.LookupArchitecture("llama")
+	bert, _ := gguf.LookupArchitecture("bert")
+	config := map[string]interface{}{"num_attention_heads": float64(2), "num_key_value_heads": float64(1)}
+
+	tests := []struct {
+		name    string
+		arch    *gguf.Architecture
+		config  map[string]interface{}
+		tensor  string
+		want    []float32
+		wantErr string
+	}{
+		{"query uses attention heads", llama, config, "blk.0.attn_q.weight", []float32{0, 2, 1, 3, 4, 6, 5, 7}, ""},
+		{"key uses kv heads", llama, config, "blk.3.attn_k.weight", []float32{0, 4, 1, 5, 2, 6, 3, 7}, ""},
+		{"key falls back to attention heads", llama, map[string]interface{}{"num_attention_heads": float64(2)}, "blk.0.attn_k.weight", []float32{0, 2, 1, 3, 4, 6, 5, 7}, ""},
+		{"value untouched", llama, config, "blk.0.attn_v.weight", []float32{0, 1, 2, 3, 4, 5, 6, 7}, ""},
+		{"other architecture", bert, config, "blk.0.attn_q.weight", []float32{0, 1, 2, 3, 4, 5, 6, 7}, ""},
+		{"no definition", nil, nil, "blk.0.attn_q.weight", []float32{0, 1, 2, 3, 4, 5, 6, 7}, ""},
+		{"missing heads", llama, map[string]interface{}{}, "blk.0.attn_q.weight", nil, "none of [num_attention_heads]"},
+		{"bad heads", llama, map[string]interface{}{"num_attention_heads": "two"}, "blk.0.attn_q.weight", nil, "not a positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := gguf.Tensor{Name: tt.tensor, Type: sharedgguf.TypeF32, Shape: []int{8, 2}, Data: f32Bytes(rows(8))}
+			_, err := TransformTensor(tt.arch, tt.config, &tensor)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if order := rowOrder(t, &tensor); !slices.Equal(order, tt.want) {
+				t.Errorf("row order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertSafetensorsToGGUF_RopePermute(t *testing.T) {
+	// A GPTQ query projection: the permutation moves whole Q4_0 block rows.
+	f := quantFixture{method: "gptq", bits: 4, groupSize: 32, sym: true, out: 16, in: 128}
+	f.fill()
+	tensors := append(f.tensors("model.layers.0.self_attn.q_proj"), rawTensor{
+		name: "model.layers.0.self_attn.k_proj.weight", dtype: dtypeF32, shape: []uint64{4, 2}, data: f32Bytes(rows(4)),
+	})
+	config := f.config()
+	config["num_attention_heads"] = 4
+	config["num_key_value_heads"] = 1
+	dir := writeModelDir(t, config, tensors)
+	outPath := filepath.Join(dir, "out.gguf")
+	if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gf, err := gguf.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQ, err := ropePermute(f32Bytes(f.reference()), []int{f.out, f.in}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range gf.Tensors {
+		got := &gf.Tensors[i]
+		switch got.Name {
+		case "blk.0.attn_k.weight":
+			if order, want := rowOrder(t, got), []float32{0, 2, 1, 3}; !slices.Equal(order, want) {
+				t.Errorf("attn_k row order = %v, want %v", order, want)
+			}
+		case "blk.0.attn_q.weight":
+			if got.Type != sharedgguf.TypeQ4_0 {
+				t.Fatalf("attn_q type = %d, want Q4_0", got.Type)
+			}
+			vals, err := quantize.DequantizeTensor(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(f32Bytes(vals), wantQ) {
+				t.Error("attn_q rows are not rotary-permuted")
+			}
+		default:
+			t.Errorf("unexpected tensor %q", got.Name)
+		}
+	}
+}
+
+func TestTransformTensor_Values(t *testing.T) {
+	a, err := gguf.ParseArchitecture([]byte(`
+name: shifted
+transforms:
+  - {tensors: '_norm\.weight$', op: add, value: 1}
+  - {tensors: '^token_embd\.weight$', op: scale, sqrt_of: hidden_size, keep: output.weight}
+  - {tensors: '^blk\.\d+\.ffn_up\.weight$', op: scale, value: 0.5}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]interface{}{"hidden_size": float64(16)}
+	vals := []float32{0.25, -1, 0.00390625}
+
+	tests := []struct {
+		name     string
+		tensor   gguf.Tensor
+		wantType int
+		want     []float32
+		wantKept bool
+	}{
+		{"add stores F32", gguf.Tensor{Name: "blk.0.attn_norm.weight", Type: sharedgguf.TypeBF16, Shape: []int{3}}, sharedgguf.TypeF32, []float32{1.25, 0, 1.00390625}, false},
+		{"scale by square root", gguf.Tensor{Name: "token_embd.weight", Type: sharedgguf.TypeF16, Shape: []int{1, 3}}, sharedgguf.TypeF16, []float32{1, -4, 0.015625}, true},
+		{"scale by value", gguf.Tensor{Name: "blk.1.ffn_up.weight", Type: sharedgguf.TypeF32, Shape: []int{3, 1}}, sharedgguf.TypeF32, []float32{0.125, -0.5, 0.001953125}, false},
+		{"no match", gguf.Tensor{Name: "blk.0.ffn_down.weight", Type: sharedgguf.TypeF32, Shape: []int{3}}, sharedgguf.TypeF32, vals, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensor := tt.tensor
+			if tensor.Data, err = quantize.EncodeFloats(tensor.Type, vals); err != nil {
+				t.Fatal(err)
+			}
+			orig := tensor
+			kept, err := TransformTensor(a, config, &tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tensor.Type != tt.wantType {
+				t.Errorf("type = %d, want %d", tensor.Type, tt.wantType)
+			}
+			got, err := quantize.DequantizeTensor(&tensor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+			if !tt.wantKept {
+				if len(kept) != 0 {
+					t.Errorf("kept %d tensors, want none", len(kept))
+				}
+				return
+			}
+			if len(kept) != 1 || kept[0].Name != "output.weight" || kept[0].Type != orig.Type || !bytes.Equal(kept[0].Data, orig.Data) {
+				t.Errorf("kept = %+v, want the untransformed tensor as output.weight", kept)
+			}
+		})
+	}
+
+	q4 := gguf.Tensor{Name: "blk.0.attn_norm.weight", Type: sharedgguf.TypeQ4_0, Shape: []int{32}, Data: make([]byte, 18)}
+	if _, err := TransformTensor(a, config, &q4); err == nil || !strings.Contains(err.Error(), "source model stores it quantized") {
+		t.Errorf("add on Q4_0: err = %v", err)
+	}
+	embd := gguf.Tensor{Name: "token_embd.weight", Type: sharedgguf.TypeF32, Shape: []int{1}, Data: f32Bytes([]float32{1})}
+	if _, err := TransformTensor(a, nil, &embd); err == nil || !strings.Contains(err.Error(), "hidden_size") {
+		t.Errorf("scale without hidden_size: err = %v", err)
+	}
+}
+
+func TestConvertSafetensorsToGGUF_Gemma2(t *testing.T) {
+	embd := []float32{1, 2, -3, 0.5, 0, 4, 8, -1}
+	norm := []float32{0.5, -0.25, 0, 2}
+	var tensors []rawTensor
+	for _, n := range []string{"model.norm.weight", "model.layers.0.input_layernorm.weight", "model.layers.0.post_attention_layernorm.weight", "model.layers.0.pre_feedforward_layernorm.weight", "model.layers.0.post_feedforward_layernorm.weight"} {
+		tensors = append(tensors, rawTensor{name: n, dtype: dtypeF32, shape: []uint64{4}, data: f32Bytes(norm)})
+	}
+	tensors = append(tensors,
+		rawTensor{name: "model.embed_tokens.weight", dtype: dtypeF16, shape: []uint64{2, 4}, data: f16Bytes(embd)},
+		rawTensor{name: "model.layers.0.self_attn.q_proj.weight", dtype: dtypeF32, shape: []uint64{4, 2}, data: f32Bytes(rows(4))},
+	)
+	config := map[string]interface{}{
+		"architectures":           []string{"Gemma2ForCausalLM"},
+		"model_type":              "gemma2",
+		"hidden_size":             4,
+		"num_attention_heads":     1,
+		"head_dim":                256,
+		"attn_logit_softcapping":  50.0,
+		"final_logit_softcapping": 30.0,
+		"sliding_window":          4096,
+		"query_pre_attn_scalar":   224,
+	}
+	dir := writeModelDir(t, config, tensors)
+	outPath := filepath.Join(dir, "out.gguf")
+	if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gf, err := gguf.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shifted := make([]float32, len(norm))
+	for i, v := range norm {
+		shifted[i] = v + 1
+	}
+	want := map[string][]float32{
+		"output_norm.weight":               shifted,
+		"blk.0.attn_norm.weight":           shifted,
+		"blk.0.post_attention_norm.weight": shifted,
+		"blk.0.ffn_norm.weight":            shifted,
+		"blk.0.post_ffw_norm.weight":       shifted,
+		"token_embd.weight":                embd,    // llama.cpp scales it and reuses it as the output head
+		"blk.0.attn_q.weight":              rows(4), // Gemma is not rotary-permuted
+	}
+	if len(gf.Tensors) != len(want) {
+		t.Errorf("got %d tensors, want %d", len(gf.Tensors), len(want))
+	}
+	for i := range gf.Tensors {
+		got := &gf.Tensors[i]
+		w, ok := want[got.Name]
+		if !ok {
+			t.Errorf("unexpected tensor %q", got.Name)
+			continue
+		}
+		vals, err := quantize.DequantizeTensor(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(vals, w) {
+			t.Errorf("%s = %v, want %v", got.Name, vals, w)
+		}
+	}
+
+	for key, wantVal := range map[string]any{
+		"general.architecture":                   "gemma2",
+		"gemma2.attn_logit_softcapping":          float32(50),
+		"gemma2.final_logit_softcapping":         float32(30),
+		"gemma2.attention.sliding_window":        uint32(4096),
+		"gemma2.attention.query_pre_attn_scalar": float32(224),
+		"gemma2.attention.key_length":            uint32(256),
+	} {
+		if e, ok := gf.Lookup(key); !ok || e.Value != wantVal {
+			t.Errorf("%s = %v (present %v), want %v", key, e.Value, ok, wantVal)
+		}
+	}
+}
+
+func TestConvertSafetensorsToGGUF_Qwen(t *testing.T) {
+	vec := f32Bytes([]float32{1, 2})
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		source map[string]string // source → GGUF name, "" if not written
+		wantMD map[string]any
+		noMD   []string
+	}{
+		{
+			name: "qwen2 tied",
+			config: map[string]interface{}{
+				"architectures": []string{"Qwen2ForCausalLM"}, "tie_word_embeddings": true,
+				"rope_theta": 1000000.0, "sliding_window": 32768, "use_sliding_window": false,
+			},
+			source: map[string]string{
+				"model.embed_tokens.weight":            "token_embd.weight",
+				"lm_head.weight":                       "",
+				"model.layers.0.self_attn.q_proj.bias": "blk.0.attn_q.bias",
+				"model.layers.0.self_attn.k_proj.bias": "blk.0.attn_k.bias",
+				"model.layers.0.self_attn.v_proj.bias": "blk.0.attn_v.bias",
+			},
+			wantMD: map[string]any{"general.architecture": "qwen2", "qwen2.rope.freq_base": float32(1e6)},
+			noMD:   []string{"qwen2.attention.sliding_window"},
+		},
+		{
+			name: "qwen3",
+			config: map[string]interface{}{
+				"model_type": "qwen3", "tie_word_embeddings": false, "head_dim": 128,
+				"sliding_window": 4096, "use_sliding_window": true,
+			},
+			source: map[string]string{
+				"model.embed_tokens.weight":              "token_embd.weight",
+				"lm_head.weight":                         "output.weight",
+				"model.layers.0.self_attn.q_norm.weight": "blk.0.attn_q_norm.weight",
+				"model.layers.0.self_attn.k_norm.weight": "blk.0.attn_k_norm.weight",
+			},
+			wantMD: map[string]any{"general.architecture": "qwen3", "qwen3.attention.sliding_window": uint32(4096), "qwen3.attention.key_length": uint32(128)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tensors []rawTensor
+			for name := range tt.source {
+				tensors = append(tensors, rawTensor{name: name, dtype: dtypeF32, shape: []uint64{2}, data: vec})
+			}
+			dir := writeModelDir(t, tt.config, tensors)
+			outPath := filepath.Join(dir, "out.gguf")
+			if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			gf, err := gguf.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written := 0
+			for src, want := range tt.source {
+				if want == "" {
+					continue
+				}
+				written++
+				if findTensor(gf, want) == nil {
+					t.Errorf("%s not written as %s", src, want)
+				}
+			}
+			if len(gf.Tensors) != written {
+				t.Errorf("got %d tensors, want %d", len(gf.Tensors), written)
+			}
+			if findTensor(gf, "output.weight") != nil && tt.config["tie_word_embeddings"] == true {
+				t.Error("tied output.weight was written")
+			}
+			for key, v := range tt.wantMD {
+				if e, ok := gf.Lookup(key); !ok || e.Value != v {
+					t.Errorf("%s = %v (present %v), want %v", key, e.Value, ok, v)
+				}
+			}
+			for _, key := range tt.noMD {
+				if _, ok := gf.Lookup(key); ok {
+					t.Errorf("unexpected %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestUngroupQKV(t *testing.T) {
+	// Rows of head dim 1: row r holds r.
+	tests := []struct {
+		name           string
+		heads, kvHeads int
+		want           []float32
+	}{
+		// GPT-NeoX: q0 k0 v0 q1 k1 v1.
+		{"per head", 2, 2, []float32{0, 3, 1, 4, 2, 5}},
+		// Falcon: q0 q1 k0 v0 q2 q3 k1 v1.
+		{"grouped", 4, 2, []float32{0, 1, 4, 5, 2, 6, 3, 7}},
+		// Falcon-7B multi-query: already q0 q1 q2 k v.
+		{"multi-query", 3, 1, []float32{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		n := len(tt.want)
+		got, err := ungroupQKV(f32Bytes(rows(n)), []int{n, 2}, tt.heads, tt.kvHeads)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if order := rowOrder(t, &gguf.Tensor{Type: sharedgguf.TypeF32, Shape: []int{n, 2}, Data: got}); !slices.Equal(order, tt.want) {
+			t.Errorf("%s: row order = %v, want %v", tt.name, order, tt.want)
+		}
+	}
+	if _, err := ungroupQKV(f32Bytes(rows(6)), []int{6, 2}, 3, 2); err == nil {
+		t.Error("expected error for query heads not divisible into groups")
+	}
+	if _, err := ungroupQKV(f32Bytes(rows(7)), []int{7, 2}, 2, 2); err == nil {
+		t.Error("expected error for rows not divisible into heads")
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	got, shape, err := transpose(f32Bytes([]float32{1, 2, 3, 4, 5, 6}), []int{2, 3}, sharedgguf.TypeF32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, _ := quantize.DequantizeTensor(&gguf.Tensor{Type: sharedgguf.TypeF32, Shape: shape, Data: got})
+	if !slices.Equal(shape, []int{3, 2}) || !slices.Equal(vals, []float32{1, 4, 2, 5, 3, 6}) {
+		t.Errorf("transpose = %v %v, want [3 2] [1 4 2 5 3 6]", shape, vals)
+	}
+	if _, _, err := transpose(make([]byte, 36), []int{1, 32}, sharedgguf.TypeQ8_0); err == nil {
+		t.Error("expected error for a block-quantized tensor")
+	}
+	if _, _, err := transpose(f32Bytes(rows(2)), []int{4}, sharedgguf.TypeF32); err == nil {
+		t.Error("expected error for a 1-D tensor")
+	}
+}
+
+func TestConvertSafetensorsToGGUF_ResearchLayouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		source   string
+		shape    []uint64
+		data     []float32
+		want     string
+		wantVals []float32
+		dropped  string
+		wantArc  string
+		wantMeta map[string]any
+	}{
+		{
+			name:     "gpt2 conv1d",
+			config:   map[string]interface{}{"architectures": []string{"GPT2LMHeadModel"}, "n_embd": 2, "n_head": 1},
+			source:   "transformer.h.0.mlp.c_fc.weight",
+			shape:    []uint64{2, 3},
+			data:     []float32{1, 2, 3, 4, 5, 6},
+			want:     "blk.0.ffn_up.weight",
+			wantVals: []float32{1, 4, 2, 5, 3, 6},
+			dropped:  "transformer.h.0.attn.bias",
+			wantArc:  "gpt2",
+		},
+		{
+			name:     "gptneox",
+			config:   map[string]interface{}{"model_type": "gpt_neox", "num_attention_heads": 2, "hidden_size": 2},
+			source:   "gpt_neox.layers.0.attention.query_key_value.bias",
+			shape:    []uint64{6},
+			data:     []float32{0, 1, 2, 3, 4, 5},
+			want:     "blk.0.attn_qkv.bias",
+			wantVals: []float32{0, 3, 1, 4, 2, 5},
+			dropped:  "gpt_neox.layers.0.attention.rotary_emb.inv_freq",
+			wantArc:  "gptneox",
+			wantMeta: map[string]any{"gptneox.use_parallel_residual": true},
+		},
+		{
+			name: "falcon new decoder architecture",
+			config: map[string]interface{}{
+				"architectures": []string{"FalconForCausalLM"}, "new_decoder_architecture": true,
+				"num_attention_heads": 4, "num_kv_heads": 2,
+			},
+			source:   "transformer.h.0.self_attention.query_key_value.weight",
+			shape:    []uint64{8, 1},
+			data:     []float32{0, 1, 2, 3, 4, 5, 6, 7},
+			want:     "blk.0.attn_qkv.weight",
+			wantVals: []float32{0, 1, 4, 5, 2, 6, 3, 7},
+			wantArc:  "falcon",
+		},
+		{
+			name:     "falcon multi-query",
+			config:   map[string]interface{}{"architectures": []string{"FalconForCausalLM"}, "multi_query": true, "num_attention_heads": 3},
+			source:   "transformer.h.0.self_attention.query_key_value.weight",
+			shape:    []uint64{5, 1},
+			data:     []float32{0, 1, 2, 3, 4},
+			want:     "blk.0.attn_qkv.weight",
+			wantVals: []float32{0, 1, 2, 3, 4},
+			wantArc:  "falcon",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tensors := []rawTensor{{name: tt.source, dtype: dtypeF32, shape: tt.shape, data: f32Bytes(tt.data)}}
+			if tt.dropped != "" {
+				tensors = append(tensors, rawTensor{name: tt.dropped, dtype: dtypeF32, shape: []uint64{2}, data: f32Bytes([]float32{1, 1})})
+			}
+			dir := writeModelDir(t, tt.config, tensors)
+			outPath := filepath.Join(dir, "out.gguf")
+			if err := ConvertSafetensorsToGGUF(dir, outPath, ""); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			gf, err := gguf.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e, _ := gf.Lookup("general.architecture"); e.Value != tt.wantArc {
+				t.Errorf("general.architecture = %v, want %s", e.Value, tt.wantArc)
+			}
+			for key, want := range tt.wantMeta {
+				if e, ok := gf.Lookup(key); !ok || e.Value != want {
+					t.Errorf("%s = %v (present %v), want %v", key, e.Value, ok, want)
+				}
+			}
+			if len(gf.Tensors) != 1 {
+				t.Errorf("got %d tensors, want 1", len(gf.Tensors))
+			}
+			got := findTensor(gf, tt.want)
+			if got == nil {
+				t.Fatalf("missing %s", tt.want)
+			}
+			vals, err := quantize.DequantizeTensor(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(vals, tt.wantVals) {
+				t.Errorf("%s = %v, want %v", tt.want, vals, tt.wantVals)
+			}
+		})
+	}
+}
@@ -0,0 +1,562 @@
+package gguf
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Architecture declares how one model family's HuggingFace tensor names and
+// config.json keys map to GGUF. Definitions are written in YAML or JSON; the
+// built-in ones are embedded from archs/*.yaml.
+type Architecture struct {
+	// Name is the architecture name, as given to --arch.
+	Name string `json:"name" yaml:"name"`
+	// ModelTypes and HFArchitectures list the config.json model_type values
+	// and architectures class names DetectArchitecture recognizes, besides
+	// Name itself as a model_type.
+	ModelTypes      []string `json:"model_types,omitempty" yaml:"model_types,omitempty"`
+	HFArchitectures []string `json:"hf_architectures,omitempty" yaml:"hf_architectures,omitempty"`
+	// LayerPattern matches per-layer tensor names and captures the layer
+	// number and the suffix looked up in LayerSuffixes. A match maps to
+	// "blk.<layer>.<GGUF suffix>".
+	LayerPattern  string            `json:"layer_pattern,omitempty" yaml:"layer_pattern,omitempty"`
+	LayerSuffixes map[string]string `json:"layer_suffixes,omitempty" yaml:"layer_suffixes,omitempty"`
+	// ExpertPattern matches the per-expert tensor names of mixture-of-experts
+	// layers and captures the layer number, the expert number and the suffix
+	// looked up in ExpertSuffixes. The experts of one layer and suffix are
+	// stacked into a single tensor named "blk.<layer>.<GGUF suffix>".
+	ExpertPattern  string            `json:"expert_pattern,omitempty" yaml:"expert_pattern,omitempty"`
+	ExpertSuffixes map[string]string `json:"expert_suffixes,omitempty" yaml:"expert_suffixes,omitempty"`
+	// StaticTensors maps whole non-layer tensor names to GGUF names.
+	StaticTensors map[string]string `json:"static_tensors,omitempty" yaml:"static_tensors,omitempty"`
+	// Config maps config.json keys to typed GGUF metadata keys, in output
+	// order.
+	Config []ConfigKey `json:"config,omitempty" yaml:"config,omitempty"`
+	// Metadata holds metadata entries with fixed values, written after the
+	// config keys.
+	Metadata []StaticMetadata `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Transforms lists the operations conversion applies to tensor data
+	// after name mapping, in order.
+	Transforms []TensorTransform `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+
+	layerRE  *regexp.Regexp
+	expertRE *regexp.Regexp
+	static   []MetadataEntry
+}
+
+// ConfigKey maps one config.json key to a GGUF metadata key. GGUFKey may
+// contain the {arch} placeholder; Type is "uint32", "float32", "bool" or
+// "string".
+// When, if set, names a config.json key that must be true for the entry to
+// be written, as use_sliding_window for sliding_window.
+//
+// Times multiplies a numeric value, and TimesHeadDim multiplies it by the
+// attention head dimension (head_dim, or hidden_size divided by
+// num_attention_heads), as GPT-NeoX's rotary_pct becomes a rotary dimension
+// count.
+//
+// Only the first entry written for a GGUF key counts, so later config keys
+// and static metadata for it serve as fallbacks, as n_embd times 4 for a
+// GPT-2 feed-forward length without n_inner.
+type ConfigKey struct {
+	HFKey        string  `json:"hf_key" yaml:"hf_key"`
+	GGUFKey      string  `json:"gguf_key" yaml:"gguf_key"`
+	Type         string  `json:"type" yaml:"type"`
+	When         string  `json:"when,omitempty" yaml:"when,omitempty"`
+	Times        float64 `json:"times,omitempty" yaml:"times,omitempty"`
+	TimesHeadDim bool    `json:"times_head_dim,omitempty" yaml:"times_head_dim,omitempty"`
+}
+
+// StaticMetadata is a metadata entry with a fixed value. Key may contain the
+// {arch} placeholder; Type is "uint32", "float32", "bool" or "string".
+type StaticMetadata struct {
+	Key   string `json:"key" yaml:"key"`
+	Type  string `json:"type" yaml:"type"`
+	Value any    `json:"value" yaml:"value"`
+}
+
+// TensorTransform declares an operation on the data of the tensors whose
+// GGUF names match the Tensors pattern. Op is one of:
+//
+//   - "rope_permute": reorder the rows of a query or key projection from the
+//     HuggingFace rotary layout, where each head holds the first halves of its
+//     rotary pairs followed by the second halves, to the interleaved pairs
+//     llama.cpp rotates. Heads lists the config.json keys giving the number of
+//     heads; the first one present is used. Architectures whose rotary heads
+//     llama.cpp also rotates in halves need no permutation.
+//   - "add": add Value to every element, as for RMSNorm weights stored as an
+//     offset from one.
+//   - "scale": multiply every element by Value, or by the square root of the
+//     config.json value SqrtOf, as for embeddings a runtime does not scale.
+//   - "drop": leave the tensor out, as a copy of the embedding serving as a
+//     tied output head.
+//   - "transpose": swap the rows and columns of a 2-D tensor, as for GPT-2's
+//     Conv1D weights stored [in, out] rather than [out, in].
+//   - "ungroup_qkv": reorder the rows of a fused query, key and value
+//     projection stored in groups, each of its query heads followed by one
+//     key and one value head, into all query rows, then the key and the value
+//     rows, as llama.cpp reads attn_qkv. Heads and KVHeads list the
+//     config.json keys giving the number of query and key-value heads; without
+//     KVHeads every query head has its own key and value, as in GPT-NeoX.
+//   - "split": cut a fused tensor, such as Phi-3's qkv_proj, into Parts by
+//     rows, in order.
+//   - "concat": join the Parts, each a tensor matching Tensors, by rows in
+//     order into the tensor named Into.
+//
+// Part and Into names may refer to the groups of the Tensors pattern as $1,
+// $2 and so on. Split and concat run before the other transforms, which see
+// the split parts and the parts of a concatenation rather than the fused
+// tensor.
+//
+// When names a config.json key that must be true for the transform to apply.
+//
+// Keep names a tensor that also receives the untransformed data unless the
+// model has a tensor of that name. No built-in definition uses it; it lets an
+// --arch-file definition that transforms a tied embedding still write the
+// output head from the original values.
+type TensorTransform struct {
+	Tensors string       `json:"tensors" yaml:"tensors"`
+	Op      string       `json:"op" yaml:"op"`
+	Heads   []string     `json:"heads,omitempty" yaml:"heads,omitempty"`
+	KVHeads []string     `json:"kv_heads,omitempty" yaml:"kv_heads,omitempty"`
+	Value   float64      `json:"value,omitempty" yaml:"value,omitempty"`
+	SqrtOf  string       `json:"sqrt_of,omitempty" yaml:"sqrt_of,omitempty"`
+	Keep    string       `json:"keep,omitempty" yaml:"keep,omitempty"`
+	When    string       `json:"when,omitempty" yaml:"when,omitempty"`
+	Parts   []TensorPart `json:"parts,omitempty" yaml:"parts,omitempty"`
+	Into    string       `json:"into,omitempty" yaml:"into,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// TensorPart is one row range of a split or concat transform. Its row count
+// is the config.json value of the first of Rows present, or that of Heads
+// times the head dimension (head_dim, or hidden_size divided by
+// num_attention_heads). One part of a split may give neither and takes the
+// remaining rows; a concat checks the counts given.
+type TensorPart struct {
+	Name  string   `json:"name" yaml:"name"`
+	Rows  []string `json:"rows,omitempty" yaml:"rows,omitempty"`
+	Heads []string `json:"heads,omitempty" yaml:"heads,omitempty"`
+}
+
+// Transform operations.
+const (
+	OpRopePermute = "rope_permute"
+	OpAdd         = "add"
+	OpScale       = "scale"
+	OpDrop        = "drop"
+	OpTranspose   = "transpose"
+	OpUngroupQKV  = "ungroup_qkv"
+	OpSplit       = "split"
+	OpConcat      = "concat"
+)
+
+// Matches reports whether t applies to the tensor with GGUF name name.
+func (t *TensorTransform) Matches(name string) bool {
+	return t.re != nil && t.re.MatchString(name)
+}
+
+// Expand returns template with $1, $2 and so on replaced by the groups of
+// the Tensors pattern matched in name, as for the names of Parts and Into.
+// It returns "" if name does not match.
+func (t *TensorTransform) Expand(name, template string) string {
+	if t.re == nil {
+		return ""
+	}
+	m := t.re.FindStringSubmatchIndex(name)
+	if m == nil {
+		return ""
+	}
+	return string(t.re.ExpandString(nil, template, name, m))
+}
+
+// Enabled reports whether config satisfies the When condition of t.
+func (t *TensorTransform) Enabled(config map[string]interface{}) bool {
+	return t.When == "" || config[t.When] == true
+}
+
+// compile validates t and prepares its tensor pattern.
+func (t *TensorTransform) compile() error {
+	if t.Tensors == "" {
+		return fmt.Errorf("transform %q needs a tensors pattern", t.Op)
+	}
+	re, err := regexp.Compile(t.Tensors)
+	if err != nil {
+		return fmt.Errorf("transform %q: tensors: %w", t.Op, err)
+	}
+	switch t.Op {
+	case OpRopePermute, OpUngroupQKV:
+		if len(t.Heads) == 0 {
+			return fmt.Errorf("transform %q needs heads", t.Op)
+		}
+	case OpAdd:
+		if t.Value == 0 {
+			return fmt.Errorf("transform %q needs a non-zero value", t.Op)
+		}
+	case OpScale:
+		if (t.Value == 0) == (t.SqrtOf == "") {
+			return fmt.Errorf("transform %q needs either a non-zero value or sqrt_of", t.Op)
+		}
+	case OpDrop, OpTranspose:
+	case OpSplit, OpConcat:
+		if len(t.Parts) < 2 {
+			return fmt.Errorf("transform %q needs at least two parts", t.Op)
+		}
+		if t.Keep != "" {
+			return fmt.Errorf("transform %q cannot keep a copy", t.Op)
+		}
+		if (t.Op == OpConcat) != (t.Into != "") {
+			return fmt.Errorf("transform %q: into is given for concat and only for concat", t.Op)
+		}
+		rest := 0
+		for _, p := range t.Parts {
+			if p.Name == "" {
+				return fmt.Errorf("transform %q: part needs a name", t.Op)
+			}
+			if len(p.Rows) > 0 && len(p.Heads) > 0 {
+				return fmt.Errorf("transform %q: part %q gives both rows and heads", t.Op, p.Name)
+			}
+			if len(p.Rows) == 0 && len(p.Heads) == 0 {
+				rest++
+			}
+		}
+		if t.Op == OpSplit && rest > 1 {
+			return fmt.Errorf("transform %q: %d parts without rows or heads, want at most one", t.Op, rest)
+		}
+	default:
+		return fmt.Errorf("unknown transform op %q: want %s, %s, %s, %s, %s, %s, %s or %s", t.Op, OpRopePermute, OpAdd, OpScale, OpDrop, OpTranspose, OpUngroupQKV, OpSplit, OpConcat)
+	}
+	t.re = re
+	return nil
+}
+
+// metaTypeNames gives the metadata types a definition may use.
+var metaTypeNames = map[string]uint32{
+	"uint32":  MetaTypeUint32,
+	"float32": MetaTypeFloat32,
+	"bool":    MetaTypeBool,
+	"string":  MetaTypeString,
+}
+
+// defaultArch is the built-in architecture whose config keys and metadata
+// apply to architecture names without a definition.
+const defaultArch = "llama"
+
+//go:embed archs/*.yaml
+var builtinArchFS embed.FS
+
+var (
+	archMu sync.RWMutex
+	// archs holds the registered definitions, most recently registered
+	// first, followed by the built-ins in name order.
+	archs []*Architecture
+)
+
+func init() {
+	entries, err := builtinArchFS.ReadDir("archs")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		data, err := builtinArchFS.ReadFile(path.Join("archs", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		a, err := ParseArchitecture(data)
+		if err != nil {
+			panic(fmt.Sprintf("built-in architecture %s: %v", e.Name(), err))
+		}
+		archs = append(archs, a)
+	}
+}
+
+// ParseArchitecture parses and validates an architecture definition in YAML
+// or JSON. Unknown fields are rejected so typos do not go unnoticed.
+func ParseArchitecture(data []byte) (*Architecture, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var a Architecture
+	if err := dec.Decode(&a); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("empty architecture definition")
+		}
+		return nil, fmt.Errorf("parse architecture definition: %w", err)
+	}
+	if err := a.compile(); err != nil {
+		return nil, fmt.Errorf("architecture %q: %w", a.Name, err)
+	}
+	return &a, nil
+}
+
+// LoadArchitecture reads an architecture definition file in YAML or JSON.
+func LoadArchitecture(path string) (*Architecture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read architecture definition: %w", err)
+	}
+	return ParseArchitecture(data)
+}
+
+// compile validates a and prepares its layer pattern and static metadata.
+func (a *Architecture) compile() error {
+	if a.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if a.LayerPattern != "" {
+		re, err := regexp.Compile(a.LayerPattern)
+		if err != nil {
+			return fmt.Errorf("layer_pattern: %w", err)
+		}
+		if re.NumSubexp() != 2 {
+			return fmt.Errorf("layer_pattern %q has %d capture groups, want 2 (layer number and suffix)", a.LayerPattern, re.NumSubexp())
+		}
+		a.layerRE = re
+	} else if len(a.LayerSuffixes) > 0 {
+		return fmt.Errorf("layer_suffixes given without layer_pattern")
+	}
+	if a.ExpertPattern != "" {
+		re, err := regexp.Compile(a.ExpertPattern)
+		if err != nil {
+			return fmt.Errorf("expert_pattern: %w", err)
+		}
+		if re.NumSubexp() != 3 {
+			return fmt.Errorf("expert_pattern %q has %d capture groups, want 3 (layer number, expert number and suffix)", a.ExpertPattern, re.NumSubexp())
+		}
+		a.expertRE = re
+	} else if len(a.ExpertSuffixes) > 0 {
+		return fmt.Errorf("expert_suffixes given without expert_pattern")
+	}
+	for _, c := range a.Config {
+		if c.HFKey == "" || c.GGUFKey == "" {
+			return fmt.Errorf("config entry %+v needs hf_key and gguf_key", c)
+		}
+		if _, ok := metaTypeNames[c.Type]; !ok {
+			return fmt.Errorf("config key %q: unsupported type %q: want uint32, float32, bool or string", c.HFKey, c.Type)
+		}
+	}
+	a.static = nil
+	for _, m := range a.Metadata {
+		e, ok := metadataEntry(m.Key, metaTypeNames[m.Type], m.Value)
+		if m.Key == "" || !ok {
+			return fmt.Errorf("metadata %q: value %v is not a %q", m.Key, m.Value, m.Type)
+		}
+		a.static = append(a.static, e)
+	}
+	for i := range a.Transforms {
+		if err := a.Transforms[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metadataEntry converts a config or definition value to a metadata entry
+// of type typ, reporting false if it does not fit.
+func metadataEntry(key string, typ uint32, v any) (MetadataEntry, bool) {
+	var value any
+	var err error
+	switch typ {
+	case MetaTypeUint32:
+		value, err = toUint32(v)
+	case MetaTypeFloat32:
+		value, err = toFloat32(v)
+	case MetaTypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			return MetadataEntry{}, false
+		}
+		value = b
+	case MetaTypeString:
+		s, ok := v.(string)
+		if !ok {
+			return MetadataEntry{}, false
+		}
+		value = s
+	default:
+		return MetadataEntry{}, false
+	}
+	if err != nil {
+		return MetadataEntry{}, false
+	}
+	return MetadataEntry{Key: key, Type: typ, Value: value}, true
+}
+
+// RegisterArchitecture adds a to the architectures MapTensorName and
+// MapMetadata consult, ahead of those registered earlier and of the
+// built-ins. A definition with the same name is replaced.
+func RegisterArchitecture(a *Architecture) error {
+	if err := a.compile(); err != nil {
+		return fmt.Errorf("architecture %q: %w", a.Name, err)
+	}
+	archMu.Lock()
+	defer archMu.Unlock()
+	kept := []*Architecture{a}
+	for _, b := range archs {
+		if b.Name != a.Name {
+			kept = append(kept, b)
+		}
+	}
+	archs = kept
+	return nil
+}
+
+// LookupArchitecture returns the registered or built-in definition named
+// name.
+func LookupArchitecture(name string) (*Architecture, bool) {
+	archMu.RLock()
+	defer archMu.RUnlock()
+	for _, a := range archs {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// registeredArchs returns the definitions in lookup order.
+func registeredArchs() []*Architecture {
+	archMu.RLock()
+	defer archMu.RUnlock()
+	return archs
+}
+
+// MapTensorName converts a HuggingFace tensor name to its GGUF name using
+// this definition alone, reporting false if no mapping matches.
+func (a *Architecture) MapTensorName(name string) (string, bool) {
+	if gguf, ok := a.StaticTensors[name]; ok {
+		return gguf, true
+	}
+	if a.layerRE != nil {
+		if m := a.layerRE.FindStringSubmatch(name); m != nil {
+			if suffix, ok := a.LayerSuffixes[m[2]]; ok {
+				return "blk." + m[1] + "." + suffix, true
+			}
+		}
+	}
+	return "", false
+}
+
+// MapExpertName converts the HuggingFace name of one expert's tensor to the
+// GGUF name of the stacked tensor it belongs to and returns the expert
+// number, reporting false if the expert pattern does not match.
+func (a *Architecture) MapExpertName(name string) (string, int, bool) {
+	if a.expertRE == nil {
+		return "", 0, false
+	}
+	m := a.expertRE.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	suffix, ok := a.ExpertSuffixes[m[3]]
+	if !ok {
+		return "", 0, false
+	}
+	expert, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return "blk." + m[1] + "." + suffix, expert, true
+}
+
+// mapMetadata returns the config and static metadata entries of a, with
+// {arch} replaced by arch. Entries for a key already written are skipped.
+func (a *Architecture) mapMetadata(arch string, config map[string]interface{}) []MetadataEntry {
+	var entries []MetadataEntry
+	seen := map[string]bool{}
+	add := func(e MetadataEntry) {
+		if !seen[e.Key] {
+			seen[e.Key] = true
+			entries = append(entries, e)
+		}
+	}
+	for _, c := range a.Config {
+		val, ok := config[c.HFKey]
+		if !ok || (c.When != "" && config[c.When] != true) {
+			continue
+		}
+		if c.Times != 0 || c.TimesHeadDim {
+			if val, ok = scaleValue(config, c, val); !ok {
+				continue
+			}
+		}
+		if e, ok := metadataEntry(replaceArch(c.GGUFKey, arch), metaTypeNames[c.Type], val); ok {
+			add(e)
+		}
+	}
+	for _, e := range a.static {
+		e.Key = replaceArch(e.Key, arch)
+		add(e)
+	}
+	return entries
+}
+
+// scaleValue multiplies the config value v of c as c's Times and
+// TimesHeadDim declare, reporting false if v is not a number or config has
+// no head dimension.
+func scaleValue(config map[string]interface{}, c ConfigKey, v any) (float64, bool) {
+	var x float64
+	switch n := v.(type) {
+	case float64:
+		x = n
+	case int:
+		x = float64(n)
+	case int64:
+		x = float64(n)
+	default:
+		return 0, false
+	}
+	if c.Times != 0 {
+		x *= c.Times
+	}
+	if c.TimesHeadDim {
+		dim, err := HeadDim(config)
+		if err != nil {
+			return 0, false
+		}
+		x *= float64(dim)
+	}
+	return x, true
+}
+
+// HeadDim returns the attention head dimension from config.json: head_dim,
+// or hidden_size divided by num_attention_heads.
+func HeadDim(config map[string]interface{}) (int, error) {
+	if _, ok := config["head_dim"]; ok {
+		return positiveConfigInt(config, "head_dim")
+	}
+	hidden, err := positiveConfigInt(config, "hidden_size")
+	if err != nil {
+		return 0, err
+	}
+	heads, err := positiveConfigInt(config, "num_attention_heads")
+	if err != nil {
+		return 0, err
+	}
+	if hidden%heads != 0 {
+		return 0, fmt.Errorf("config.json hidden_size %d is not a multiple of num_attention_heads %d", hidden, heads)
+	}
+	return hidden / heads, nil
+}
+
+// positiveConfigInt returns the config.json value key as a positive integer.
+func positiveConfigInt(config map[string]interface{}, key string) (int, error) {
+	v, ok := config[key]
+	if !ok {
+		return 0, fmt.Errorf("config.json has no %s", key)
+	}
+	n, err := toUint32(v)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("config.json %s = %v is not a positive integer", key, v)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,279 @@
+package gguf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// restoreArchs undoes RegisterArchitecture calls made by a test.
+func restoreArchs(t *testing.T) {
+	saved := registeredArchs()
+	t.Cleanup(func() {
+		archMu.Lock()
+		archs = saved
+		archMu.Unlock()
+	})
+}
+
+const customYAML = `
+name: mixer
+layer_pattern: '^net\.blocks\.(\d+)\.(.+)$'
+layer_suffixes:
+  attn.qkv.weight: attn_qkv.weight
+  norm1.weight: attn_norm.weight
+static_tensors:
+  net.embed.weight: token_embd.weight
+  model.norm.weight: final_norm.weight
+config:
+  - {hf_key: width, gguf_key: "{arch}.embedding_length", type: uint32}
+  - {hf_key: eps, gguf_key: "{arch}.attention.layer_norm_epsilon", type: float32}
+  - {hf_key: act, gguf_key: "{arch}.activation", type: string}
+metadata:
+  - {key: "{arch}.causal", type: uint32, value: 1}
+`
+
+const customJSON = `{
+  "name": "mixer",
+  "layer_pattern": "^net\\.blocks\\.(\\d+)\\.(.+)$",
+  "layer_suffixes": {"attn.qkv.weight": "attn_qkv.weight", "norm1.weight": "attn_norm.weight"},
+  "static_tensors": {"net.embed.weight": "token_embd.weight", "model.norm.weight": "final_norm.weight"},
+  "config": [
+    {"hf_key": "width", "gguf_key": "{arch}.embedding_length", "type": "uint32"},
+    {"hf_key": "eps", "gguf_key": "{arch}.attention.layer_norm_epsilon", "type": "float32"},
+    {"hf_key": "act", "gguf_key": "{arch}.activation", "type": "string"}
+  ],
+  "metadata": [{"key": "{arch}.causal", "type": "uint32", "value": 1}]
+}`
+
+func TestParseArchitecture(t *testing.T) {
+	fromYAML, err := ParseArchitecture([]byte(customYAML))
+	if err != nil {
+		t.Fatalf("YAML: %v", err)
+	}
+	fromJSON, err := ParseArchitecture([]byte(customJSON))
+	if err != nil {
+		t.Fatalf("JSON: %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML and JSON definitions differ:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+
+	tests := []struct {
+		name string
+		def  string
+	}{
+		{"empty", ""},
+		{"no name", "layer_pattern: '^(\\d+)\\.(.+)$'"},
+		{"bad regex", "name: x\nlayer_pattern: '('"},
+		{"one group", "name: x\nlayer_pattern: '^layers\\.(\\d+)\\..+$'"},
+		{"suffixes without pattern", "name: x\nlayer_suffixes: {a: b}"},
+		{"bad config type", "name: x\nconfig: [{hf_key: a, gguf_key: b, type: uint64}]"},
+		{"missing gguf key", "name: x\nconfig: [{hf_key: a, type: uint32}]"},
+		{"bad metadata value", "name: x\nmetadata: [{key: a, type: uint32, value: many}]"},
+		{"unknown field", "name: x\nlayer_suffix: {a: b}"},
+		{"unknown transform", "name: x\ntransforms: [{tensors: 'q$', op: flip}]"},
+		{"transform without tensors", "name: x\ntransforms: [{op: rope_permute, heads: [n]}]"},
+		{"rope_permute without heads", "name: x\ntransforms: [{tensors: 'q$', op: rope_permute}]"},
+		{"expert pattern with two groups", "name: x\nexpert_pattern: '^(\\d+)\\.(.+)$'"},
+		{"expert suffixes without pattern", "name: x\nexpert_suffixes: {w1.weight: ffn_gate_exps.weight}"},
+		{"add without value", "name: x\ntransforms: [{tensors: 'norm$', op: add}]"},
+		{"scale with value and sqrt_of", "name: x\ntransforms: [{tensors: 'embd$', op: scale, value: 2, sqrt_of: n}]"},
+		{"ungroup_qkv without heads", "name: x\ntransforms: [{tensors: 'qkv$', op: ungroup_qkv}]"},
+		{"split with one part", "name: x\ntransforms: [{tensors: 'qkv$', op: split, parts: [{name: q}]}]"},
+		{"split with two rest parts", "name: x\ntransforms: [{tensors: 'qkv$', op: split, parts: [{name: q}, {name: k}]}]"},
+		{"split with into", "name: x\ntransforms: [{tensors: 'qkv$', op: split, into: y, parts: [{name: q, rows: [n]}, {name: k}]}]"},
+		{"concat without into", "name: x\ntransforms: [{tensors: '[qk]$', op: concat, parts: [{name: q}, {name: k}]}]"},
+		{"part with rows and heads", "name: x\ntransforms: [{tensors: 'qkv$', op: split, parts: [{name: q, rows: [n], heads: [h]}, {name: k}]}]"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseArchitecture([]byte(tt.def)); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestBuiltinArchitectures(t *testing.T) {
+	for _, name := range []string{"llama", "bert", "falcon", "gemma", "gemma2", "gemma3", "gpt2", "gptneox", "phi3", "qwen2", "qwen2moe", "qwen3", "qwen3moe"} {
+		if _, ok := LookupArchitecture(name); !ok {
+			t.Errorf("built-in architecture %q missing", name)
+		}
+	}
+	llama, _ := LookupArchitecture("llama")
+	for name, want := range map[string]bool{"blk.0.attn_q.weight": true, "blk.12.attn_k.weight": true, "blk.0.attn_v.weight": false} {
+		matched := false
+		for i := range llama.Transforms {
+			matched = matched || llama.Transforms[i].Matches(name)
+		}
+		if matched != want {
+			t.Errorf("llama transforms match %q = %v, want %v", name, matched, want)
+		}
+	}
+	if _, ok := LookupArchitecture("mixer"); ok {
+		t.Error("unexpected architecture mixer")
+	}
+}
+
+func TestMapArchTensorName(t *testing.T) {
+	tests := []struct {
+		arch, name, want string
+	}{
+		{"gemma2", "model.layers.1.post_attention_layernorm.weight", "blk.1.post_attention_norm.weight"},
+		{"gemma3", "model.layers.0.self_attn.q_norm.weight", "blk.0.attn_q_norm.weight"},
+		{"llama", "model.layers.1.post_attention_layernorm.weight", "blk.1.ffn_norm.weight"},
+		{"gemma2", "lm_head.weight", "output.weight"}, // falls back to the other definitions
+		{"qwen2", "model.layers.0.self_attn.v_proj.bias", "blk.0.attn_v.bias"},
+		{"qwen3", "model.layers.2.self_attn.k_norm.weight", "blk.2.attn_k_norm.weight"},
+		{"olmo", "model.layers.0.mlp.up_proj.weight", "blk.0.ffn_up.weight"},
+		{"phi3", "model.layers.4.self_attn.qkv_proj.weight", "blk.4.attn_qkv.weight"},
+		{"gpt2", "transformer.h.3.attn.c_attn.bias", "blk.3.attn_qkv.bias"},
+		{"gpt2", "h.3.mlp.c_proj.weight", "blk.3.ffn_down.weight"},
+		{"gpt2", "wpe.weight", "position_embd.weight"},
+		{"gptneox", "gpt_neox.layers.5.mlp.dense_4h_to_h.bias", "blk.5.ffn_down.bias"},
+		{"gptneox", "embed_out.weight", "output.weight"},
+		{"falcon", "transformer.h.1.ln_mlp.weight", "blk.1.attn_norm_2.weight"},
+		{"falcon", "transformer.h.1.self_attention.query_key_value.weight", "blk.1.attn_qkv.weight"},
+		{"falcon", "transformer.ln_f.bias", "output_norm.bias"},
+		{"phi3", "model.layers.4.mlp.gate_up_proj.weight", "blk.4.ffn_up.weight"},
+	}
+	for _, tt := range tests {
+		if got := MapArchTensorName(tt.arch, tt.name); got != tt.want {
+			t.Errorf("MapArchTensorName(%q, %q) = %q, want %q", tt.arch, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMapExpertName(t *testing.T) {
+	llama, _ := LookupArchitecture("llama")
+	qwen, _ := LookupArchitecture("qwen2moe")
+	tests := []struct {
+		a      *Architecture
+		name   string
+		want   string
+		expert int
+		ok     bool
+	}{
+		{llama, "model.layers.3.block_sparse_moe.experts.7.w2.weight", "blk.3.ffn_down_exps.weight", 7, true},
+		{llama, "model.layers.0.block_sparse_moe.experts.0.w3.weight", "blk.0.ffn_up_exps.weight", 0, true},
+		{llama, "model.layers.0.block_sparse_moe.experts.0.w4.weight", "", 0, false},
+		{llama, "model.layers.0.mlp.experts.1.gate_proj.weight", "", 0, false},
+		{qwen, "model.layers.1.mlp.experts.59.gate_proj.weight", "blk.1.ffn_gate_exps.weight", 59, true},
+		{qwen, "model.layers.1.mlp.shared_expert.gate_proj.weight", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, expert, ok := tt.a.MapExpertName(tt.name)
+		if got != tt.want || expert != tt.expert || ok != tt.ok {
+			t.Errorf("%s MapExpertName(%q) = %q, %d, %v, want %q, %d, %v", tt.a.Name, tt.name, got, expert, ok, tt.want, tt.expert, tt.ok)
+		}
+	}
+}
+
+func TestTensorTransformExpand(t *testing.T) {
+	a, err := ParseArchitecture([]byte(`
+name: x
+transforms:
+  - tensors: '^blk\.(\d+)\.attn_qkv\.(weight|bias)$'
+    op: split
+    parts: [{name: 'blk.$1.attn_q.$2', heads: [n]}, {name: 'blk.$1.attn_kv.$2'}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &a.Transforms[0]
+	if got := tr.Expand("blk.3.attn_qkv.bias", tr.Parts[0].Name); got != "blk.3.attn_q.bias" {
+		t.Errorf("Expand = %q, want blk.3.attn_q.bias", got)
+	}
+	if got := tr.Expand("blk.3.attn_q.bias", tr.Parts[0].Name); got != "" {
+		t.Errorf("Expand of a name that does not match = %q, want empty", got)
+	}
+}
+
+func TestHeadDim(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		want    int
+		wantErr string
+	}{
+		{"head_dim", map[string]interface{}{"head_dim": float64(128), "hidden_size": float64(512), "num_attention_heads": float64(8)}, 128, ""},
+		{"hidden_size over heads", map[string]interface{}{"hidden_size": float64(512), "num_attention_heads": float64(8)}, 64, ""},
+		{"zero head_dim", map[string]interface{}{"head_dim": float64(0)}, 0, "not a positive integer"},
+		{"uneven heads", map[string]interface{}{"hidden_size": float64(512), "num_attention_heads": float64(3)}, 0, "not a multiple"},
+		{"no hidden_size", map[string]interface{}{"num_attention_heads": float64(8)}, 0, "no hidden_size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HeadDim(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("HeadDim = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterArchitecture(t *testing.T) {
+	restoreArchs(t)
+	path := filepath.Join(t.TempDir(), "mixer.yaml")
+	if err := os.WriteFile(path, []byte(customYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadArchitecture(path)
+	if err != nil {
+		t.Fatalf("LoadArchitecture: %v", err)
+	}
+	if err := RegisterArchitecture(a); err != nil {
+		t.Fatalf("RegisterArchitecture: %v", err)
+	}
+
+	names := map[string]string{
+		"net.blocks.3.attn.qkv.weight":           "blk.3.attn_qkv.weight",
+		"net.blocks.0.norm1.weight":              "blk.0.attn_norm.weight",
+		"net.embed.weight":                       "token_embd.weight",
+		"model.norm.weight":                      "final_norm.weight", // registered definitions win
+		"model.layers.2.mlp.up_proj.weight":      "blk.2.ffn_up.weight",
+		"net.blocks.0.unknown.weight":            "net.blocks.0.unknown.weight",
+		"bert.encoder.layer.1.output.dense.bias": "blk.1.ffn_down.bias",
+	}
+	for in, want := range names {
+		if got := MapTensorName(in); got != want {
+			t.Errorf("MapTensorName(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	config := map[string]interface{}{"width": float64(64), "eps": 1e-6, "act": "gelu", "hidden_size": float64(32)}
+	got := MapMetadata("mixer", config)
+	want := []MetadataEntry{
+		{Key: "general.architecture", Type: MetaTypeString, Value: "mixer"},
+		{Key: "general.file_type", Type: MetaTypeUint32, Value: uint32(0)},
+		{Key: "mixer.embedding_length", Type: MetaTypeUint32, Value: uint32(64)},
+		{Key: "mixer.attention.layer_norm_epsilon", Type: MetaTypeFloat32, Value: float32(1e-6)},
+		{Key: "mixer.activation", Type: MetaTypeString, Value: "gelu"},
+		{Key: "mixer.causal", Type: MetaTypeUint32, Value: uint32(1)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MapMetadata =\n%+v\nwant\n%+v", got, want)
+	}
+
+	// Registering the same name again replaces the definition.
+	b, _ := ParseArchitecture([]byte("name: mixer\nstatic_tensors: {net.head.weight: output.weight}"))
+	if err := RegisterArchitecture(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := MapTensorName("net.embed.weight"); got != "net.embed.weight" {
+		t.Errorf("replaced definition still maps net.embed.weight to %q", got)
+	}
+	if got, _ := LookupArchitecture("mixer"); got != b {
+		t.Error("LookupArchitecture did not return the replacement")
+	}
+	if err := RegisterArchitecture(&Architecture{}); err == nil {
+		t.Error("expected error for a definition without a name")
+	}
+}
@@ -0,0 +1,133 @@
+package gguf
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Detection is the architecture DetectArchitecture chose and why.
+type Detection struct {
+	Name string
+	// Reason states the evidence that decided, for printing to the user.
+	Reason string
+}
+
+// DetectArchitecture infers the architecture of a model from its
+// config.json (config may be nil or empty) and, failing that, from its
+// tensor names. In order:
+//
+//  1. the class names in config "architectures", matched against each
+//     definition's hf_architectures;
+//  2. config "model_type", matched against each definition's name and
+//     model_types; a model_type no definition knows is used as the name
+//     itself, as MapMetadata accepts any name;
+//  3. the definition whose layer pattern and static names map the most
+//     tensors.
+//
+// The first step that matches decides. A step that matches more than one
+// architecture is an error, since guessing would silently write the wrong
+// metadata keys. The exception is a tie of tensor names between the default
+// llama definition and others mapping exactly the same names, such as
+// Gemma's, which shares Llama's layout: the names cannot tell them apart and
+// llama is chosen, as Gemma checkpoints always identify themselves in
+// config.json. When nothing matches, the default llama definition is chosen.
+func DetectArchitecture(config map[string]interface{}, tensorNames []string) (Detection, error) {
+	defs := registeredArchs()
+
+	if classes := configStrings(config["architectures"]); len(classes) > 0 {
+		found := map[string]string{}
+		for _, class := range classes {
+			for _, a := range defs {
+				if slices.Contains(a.HFArchitectures, class) {
+					if _, ok := found[a.Name]; !ok {
+						found[a.Name] = "config.json architectures lists " + class
+					}
+					break
+				}
+			}
+		}
+		if d, err := decide(found, "config.json architectures"); d.Name != "" || err != nil {
+			return d, err
+		}
+	}
+
+	if modelType, ok := config["model_type"].(string); ok && modelType != "" {
+		for _, a := range defs {
+			if a.Name == modelType || slices.Contains(a.ModelTypes, modelType) {
+				return Detection{Name: a.Name, Reason: fmt.Sprintf("config.json model_type is %q", modelType)}, nil
+			}
+		}
+		return Detection{Name: modelType, Reason: fmt.Sprintf("config.json model_type is %q, which has no definition; metadata uses the %s config keys", modelType, defaultArch)}, nil
+	}
+
+	if len(tensorNames) > 0 {
+		counts := map[string]int{}
+		matched := map[string][]bool{}
+		for _, a := range defs {
+			matched[a.Name] = make([]bool, len(tensorNames))
+			for i, name := range tensorNames {
+				if _, ok := a.MapTensorName(name); ok {
+					counts[a.Name]++
+					matched[a.Name][i] = true
+				}
+			}
+		}
+		best := 0
+		for _, n := range counts {
+			best = max(best, n)
+		}
+		found := map[string]string{}
+		for name, n := range counts {
+			if n == best && n > 0 {
+				found[name] = fmt.Sprintf("%d of %d tensor names match its mappings", n, len(tensorNames))
+			}
+		}
+		if evidence, ok := found[defaultArch]; ok && len(found) > 1 {
+			same := true
+			for name := range found {
+				same = same && slices.Equal(matched[name], matched[defaultArch])
+			}
+			if same {
+				found = map[string]string{defaultArch: fmt.Sprintf("%s, as do %d definitions sharing its layout", evidence, len(found)-1)}
+			}
+		}
+		if d, err := decide(found, "tensor names"); d.Name != "" || err != nil {
+			return d, err
+		}
+	}
+
+	return Detection{Name: defaultArch, Reason: "default; no config.json architectures, model_type or tensor names matched a definition"}, nil
+}
+
+// decide returns the single architecture in found, which maps names to the
+// evidence for them, or an error naming the candidates if there are several.
+func decide(found map[string]string, source string) (Detection, error) {
+	switch len(found) {
+	case 0:
+		return Detection{}, nil
+	case 1:
+		for name, evidence := range found {
+			return Detection{Name: name, Reason: evidence}, nil
+		}
+	}
+	names := make([]string, 0, len(found))
+	for name, evidence := range found {
+		names = append(names, fmt.Sprintf("%s (%s)", name, evidence))
+	}
+	sort.Strings(names)
+	return Detection{}, fmt.Errorf("ambiguous architecture from %s: %s; pass --arch", source, strings.Join(names, ", "))
+}
+
+// configStrings returns the strings of a config.json list value.
+func configStrings(v any) []string {
+	list, _ := v.([]interface{})
+	var out []string
+	for _, e := range list {
+		if s, ok := e.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
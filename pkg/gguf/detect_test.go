@@ -0,0 +1,141 @@
+package gguf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectArchitecture(t *testing.T) {
+	llamaNames := []string{"model.embed_tokens.weight", "model.layers.0.self_attn.q_proj.weight", "lm_head.weight"}
+	bertNames := []string{"bert.embeddings.word_embeddings.weight", "bert.encoder.layer.0.attention.self.query.weight"}
+
+	tests := []struct {
+		name       string
+		config     map[string]interface{}
+		tensors    []string
+		want       string
+		wantReason string
+		wantErr    string
+	}{
+		{
+			name:       "architectures",
+			config:     map[string]interface{}{"architectures": []interface{}{"BertForSequenceClassification"}, "model_type": "llama"},
+			tensors:    llamaNames,
+			want:       "bert",
+			wantReason: "architectures lists BertForSequenceClassification",
+		},
+		{
+			name:   "architectures of one family",
+			config: map[string]interface{}{"architectures": []interface{}{"RobertaModel", "RobertaForMaskedLM"}},
+			want:   "bert",
+		},
+		{
+			name:    "conflicting architectures",
+			config:  map[string]interface{}{"architectures": []interface{}{"LlamaForCausalLM", "BertModel"}},
+			wantErr: "ambiguous architecture from config.json architectures: bert (config.json architectures lists BertModel), llama",
+		},
+		{
+			name:       "unknown class falls back to model_type",
+			config:     map[string]interface{}{"architectures": []interface{}{"MyForCausalLM"}, "model_type": "mistral"},
+			want:       "llama",
+			wantReason: `model_type is "mistral"`,
+		},
+		{name: "model_type name", config: map[string]interface{}{"model_type": "bert"}, want: "bert"},
+		{name: "model_type alias", config: map[string]interface{}{"model_type": "roberta"}, tensors: llamaNames, want: "bert"},
+		{
+			name:       "model_type without definition",
+			config:     map[string]interface{}{"model_type": "olmo"},
+			tensors:    llamaNames,
+			want:       "olmo",
+			wantReason: "no definition",
+		},
+		{name: "llama tensor names", tensors: llamaNames, want: "llama", wantReason: "3 of 3 tensor names"},
+		{name: "bert tensor names", config: map[string]interface{}{}, tensors: append(bertNames, "extra"), want: "bert", wantReason: "2 of 3"},
+		{
+			name:    "tied tensor names",
+			tensors: []string{"model.layers.0.mlp.up_proj.weight", "bert.encoder.layer.0.output.dense.weight"},
+			wantErr: "ambiguous architecture from tensor names",
+		},
+		{
+			name:       "layout shared with gemma",
+			tensors:    []string{"model.embed_tokens.weight", "model.layers.0.self_attn.q_proj.weight"},
+			want:       "llama",
+			wantReason: "sharing its layout",
+		},
+		{
+			name:    "gemma2 tensor names",
+			tensors: []string{"model.embed_tokens.weight", "model.layers.0.pre_feedforward_layernorm.weight"},
+			wantErr: "ambiguous architecture from tensor names: gemma2",
+		},
+		{name: "gemma2 architectures", config: map[string]interface{}{"architectures": []interface{}{"Gemma2ForCausalLM"}}, want: "gemma2"},
+		{name: "qwen2 model_type", config: map[string]interface{}{"model_type": "qwen2"}, want: "qwen2"},
+		{name: "qwen3 moe architectures", config: map[string]interface{}{"architectures": []interface{}{"Qwen3MoeForCausalLM"}}, want: "qwen3moe"},
+		{
+			name:       "qwen2 tensor names",
+			tensors:    append(llamaNames, "model.layers.0.self_attn.q_proj.bias", "model.layers.0.mlp.up_proj.weight"),
+			want:       "qwen2",
+			wantReason: "5 of 5",
+		},
+		{
+			name:       "phi3 tensor names",
+			tensors:    []string{"model.embed_tokens.weight", "model.layers.0.self_attn.qkv_proj.weight", "model.layers.0.mlp.gate_up_proj.weight"},
+			want:       "phi3",
+			wantReason: "3 of 3",
+		},
+		{
+			name:    "gpt2 tensor names",
+			tensors: []string{"transformer.wte.weight", "transformer.h.0.attn.c_attn.weight", "transformer.h.0.attn.bias", "transformer.ln_f.weight"},
+			want:    "gpt2",
+		},
+		{
+			name:    "falcon tensor names",
+			tensors: []string{"transformer.word_embeddings.weight", "transformer.h.0.self_attention.query_key_value.weight", "transformer.ln_f.weight"},
+			want:    "falcon",
+		},
+		{name: "gptneox model_type", config: map[string]interface{}{"model_type": "gpt_neox"}, want: "gptneox"},
+		{name: "gemma3 model_type", config: map[string]interface{}{"model_type": "gemma3_text"}, want: "gemma3"},
+		{name: "nothing matches", tensors: []string{"w0", "w1"}, want: "llama", wantReason: "default"},
+		{name: "nothing at all", want: "llama", wantReason: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := DetectArchitecture(tt.config, tt.tensors)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DetectArchitecture: %v", err)
+			}
+			if d.Name != tt.want {
+				t.Errorf("name = %q, want %q (reason %q)", d.Name, tt.want, d.Reason)
+			}
+			if !strings.Contains(d.Reason, tt.wantReason) {
+				t.Errorf("reason = %q, want it to mention %q", d.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDetectArchitecture_Registered(t *testing.T) {
+	restoreArchs(t)
+	a, err := ParseArchitecture([]byte(customYAML + "model_types: [mixer_v2]\nhf_architectures: [MixerModel]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterArchitecture(a); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []map[string]interface{}{
+		{"architectures": []interface{}{"MixerModel"}},
+		{"model_type": "mixer_v2"},
+		nil,
+	} {
+		d, err := DetectArchitecture(config, []string{"net.blocks.0.norm1.weight", "net.embed.weight"})
+		if err != nil || d.Name != "mixer" {
+			t.Errorf("config %v: got %+v, %v, want mixer", config, d, err)
+		}
+	}
+}
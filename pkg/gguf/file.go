@@ -0,0 +1,429 @@
+package gguf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Magic is the first four bytes of every GGUF file ("GGUF" little-endian).
+const Magic = 0x46554747
+
+// defaultAlignment is the tensor data alignment used when a file does not
+// set general.alignment.
+const defaultAlignment = 32
+
+// File is a GGUF file held in memory. Unlike the shared writer, it preserves
+// metadata of every value type, so files can be read, modified and written
+// back without losing information.
+type File struct {
+	// Metadata holds the key-value pairs in file order.
+	Metadata []MetadataEntry
+	// Tensors holds the tensors in file order.
+	Tensors []Tensor
+}
+
+// Tensor is one tensor of a GGUF file.
+type Tensor struct {
+	Name string
+	// Type is the GGML tensor type ID.
+	Type int
+	// Shape is in row-major order, outermost dimension first. GGUF stores
+	// dimensions innermost first; Read and Write reverse them.
+	Shape []int
+	Data  []byte
+}
+
+// Elements returns the number of values in the tensor.
+func (t *Tensor) Elements() int {
+	n := 1
+	for _, d := range t.Shape {
+		n *= d
+	}
+	return n
+}
+
+// Array is the value of a metadata entry of type MetaTypeArray.
+type Array struct {
+	// Type is the metadata value type of every element.
+	Type   uint32
+	Values []any
+}
+
+// Lookup returns the metadata entry with the given key.
+func (f *File) Lookup(key string) (MetadataEntry, bool) {
+	for _, e := range f.Metadata {
+		if e.Key == key {
+			return e, true
+		}
+	}
+	return MetadataEntry{}, false
+}
+
+// SetMetadata replaces the value of key, or appends the entry if the key is
+// not present.
+func (f *File) SetMetadata(key string, typ uint32, value any) {
+	f.Metadata = setEntry(f.Metadata, MetadataEntry{Key: key, Type: typ, Value: value})
+}
+
+// alignment returns the tensor data alignment declared by general.alignment.
+func (f *File) alignment() (int, error) {
+	e, ok := f.Lookup("general.alignment")
+	if !ok {
+		return defaultAlignment, nil
+	}
+	a, ok := e.Value.(uint32)
+	if !ok || a == 0 || a&(a-1) != 0 {
+		return 0, fmt.Errorf("invalid general.alignment %v", e.Value)
+	}
+	return int(a), nil
+}
+
+// ReadFile reads the GGUF file at path.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read gguf: %w", err)
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Parse decodes a GGUF version 2 or 3 file. Tensor data is copied out of
+// data, so the buffer may be reused afterwards.
+func Parse(data []byte) (*File, error) {
+	d := &decoder{buf: data}
+	if magic := d.u32(); magic != Magic {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, fmt.Errorf("not a GGUF file: magic %#x", magic)
+	}
+	if version := d.u32(); version != 2 && version != 3 && d.err == nil {
+		return nil, fmt.Errorf("unsupported GGUF version %d", version)
+	}
+	nTensors := d.count(8)
+	nKV := d.count(12)
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	f := &File{Metadata: make([]MetadataEntry, 0, nKV)}
+	for range nKV {
+		key := d.str()
+		typ := d.u32()
+		v := d.value(typ)
+		if d.err != nil {
+			return nil, fmt.Errorf("metadata %q: %w", key, d.err)
+		}
+		f.Metadata = append(f.Metadata, MetadataEntry{Key: key, Type: typ, Value: v})
+	}
+
+	align, err := f.alignment()
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]uint64, nTensors)
+	f.Tensors = make([]Tensor, nTensors)
+	for i := range f.Tensors {
+		t := &f.Tensors[i]
+		t.Name = d.str()
+		nDims := d.u32()
+		if nDims > 8 && d.err == nil {
+			return nil, fmt.Errorf("tensor %q: %d dimensions", t.Name, nDims)
+		}
+		t.Shape = make([]int, nDims)
+		for j := int(nDims) - 1; j >= 0; j-- {
+			dim := d.u64()
+			if dim > math.MaxInt32 && d.err == nil {
+				return nil, fmt.Errorf("tensor %q: dimension %d too large", t.Name, dim)
+			}
+			t.Shape[j] = int(dim)
+		}
+		t.Type = int(d.u32())
+		offsets[i] = d.u64()
+		if d.err != nil {
+			return nil, fmt.Errorf("tensor info %d: %w", i, d.err)
+		}
+	}
+
+	start := alignUp(d.pos, align)
+	for i := range f.Tensors {
+		t := &f.Tensors[i]
+		size, err := TensorSize(t.Type, t.Elements())
+		if err != nil {
+			return nil, fmt.Errorf("tensor %q: %w", t.Name, err)
+		}
+		if offsets[i] > uint64(len(data)) || uint64(start)+offsets[i]+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("tensor %q: data out of bounds", t.Name)
+		}
+		off := start + int(offsets[i])
+		t.Data = bytes.Clone(data[off : off+size])
+	}
+	return f, nil
+}
+
+// Write encodes the file as GGUF version 3, laying out tensor data in order
+// at the alignment given by general.alignment.
+func (f *File) Write(w io.Writer) error {
+	align, err := f.alignment()
+	if err != nil {
+		return err
+	}
+
+	e := &encoder{}
+	e.u32(Magic)
+	e.u32(3)
+	e.u64(uint64(len(f.Tensors)))
+	e.u64(uint64(len(f.Metadata)))
+	for _, m := range f.Metadata {
+		e.str(m.Key)
+		e.u32(m.Type)
+		if err := e.value(m.Type, m.Value); err != nil {
+			return fmt.Errorf("metadata %q: %w", m.Key, err)
+		}
+	}
+
+	var off int
+	for _, t := range f.Tensors {
+		size, err := TensorSize(t.Type, t.Elements())
+		if err != nil {
+			return fmt.Errorf("tensor %q: %w", t.Name, err)
+		}
+		if size != len(t.Data) {
+			return fmt.Errorf("tensor %q: have %d bytes, want %d", t.Name, len(t.Data), size)
+		}
+		e.str(t.Name)
+		e.u32(uint32(len(t.Shape)))
+		for j := len(t.Shape) - 1; j >= 0; j-- {
+			e.u64(uint64(t.Shape[j]))
+		}
+		e.u32(uint32(t.Type))
+		e.u64(uint64(off))
+		off = alignUp(off+size, align)
+	}
+
+	e.pad(align)
+	for _, t := range f.Tensors {
+		e.buf = append(e.buf, t.Data...)
+		e.pad(align)
+	}
+	_, err = w.Write(e.buf)
+	return err
+}
+
+func alignUp(n, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// decoder reads little-endian GGUF values from a buffer. The first error is
+// kept in err; later reads return zero values.
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf)-d.pos {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// count reads a u64 element count and rejects counts that cannot fit in
+// the rest of the buffer at minSize bytes per element.
+func (d *decoder) count(minSize int) int {
+	n := d.u64()
+	if d.err == nil && n > uint64((len(d.buf)-d.pos)/minSize) {
+		d.err = fmt.Errorf("count %d exceeds file size", n)
+	}
+	return int(n)
+}
+
+func (d *decoder) str() string {
+	return string(d.next(d.count(1)))
+}
+
+func (d *decoder) value(typ uint32) any {
+	switch typ {
+	case MetaTypeUint8:
+		return d.u8()
+	case MetaTypeInt8:
+		return int8(d.u8())
+	case MetaTypeUint16:
+		return d.u16()
+	case MetaTypeInt16:
+		return int16(d.u16())
+	case MetaTypeUint32:
+		return d.u32()
+	case MetaTypeInt32:
+		return int32(d.u32())
+	case MetaTypeFloat32:
+		return math.Float32frombits(d.u32())
+	case MetaTypeBool:
+		return d.u8() != 0
+	case MetaTypeString:
+		return d.str()
+	case MetaTypeUint64:
+		return d.u64()
+	case MetaTypeInt64:
+		return int64(d.u64())
+	case MetaTypeFloat64:
+		return math.Float64frombits(d.u64())
+	case MetaTypeArray:
+		elem := d.u32()
+		if elem == MetaTypeArray && d.err == nil {
+			d.err = fmt.Errorf("nested arrays are not supported")
+		}
+		n := d.count(1)
+		arr := Array{Type: elem, Values: make([]any, 0, n)}
+		for range n {
+			arr.Values = append(arr.Values, d.value(elem))
+			if d.err != nil {
+				break
+			}
+		}
+		return arr
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("unknown metadata type %d", typ)
+	}
+	return nil
+}
+
+// encoder appends little-endian GGUF values to a buffer.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u32(v uint32) { e.buf = binary.LittleEndian.AppendUint32(e.buf, v) }
+func (e *encoder) u64(v uint64) { e.buf = binary.LittleEndian.AppendUint64(e.buf, v) }
+
+func (e *encoder) str(s string) {
+	e.u64(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) pad(align int) {
+	for len(e.buf)%align != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) value(typ uint32, v any) error {
+	ok := true
+	switch typ {
+	case MetaTypeUint8:
+		var x uint8
+		x, ok = v.(uint8)
+		e.buf = append(e.buf, x)
+	case MetaTypeInt8:
+		var x int8
+		x, ok = v.(int8)
+		e.buf = append(e.buf, byte(x))
+	case MetaTypeUint16:
+		var x uint16
+		x, ok = v.(uint16)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, x)
+	case MetaTypeInt16:
+		var x int16
+		x, ok = v.(int16)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(x))
+	case MetaTypeUint32:
+		var x uint32
+		x, ok = v.(uint32)
+		e.u32(x)
+	case MetaTypeInt32:
+		var x int32
+		x, ok = v.(int32)
+		e.u32(uint32(x))
+	case MetaTypeFloat32:
+		var x float32
+		x, ok = v.(float32)
+		e.u32(math.Float32bits(x))
+	case MetaTypeBool:
+		var x bool
+		x, ok = v.(bool)
+		if x {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case MetaTypeString:
+		var x string
+		x, ok = v.(string)
+		e.str(x)
+	case MetaTypeUint64:
+		var x uint64
+		x, ok = v.(uint64)
+		e.u64(x)
+	case MetaTypeInt64:
+		var x int64
+		x, ok = v.(int64)
+		e.u64(uint64(x))
+	case MetaTypeFloat64:
+		var x float64
+		x, ok = v.(float64)
+		e.u64(math.Float64bits(x))
+	case MetaTypeArray:
+		arr, isArr := v.(Array)
+		if !isArr || arr.Type == MetaTypeArray {
+			return fmt.Errorf("invalid array value %T", v)
+		}
+		e.u32(arr.Type)
+		e.u64(uint64(len(arr.Values)))
+		for i, x := range arr.Values {
+			if err := e.value(arr.Type, x); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown metadata type %d", typ)
+	}
+	if !ok {
+		return fmt.Errorf("value %v (%T) does not match metadata type %d", v, v, typ)
+	}
+	return nil
+}
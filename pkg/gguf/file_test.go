@@ -0,0 +1,183 @@
+package gguf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+)
+
+func TestFile_RoundTrip(t *testing.T) {
+	f := &File{
+		Metadata: []MetadataEntry{
+			{Key: "general.architecture", Type: MetaTypeString, Value: "llama"},
+			{Key: "u8", Type: MetaTypeUint8, Value: uint8(7)},
+			{Key: "i8", Type: MetaTypeInt8, Value: int8(-7)},
+			{Key: "u16", Type: MetaTypeUint16, Value: uint16(700)},
+			{Key: "i16", Type: MetaTypeInt16, Value: int16(-700)},
+			{Key: "u32", Type: MetaTypeUint32, Value: uint32(70000)},
+			{Key: "i32", Type: MetaTypeInt32, Value: int32(-70000)},
+			{Key: "f32", Type: MetaTypeFloat32, Value: float32(1.5)},
+			{Key: "bool", Type: MetaTypeBool, Value: true},
+			{Key: "u64", Type: MetaTypeUint64, Value: uint64(1 << 40)},
+			{Key: "i64", Type: MetaTypeInt64, Value: int64(-1 << 40)},
+			{Key: "f64", Type: MetaTypeFloat64, Value: 2.25},
+			{Key: "tokenizer.ggml.tokens", Type: MetaTypeArray, Value: Array{
+				Type: MetaTypeString, Values: []any{"<s>", "</s>", "hello"},
+			}},
+			{Key: "tokenizer.ggml.scores", Type: MetaTypeArray, Value: Array{
+				Type: MetaTypeFloat32, Values: []any{float32(0), float32(-1)},
+			}},
+		},
+		Tensors: []Tensor{
+			{Name: "a", Type: sharedgguf.TypeF32, Shape: []int{2, 3}, Data: bytes.Repeat([]byte{1}, 24)},
+			{Name: "b", Type: sharedgguf.TypeQ8_0, Shape: []int{1, 64}, Data: bytes.Repeat([]byte{2}, 68)},
+			{Name: "c", Type: TypeQ4_K, Shape: []int{256}, Data: bytes.Repeat([]byte{3}, 144)},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("round trip mismatch:\ngot  %+v\nwant %+v", got, f)
+	}
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err != nil {
+		t.Errorf("ReadFile: %v", err)
+	}
+}
+
+func TestParse_SharedWriterOutput(t *testing.T) {
+	w := sharedgguf.NewWriter()
+	w.AddMetadataString("general.architecture", "llama")
+	w.AddMetadataUint32("general.file_type", 1)
+	w.AddMetadataFloat32("llama.rope.freq_base", 10000)
+	w.AddTensorF32("output_norm.weight", []int{4}, []float32{1, 2, 3, 4})
+	w.AddTensor("token_embd.weight", sharedgguf.TypeF16, []int{3, 2}, make([]byte, 12))
+
+	var buf bytes.Buffer
+	if err := w.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if e, ok := f.Lookup("general.file_type"); !ok || e.Value != uint32(1) {
+		t.Errorf("general.file_type = %+v", e)
+	}
+	if len(f.Tensors) != 2 {
+		t.Fatalf("got %d tensors, want 2", len(f.Tensors))
+	}
+	if got := f.Tensors[1].Shape; !reflect.DeepEqual(got, []int{3, 2}) {
+		t.Errorf("token_embd shape = %v, want [3 2]", got)
+	}
+	if got := binary.LittleEndian.Uint32(f.Tensors[0].Data[4:]); got != 0x40000000 {
+		t.Errorf("output_norm[1] bits = %#x, want 2.0", got)
+	}
+}
+
+func TestFile_SetMetadata(t *testing.T) {
+	f := &File{Metadata: []MetadataEntry{
+		{Key: "general.file_type", Type: MetaTypeUint32, Value: uint32(1)},
+	}}
+	f.SetMetadata("general.file_type", MetaTypeUint32, uint32(15))
+	f.SetMetadata("general.quantization_version", MetaTypeUint32, uint32(2))
+	if len(f.Metadata) != 2 || f.Metadata[0].Value != uint32(15) {
+		t.Errorf("metadata = %+v", f.Metadata)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	valid := func() []byte {
+		f := &File{
+			Metadata: []MetadataEntry{{Key: "k", Type: MetaTypeString, Value: "v"}},
+			Tensors:  []Tensor{{Name: "t", Type: sharedgguf.TypeF32, Shape: []int{4}, Data: make([]byte, 16)}},
+		}
+		var buf bytes.Buffer
+		if err := f.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	badMagic := valid()
+	badMagic[0] = 'X'
+	badVersion := valid()
+	badVersion[4] = 9
+	hugeCount := valid()
+	binary.LittleEndian.PutUint64(hugeCount[16:], 1<<40)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", badMagic},
+		{"bad version", badVersion},
+		{"huge metadata count", hugeCount},
+		{"truncated metadata", valid()[:30]},
+		{"truncated data", valid()[:len(valid())-20]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestFile_WriteErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		f    File
+	}{
+		{"wrong data size", File{Tensors: []Tensor{{Name: "t", Type: sharedgguf.TypeF32, Shape: []int{4}, Data: make([]byte, 15)}}}},
+		{"unknown tensor type", File{Tensors: []Tensor{{Name: "t", Type: 99, Shape: []int{4}}}}},
+		{"value type mismatch", File{Metadata: []MetadataEntry{{Key: "k", Type: MetaTypeUint32, Value: "x"}}}},
+		{"bad alignment", File{Metadata: []MetadataEntry{{Key: "general.alignment", Type: MetaTypeUint32, Value: uint32(3)}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.f.Write(&bytes.Buffer{}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestTensorSize(t *testing.T) {
+	tests := []struct {
+		typ, n, want int
+	}{
+		{sharedgguf.TypeF32, 10, 40},
+		{sharedgguf.TypeQ4_0, 64, 36},
+		{sharedgguf.TypeQ8_0, 64, 68},
+		{TypeQ6_K, 512, 420},
+		{sharedgguf.TypeBF16, 3, 6},
+	}
+	for _, tt := range tests {
+		got, err := TensorSize(tt.typ, tt.n)
+		if err != nil || got != tt.want {
+			t.Errorf("TensorSize(%d, %d) = %d, %v; want %d", tt.typ, tt.n, got, err, tt.want)
+		}
+	}
+	if _, err := TensorSize(TypeQ4_K, 100); err == nil {
+		t.Error("expected error for partial block")
+	}
+}
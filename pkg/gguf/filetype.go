@@ -0,0 +1,105 @@
+package gguf
+
+import sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+// QuantizationVersion is the ggml block-quantization format version of the
+// block layouts this module writes. Files holding block-quantized tensors
+// record it as general.quantization_version.
+const QuantizationVersion uint32 = 2
+
+// tensorFileTypes maps tensor types to the llama.cpp general.file_type of a
+// file whose weights are mostly of that type. K-quants use the value of the
+// uniform "_S" variant.
+var tensorFileTypes = map[int]uint32{
+	sharedgguf.TypeF32:  0,
+	sharedgguf.TypeF16:  1,
+	sharedgguf.TypeQ4_0: 2,
+	TypeQ4_1:            3,
+	sharedgguf.TypeQ8_0: 7,
+	TypeQ5_0:            8,
+	TypeQ5_1:            9,
+	TypeQ2_K:            10,
+	TypeQ3_K:            11,
+	TypeQ4_K:            14,
+	TypeQ5_K:            16,
+	TypeQ6_K:            18,
+	sharedgguf.TypeBF16: 32,
+}
+
+// IsQuantized reports whether typ is a block-quantized tensor type.
+func IsQuantized(typ int) bool {
+	return typeLayouts[typ].values > 1
+}
+
+// FileType returns the general.file_type describing tensors: the file type
+// of the tensor type holding the most values among the weight matrices
+// (tensors of two or more dimensions), or among all tensors when there are
+// none. Vectors such as norms and biases are skipped because converters keep
+// them in F32 whatever the weights are stored as. Integer and F64 tensors
+// are not counted; with nothing to count the result is 0 (F32). Only Type
+// and Shape are read.
+func FileType(tensors []Tensor) uint32 {
+	counts := make(map[int]int)
+	for _, matrices := range []bool{true, false} {
+		for i := range tensors {
+			t := &tensors[i]
+			if _, ok := tensorFileTypes[t.Type]; !ok || (matrices && len(t.Shape) < 2) {
+				continue
+			}
+			counts[t.Type] += t.Elements()
+		}
+		if len(counts) > 0 {
+			break
+		}
+	}
+	best, bestCount := sharedgguf.TypeF32, -1
+	for typ, n := range counts {
+		// Ties go to the lower type ID so the result is deterministic.
+		if n > bestCount || (n == bestCount && typ < best) {
+			best, bestCount = typ, n
+		}
+	}
+	return tensorFileTypes[best]
+}
+
+// SetFileType sets general.file_type in entries to FileType(tensors) and
+// general.quantization_version to QuantizationVersion when any tensor is
+// block-quantized, removing a stale quantization version otherwise. Existing
+// entries keep their position; new ones are appended.
+func SetFileType(entries []MetadataEntry, tensors []Tensor) []MetadataEntry {
+	quantized := false
+	for i := range tensors {
+		if IsQuantized(tensors[i].Type) {
+			quantized = true
+			break
+		}
+	}
+	entries = setEntry(entries, MetadataEntry{Key: "general.file_type", Type: MetaTypeUint32, Value: FileType(tensors)})
+	if quantized {
+		return setEntry(entries, MetadataEntry{Key: "general.quantization_version", Type: MetaTypeUint32, Value: QuantizationVersion})
+	}
+	out := entries[:0]
+	for _, e := range entries {
+		if e.Key != "general.quantization_version" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// SetFileType updates general.file_type and general.quantization_version
+// from the tensors of f, as the SetFileType function does.
+func (f *File) SetFileType() {
+	f.Metadata = SetFileType(f.Metadata, f.Tensors)
+}
+
+// setEntry replaces the entry with e's key, or appends e.
+func setEntry(entries []MetadataEntry, e MetadataEntry) []MetadataEntry {
+	for i := range entries {
+		if entries[i].Key == e.Key {
+			entries[i] = e
+			return entries
+		}
+	}
+	return append(entries, e)
+}
@@ -0,0 +1,87 @@
+package gguf
+
+import (
+	"testing"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+)
+
+func TestFileType(t *testing.T) {
+	tests := []struct {
+		name    string
+		tensors []Tensor
+		want    uint32
+	}{
+		{"empty", nil, 0},
+		{
+			name: "f16 weights with f32 norms",
+			tensors: []Tensor{
+				{Type: sharedgguf.TypeF16, Shape: []int{4, 4}},
+				{Type: sharedgguf.TypeF32, Shape: []int{64}},
+				{Type: sharedgguf.TypeF32, Shape: []int{64}},
+			},
+			want: 1,
+		},
+		{
+			name: "most values decide",
+			tensors: []Tensor{
+				{Type: sharedgguf.TypeQ8_0, Shape: []int{32, 32}},
+				{Type: TypeQ4_K, Shape: []int{2, 256}},
+				{Type: TypeQ4_K, Shape: []int{2, 256}},
+			},
+			want: 7,
+		},
+		{
+			name: "k-quant",
+			tensors: []Tensor{
+				{Type: TypeQ4_K, Shape: []int{8, 256}},
+				{Type: TypeQ6_K, Shape: []int{2, 256}},
+			},
+			want: 14,
+		},
+		{"bf16", []Tensor{{Type: sharedgguf.TypeBF16, Shape: []int{2, 2}}}, 32},
+		{"tie goes to the lower type", []Tensor{{Type: sharedgguf.TypeBF16, Shape: []int{2, 2}}, {Type: sharedgguf.TypeF16, Shape: []int{4, 1}}}, 1},
+		{"vectors only", []Tensor{{Type: sharedgguf.TypeF16, Shape: []int{8}}}, 1},
+		{"integers skipped", []Tensor{{Type: TypeI32, Shape: []int{64, 64}}, {Type: sharedgguf.TypeF16, Shape: []int{2, 2}}}, 1},
+	}
+	for _, tt := range tests {
+		if got := FileType(tt.tensors); got != tt.want {
+			t.Errorf("%s: FileType = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSetFileType(t *testing.T) {
+	entries := []MetadataEntry{
+		{Key: "general.architecture", Type: MetaTypeString, Value: "llama"},
+		{Key: "general.file_type", Type: MetaTypeUint32, Value: uint32(0)},
+	}
+	quantized := []Tensor{{Type: sharedgguf.TypeQ4_0, Shape: []int{2, 32}}, {Type: sharedgguf.TypeF32, Shape: []int{32}}}
+	entries = SetFileType(entries, quantized)
+	want := []MetadataEntry{
+		{Key: "general.architecture", Type: MetaTypeString, Value: "llama"},
+		{Key: "general.file_type", Type: MetaTypeUint32, Value: uint32(2)},
+		{Key: "general.quantization_version", Type: MetaTypeUint32, Value: QuantizationVersion},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	// A file without block-quantized tensors loses its quantization version.
+	f := &File{Metadata: entries, Tensors: []Tensor{{Type: sharedgguf.TypeF16, Shape: []int{2, 32}}}}
+	f.SetFileType()
+	if e, _ := f.Lookup("general.file_type"); e.Value != uint32(1) {
+		t.Errorf("general.file_type = %v, want 1", e.Value)
+	}
+	if _, ok := f.Lookup("general.quantization_version"); ok {
+		t.Error("general.quantization_version kept for a float file")
+	}
+	if len(f.Metadata) != 2 {
+		t.Errorf("metadata = %+v", f.Metadata)
+	}
+}
@@ -14,94 +14,22 @@ type MetadataEntry struct {
 	Value any
 }
 
-// configMapping defines how HuggingFace config keys map to GGUF metadata keys.
-// The placeholder {arch} is replaced with the architecture name at runtime.
-var configMapping = []struct {
-	hfKey    string
-	ggufKey  string
-	ggufType uint32
-}{
-	{"hidden_size", "{arch}.embedding_length", sharedgguf.MetaTypeUint32},
-	{"num_hidden_layers", "{arch}.block_count", sharedgguf.MetaTypeUint32},
-	{"num_attention_heads", "{arch}.attention.head_count", sharedgguf.MetaTypeUint32},
-	{"num_key_value_heads", "{arch}.attention.head_count_kv", sharedgguf.MetaTypeUint32},
-	{"intermediate_size", "{arch}.feed_forward_length", sharedgguf.MetaTypeUint32},
-	{"vocab_size", "{arch}.vocab_size", sharedgguf.MetaTypeUint32},
-	{"max_position_embeddings", "{arch}.context_length", sharedgguf.MetaTypeUint32},
-	{"rms_norm_eps", "{arch}.attention.layer_norm_rms_epsilon", sharedgguf.MetaTypeFloat32},
-	{"rope_theta", "{arch}.rope.freq_base", sharedgguf.MetaTypeFloat32},
-}
-
-// bertExtraMapping defines BERT-specific config keys not covered by configMapping.
-var bertExtraMapping = []struct {
-	hfKey    string
-	ggufKey  string
-	ggufType uint32
-}{
-	{"layer_norm_eps", "{arch}.attention.layer_norm_epsilon", sharedgguf.MetaTypeFloat32},
-	{"num_labels", "{arch}.num_labels", sharedgguf.MetaTypeUint32},
-}
-
-// bertStaticMetadata defines BERT-specific metadata with fixed values.
-var bertStaticMetadata = []MetadataEntry{
-	{Key: "{arch}.pooler_type", Type: sharedgguf.MetaTypeString, Value: "cls"},
-}
-
-// MapMetadata converts HuggingFace/ONNX config fields to GGUF metadata entries.
+// MapMetadata converts HuggingFace/ONNX config fields to GGUF metadata entries
+// with the config keys and static metadata of the architecture definition
+// named arch, or of the llama definition if there is none.
+// general.file_type is written as 0 (F32); once the tensors to be written are
+// known, SetFileType replaces it with their actual file type.
 func MapMetadata(arch string, config map[string]interface{}) []MetadataEntry {
 	entries := []MetadataEntry{
 		{Key: "general.architecture", Type: sharedgguf.MetaTypeString, Value: arch},
-		{Key: "general.file_type", Type: sharedgguf.MetaTypeUint32, Value: uint32(0)}, // F32
+		{Key: "general.file_type", Type: sharedgguf.MetaTypeUint32, Value: uint32(0)}, // F32 until SetFileType
 	}
 
-	for _, m := range configMapping {
-		val, ok := config[m.hfKey]
-		if !ok {
-			continue
-		}
-
-		key := replaceArch(m.ggufKey, arch)
-
-		switch m.ggufType {
-		case sharedgguf.MetaTypeUint32:
-			if u, err := toUint32(val); err == nil {
-				entries = append(entries, MetadataEntry{Key: key, Type: sharedgguf.MetaTypeUint32, Value: u})
-			}
-		case sharedgguf.MetaTypeFloat32:
-			if f, err := toFloat32(val); err == nil {
-				entries = append(entries, MetadataEntry{Key: key, Type: sharedgguf.MetaTypeFloat32, Value: f})
-			}
-		}
+	a, ok := LookupArchitecture(arch)
+	if !ok {
+		a, _ = LookupArchitecture(defaultArch)
 	}
-
-	if arch == "bert" {
-		for _, m := range bertExtraMapping {
-			val, ok := config[m.hfKey]
-			if !ok {
-				continue
-			}
-			key := replaceArch(m.ggufKey, arch)
-			switch m.ggufType {
-			case sharedgguf.MetaTypeUint32:
-				if u, err := toUint32(val); err == nil {
-					entries = append(entries, MetadataEntry{Key: key, Type: sharedgguf.MetaTypeUint32, Value: u})
-				}
-			case sharedgguf.MetaTypeFloat32:
-				if f, err := toFloat32(val); err == nil {
-					entries = append(entries, MetadataEntry{Key: key, Type: sharedgguf.MetaTypeFloat32, Value: f})
-				}
-			}
-		}
-		for _, m := range bertStaticMetadata {
-			entries = append(entries, MetadataEntry{
-				Key:   replaceArch(m.Key, arch),
-				Type:  m.Type,
-				Value: m.Value,
-			})
-		}
-	}
-
-	return entries
+	return append(entries, a.mapMetadata(arch, config)...)
 }
 
 func replaceArch(pattern, arch string) string {
@@ -183,3 +183,95 @@ func TestMapMetadata_PartialConfig(t *testing.T) {
 		t.Fatalf("expected 4 entries, got %d", len(entries))
 	}
 }
+
+func TestMapMetadata_When(t *testing.T) {
+	for _, use := range []interface{}{true, false, nil} {
+		config := map[string]interface{}{"sliding_window": float64(4096), "rope_theta": float64(1e6)}
+		if use != nil {
+			config["use_sliding_window"] = use
+		}
+		entryMap := make(map[string]MetadataEntry)
+		for _, e := range MapMetadata("qwen2", config) {
+			entryMap[e.Key] = e
+		}
+		e, ok := entryMap["qwen2.attention.sliding_window"]
+		if ok != (use == true) {
+			t.Errorf("use_sliding_window %v: sliding_window present = %v", use, ok)
+		} else if ok && e.Value != uint32(4096) {
+			t.Errorf("sliding_window = %v, want 4096", e.Value)
+		}
+		if e := entryMap["qwen2.rope.freq_base"]; e.Value != float32(1e6) {
+			t.Errorf("rope.freq_base = %v, want 1e6", e.Value)
+		}
+	}
+}
+
+func TestMapMetadata_Fallbacks(t *testing.T) {
+	tests := []struct {
+		name   string
+		arch   string
+		config map[string]interface{}
+		want   map[string]any
+	}{
+		{
+			name:   "gpt2 feed-forward from n_embd",
+			arch:   "gpt2",
+			config: map[string]interface{}{"n_embd": float64(768), "n_inner": nil, "n_positions": float64(1024), "n_ctx": float64(512)},
+			want:   map[string]any{"gpt2.feed_forward_length": uint32(3072), "gpt2.context_length": uint32(1024)},
+		},
+		{
+			name:   "gpt2 n_inner",
+			arch:   "gpt2",
+			config: map[string]interface{}{"n_embd": float64(768), "n_inner": float64(1000)},
+			want:   map[string]any{"gpt2.feed_forward_length": uint32(1000)},
+		},
+		{
+			name:   "gptneox rotary_pct",
+			arch:   "gptneox",
+			config: map[string]interface{}{"hidden_size": float64(512), "num_attention_heads": float64(8), "rotary_pct": 0.25},
+			want:   map[string]any{"gptneox.rope.dimension_count": uint32(16), "gptneox.use_parallel_residual": true},
+		},
+		{
+			name:   "gptneox sequential residual",
+			arch:   "gptneox",
+			config: map[string]interface{}{"use_parallel_residual": false},
+			want:   map[string]any{"gptneox.use_parallel_residual": false},
+		},
+		{
+			name:   "falcon multi-query",
+			arch:   "falcon",
+			config: map[string]interface{}{"hidden_size": float64(4544), "num_attention_heads": float64(71), "multi_query": true},
+			want: map[string]any{
+				"falcon.attention.head_count_kv": uint32(1), "falcon.context_length": uint32(2048),
+				"falcon.feed_forward_length": uint32(18176), "falcon.attention.head_count": uint32(71),
+			},
+		},
+		{
+			name:   "falcon new decoder architecture",
+			arch:   "falcon",
+			config: map[string]interface{}{"n_head": float64(128), "num_kv_heads": float64(8), "max_position_embeddings": float64(2048)},
+			want:   map[string]any{"falcon.attention.head_count_kv": uint32(8), "falcon.attention.head_count": uint32(128)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entryMap := make(map[string]MetadataEntry)
+			for _, e := range MapMetadata(tt.arch, tt.config) {
+				if _, ok := entryMap[e.Key]; ok {
+					t.Errorf("%s written twice", e.Key)
+				}
+				entryMap[e.Key] = e
+			}
+			for key, v := range tt.want {
+				if e, ok := entryMap[key]; !ok || e.Value != v {
+					t.Errorf("%s = %v (present %v), want %v", key, e.Value, ok, v)
+				}
+			}
+		})
+	}
+	for _, e := range MapMetadata("gptneox", map[string]interface{}{"rotary_pct": 0.25}) {
+		if e.Key == "gptneox.rope.dimension_count" {
+			t.Error("rope.dimension_count written without a head dimension")
+		}
+	}
+}
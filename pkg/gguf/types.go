@@ -0,0 +1,81 @@
+package gguf
+
+import (
+	"fmt"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+)
+
+// GGML tensor type IDs for the block-quantized formats produced by
+// pkg/quantize and the integer and double types GGUF files may carry. The
+// values are fixed by the GGUF specification; the basic float, Q4_0 and
+// Q8_0 types come from the shared ztensor package.
+const (
+	TypeQ4_1 = 3
+	TypeQ5_0 = 6
+	TypeQ5_1 = 7
+	TypeQ2_K = 10
+	TypeQ3_K = 11
+	TypeQ4_K = 12
+	TypeQ5_K = 13
+	TypeQ6_K = 14
+	TypeI8   = 24
+	TypeI16  = 25
+	TypeI32  = 26
+	TypeI64  = 27
+	TypeF64  = 28
+)
+
+// Metadata value types defined by the GGUF specification. The shared
+// ztensor writer only emits the uint32, float32 and string types.
+const (
+	MetaTypeUint8   uint32 = 0
+	MetaTypeInt8    uint32 = 1
+	MetaTypeUint16  uint32 = 2
+	MetaTypeInt16   uint32 = 3
+	MetaTypeUint32  uint32 = 4
+	MetaTypeInt32   uint32 = 5
+	MetaTypeFloat32 uint32 = 6
+	MetaTypeBool    uint32 = 7
+	MetaTypeString  uint32 = 8
+	MetaTypeArray   uint32 = 9
+	MetaTypeUint64  uint32 = 10
+	MetaTypeInt64   uint32 = 11
+	MetaTypeFloat64 uint32 = 12
+)
+
+// typeLayouts gives the block size in values and bytes of each tensor type
+// this package can size.
+var typeLayouts = map[int]struct{ values, bytes int }{
+	sharedgguf.TypeF32:  {1, 4},
+	sharedgguf.TypeF16:  {1, 2},
+	sharedgguf.TypeQ4_0: {32, 18},
+	TypeQ4_1:            {32, 20},
+	TypeQ5_0:            {32, 22},
+	TypeQ5_1:            {32, 24},
+	sharedgguf.TypeQ8_0: {32, 34},
+	TypeQ2_K:            {256, 84},
+	TypeQ3_K:            {256, 110},
+	TypeQ4_K:            {256, 144},
+	TypeQ5_K:            {256, 176},
+	TypeQ6_K:            {256, 210},
+	TypeI8:              {1, 1},
+	TypeI16:             {1, 2},
+	TypeI32:             {1, 4},
+	TypeI64:             {1, 8},
+	TypeF64:             {1, 8},
+	sharedgguf.TypeBF16: {1, 2},
+}
+
+// TensorSize returns the number of data bytes of a tensor of the given type
+// holding n values.
+func TensorSize(typ, n int) (int, error) {
+	l, ok := typeLayouts[typ]
+	if !ok {
+		return 0, fmt.Errorf("unsupported tensor type %d", typ)
+	}
+	if n%l.values != 0 {
+		return 0, fmt.Errorf("%d values is not a multiple of the type %d block size %d", n, typ, l.values)
+	}
+	return n / l.values * l.bytes, nil
+}
@@ -3,13 +3,14 @@ package importer
 import (
 	"encoding/binary"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 
+	"github.com/zerfoo/float16"
 	"github.com/zerfoo/zmf"
 	"github.com/zerfoo/zonnx/internal/onnx"
+	"github.com/zerfoo/zonnx/pkg/converter"
 	_ "github.com/zerfoo/zonnx/pkg/importer/layers" // Blank import to trigger layer registration
 	"github.com/zerfoo/zonnx/pkg/registry"
 	"google.golang.org/protobuf/proto"
@@ -19,6 +20,20 @@ import (
 func ConvertOnnxToZmf(
 	path string,
 ) (*zmf.Model, error) {
+	return ConvertOnnxToZmfWithOptions(path, Options{})
+}
+
+// Options configures ConvertOnnxToZmfWithOptions.
+type Options struct {
+	// RepackNBits stores MatMulNBits weights as Q4_0, Q4_1 or Q8_0 blocks
+	// consumed by a MatMul node, as converter.Options.RepackNBits does,
+	// when their block layout allows it. Other MatMulNBits nodes are kept
+	// as they are. zonnx convert sets it with --repack-nbits.
+	RepackNBits bool
+}
+
+// ConvertOnnxToZmfWithOptions is like ConvertOnnxToZmf but applies opts.
+func ConvertOnnxToZmfWithOptions(path string, opts Options) (*zmf.Model, error) {
 	onnxModel, err := LoadOnnxModel(path)
 	if err != nil {
 		return nil, err
@@ -48,6 +63,7 @@ func ConvertOnnxToZmf(
 		tensorName := qa.GetTensorName()
 		var scale float32
 		var zeroPoint int64
+		perAxis := false
 
 		for _, param := range qa.GetQuantParameterTensorNames() {
 			paramName := param.GetKey()
@@ -57,6 +73,12 @@ func ConvertOnnxToZmf(
 			if !ok {
 				return nil, fmt.Errorf("quantization parameter tensor %s not found for %s", paramTensorName, tensorName)
 			}
+			// zmf.Quantization holds a single scale and zero point; per-axis
+			// weights are carried by folding their DequantizeLinear nodes.
+			if numElements(paramTensor.GetDims()) > 1 {
+				perAxis = true
+				break
+			}
 
 			switch paramName {
 			case "SCALE_TENSOR":
@@ -73,6 +95,9 @@ func ConvertOnnxToZmf(
 				zeroPoint = val
 			}
 		}
+		if perAxis {
+			continue
+		}
 
 		if scale != 0 || zeroPoint != 0 { // Only add if quantization info is present
 			ctx.QuantizationInfo[tensorName] = &zmf.Quantization{
@@ -82,6 +107,13 @@ func ConvertOnnxToZmf(
 		}
 	}
 
+	// Fold QuantizeLinear/DequantizeLinear pairs around weights into plain
+	// parameters.
+	fold, err := foldQDQ(onnxModel.GetGraph(), ctx.Initializers, path)
+	if err != nil {
+		return nil, err
+	}
+
 	zmfGraph := &zmf.Graph{
 		Parameters: make(map[string]*zmf.Tensor),
 		Nodes:      make([]*zmf.Node, 0),
@@ -91,19 +123,47 @@ func ConvertOnnxToZmf(
 
 	// Populate parameters from initializers
 	for _, init := range onnxModel.GetGraph().GetInitializer() {
+		if fold.dropped[init.GetName()] {
+			continue
+		}
 		t, err := onnxTensorToZmfTensor(init, ctx, path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert tensor %q: %w", init.GetName(), err)
 		}
 		zmfGraph.Parameters[init.GetName()] = t
 	}
+	for name, t := range fold.params {
+		zmfGraph.Parameters[name] = t
+	}
 
 	// Populate nodes
 	for _, nodeDef := range onnxModel.GetGraph().GetNode() {
+		if fold.nodes[nodeDef] {
+			continue
+		}
+		if opts.RepackNBits && nodeDef.GetOpType() == "MatMulNBits" {
+			zmfNode, ok, err := converter.RepackMatMulNBits(nodeDef, ctx.Initializers, zmfGraph.Parameters)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert MatMulNBits node '%s': %w", nodeDef.GetName(), err)
+			}
+			if ok {
+				// The packed initializers are replaced by the repacked weight.
+				for _, in := range nodeDef.GetInput()[1:] {
+					delete(zmfGraph.Parameters, in)
+				}
+				zmfNode.Inputs[0] = fold.input(zmfNode.Inputs[0])
+				zmfGraph.Nodes = append(zmfGraph.Nodes, zmfNode)
+				continue
+			}
+		}
+		inputs := make([]string, len(nodeDef.GetInput()))
+		for i, in := range nodeDef.GetInput() {
+			inputs[i] = fold.input(in)
+		}
 		zmfNode := &zmf.Node{
 			Name:       nodeDef.GetName(),
 			OpType:     nodeDef.GetOpType(),
-			Inputs:     nodeDef.GetInput(),
+			Inputs:     inputs,
 			Outputs:    nodeDef.GetOutput(),
 			Attributes: make(map[string]*zmf.Attribute),
 		}
@@ -174,6 +234,21 @@ func onnxTensorToZmfTensor(ot *onnx.TensorProto, ctx *registry.ConversionContext
 		data = ot.GetRawData()
 	}
 
+	// ZMF has a single FLOAT8 type that cannot tell the four ONNX encodings
+	// apart, so FP8 tensors are decoded to float16, which holds every FP8
+	// value exactly.
+	if _, ok := fp8Formats[onnx.TensorProto_DataType(ot.GetDataType())]; ok {
+		vals, err := fp8Values(ot, data)
+		if err != nil {
+			return nil, fmt.Errorf("decode FP8 tensor %q: %w", ot.GetName(), err)
+		}
+		zmfDtype = zmf.Tensor_FLOAT16
+		data = make([]byte, len(vals)*2)
+		for i, v := range vals {
+			binary.LittleEndian.PutUint16(data[i*2:], float16.FromFloat32(v).Bits())
+		}
+	}
+
 	zmfTensor := &zmf.Tensor{
 		Dtype: zmfDtype,
 		Shape: ot.GetDims(),
@@ -356,32 +431,62 @@ func onnxAttributeToZmfAttribute(oa *onnx.AttributeProto) (*zmf.Attribute, error
 	return zmfAttr, nil
 }
 
-// extractScalarFloat extracts a single float32 value from an ONNX TensorProto.
+// extractScalarFloat extracts a single float32 value from an ONNX TensorProto
+// holding one float32 or float16 element.
 func extractScalarFloat(t *onnx.TensorProto) (float32, error) {
-	if len(t.GetDims()) != 0 {
+	if n := numElements(t.GetDims()); n != 1 {
 		return 0, fmt.Errorf("expected scalar tensor, got shape %v", t.GetDims())
 	}
-	if onnx.TensorProto_DataType(t.GetDataType()) != onnx.TensorProto_FLOAT {
-		return 0, fmt.Errorf("expected float32 tensor, got type %s", onnx.TensorProto_DataType(t.GetDataType()).String())
+	dt := onnx.TensorProto_DataType(t.GetDataType())
+	if dt != onnx.TensorProto_FLOAT && dt != onnx.TensorProto_FLOAT16 {
+		return 0, fmt.Errorf("expected float32 tensor, got type %s", dt.String())
+	}
+	vals, err := qdqFloats(t, "")
+	if err != nil {
+		return 0, err
 	}
-	if len(t.GetRawData()) != 4 {
-		return 0, fmt.Errorf("expected 4 bytes for float32, got %d bytes", len(t.GetRawData()))
+	if len(vals) != 1 {
+		return 0, fmt.Errorf("expected 1 value, got %d", len(vals))
 	}
-	return math.Float32frombits(binary.LittleEndian.Uint32(t.GetRawData())), nil
+	return vals[0], nil
 }
 
-// extractScalarInt64 extracts a single int64 value from an ONNX TensorProto.
+// extractScalarInt64 extracts a single integer value from an ONNX TensorProto
+// holding one int8, uint8, int32 or int64 element.
 func extractScalarInt64(t *onnx.TensorProto) (int64, error) {
-	if len(t.GetDims()) != 0 {
+	if n := numElements(t.GetDims()); n != 1 {
 		return 0, fmt.Errorf("expected scalar tensor, got shape %v", t.GetDims())
 	}
-	if onnx.TensorProto_DataType(t.GetDataType()) != onnx.TensorProto_INT64 {
-		return 0, fmt.Errorf("expected int64 tensor, got type %s", onnx.TensorProto_DataType(t.GetDataType()).String())
-	}
-	if len(t.GetRawData()) != 8 {
-		return 0, fmt.Errorf("expected 8 bytes for int64, got %d bytes", len(t.GetRawData()))
+	raw := t.GetRawData()
+	switch dt := onnx.TensorProto_DataType(t.GetDataType()); dt {
+	case onnx.TensorProto_INT8, onnx.TensorProto_UINT8:
+		vals, err := qdqInts(t, "")
+		if err != nil {
+			return 0, err
+		}
+		if len(vals) != 1 {
+			return 0, fmt.Errorf("expected 1 value, got %d", len(vals))
+		}
+		return int64(vals[0]), nil
+	case onnx.TensorProto_INT32:
+		if len(raw) == 4 {
+			return int64(int32(binary.LittleEndian.Uint32(raw))), nil
+		}
+		if len(t.GetInt32Data()) == 1 {
+			return int64(t.GetInt32Data()[0]), nil
+		}
+		return 0, fmt.Errorf("expected 4 bytes for int32, got %d bytes", len(raw))
+	case onnx.TensorProto_INT64:
+		if len(raw) == 8 {
+			return int64(binary.LittleEndian.Uint64(raw)), nil
+		}
+		if len(t.GetInt64Data()) == 1 {
+			return t.GetInt64Data()[0], nil
+		}
+		return 0, fmt.Errorf("expected 8 bytes for int64, got %d bytes", len(raw))
+	default:
+		return 0, fmt.Errorf("expected integer tensor, got type %s", dt.String())
 	}
-	return int64(binary.LittleEndian.Uint64(t.GetRawData())), nil
 }
 
 // LoadOnnxModel reads an ONNX model file and returns the parsed ModelProto.
@@ -0,0 +1,476 @@
+package importer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/zerfoo/float16"
+	"github.com/zerfoo/zmf"
+	"github.com/zerfoo/zonnx/internal/onnx"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+)
+
+// q8Block is the number of values sharing a scale in a Q8_0 block.
+const q8Block = 32
+
+// fp8Formats maps the ONNX FP8 types to their encodings.
+var fp8Formats = map[onnx.TensorProto_DataType]quantize.FP8Format{
+	onnx.TensorProto_FLOAT8E4M3FN:   quantize.FP8E4M3FN,
+	onnx.TensorProto_FLOAT8E4M3FNUZ: quantize.FP8E4M3FNUZ,
+	onnx.TensorProto_FLOAT8E5M2:     quantize.FP8E5M2,
+	onnx.TensorProto_FLOAT8E5M2FNUZ: quantize.FP8E5M2FNUZ,
+}
+
+// qdqFold is the result of folding the QuantizeLinear/DequantizeLinear pairs
+// around weight initializers of a QDQ model.
+type qdqFold struct {
+	// params holds the folded weights, keyed by the name their consumers
+	// now read.
+	params map[string]*zmf.Tensor
+	// nodes are the QuantizeLinear and DequantizeLinear nodes folded away.
+	nodes map[*onnx.NodeProto]bool
+	// renames maps each folded DequantizeLinear output to its weight.
+	renames map[string]string
+	// dropped are initializers only the folded nodes used.
+	dropped map[string]bool
+}
+
+// input returns the name a node should read in place of name.
+func (f *qdqFold) input(name string) string {
+	if r, ok := f.renames[name]; ok {
+		return r
+	}
+	return name
+}
+
+// foldQDQ finds DequantizeLinear nodes whose input is an int8, uint8 or FP8
+// weight initializer, or the QuantizeLinear of a float weight initializer, and
+// replaces each with a parameter holding the weight. Per-tensor, per-axis and
+// blocked scales are supported. A weight becomes a Q8_0 tensor when it is
+// symmetric (zero point 0 for int8, 128 for uint8), its last dimension is a
+// multiple of 32 and every 32 consecutive values share a scale; otherwise it
+// is dequantized to float32, as FP8 weights always are. The parameter takes
+// the initializer's name with any "_quantized" suffix that ONNX Runtime adds
+// removed.
+func foldQDQ(graph *onnx.GraphProto, initializers map[string]*onnx.TensorProto, modelPath string) (*qdqFold, error) {
+	f := &qdqFold{
+		params:  make(map[string]*zmf.Tensor),
+		nodes:   make(map[*onnx.NodeProto]bool),
+		renames: make(map[string]string),
+		dropped: make(map[string]bool),
+	}
+
+	producers := make(map[string]*onnx.NodeProto)
+	consumers := make(map[string]int)
+	for _, n := range graph.GetNode() {
+		for _, out := range n.GetOutput() {
+			producers[out] = n
+		}
+		for _, in := range n.GetInput() {
+			consumers[in]++
+		}
+	}
+
+	for _, dq := range graph.GetNode() {
+		if dq.GetOpType() != "DequantizeLinear" || len(dq.GetInput()) < 2 || len(dq.GetOutput()) != 1 {
+			continue
+		}
+		src := dq.GetInput()[0]
+		folded := []*onnx.NodeProto{dq}
+		var (
+			w   *qdqWeight
+			err error
+		)
+		if init, ok := initializers[src]; ok {
+			w, err = readQDQWeight(dq, init, initializers, modelPath)
+		} else if q := producers[src]; q != nil && q.GetOpType() == "QuantizeLinear" && len(q.GetInput()) > 0 && consumers[src] == 1 {
+			init, ok := initializers[q.GetInput()[0]]
+			if !ok {
+				continue
+			}
+			w, err = quantizeQDQWeight(q, dq, init, initializers, modelPath)
+			folded = append(folded, q)
+			src = q.GetInput()[0]
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fold %s node %q: %w", dq.GetOpType(), dq.GetName(), err)
+		}
+		if w == nil {
+			continue
+		}
+
+		name := src
+		if base, ok := strings.CutSuffix(src, "_quantized"); ok {
+			if _, taken := initializers[base]; !taken && producers[base] == nil {
+				name = base
+			}
+		}
+		f.params[name] = w.tensor()
+		f.renames[dq.GetOutput()[0]] = name
+		for _, n := range folded {
+			f.nodes[n] = true
+		}
+	}
+
+	// Drop the initializers that only folded nodes read.
+	used := make(map[string]bool)
+	for _, n := range graph.GetNode() {
+		if f.nodes[n] {
+			continue
+		}
+		for _, in := range n.GetInput() {
+			used[f.input(in)] = true
+		}
+	}
+	for n := range f.nodes {
+		for _, in := range n.GetInput() {
+			if _, ok := initializers[in]; ok && !used[in] {
+				f.dropped[in] = true
+			}
+		}
+	}
+	return f, nil
+}
+
+// qdqWeight is a quantized weight with the scales and zero points of the
+// DequantizeLinear node that reads it.
+type qdqWeight struct {
+	shape  []int64
+	values []int32   // quantized values
+	floats []float32 // FP8 values, which have no zero point
+	signed bool      // int8 rather than uint8
+	scales []float32
+	zps    []int32       // one per scale
+	index  func(int) int // scale of each value
+}
+
+// readQDQWeight reads an int8, uint8 or FP8 initializer dequantized by dq.
+// It returns nil if the initializer has another type or dq's parameters are
+// not initializers.
+func readQDQWeight(dq *onnx.NodeProto, init *onnx.TensorProto, initializers map[string]*onnx.TensorProto, modelPath string) (*qdqWeight, error) {
+	dt := onnx.TensorProto_DataType(init.GetDataType())
+	_, fp8 := fp8Formats[dt]
+	if dt != onnx.TensorProto_INT8 && dt != onnx.TensorProto_UINT8 && !fp8 {
+		return nil, nil
+	}
+	w, err := qdqParams(dq, init.GetDims(), initializers, modelPath)
+	if w == nil || err != nil {
+		return nil, err
+	}
+	if fp8 {
+		if w.floats, err = qdqFloats(init, modelPath); err != nil {
+			return nil, err
+		}
+		if len(w.floats) != numElements(w.shape) {
+			return nil, fmt.Errorf("weight %q has %d values, want %d", init.GetName(), len(w.floats), numElements(w.shape))
+		}
+		return w, nil
+	}
+	w.signed = dt == onnx.TensorProto_INT8
+	if w.values, err = qdqInts(init, modelPath); err != nil {
+		return nil, err
+	}
+	if len(w.values) != numElements(w.shape) {
+		return nil, fmt.Errorf("weight %q has %d values, want %d", init.GetName(), len(w.values), numElements(w.shape))
+	}
+	return w, nil
+}
+
+// quantizeQDQWeight applies QuantizeLinear node q to a float initializer and
+// returns the result with the parameters of dq, which reads q's output. It
+// returns nil if the initializer is not float, a parameter is not an
+// initializer or q does not quantize to int8 or uint8.
+func quantizeQDQWeight(q, dq *onnx.NodeProto, init *onnx.TensorProto, initializers map[string]*onnx.TensorProto, modelPath string) (*qdqWeight, error) {
+	dt := onnx.TensorProto_DataType(init.GetDataType())
+	if dt != onnx.TensorProto_FLOAT && dt != onnx.TensorProto_FLOAT16 {
+		return nil, nil
+	}
+	qp, err := qdqParams(q, init.GetDims(), initializers, modelPath)
+	if qp == nil || err != nil {
+		return nil, err
+	}
+	w, err := qdqParams(dq, init.GetDims(), initializers, modelPath)
+	if w == nil || err != nil {
+		return nil, err
+	}
+	floats, err := qdqFloats(init, modelPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(floats) != numElements(w.shape) {
+		return nil, fmt.Errorf("weight %q has %d values, want %d", init.GetName(), len(floats), numElements(w.shape))
+	}
+
+	// The output type is the opset-21 output_dtype attribute when set, else
+	// that of the zero point, and defaults to uint8.
+	out := onnx.TensorProto_UINT8
+	if in := q.GetInput(); len(in) >= 3 && in[2] != "" {
+		out = onnx.TensorProto_DataType(initializers[in[2]].GetDataType())
+	}
+	for _, attr := range q.GetAttribute() {
+		if attr.GetName() == "output_dtype" && attr.GetI() != 0 {
+			out = onnx.TensorProto_DataType(attr.GetI())
+		}
+	}
+	if out != onnx.TensorProto_INT8 && out != onnx.TensorProto_UINT8 {
+		return nil, nil
+	}
+	w.signed = out == onnx.TensorProto_INT8
+	lo, hi := 0.0, 255.0
+	if w.signed {
+		lo, hi = -128, 127
+	}
+	w.values = make([]int32, len(floats))
+	for i, x := range floats {
+		j := qp.index(i)
+		v := math.RoundToEven(float64(x/qp.scales[j])) + float64(qp.zps[j])
+		w.values[i] = int32(math.Max(lo, math.Min(hi, v)))
+	}
+	return w, nil
+}
+
+// qdqParams reads the scale and zero point inputs and the axis and
+// block_size attributes of a QuantizeLinear or DequantizeLinear node applied
+// to a tensor of the given shape. It returns nil if an input is not an
+// initializer.
+func qdqParams(node *onnx.NodeProto, shape []int64, initializers map[string]*onnx.TensorProto, modelPath string) (*qdqWeight, error) {
+	in := node.GetInput()
+	if len(in) < 2 {
+		return nil, nil
+	}
+	scaleInit, ok := initializers[in[1]]
+	if !ok {
+		return nil, nil
+	}
+	w := &qdqWeight{shape: shape}
+	var err error
+	if w.scales, err = qdqFloats(scaleInit, modelPath); err != nil {
+		return nil, fmt.Errorf("scale %q: %w", in[1], err)
+	}
+	w.zps = make([]int32, len(w.scales))
+	if len(in) >= 3 && in[2] != "" {
+		zpInit, ok := initializers[in[2]]
+		if !ok {
+			return nil, nil
+		}
+		// FP8 zero points only give the type; ONNX requires them to be 0.
+		if _, fp8 := fp8Formats[onnx.TensorProto_DataType(zpInit.GetDataType())]; !fp8 {
+			if w.zps, err = qdqInts(zpInit, modelPath); err != nil {
+				return nil, fmt.Errorf("zero point %q: %w", in[2], err)
+			}
+			if len(w.zps) != len(w.scales) {
+				return nil, fmt.Errorf("zero point %q has %d values, scale has %d", in[2], len(w.zps), len(w.scales))
+			}
+		}
+	}
+
+	axis, blockSize := int64(1), int64(0)
+	for _, attr := range node.GetAttribute() {
+		switch attr.GetName() {
+		case "axis":
+			axis = attr.GetI()
+		case "block_size":
+			blockSize = attr.GetI()
+		}
+	}
+	if w.index, err = qdqIndexer(shape, int(axis), int(blockSize), len(w.scales)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// qdqIndexer returns the function mapping each flat index of a tensor of
+// the given shape to its scale: the only scale when count is 1, the scale of
+// its position along axis, or with blockSize > 0 the scale of its block of
+// blockSize positions along axis.
+func qdqIndexer(shape []int64, axis, blockSize, count int) (func(int) int, error) {
+	if count == 1 {
+		return func(int) int { return 0 }, nil
+	}
+	r := len(shape)
+	if axis < 0 {
+		axis += r
+	}
+	if axis < 0 || axis >= r {
+		return nil, fmt.Errorf("axis %d out of range for shape %v", axis, shape)
+	}
+	dim := int(shape[axis])
+	stride := numElements(shape[axis+1:])
+	if blockSize <= 0 {
+		if count != dim {
+			return nil, fmt.Errorf("%d scales for axis %d of shape %v", count, axis, shape)
+		}
+		return func(i int) int { return (i / stride) % dim }, nil
+	}
+	blocks := (dim + blockSize - 1) / blockSize
+	if count != numElements(shape[:axis])*blocks*stride {
+		return nil, fmt.Errorf("%d scales for blocks of %d along axis %d of shape %v", count, blockSize, axis, shape)
+	}
+	return func(i int) int {
+		outer, a, inner := i/(dim*stride), (i/stride)%dim, i%stride
+		return (outer*blocks+a/blockSize)*stride + inner
+	}, nil
+}
+
+// tensor returns the weight as a Q8_0 tensor when the Q8_0 format can hold
+// it exactly, and as dequantized float32 values otherwise.
+func (w *qdqWeight) tensor() *zmf.Tensor {
+	if w.floats != nil {
+		data := make([]byte, len(w.floats)*4)
+		for i, x := range w.floats {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(w.scales[w.index(i)]*x))
+		}
+		return &zmf.Tensor{Dtype: zmf.Tensor_FLOAT32, Shape: w.shape, Data: data}
+	}
+	if w.q8Compatible() {
+		return w.q8Tensor()
+	}
+	data := make([]byte, len(w.values)*4)
+	for i, v := range w.values {
+		j := w.index(i)
+		x := w.scales[j] * float32(v-w.zps[j])
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(x))
+	}
+	return &zmf.Tensor{Dtype: zmf.Tensor_FLOAT32, Shape: w.shape, Data: data}
+}
+
+// zero returns the zero point of symmetric quantization.
+func (w *qdqWeight) zero() int32 {
+	if w.signed {
+		return 0
+	}
+	return 128
+}
+
+// q8Compatible reports whether the weight is symmetric, its rows split into
+// whole Q8_0 blocks and each block shares one scale.
+func (w *qdqWeight) q8Compatible() bool {
+	if len(w.shape) == 0 || w.shape[len(w.shape)-1]%q8Block != 0 {
+		return false
+	}
+	for _, zp := range w.zps {
+		if zp != w.zero() {
+			return false
+		}
+	}
+	for b := 0; b < len(w.values); b += q8Block {
+		j := w.index(b)
+		for i := b + 1; i < b+q8Block; i++ {
+			if w.index(i) != j {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// q8Tensor encodes the weight as Q8_0 blocks in the layout pkg/quantize
+// writes: a float32 scale followed by 32 int8 values.
+func (w *qdqWeight) q8Tensor() *zmf.Tensor {
+	const size = 4 + q8Block
+	data := make([]byte, len(w.values)/q8Block*size)
+	for b := 0; b < len(w.values)/q8Block; b++ {
+		out := data[b*size:]
+		binary.LittleEndian.PutUint32(out, math.Float32bits(w.scales[w.index(b*q8Block)]))
+		for i, v := range w.values[b*q8Block : (b+1)*q8Block] {
+			out[4+i] = byte(int8(v - w.zero()))
+		}
+	}
+	return &zmf.Tensor{Dtype: zmf.Tensor_Q8_0, Shape: w.shape, Data: data}
+}
+
+// tensorBytes returns the raw data of t, loading external data from disk.
+func tensorBytes(t *onnx.TensorProto, modelPath string) ([]byte, error) {
+	if len(t.GetExternalData()) > 0 {
+		return loadExternalData(t, modelPath)
+	}
+	return t.GetRawData(), nil
+}
+
+// qdqInts returns the values of an int8 or uint8 tensor.
+func qdqInts(t *onnx.TensorProto, modelPath string) ([]int32, error) {
+	dt := onnx.TensorProto_DataType(t.GetDataType())
+	if dt != onnx.TensorProto_INT8 && dt != onnx.TensorProto_UINT8 {
+		return nil, fmt.Errorf("expected int8 or uint8 tensor, got type %s", dt.String())
+	}
+	raw, err := tensorBytes(t, modelPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		// Without raw data, 8-bit values are stored in int32_data.
+		return t.GetInt32Data(), nil
+	}
+	vals := make([]int32, len(raw))
+	for i, b := range raw {
+		if dt == onnx.TensorProto_INT8 {
+			vals[i] = int32(int8(b))
+		} else {
+			vals[i] = int32(b)
+		}
+	}
+	return vals, nil
+}
+
+// qdqFloats returns the values of a float32, float16 or FP8 tensor.
+func qdqFloats(t *onnx.TensorProto, modelPath string) ([]float32, error) {
+	raw, err := tensorBytes(t, modelPath)
+	if err != nil {
+		return nil, err
+	}
+	switch dt := onnx.TensorProto_DataType(t.GetDataType()); dt {
+	case onnx.TensorProto_FLOAT:
+		if len(raw) == 0 {
+			return t.GetFloatData(), nil
+		}
+		vals := make([]float32, len(raw)/4)
+		for i := range vals {
+			vals[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+		}
+		return vals, nil
+	case onnx.TensorProto_FLOAT16:
+		if len(raw) == 0 {
+			// Without raw data, float16 bits are stored in int32_data.
+			vals := make([]float32, len(t.GetInt32Data()))
+			for i, bits := range t.GetInt32Data() {
+				vals[i] = float16.Float16(uint16(bits)).ToFloat32()
+			}
+			return vals, nil
+		}
+		vals := make([]float32, len(raw)/2)
+		for i := range vals {
+			vals[i] = float16.Float16(binary.LittleEndian.Uint16(raw[i*2:])).ToFloat32()
+		}
+		return vals, nil
+	default:
+		if _, ok := fp8Formats[dt]; !ok {
+			return nil, fmt.Errorf("expected float, float16 or FP8 tensor, got type %s", dt.String())
+		}
+		return fp8Values(t, raw)
+	}
+}
+
+// fp8Values decodes an FP8 tensor whose raw or external data is raw.
+func fp8Values(t *onnx.TensorProto, raw []byte) ([]float32, error) {
+	if len(raw) == 0 {
+		// Without raw data, FP8 bytes are stored in int32_data.
+		raw = make([]byte, len(t.GetInt32Data()))
+		for i, b := range t.GetInt32Data() {
+			raw[i] = byte(b)
+		}
+	}
+	return quantize.DecodeFP8(fp8Formats[onnx.TensorProto_DataType(t.GetDataType())], raw)
+}
+
+// numElements returns the number of values in a tensor of the given shape.
+func numElements(shape []int64) int {
+	n := 1
+	for _, d := range shape {
+		n *= int(d)
+	}
+	return n
+}
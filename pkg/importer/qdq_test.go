@@ -0,0 +1,579 @@
+package importer
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zerfoo/float16"
+	"github.com/zerfoo/zmf"
+	"github.com/zerfoo/zonnx/internal/onnx"
+	"github.com/zerfoo/zonnx/pkg/quantize"
+	"github.com/zerfoo/zonnx/pkg/registry"
+	"google.golang.org/protobuf/proto"
+)
+
+func strPtr(s string) *string { return &s }
+func int32Ptr(v int32) *int32 { return &v }
+func proto64(v int64) *int64  { return &v }
+
+func intAttr(name string, v int64) *onnx.AttributeProto {
+	t := onnx.AttributeProto_INT
+	return &onnx.AttributeProto{Name: strPtr(name), Type: &t, I: &v}
+}
+
+func floatTensor(name string, dims []int64, vals []float32) *onnx.TensorProto {
+	raw := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(v))
+	}
+	return &onnx.TensorProto{Name: strPtr(name), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT)), Dims: dims, RawData: raw}
+}
+
+func int8Tensor(name string, dt onnx.TensorProto_DataType, dims []int64, vals []int32) *onnx.TensorProto {
+	raw := make([]byte, len(vals))
+	for i, v := range vals {
+		raw[i] = byte(v)
+	}
+	return &onnx.TensorProto{Name: strPtr(name), DataType: int32Ptr(int32(dt)), Dims: dims, RawData: raw}
+}
+
+// qdqModel writes a model that runs nodes and feeds the output of the last
+// one to a MatMul, and returns its path.
+func qdqModel(t *testing.T, inits []*onnx.TensorProto, nodes []*onnx.NodeProto, annotations []*onnx.TensorAnnotation) string {
+	t.Helper()
+	last := nodes[len(nodes)-1].GetOutput()[0]
+	nodes = append(nodes, &onnx.NodeProto{
+		Name:   strPtr("matmul"),
+		OpType: strPtr("MatMul"),
+		Input:  []string{"x", last},
+		Output: []string{"y"},
+	})
+	model := &onnx.ModelProto{
+		OpsetImport: []*onnx.OperatorSetIdProto{{Version: proto64(21)}},
+		Graph: &onnx.GraphProto{
+			Initializer:            inits,
+			Node:                   nodes,
+			QuantizationAnnotation: annotations,
+		},
+	}
+	data, err := proto.Marshal(model)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "model.onnx")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func dqNode(input, scale, zp string, attrs ...*onnx.AttributeProto) *onnx.NodeProto {
+	in := []string{input, scale}
+	if zp != "" {
+		in = append(in, zp)
+	}
+	return &onnx.NodeProto{
+		Name:      strPtr(input + "_dq"),
+		OpType:    strPtr("DequantizeLinear"),
+		Input:     in,
+		Output:    []string{input + "_DequantizeLinear_Output"},
+		Attribute: attrs,
+	}
+}
+
+// paramValues returns the values of a FLOAT32 or Q8_0 parameter.
+func paramValues(t *testing.T, p *zmf.Tensor, n int) []float32 {
+	t.Helper()
+	switch p.Dtype {
+	case zmf.Tensor_FLOAT32:
+		vals := make([]float32, n)
+		for i := range vals {
+			vals[i] = math.Float32frombits(binary.LittleEndian.Uint32(p.Data[i*4:]))
+		}
+		return vals
+	case zmf.Tensor_Q8_0:
+		vals, err := quantize.Dequantize(quantize.Q8_0, p.Data, n)
+		if err != nil {
+			t.Fatalf("Dequantize: %v", err)
+		}
+		return vals
+	}
+	t.Fatalf("unexpected dtype %v", p.Dtype)
+	return nil
+}
+
+func TestConvertOnnxToZmf_QDQWeights(t *testing.T) {
+	const rows, cols = 4, 64
+	levels := make([]int32, rows*cols)
+	for i := range levels {
+		levels[i] = int32(i*37%255) - 127
+	}
+	ulevels := make([]int32, len(levels))
+	for i, v := range levels {
+		ulevels[i] = v + 128
+	}
+	rowScales := []float32{0.5, 0.25, 0.125, 2}
+	colScales := make([]float32, cols)
+	for i := range colScales {
+		colScales[i] = float32(i+1) / 64
+	}
+	blockScales := make([]float32, rows*cols/16)
+	for i := range blockScales {
+		blockScales[i] = float32(i%7+1) / 8
+	}
+	wide := make([]float32, rows*cols/32)
+	for i := range wide {
+		wide[i] = float32(i+1) / 4
+	}
+
+	tests := []struct {
+		name      string
+		inits     []*onnx.TensorProto
+		dq        *onnx.NodeProto
+		wantDtype zmf.Tensor_DataType
+		want      func(i int) float32
+	}{
+		{
+			name: "int8 per-tensor",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_INT8, []int64{rows, cols}, levels),
+				floatTensor("w_scale", nil, []float32{0.5}),
+				int8Tensor("w_zero_point", onnx.TensorProto_INT8, nil, []int32{0}),
+			},
+			dq:        dqNode("w_quantized", "w_scale", "w_zero_point"),
+			wantDtype: zmf.Tensor_Q8_0,
+			want:      func(i int) float32 { return 0.5 * float32(levels[i]) },
+		},
+		{
+			name: "int8 per-row",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_INT8, []int64{rows, cols}, levels),
+				floatTensor("w_scale", []int64{rows}, rowScales),
+			},
+			dq:        dqNode("w_quantized", "w_scale", "", intAttr("axis", 0)),
+			wantDtype: zmf.Tensor_Q8_0,
+			want:      func(i int) float32 { return rowScales[i/cols] * float32(levels[i]) },
+		},
+		{
+			name: "uint8 symmetric per-row",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_UINT8, []int64{rows, cols}, ulevels),
+				floatTensor("w_scale", []int64{rows}, rowScales),
+				int8Tensor("w_zero_point", onnx.TensorProto_UINT8, []int64{rows}, []int32{128, 128, 128, 128}),
+			},
+			dq:        dqNode("w_quantized", "w_scale", "w_zero_point", intAttr("axis", -2)),
+			wantDtype: zmf.Tensor_Q8_0,
+			want:      func(i int) float32 { return rowScales[i/cols] * float32(levels[i]) },
+		},
+		{
+			name: "int8 per-column",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_INT8, []int64{rows, cols}, levels),
+				floatTensor("w_scale", []int64{cols}, colScales),
+			},
+			dq:        dqNode("w_quantized", "w_scale", ""),
+			wantDtype: zmf.Tensor_FLOAT32,
+			want:      func(i int) float32 { return colScales[i%cols] * float32(levels[i]) },
+		},
+		{
+			name: "uint8 asymmetric per-tensor",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_UINT8, []int64{rows, cols}, ulevels),
+				floatTensor("w_scale", nil, []float32{0.25}),
+				int8Tensor("w_zero_point", onnx.TensorProto_UINT8, nil, []int32{100}),
+			},
+			dq:        dqNode("w_quantized", "w_scale", "w_zero_point"),
+			wantDtype: zmf.Tensor_FLOAT32,
+			want:      func(i int) float32 { return 0.25 * float32(ulevels[i]-100) },
+		},
+		{
+			name: "int8 blocks of 16",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_INT8, []int64{rows, cols}, levels),
+				floatTensor("w_scale", []int64{rows, cols / 16}, blockScales),
+			},
+			dq:        dqNode("w_quantized", "w_scale", "", intAttr("axis", 1), intAttr("block_size", 16)),
+			wantDtype: zmf.Tensor_FLOAT32,
+			want:      func(i int) float32 { return blockScales[i/16] * float32(levels[i]) },
+		},
+		{
+			name: "int8 blocks of 32",
+			inits: []*onnx.TensorProto{
+				int8Tensor("w_quantized", onnx.TensorProto_INT8, []int64{rows, cols}, levels),
+				floatTensor("w_scale", []int64{rows, cols / 32}, wide),
+			},
+			dq:        dqNode("w_quantized", "w_scale", "", intAttr("axis", 1), intAttr("block_size", 32)),
+			wantDtype: zmf.Tensor_Q8_0,
+			want:      func(i int) float32 { return wide[i/32] * float32(levels[i]) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := qdqModel(t, tt.inits, []*onnx.NodeProto{tt.dq}, nil)
+			m, err := ConvertOnnxToZmf(path)
+			if err != nil {
+				t.Fatalf("ConvertOnnxToZmf: %v", err)
+			}
+			p := m.Graph.Parameters["w"]
+			if p == nil {
+				t.Fatalf("missing folded parameter 'w'; have %v", paramNames(m))
+			}
+			if p.Dtype != tt.wantDtype {
+				t.Errorf("dtype = %v, want %v", p.Dtype, tt.wantDtype)
+			}
+			if len(p.Shape) != 2 || p.Shape[0] != rows || p.Shape[1] != cols {
+				t.Errorf("shape = %v, want [%d %d]", p.Shape, rows, cols)
+			}
+			got := paramValues(t, p, rows*cols)
+			for i := range got {
+				if want := tt.want(i); got[i] != want {
+					t.Fatalf("value %d = %v, want %v", i, got[i], want)
+				}
+			}
+
+			for _, name := range []string{"w_quantized", "w_scale", "w_zero_point"} {
+				if m.Graph.Parameters[name] != nil {
+					t.Errorf("unexpected parameter %q", name)
+				}
+			}
+			if len(m.Graph.Nodes) != 1 || m.Graph.Nodes[0].OpType != "MatMul" {
+				t.Fatalf("nodes = %v, want only MatMul", m.Graph.Nodes)
+			}
+			if in := m.Graph.Nodes[0].Inputs; in[1] != "w" {
+				t.Errorf("MatMul inputs = %v, want [x w]", in)
+			}
+		})
+	}
+}
+
+func TestConvertOnnxToZmf_QuantizeDequantizePair(t *testing.T) {
+	const rows, cols = 2, 32
+	weights := make([]float32, rows*cols)
+	for i := range weights {
+		weights[i] = float32(i%13-6) * 0.3
+	}
+	scales := []float32{0.1, 0.05}
+	inits := []*onnx.TensorProto{
+		floatTensor("w", []int64{rows, cols}, weights),
+		floatTensor("w_scale", []int64{rows}, scales),
+		int8Tensor("w_zero_point", onnx.TensorProto_INT8, []int64{rows}, []int32{0, 0}),
+	}
+	nodes := []*onnx.NodeProto{
+		{
+			Name:      strPtr("w_q"),
+			OpType:    strPtr("QuantizeLinear"),
+			Input:     []string{"w", "w_scale", "w_zero_point"},
+			Output:    []string{"w_QuantizeLinear_Output"},
+			Attribute: []*onnx.AttributeProto{intAttr("axis", 0)},
+		},
+		dqNode("w_QuantizeLinear_Output", "w_scale", "w_zero_point", intAttr("axis", 0)),
+	}
+	m, err := ConvertOnnxToZmf(qdqModel(t, inits, nodes, nil))
+	if err != nil {
+		t.Fatalf("ConvertOnnxToZmf: %v", err)
+	}
+	p := m.Graph.Parameters["w"]
+	if p == nil || p.Dtype != zmf.Tensor_Q8_0 {
+		t.Fatalf("parameter 'w' = %v, want Q8_0", p)
+	}
+	got := paramValues(t, p, rows*cols)
+	for i, x := range weights {
+		s := scales[i/cols]
+		q := math.Max(-128, math.Min(127, math.RoundToEven(float64(x/s))))
+		if want := s * float32(q); got[i] != want {
+			t.Fatalf("value %d = %v, want %v", i, got[i], want)
+		}
+	}
+	if len(m.Graph.Nodes) != 1 || m.Graph.Nodes[0].Inputs[1] != "w" {
+		t.Errorf("nodes = %v, want MatMul reading w", m.Graph.Nodes)
+	}
+}
+
+func TestConvertOnnxToZmf_QuantizeOutputDtype(t *testing.T) {
+	// Without a zero point, QuantizeLinear quantizes to uint8 unless the
+	// opset-21 output_dtype attribute says otherwise.
+	const rows, cols = 2, 32
+	weights := make([]float32, rows*cols)
+	for i := range weights {
+		weights[i] = float32(i%13-6) * 0.3
+	}
+	scales := []float32{0.1, 0.05}
+	tests := []struct {
+		name   string
+		attrs  []*onnx.AttributeProto
+		lo, hi float64
+	}{
+		{name: "default uint8", lo: 0, hi: 255},
+		{name: "output_dtype int8", attrs: []*onnx.AttributeProto{intAttr("output_dtype", int64(onnx.TensorProto_INT8))}, lo: -128, hi: 127},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inits := []*onnx.TensorProto{
+				floatTensor("w", []int64{rows, cols}, weights),
+				floatTensor("w_scale", []int64{rows}, scales),
+			}
+			nodes := []*onnx.NodeProto{
+				{
+					Name:      strPtr("w_q"),
+					OpType:    strPtr("QuantizeLinear"),
+					Input:     []string{"w", "w_scale"},
+					Output:    []string{"w_QuantizeLinear_Output"},
+					Attribute: append([]*onnx.AttributeProto{intAttr("axis", 0)}, tt.attrs...),
+				},
+				dqNode("w_QuantizeLinear_Output", "w_scale", "", intAttr("axis", 0)),
+			}
+			m, err := ConvertOnnxToZmf(qdqModel(t, inits, nodes, nil))
+			if err != nil {
+				t.Fatalf("ConvertOnnxToZmf: %v", err)
+			}
+			p := m.Graph.Parameters["w"]
+			if p == nil {
+				t.Fatalf("missing folded parameter 'w'; have %v", paramNames(m))
+			}
+			got := paramValues(t, p, rows*cols)
+			for i, x := range weights {
+				s := scales[i/cols]
+				q := math.Max(tt.lo, math.Min(tt.hi, math.RoundToEven(float64(x/s))))
+				if want := s * float32(q); got[i] != want {
+					t.Fatalf("value %d = %v, want %v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertOnnxToZmfWithOptions_RepackNBits(t *testing.T) {
+	// Two 4-bit MatMulNBits weights with N=2 outputs and K=32 inputs: nb32
+	// holds one 32-value block per row and is repacked to Q4_0, nb16 holds
+	// blocks of 16 and is kept as it is. A QDQ weight is folded alongside.
+	const n, k = 2, 32
+	nibbles := func(row, j int) int { return (j + 3*row) % 16 }
+	var packed []byte
+	for row := range n {
+		for j := 0; j < k; j += 2 {
+			packed = append(packed, byte(nibbles(row, j)|nibbles(row, j+1)<<4))
+		}
+	}
+	nbits := func(name string, blockSize int64) *onnx.NodeProto {
+		return &onnx.NodeProto{
+			Name:   strPtr(name),
+			OpType: strPtr("MatMulNBits"),
+			Input:  []string{"x", name + "_w", name + "_s"},
+			Output: []string{name + "_y"},
+			Attribute: []*onnx.AttributeProto{
+				intAttr("K", k), intAttr("N", n), intAttr("bits", 4), intAttr("block_size", blockSize),
+			},
+		}
+	}
+	levels := make([]int32, n*k)
+	for i := range levels {
+		levels[i] = int32(i%15) - 7
+	}
+	inits := []*onnx.TensorProto{
+		int8Tensor("nb32_w", onnx.TensorProto_UINT8, []int64{n, 1, k / 2}, nil),
+		floatTensor("nb32_s", []int64{n}, []float32{0.5, 0.25}),
+		int8Tensor("nb16_w", onnx.TensorProto_UINT8, []int64{n, 2, 8}, nil),
+		floatTensor("nb16_s", []int64{n * 2}, []float32{0.5, 0.5, 0.25, 0.25}),
+		int8Tensor("w_quantized", onnx.TensorProto_INT8, []int64{n, k}, levels),
+		floatTensor("w_scale", nil, []float32{0.5}),
+	}
+	inits[0].RawData = packed
+	inits[2].RawData = packed
+	nodes := []*onnx.NodeProto{nbits("nb32", 32), nbits("nb16", 16), dqNode("w_quantized", "w_scale", "")}
+
+	m, err := ConvertOnnxToZmfWithOptions(qdqModel(t, inits, nodes, nil), Options{RepackNBits: true})
+	if err != nil {
+		t.Fatalf("ConvertOnnxToZmfWithOptions: %v", err)
+	}
+
+	p := m.Graph.Parameters["nb32_w_repacked"]
+	if p == nil || p.Dtype != zmf.Tensor_Q4_0 {
+		t.Fatalf("parameter 'nb32_w_repacked' = %v, want Q4_0; have %v", p, paramNames(m))
+	}
+	got, err := quantize.Dequantize(quantize.Q4_0, p.Data, n*k)
+	if err != nil {
+		t.Fatalf("Dequantize: %v", err)
+	}
+	scales := []float32{0.5, 0.25}
+	for i, v := range got {
+		if want := float32(nibbles(i/k, i%k)-8) * scales[i/k]; v != want {
+			t.Fatalf("value [%d,%d] = %v, want %v", i/k, i%k, v, want)
+		}
+	}
+	for _, name := range []string{"nb32_w", "nb32_s", "w_quantized", "w_scale"} {
+		if m.Graph.Parameters[name] != nil {
+			t.Errorf("unexpected parameter %q", name)
+		}
+	}
+	for _, name := range []string{"nb16_w", "nb16_s", "w"} {
+		if m.Graph.Parameters[name] == nil {
+			t.Errorf("missing parameter %q", name)
+		}
+	}
+
+	var ops []string
+	for _, node := range m.Graph.Nodes {
+		ops = append(ops, node.OpType)
+	}
+	if len(ops) != 3 || ops[0] != "MatMul" || ops[1] != "MatMulNBits" || ops[2] != "MatMul" {
+		t.Fatalf("ops = %v, want [MatMul MatMulNBits MatMul]", ops)
+	}
+	if a := m.Graph.Nodes[0].Attributes["transB"]; a == nil || a.GetI() != 1 {
+		t.Errorf("transB = %v, want 1", a)
+	}
+	if in := m.Graph.Nodes[0].Inputs; len(in) != 2 || in[1] != "nb32_w_repacked" {
+		t.Errorf("inputs = %v, want [x nb32_w_repacked]", in)
+	}
+}
+
+func TestConvertOnnxToZmf_FP8DequantizeLinear(t *testing.T) {
+	const rows, cols = 2, 32
+	// E4M3FN bytes of both signs, avoiding the 0x7F/0xFF NaNs.
+	raw := make([]byte, rows*cols)
+	for i := range raw {
+		raw[i] = byte(i*11%0x7F) | byte(i%2)<<7
+	}
+	scales := []float32{0.5, 3}
+	inits := []*onnx.TensorProto{
+		{Name: strPtr("w"), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT8E4M3FN)), Dims: []int64{rows, cols}, RawData: raw},
+		floatTensor("w_scale", []int64{rows}, scales),
+		{Name: strPtr("w_zero_point"), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT8E4M3FN)), Dims: []int64{rows}, RawData: []byte{0, 0}},
+	}
+	m, err := ConvertOnnxToZmf(qdqModel(t, inits, []*onnx.NodeProto{dqNode("w", "w_scale", "w_zero_point", intAttr("axis", 0))}, nil))
+	if err != nil {
+		t.Fatalf("ConvertOnnxToZmf: %v", err)
+	}
+	p := m.Graph.Parameters["w"]
+	if p == nil || p.Dtype != zmf.Tensor_FLOAT32 {
+		t.Fatalf("parameter 'w' = %v, want FLOAT32", p)
+	}
+	decoded, err := quantize.DecodeFP8(quantize.FP8E4M3FN, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := paramValues(t, p, rows*cols)
+	for i, x := range decoded {
+		if want := scales[i/cols] * x; got[i] != want {
+			t.Fatalf("value %d = %v, want %v", i, got[i], want)
+		}
+	}
+	if len(m.Graph.Parameters) != 1 || len(m.Graph.Nodes) != 1 {
+		t.Errorf("parameters %v, nodes %v: want only w and MatMul", paramNames(m), m.Graph.Nodes)
+	}
+}
+
+func TestOnnxTensorToZmfTensor_FP8(t *testing.T) {
+	ctx := &registry.ConversionContext{}
+	tests := []struct {
+		name   string
+		tensor *onnx.TensorProto
+		want   []float32
+	}{
+		{
+			name:   "e4m3fn raw",
+			tensor: &onnx.TensorProto{Name: strPtr("a"), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT8E4M3FN)), Dims: []int64{4}, RawData: []byte{0x38, 0xC0, 0x7E, 0x01}},
+			want:   []float32{1, -2, 448, 1.0 / 512},
+		},
+		{
+			name:   "e4m3fnuz raw",
+			tensor: &onnx.TensorProto{Name: strPtr("b"), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT8E4M3FNUZ)), Dims: []int64{2}, RawData: []byte{0x40, 0xC8}},
+			want:   []float32{1, -2},
+		},
+		{
+			name:   "e5m2 int32_data",
+			tensor: &onnx.TensorProto{Name: strPtr("c"), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT8E5M2)), Dims: []int64{3}, Int32Data: []int32{0x3C, 0xC4, 0x7B}},
+			want:   []float32{1, -4, 57344},
+		},
+		{
+			name:   "e5m2fnuz raw",
+			tensor: &onnx.TensorProto{Name: strPtr("d"), DataType: int32Ptr(int32(onnx.TensorProto_FLOAT8E5M2FNUZ)), Dims: []int64{2}, RawData: []byte{0x40, 0x01}},
+			want:   []float32{1, 1.0 / 131072},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := onnxTensorToZmfTensor(tt.tensor, ctx, "")
+			if err != nil {
+				t.Fatalf("onnxTensorToZmfTensor: %v", err)
+			}
+			if got.Dtype != zmf.Tensor_FLOAT16 {
+				t.Fatalf("dtype = %v, want FLOAT16", got.Dtype)
+			}
+			if len(got.Data) != 2*len(tt.want) {
+				t.Fatalf("got %d bytes, want %d", len(got.Data), 2*len(tt.want))
+			}
+			for i, want := range tt.want {
+				if v := float16.Float16(binary.LittleEndian.Uint16(got.Data[i*2:])).ToFloat32(); v != want {
+					t.Errorf("value %d = %v, want %v", i, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertOnnxToZmf_QuantizationAnnotations(t *testing.T) {
+	levels := make([]int32, 32)
+	inits := []*onnx.TensorProto{
+		int8Tensor("w_quantized", onnx.TensorProto_UINT8, []int64{1, 32}, levels),
+		floatTensor("w_scale", []int64{1}, []float32{0.5}),
+		int8Tensor("w_zero_point", onnx.TensorProto_UINT8, []int64{1}, []int32{3}),
+		floatTensor("x_scale", []int64{32}, make([]float32, 32)),
+	}
+	annotations := []*onnx.TensorAnnotation{
+		{
+			TensorName: strPtr("act"),
+			QuantParameterTensorNames: []*onnx.StringStringEntryProto{
+				{Key: strPtr("SCALE_TENSOR"), Value: strPtr("w_scale")},
+				{Key: strPtr("ZERO_POINT_TENSOR"), Value: strPtr("w_zero_point")},
+			},
+		},
+		{
+			TensorName: strPtr("per_axis"),
+			QuantParameterTensorNames: []*onnx.StringStringEntryProto{
+				{Key: strPtr("SCALE_TENSOR"), Value: strPtr("x_scale")},
+			},
+		},
+	}
+	nodes := []*onnx.NodeProto{dqNode("w_quantized", "w_scale", "w_zero_point", intAttr("axis", 0))}
+	m, err := ConvertOnnxToZmf(qdqModel(t, inits, nodes, annotations))
+	if err != nil {
+		t.Fatalf("ConvertOnnxToZmf: %v", err)
+	}
+	if m.Graph.Parameters["w"] == nil {
+		t.Errorf("missing folded parameter 'w'; have %v", paramNames(m))
+	}
+}
+
+func TestExtractScalars(t *testing.T) {
+	f, err := extractScalarFloat(floatTensor("s", []int64{1}, []float32{0.75}))
+	if err != nil || f != 0.75 {
+		t.Errorf("extractScalarFloat = %v, %v; want 0.75", f, err)
+	}
+	if _, err := extractScalarFloat(floatTensor("s", []int64{2}, []float32{1, 2})); err == nil {
+		t.Error("extractScalarFloat accepted two values")
+	}
+	for _, tt := range []struct {
+		tensor *onnx.TensorProto
+		want   int64
+	}{
+		{int8Tensor("zp", onnx.TensorProto_UINT8, nil, []int32{200}), 200},
+		{int8Tensor("zp", onnx.TensorProto_INT8, []int64{1}, []int32{-5}), -5},
+		{&onnx.TensorProto{DataType: int32Ptr(int32(onnx.TensorProto_INT64)), Int64Data: []int64{42}}, 42},
+	} {
+		got, err := extractScalarInt64(tt.tensor)
+		if err != nil || got != tt.want {
+			t.Errorf("extractScalarInt64 = %v, %v; want %d", got, err, tt.want)
+		}
+	}
+}
+
+func paramNames(m *zmf.Model) []string {
+	var names []string
+	for name := range m.Graph.Parameters {
+		names = append(names, name)
+	}
+	return names
+}
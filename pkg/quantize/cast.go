@@ -0,0 +1,77 @@
+package quantize
+
+import (
+	"fmt"
+	"strings"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// FloatFileType returns the general.file_type of a file whose weights are
+// stored as typ, which must be TypeF32, TypeF16 or TypeBF16.
+func FloatFileType(typ int) (uint32, bool) {
+	ft, ok := floatFileTypes[typ]
+	return ft, ok
+}
+
+// IsNorm reports whether the GGUF tensor name is a normalization weight or
+// bias. Whole-model casts keep these small, rounding-sensitive tensors in
+// float32.
+func IsNorm(name string) bool {
+	return strings.Contains(strings.ToLower(name), "norm")
+}
+
+// CastType returns the type a float tensor named name is stored as when a
+// whole model is cast to typ: F32 for norm tensors unless castNorms is set,
+// typ otherwise.
+func CastType(name string, typ int, castNorms bool) int {
+	if !castNorms && IsNorm(name) {
+		return sharedgguf.TypeF32
+	}
+	return typ
+}
+
+// CastFloats re-encodes n values of float GGUF data of type from (F32, F16,
+// BF16 or F64) as type to (F32, F16 or BF16), rounding to nearest even and
+// keeping half-precision subnormals.
+func CastFloats(from int, data []byte, n int, to int) ([]byte, error) {
+	if !isGGUFFloat(from) {
+		return nil, fmt.Errorf("cast from non-float type %d", from)
+	}
+	if from == to {
+		return data, nil
+	}
+	size, err := gguf.TensorSize(from, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("have %d bytes for %d values of type %d, want %d", len(data), n, from, size)
+	}
+	return EncodeFloats(to, ggufDecoders[from](data, n))
+}
+
+// CastGGUF casts every float tensor of f to typ, which must be TypeF32,
+// TypeF16 or TypeBF16, keeping norm tensors in F32 unless castNorms is set.
+// Block-quantized and integer tensors are left unchanged. general.file_type
+// is not updated.
+func CastGGUF(f *gguf.File, typ int, castNorms bool) error {
+	if _, ok := floatFileTypes[typ]; !ok {
+		return fmt.Errorf("unsupported cast target type %d: want F32, F16 or BF16", typ)
+	}
+	for i := range f.Tensors {
+		t := &f.Tensors[i]
+		if !isGGUFFloat(t.Type) {
+			continue
+		}
+		to := CastType(t.Name, typ, castNorms)
+		data, err := CastFloats(t.Type, t.Data, t.Elements(), to)
+		if err != nil {
+			return fmt.Errorf("tensor %q: %w", t.Name, err)
+		}
+		t.Type, t.Data = to, data
+	}
+	return nil
+}
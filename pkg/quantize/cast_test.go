@@ -0,0 +1,163 @@
+package quantize
+
+import (
+	"math"
+	"testing"
+
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// referenceFloat16 rounds f to half precision in float64 arithmetic.
+func referenceFloat16(f float32) uint16 {
+	x := float64(f)
+	var sign uint16
+	if math.Signbit(x) {
+		sign, x = 0x8000, -x
+	}
+	switch {
+	case math.IsNaN(x):
+		return 0x7E00
+	case x >= 65520: // halfway between the largest half and 2^16
+		return sign | 0x7C00
+	case x < math.Ldexp(1, -14):
+		return sign | uint16(math.RoundToEven(math.Ldexp(x, 24)))
+	}
+	_, e := math.Frexp(x) // x = m * 2^e, 0.5 <= m < 1
+	q := math.RoundToEven(math.Ldexp(x, 11-e))
+	bits := uint16(e+14)<<10 + uint16(q) - 1024 // a carry bumps the exponent
+	return sign | bits
+}
+
+func TestFloat32ToFloat16Bits(t *testing.T) {
+	tests := []struct {
+		in   float32
+		want uint16
+	}{
+		{0, 0},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3C00},
+		{1 + 1.0/2048, 0x3C00},                     // tie rounds to even
+		{1 + 3.0/2048, 0x3C02},                     // tie rounds to even
+		{65504, 0x7BFF},                            // largest half
+		{65519, 0x7BFF},                            // rounds down
+		{65520, 0x7C00},                            // rounds to infinity
+		{float32(math.Ldexp(1, -24)), 0x0001},      // smallest subnormal
+		{float32(math.Ldexp(1, -25)), 0x0000},      // tie rounds to even zero
+		{float32(math.Ldexp(1.0001, -25)), 0x0001}, // just above the tie
+		{float32(math.Ldexp(1.5, -24)), 0x0002},    // tie rounds to even
+		{float32(math.Ldexp(1023.5, -24)), 0x0400}, // rounds up to the smallest normal
+		{float32(math.Inf(-1)), 0xFC00},
+		{float32(math.Ldexp(1, -140)), 0x0000},                // float32 subnormal
+		{-float32(math.Ldexp(3, -26)), 0x8001},                // negative subnormal
+		{float32(math.Ldexp(1+1.0/1024+1.0/4096, 0)), 0x3C01}, // below the tie
+	}
+	for _, tt := range tests {
+		if got := float32ToFloat16Bits(tt.in); got != tt.want {
+			t.Errorf("float32ToFloat16Bits(%g) = %#04x, want %#04x", tt.in, got, tt.want)
+		}
+	}
+	if got := float32ToFloat16Bits(float32(math.NaN())); got&0x7C00 != 0x7C00 || got&0x3FF == 0 {
+		t.Errorf("NaN = %#04x, want a NaN", got)
+	}
+
+	// Compare a spread of bit patterns with the float64 reference.
+	for b := uint32(0); b < 0xFF800000; b += 65521 {
+		f := math.Float32frombits(b)
+		if math.IsNaN(float64(f)) {
+			continue
+		}
+		if got, want := float32ToFloat16Bits(f), referenceFloat16(f); got != want {
+			t.Fatalf("float32ToFloat16Bits(%g) = %#04x, want %#04x", f, got, want)
+		}
+	}
+}
+
+func TestCastFloats(t *testing.T) {
+	vals := []float32{1, -2.5, 1e-6, 65504, 3.14159}
+	f32 := encodeFloat32(vals)
+	for _, to := range []int{sharedgguf.TypeF32, sharedgguf.TypeF16, sharedgguf.TypeBF16} {
+		got, err := CastFloats(sharedgguf.TypeF32, f32, len(vals), to)
+		if err != nil {
+			t.Fatalf("CastFloats to %d: %v", to, err)
+		}
+		want, _ := EncodeFloats(to, vals)
+		if string(got) != string(want) {
+			t.Errorf("CastFloats to %d = %x, want %x", to, got, want)
+		}
+	}
+
+	// F16 to BF16 goes through the exact float32 value.
+	f16, _ := EncodeFloats(sharedgguf.TypeF16, vals)
+	got, err := CastFloats(sharedgguf.TypeF16, f16, len(vals), sharedgguf.TypeBF16)
+	if err != nil {
+		t.Fatalf("CastFloats: %v", err)
+	}
+	want, _ := EncodeFloats(sharedgguf.TypeBF16, decodeF16(f16, len(vals)))
+	if string(got) != string(want) {
+		t.Errorf("F16 to BF16 = %x, want %x", got, want)
+	}
+
+	if _, err := CastFloats(sharedgguf.TypeQ8_0, f32, len(vals), sharedgguf.TypeF16); err == nil {
+		t.Error("expected error for a block-quantized source")
+	}
+	if _, err := CastFloats(sharedgguf.TypeF32, f32[:8], len(vals), sharedgguf.TypeF16); err == nil {
+		t.Error("expected error for short data")
+	}
+	if _, err := CastFloats(sharedgguf.TypeF32, f32, len(vals), sharedgguf.TypeQ4_0); err == nil {
+		t.Error("expected error for a block-quantized target")
+	}
+}
+
+func TestCastGGUF(t *testing.T) {
+	vals := randomWeights(64, 5)
+	q8 := make([]byte, 2*34) // two ggml Q8_0 blocks
+	f := &gguf.File{Tensors: []gguf.Tensor{
+		{Name: "blk.0.attn_q.weight", Type: sharedgguf.TypeF32, Shape: []int{2, 32}, Data: encodeFloat32(vals)},
+		{Name: "blk.0.attn_norm.weight", Type: sharedgguf.TypeBF16, Shape: []int{64}, Data: encodeGGUFFloat(sharedgguf.TypeBF16, vals)},
+		{Name: "blk.0.ffn_up.weight", Type: sharedgguf.TypeQ8_0, Shape: []int{2, 32}, Data: q8},
+	}}
+	if err := CastGGUF(f, sharedgguf.TypeF16, false); err != nil {
+		t.Fatalf("CastGGUF: %v", err)
+	}
+	wantTypes := []int{sharedgguf.TypeF16, sharedgguf.TypeF32, sharedgguf.TypeQ8_0}
+	for i, want := range wantTypes {
+		if f.Tensors[i].Type != want {
+			t.Errorf("%s type = %d, want %d", f.Tensors[i].Name, f.Tensors[i].Type, want)
+		}
+	}
+	for i, v := range decodeF16(f.Tensors[0].Data, len(vals)) {
+		if want := fp16Value(float32ToFloat16Bits(vals[i])); v != want {
+			t.Fatalf("value %d = %v, want %v", i, v, want)
+		}
+	}
+	if string(f.Tensors[2].Data) != string(q8) {
+		t.Error("block-quantized tensor changed")
+	}
+
+	if err := CastGGUF(f, sharedgguf.TypeBF16, true); err != nil {
+		t.Fatalf("CastGGUF: %v", err)
+	}
+	if f.Tensors[1].Type != sharedgguf.TypeBF16 {
+		t.Errorf("norm type with castNorms = %d, want BF16", f.Tensors[1].Type)
+	}
+	if err := CastGGUF(f, sharedgguf.TypeQ4_0, false); err == nil {
+		t.Error("expected error for a block-quantized target")
+	}
+}
+
+func TestCastType(t *testing.T) {
+	if got := CastType("output_norm.weight", sharedgguf.TypeF16, false); got != sharedgguf.TypeF32 {
+		t.Errorf("norm = %d, want F32", got)
+	}
+	if got := CastType("output_norm.weight", sharedgguf.TypeF16, true); got != sharedgguf.TypeF16 {
+		t.Errorf("norm with castNorms = %d, want F16", got)
+	}
+	if got := CastType("blk.0.ffn_down.weight", sharedgguf.TypeBF16, false); got != sharedgguf.TypeBF16 {
+		t.Errorf("weight = %d, want BF16", got)
+	}
+	if ft, ok := FloatFileType(sharedgguf.TypeBF16); !ok || ft != 32 {
+		t.Errorf("FloatFileType(BF16) = %d, %v", ft, ok)
+	}
+}
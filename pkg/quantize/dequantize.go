@@ -0,0 +1,133 @@
+package quantize
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/zerfoo/float16"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// Dequantize decodes n values of type qt from data, which must hold them in
+// the block layout ModelWithOptions writes for qt.
+func Dequantize(qt QuantType, data []byte, n int) ([]float32, error) {
+	f, ok := formats[qt]
+	if !ok {
+		return nil, fmt.Errorf("unsupported quantization type: %q", qt)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid value count %d", n)
+	}
+	blocks := (n + f.blockValues - 1) / f.blockValues
+	if want := blocks * f.blockBytes; len(data) < want {
+		return nil, fmt.Errorf("%s data has %d bytes, want %d for %d values", qt, len(data), want, n)
+	}
+	return f.decode(data, n), nil
+}
+
+// ggufDecoders expands the GGUF tensor types DequantizeTensor supports.
+// Q4_0 and Q8_0 use the ggml block layout; the other block formats of this
+// package already match it.
+var ggufDecoders = map[int]func(data []byte, n int) []float32{
+	sharedgguf.TypeF32:  func(data []byte, n int) []float32 { return decodeFloat32(data[:4*n]) },
+	sharedgguf.TypeF16:  decodeF16,
+	sharedgguf.TypeBF16: decodeBF16,
+	gguf.TypeF64:        decodeFloat64,
+	sharedgguf.TypeQ4_0: dequantizeGGMLQ4_0,
+	sharedgguf.TypeQ8_0: dequantizeGGMLQ8_0,
+	gguf.TypeQ4_1:       dequantizeQ4_1Blocks,
+	gguf.TypeQ5_0:       dequantizeQ5_0Blocks,
+	gguf.TypeQ5_1:       dequantizeQ5_1Blocks,
+	gguf.TypeQ2_K:       dequantizeQ2KBlocks,
+	gguf.TypeQ3_K:       dequantizeQ3KBlocks,
+	gguf.TypeQ4_K:       dequantizeQ4KBlocks,
+	gguf.TypeQ5_K:       dequantizeQ5KBlocks,
+	gguf.TypeQ6_K:       dequantizeQ6KBlocks,
+}
+
+// DequantizeTensor returns the values of a float or block-quantized GGUF
+// tensor as float32.
+func DequantizeTensor(t *gguf.Tensor) ([]float32, error) {
+	decode, ok := ggufDecoders[t.Type]
+	if !ok {
+		return nil, fmt.Errorf("tensor %q: unsupported tensor type %d", t.Name, t.Type)
+	}
+	n := t.Elements()
+	size, err := gguf.TensorSize(t.Type, n)
+	if err != nil {
+		return nil, fmt.Errorf("tensor %q: %w", t.Name, err)
+	}
+	if len(t.Data) != size {
+		return nil, fmt.Errorf("tensor %q: have %d bytes, want %d", t.Name, len(t.Data), size)
+	}
+	return decode(t.Data, n), nil
+}
+
+// isGGUFFloat reports whether typ is a plain floating-point GGUF type.
+func isGGUFFloat(typ int) bool {
+	switch typ {
+	case sharedgguf.TypeF32, sharedgguf.TypeF16, sharedgguf.TypeBF16, gguf.TypeF64:
+		return true
+	}
+	return false
+}
+
+// floatFileTypes gives the general.file_type of a file whose weights are all
+// stored as the given float type.
+var floatFileTypes = map[int]uint32{
+	sharedgguf.TypeF32:  0,
+	sharedgguf.TypeF16:  1,
+	sharedgguf.TypeBF16: 32,
+}
+
+// DequantizeGGUF expands every block-quantized tensor of a GGUF file in
+// place to typ, which must be TypeF32, TypeF16 or TypeBF16. Float tensors
+// and tensors of other types are left unchanged. general.file_type is
+// updated from the resulting tensor types and general.quantization_version
+// is dropped.
+func DequantizeGGUF(f *gguf.File, typ int) error {
+	if _, ok := floatFileTypes[typ]; !ok {
+		return fmt.Errorf("unsupported dequantization target type %d", typ)
+	}
+	for i := range f.Tensors {
+		t := &f.Tensors[i]
+		if _, ok := ggufDecoders[t.Type]; !ok || isGGUFFloat(t.Type) {
+			continue
+		}
+		vals, err := DequantizeTensor(t)
+		if err != nil {
+			return err
+		}
+		t.Type = typ
+		t.Data = encodeGGUFFloat(typ, vals)
+	}
+	f.SetFileType()
+	return nil
+}
+
+// EncodeFloats stores vals as GGUF data of typ, which must be TypeF32,
+// TypeF16 or TypeBF16, rounding to nearest even.
+func EncodeFloats(typ int, vals []float32) ([]byte, error) {
+	if _, ok := floatFileTypes[typ]; !ok {
+		return nil, fmt.Errorf("unsupported float type %d: want F32, F16 or BF16", typ)
+	}
+	return encodeGGUFFloat(typ, vals), nil
+}
+
+// encodeGGUFFloat stores vals as little-endian F32, F16 or BF16, rounding to
+// nearest even.
+func encodeGGUFFloat(typ int, vals []float32) []byte {
+	switch typ {
+	case sharedgguf.TypeF16:
+		return encodeF16(vals)
+	case sharedgguf.TypeBF16:
+		out := make([]byte, len(vals)*2)
+		for i, v := range vals {
+			binary.LittleEndian.PutUint16(out[i*2:], float16.BFloat16FromFloat32(v).Bits())
+		}
+		return out
+	}
+	return encodeFloat32(vals)
+}
@@ -0,0 +1,140 @@
+package quantize
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+func TestDequantize(t *testing.T) {
+	vals := randomWeights(2*superBlockSize+40, 31)
+	for qt, f := range formats {
+		t.Run(string(qt), func(t *testing.T) {
+			n := len(vals)
+			if f.rowAlign > 0 {
+				n = 2 * superBlockSize
+			}
+			data := f.encode(vals[:n])
+			got, err := Dequantize(qt, data, n)
+			if err != nil {
+				t.Fatalf("Dequantize: %v", err)
+			}
+			want := f.decode(data, n)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("value %d = %v, want %v", i, got[i], want[i])
+				}
+			}
+			if _, err := Dequantize(qt, data[:len(data)-1], n); err == nil {
+				t.Error("expected error for short data")
+			}
+		})
+	}
+	if _, err := Dequantize("q9_9", nil, 0); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+func TestDequantizeTensor(t *testing.T) {
+	vals := randomWeights(superBlockSize, 32)
+	tests := []struct {
+		name    string
+		tensor  gguf.Tensor
+		maxRMSE float64
+	}{
+		{"F32", gguf.Tensor{Type: sharedgguf.TypeF32, Data: encodeFloat32(vals)}, 0},
+		{"F16", gguf.Tensor{Type: sharedgguf.TypeF16, Data: encodeF16(vals)}, 0.001},
+		{"BF16", gguf.Tensor{Type: sharedgguf.TypeBF16, Data: encodeGGUFFloat(sharedgguf.TypeBF16, vals)}, 0.005},
+		{"Q4_0", gguf.Tensor{Type: sharedgguf.TypeQ4_0, Data: GGMLData(&zmf.Tensor{Dtype: zmf.Tensor_Q4_0, Data: encodeQ4Blocks(vals)})}, 0.15},
+		{"Q8_0", gguf.Tensor{Type: sharedgguf.TypeQ8_0, Data: GGMLData(&zmf.Tensor{Dtype: zmf.Tensor_Q8_0, Data: encodeQ8Blocks(vals)})}, 0.01},
+		{"Q5_1", gguf.Tensor{Type: gguf.TypeQ5_1, Data: encodeQ5_1Blocks(vals)}, 0.05},
+		{"Q6_K", gguf.Tensor{Type: gguf.TypeQ6_K, Data: encodeQ6KBlocks(vals)}, 0.03},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tensor.Name = "w"
+			tt.tensor.Shape = []int{superBlockSize}
+			got, err := DequantizeTensor(&tt.tensor)
+			if err != nil {
+				t.Fatalf("DequantizeTensor: %v", err)
+			}
+			if e := relativeRMSE(vals, got); e > tt.maxRMSE {
+				t.Errorf("relative RMSE %.4f, want <= %.4f", e, tt.maxRMSE)
+			}
+		})
+	}
+
+	bad := []gguf.Tensor{
+		{Name: "short", Type: gguf.TypeQ4_K, Shape: []int{superBlockSize}, Data: make([]byte, q4KBlockBytes-1)},
+		{Name: "partial block", Type: gguf.TypeQ4_K, Shape: []int{100}, Data: make([]byte, q4KBlockBytes)},
+		{Name: "integer", Type: gguf.TypeI32, Shape: []int{4}, Data: make([]byte, 16)},
+	}
+	for _, tensor := range bad {
+		if _, err := DequantizeTensor(&tensor); err == nil {
+			t.Errorf("%s: expected error", tensor.Name)
+		}
+	}
+}
+
+func TestDequantizeGGUF(t *testing.T) {
+	vals := randomWeights(2*superBlockSize, 33)
+	newFile := func() *gguf.File {
+		return &gguf.File{
+			Metadata: []gguf.MetadataEntry{
+				{Key: "general.file_type", Type: gguf.MetaTypeUint32, Value: uint32(15)},
+				{Key: "general.quantization_version", Type: gguf.MetaTypeUint32, Value: gguf.QuantizationVersion},
+			},
+			Tensors: []gguf.Tensor{
+				{Name: "blk.0.attn_q.weight", Type: gguf.TypeQ4_K, Shape: []int{2, superBlockSize}, Data: encodeQ4KBlocks(vals)},
+				{Name: "blk.0.attn_norm.weight", Type: sharedgguf.TypeF32, Shape: []int{superBlockSize}, Data: encodeFloat32(vals[:superBlockSize])},
+				{Name: "ids", Type: gguf.TypeI32, Shape: []int{2}, Data: make([]byte, 8)},
+			},
+		}
+	}
+
+	tests := []struct {
+		typ      int
+		fileType uint32
+	}{
+		{sharedgguf.TypeF32, 0},
+		{sharedgguf.TypeF16, 1},
+		{sharedgguf.TypeBF16, 32},
+	}
+	for _, tt := range tests {
+		f := newFile()
+		if err := DequantizeGGUF(f, tt.typ); err != nil {
+			t.Fatalf("DequantizeGGUF(%d): %v", tt.typ, err)
+		}
+		q := f.Tensors[0]
+		if q.Type != tt.typ {
+			t.Errorf("type %d: attn_q type = %d", tt.typ, q.Type)
+		}
+		got, err := DequantizeTensor(&q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := dequantizeQ4KBlocks(encodeQ4KBlocks(vals), len(vals))
+		for i := range want {
+			if d := math.Abs(float64(got[i] - want[i])); d > 0.01*math.Abs(float64(want[i]))+1e-6 {
+				t.Fatalf("type %d: value %d = %v, want %v", tt.typ, i, got[i], want[i])
+			}
+		}
+		if f.Tensors[1].Type != sharedgguf.TypeF32 || f.Tensors[2].Type != gguf.TypeI32 {
+			t.Errorf("type %d: float or integer tensor was changed", tt.typ)
+		}
+		if e, _ := f.Lookup("general.file_type"); e.Value != tt.fileType {
+			t.Errorf("type %d: general.file_type = %v, want %d", tt.typ, e.Value, tt.fileType)
+		}
+		if _, ok := f.Lookup("general.quantization_version"); ok {
+			t.Errorf("type %d: general.quantization_version kept", tt.typ)
+		}
+	}
+
+	if err := DequantizeGGUF(newFile(), gguf.TypeQ4_K); err == nil {
+		t.Error("expected error for block-quantized target")
+	}
+}
@@ -0,0 +1,144 @@
+package quantize
+
+import (
+	"fmt"
+	"math"
+)
+
+// FP8Format is an 8-bit floating-point encoding.
+type FP8Format int
+
+const (
+	// FP8E4M3FN has 4 exponent and 3 mantissa bits, no infinities and one
+	// NaN mantissa pattern per sign. It is the SafeTensors F8_E4M3 type.
+	FP8E4M3FN FP8Format = iota
+	// FP8E4M3FNUZ is E4M3 with exponent bias 8, no negative zero and 0x80
+	// as its only NaN.
+	FP8E4M3FNUZ
+	// FP8E5M2 has 5 exponent and 2 mantissa bits with IEEE infinities and
+	// NaNs. It is the SafeTensors F8_E5M2 type.
+	FP8E5M2
+	// FP8E5M2FNUZ is E5M2 with exponent bias 16, no infinities, no negative
+	// zero and 0x80 as its only NaN.
+	FP8E5M2FNUZ
+)
+
+var fp8Tables = [...][256]float32{
+	FP8E4M3FN:   fp8Table(FP8E4M3FN),
+	FP8E4M3FNUZ: fp8Table(FP8E4M3FNUZ),
+	FP8E5M2:     fp8Table(FP8E5M2),
+	FP8E5M2FNUZ: fp8Table(FP8E5M2FNUZ),
+}
+
+// DecodeFP8 expands FP8 values to float32. Every FP8 value, including the
+// subnormals, is exactly representable as float16 and bfloat16.
+func DecodeFP8(f FP8Format, data []byte) ([]float32, error) {
+	if f < 0 || int(f) >= len(fp8Tables) {
+		return nil, fmt.Errorf("unknown FP8 format %d", f)
+	}
+	table := &fp8Tables[f]
+	out := make([]float32, len(data))
+	for i, b := range data {
+		out[i] = table[b]
+	}
+	return out, nil
+}
+
+// fp8Table returns the float32 value of every byte in format f.
+func fp8Table(f FP8Format) [256]float32 {
+	var expBits, manBits, bias int
+	switch f {
+	case FP8E4M3FN:
+		expBits, manBits, bias = 4, 3, 7
+	case FP8E4M3FNUZ:
+		expBits, manBits, bias = 4, 3, 8
+	case FP8E5M2:
+		expBits, manBits, bias = 5, 2, 15
+	case FP8E5M2FNUZ:
+		expBits, manBits, bias = 5, 2, 16
+	}
+	maxExp, maxMan := 1<<expBits-1, 1<<manBits-1
+
+	var table [256]float32
+	for i := range table {
+		b := byte(i)
+		e, m := int(b>>manBits)&maxExp, int(b)&maxMan
+		var v float64
+		switch {
+		case (f == FP8E4M3FNUZ || f == FP8E5M2FNUZ) && b == 0x80:
+			v = math.NaN()
+		case f == FP8E4M3FN && e == maxExp && m == maxMan:
+			v = math.NaN()
+		case f == FP8E5M2 && e == maxExp && m != 0:
+			v = math.NaN()
+		case f == FP8E5M2 && e == maxExp:
+			v = math.Inf(1)
+		case e == 0:
+			v = math.Ldexp(float64(m), 1-bias-manBits)
+		default:
+			v = math.Ldexp(float64(m|(maxMan+1)), e-bias-manBits)
+		}
+		if b&0x80 != 0 && !math.IsNaN(v) {
+			v = -v
+		}
+		table[i] = float32(v)
+	}
+	return table
+}
+
+// ApplyScales multiplies the values of a tensor of the given shape, viewed as
+// [rows, cols] with cols its last dimension, by the scales FP8 checkpoints
+// store beside each weight: a single scale, one scale per row, or a 2-D grid
+// with one scale per block of block[0] x block[1] values, partial at the
+// edges. When block is nil, the block size is inferred from the grid shape.
+func ApplyScales(vals []float32, shape []int, scales []float32, scaleShape []int, block []int) error {
+	rows, cols := 1, 1
+	if len(shape) > 0 {
+		cols = shape[len(shape)-1]
+		for _, d := range shape[:len(shape)-1] {
+			rows *= d
+		}
+	}
+	if len(vals) != rows*cols {
+		return fmt.Errorf("%d values for shape %v", len(vals), shape)
+	}
+
+	perRow := len(scaleShape) == 1 || len(scaleShape) == 2 && scaleShape[1] == 1
+	switch {
+	case len(scales) == 1:
+		for i := range vals {
+			vals[i] *= scales[0]
+		}
+		return nil
+	case perRow && len(scales) == rows:
+		for r := range rows {
+			for c := range cols {
+				vals[r*cols+c] *= scales[r]
+			}
+		}
+		return nil
+	case len(scaleShape) != 2:
+		return fmt.Errorf("scale shape %v does not fit weight shape %v", scaleShape, shape)
+	}
+
+	gridRows, gridCols := scaleShape[0], scaleShape[1]
+	if len(scales) != gridRows*gridCols {
+		return fmt.Errorf("%d scales for shape %v", len(scales), scaleShape)
+	}
+	var blockRows, blockCols int
+	if len(block) == 2 {
+		blockRows, blockCols = block[0], block[1]
+	} else {
+		blockRows, blockCols = (rows+gridRows-1)/gridRows, (cols+gridCols-1)/gridCols
+	}
+	if blockRows <= 0 || blockCols <= 0 || (rows+blockRows-1)/blockRows != gridRows || (cols+blockCols-1)/blockCols != gridCols {
+		return fmt.Errorf("scale grid %v does not tile weight shape %v in %dx%d blocks", scaleShape, shape, blockRows, blockCols)
+	}
+	for r := range rows {
+		row := scales[r/blockRows*gridCols:]
+		for c := range cols {
+			vals[r*cols+c] *= row[c/blockCols]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package quantize
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zerfoo/float16"
+)
+
+func TestDecodeFP8(t *testing.T) {
+	nan := float32(math.NaN())
+	tests := []struct {
+		name   string
+		format FP8Format
+		bytes  []byte
+		want   []float32
+	}{
+		{"e4m3fn", FP8E4M3FN, []byte{0x00, 0x80, 0x38, 0xB8, 0x01, 0x08, 0x7E, 0xFE, 0x7F, 0xFF}, []float32{0, 0, 1, -1, 1.0 / 512, 1.0 / 64, 448, -448, nan, nan}},
+		{"e4m3fnuz", FP8E4M3FNUZ, []byte{0x00, 0x80, 0x40, 0xC0, 0x01, 0x7F, 0xFF}, []float32{0, nan, 1, -1, 1.0 / 1024, 240, -240}},
+		{"e5m2", FP8E5M2, []byte{0x00, 0x3C, 0xBC, 0x01, 0x7B, 0x7C, 0xFC, 0x7D}, []float32{0, 1, -1, 1.0 / 65536, 57344, float32(math.Inf(1)), float32(math.Inf(-1)), nan}},
+		{"e5m2fnuz", FP8E5M2FNUZ, []byte{0x00, 0x80, 0x40, 0x01, 0x7F, 0xFF}, []float32{0, nan, 1, 1.0 / 131072, 57344, -57344}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeFP8(tt.format, tt.bytes)
+			if err != nil {
+				t.Fatalf("DecodeFP8: %v", err)
+			}
+			for i, want := range tt.want {
+				if math.IsNaN(float64(want)) {
+					if !math.IsNaN(float64(got[i])) {
+						t.Errorf("byte %#02x = %v, want NaN", tt.bytes[i], got[i])
+					}
+				} else if got[i] != want {
+					t.Errorf("byte %#02x = %v, want %v", tt.bytes[i], got[i], want)
+				}
+			}
+		})
+	}
+	if _, err := DecodeFP8(FP8Format(9), []byte{0}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestDecodeFP8ExactInHalfPrecision(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	for f := FP8E4M3FN; f <= FP8E5M2FNUZ; f++ {
+		vals, err := DecodeFP8(f, all)
+		if err != nil {
+			t.Fatalf("DecodeFP8: %v", err)
+		}
+		for i, v := range vals {
+			if math.IsNaN(float64(v)) {
+				continue
+			}
+			if h := float16.FromFloat32(v).ToFloat32(); h != v {
+				t.Errorf("format %d byte %#02x: %v is %v as float16", f, i, v, h)
+			}
+			if b := float16.BFloat16FromFloat32(v).ToFloat32(); b != v {
+				t.Errorf("format %d byte %#02x: %v is %v as bfloat16", f, i, v, b)
+			}
+		}
+	}
+}
+
+func TestApplyScales(t *testing.T) {
+	ones := func(n int) []float32 {
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = 1
+		}
+		return v
+	}
+	tests := []struct {
+		name       string
+		shape      []int
+		scales     []float32
+		scaleShape []int
+		block      []int
+		want       []float32
+		wantErr    bool
+	}{
+		{name: "per-tensor", shape: []int{2, 3}, scales: []float32{2}, scaleShape: nil, want: []float32{2, 2, 2, 2, 2, 2}},
+		{name: "per-row", shape: []int{2, 3}, scales: []float32{2, 3}, scaleShape: []int{2, 1}, want: []float32{2, 2, 2, 3, 3, 3}},
+		{name: "per-row 1-D", shape: []int{2, 3}, scales: []float32{2, 3}, scaleShape: []int{2}, want: []float32{2, 2, 2, 3, 3, 3}},
+		{
+			name: "blocks", shape: []int{3, 4}, scales: []float32{1, 2, 3, 4}, scaleShape: []int{2, 2}, block: []int{2, 2},
+			want: []float32{1, 1, 2, 2, 1, 1, 2, 2, 3, 3, 4, 4},
+		},
+		{
+			name: "inferred blocks", shape: []int{4, 3}, scales: []float32{1, 2, 3, 4}, scaleShape: []int{2, 2},
+			want: []float32{1, 1, 2, 1, 1, 2, 3, 3, 4, 3, 3, 4},
+		},
+		{name: "grid mismatch", shape: []int{4, 4}, scales: ones(9), scaleShape: []int{3, 3}, block: []int{2, 2}, wantErr: true},
+		{name: "3-D scales", shape: []int{2, 2}, scales: ones(2), scaleShape: []int{1, 1, 2}, wantErr: true},
+		{name: "count mismatch", shape: []int{2, 2}, scales: ones(3), scaleShape: []int{2, 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vals := ones(tt.shape[0] * tt.shape[1])
+			err := ApplyScales(vals, tt.shape, tt.scales, tt.scaleShape, tt.block)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyScales: %v", err)
+			}
+			for i := range tt.want {
+				if vals[i] != tt.want[i] {
+					t.Fatalf("value %d = %v, want %v (all %v)", i, vals[i], tt.want[i], vals)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package quantize
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/zerfoo/zmf"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// fileTypes maps each QuantType to the general.file_type value llama.cpp
+// writes for it. Single-type K-quants use the value of the uniform "_S"
+// variant.
+var fileTypes = map[QuantType]uint32{
+	F32:    0,
+	F16:    1,
+	Q4_0:   2,
+	Q4_1:   3,
+	Q8_0:   7,
+	Q5_0:   8,
+	Q5_1:   9,
+	Q2_K:   10,
+	Q3_K:   11,
+	Q3_K_S: 11,
+	Q3_K_M: 12,
+	Q4_K:   14,
+	Q4_K_S: 14,
+	Q4_K_M: 15,
+	Q5_K:   16,
+	Q5_K_S: 16,
+	Q5_K_M: 17,
+	Q6_K:   18,
+}
+
+// FileType returns the general.file_type value for a file quantized with qt.
+func FileType(qt QuantType) (uint32, bool) {
+	ft, ok := fileTypes[qt]
+	return ft, ok
+}
+
+// SetFileType sets general.file_type and general.quantization_version in
+// entries from the tensor types, as gguf.SetFileType does. When most weights
+// are of the base type of qt, the file type of qt itself is used instead, so
+// mixed-precision presets such as Q4_K_M keep their own value.
+func SetFileType(entries []gguf.MetadataEntry, tensors []gguf.Tensor, qt QuantType) []gguf.MetadataEntry {
+	entries = gguf.SetFileType(entries, tensors)
+	base := qt
+	if p, ok := presets[qt]; ok {
+		base = p.base
+	}
+	ft, ok := FileType(qt)
+	if baseFT, _ := FileType(base); !ok || baseFT != gguf.FileType(tensors) {
+		return entries
+	}
+	for i := range entries {
+		if entries[i].Key == "general.file_type" {
+			entries[i].Value = ft
+		}
+	}
+	return entries
+}
+
+// ggufTypes maps the data types produced by ModelWithOptions to GGML tensor
+// type IDs.
+var ggufTypes = map[zmf.Tensor_DataType]int{
+	zmf.Tensor_FLOAT16: sharedgguf.TypeF16,
+	zmf.Tensor_Q4_0:    sharedgguf.TypeQ4_0,
+	zmf.Tensor_Q8_0:    sharedgguf.TypeQ8_0,
+	DtypeQ4_1:          gguf.TypeQ4_1,
+	DtypeQ5_0:          gguf.TypeQ5_0,
+	DtypeQ5_1:          gguf.TypeQ5_1,
+	DtypeQ2_K:          gguf.TypeQ2_K,
+	DtypeQ3_K:          gguf.TypeQ3_K,
+	DtypeQ4_K:          gguf.TypeQ4_K,
+	DtypeQ5_K:          gguf.TypeQ5_K,
+	DtypeQ6_K:          gguf.TypeQ6_K,
+}
+
+// GGUFType returns the GGML tensor type of quantized data with ZMF data type
+// dt, once converted by GGMLData.
+func GGUFType(dt zmf.Tensor_DataType) (int, bool) {
+	typ, ok := ggufTypes[dt]
+	return typ, ok
+}
+
+// GGUF requantizes the tensors of a GGUF file in place. Float tensors and
+// tensors in any block format DequantizeTensor reads are decoded and
+// quantized as ModelWithOptions would quantize them; tensors it leaves
+// alone, and tensors of other types, keep their original data. A
+// block-quantized tensor selected by an f32 rule is the exception: it is
+// written dequantized as F32, since keeping its data would ignore the rule.
+// Q4_0 and Q8_0 tensors are read and written in the ggml block layout.
+// general.file_type and general.quantization_version are updated with
+// SetFileType.
+func GGUF(f *gguf.File, opts Options) error {
+	m := &zmf.Model{Graph: &zmf.Graph{Parameters: make(map[string]*zmf.Tensor)}}
+	// expand holds the block-quantized tensors an f32 rule selects. They are
+	// decoded to FLOAT32 like every block-quantized source, so their data
+	// type alone does not show that they are to be written differently.
+	expand := make(map[string]bool)
+	for _, t := range f.Tensors {
+		zt, ok, err := ggufToZMF(&t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if _, dup := m.Graph.Parameters[t.Name]; dup {
+			return fmt.Errorf("duplicate tensor %q", t.Name)
+		}
+		m.Graph.Parameters[t.Name] = zt
+		if rt, ok := matchRule(opts.Rules, t.Name); ok && rt == F32 && gguf.IsQuantized(t.Type) {
+			expand[t.Name] = true
+		}
+	}
+	sources := make(map[string]zmf.Tensor_DataType, len(m.Graph.Parameters))
+	for name, t := range m.Graph.Parameters {
+		sources[name] = t.Dtype
+	}
+
+	if err := ModelWithOptions(m, opts); err != nil {
+		return err
+	}
+
+	for i := range f.Tensors {
+		t := &f.Tensors[i]
+		zt, ok := m.Graph.Parameters[t.Name]
+		if !ok {
+			continue
+		}
+		if expand[t.Name] {
+			t.Type = sharedgguf.TypeF32
+			t.Data = zt.Data
+			continue
+		}
+		if zt.Dtype == sources[t.Name] {
+			continue
+		}
+		t.Type = ggufTypes[zt.Dtype]
+		t.Data = GGMLData(zt)
+	}
+
+	f.Metadata = SetFileType(f.Metadata, f.Tensors, opts.Type)
+	return nil
+}
+
+// ggufToZMF returns t as a floating-point ZMF tensor, decoding block
+// formats to float32. It reports false for tensor types it cannot decode.
+func ggufToZMF(t *gguf.Tensor) (*zmf.Tensor, bool, error) {
+	shape := make([]int64, len(t.Shape))
+	for i, d := range t.Shape {
+		shape[i] = int64(d)
+	}
+	zt := &zmf.Tensor{Shape: shape, Data: t.Data}
+	switch t.Type {
+	case sharedgguf.TypeF32:
+		zt.Dtype = zmf.Tensor_FLOAT32
+	case sharedgguf.TypeF16:
+		zt.Dtype = zmf.Tensor_FLOAT16
+	case sharedgguf.TypeBF16:
+		zt.Dtype = zmf.Tensor_BFLOAT16
+	case gguf.TypeF64:
+		zt.Dtype = zmf.Tensor_FLOAT64
+	default:
+		if _, ok := ggufDecoders[t.Type]; !ok {
+			return nil, false, nil
+		}
+		vals, err := DequantizeTensor(t)
+		if err != nil {
+			return nil, false, err
+		}
+		zt.Dtype = zmf.Tensor_FLOAT32
+		zt.Data = encodeFloat32(vals)
+	}
+	return zt, true, nil
+}
+
+// ggmlQ4_0Bytes and ggmlQ8_0Bytes are the ggml block sizes of Q4_0 and
+// Q8_0: an fp16 scale followed by 16 bytes of nibbles or 32 int8 values.
+const (
+	ggmlQ4_0Bytes = 2 + blockSize/2
+	ggmlQ8_0Bytes = 2 + blockSize
+)
+
+// dequantizeGGMLQ4_0 expands ggml Q4_0 blocks, whose byte j holds values j
+// (low nibble) and j+16 (high nibble), to n float32 values.
+func dequantizeGGMLQ4_0(data []byte, n int) []float32 {
+	nb := len(data) / ggmlQ4_0Bytes
+	out := make([]float32, nb*blockSize)
+	for bi := range nb {
+		b := data[bi*ggmlQ4_0Bytes : (bi+1)*ggmlQ4_0Bytes]
+		d := fp16Value(binary.LittleEndian.Uint16(b))
+		y := out[bi*blockSize:]
+		for j := range blockSize / 2 {
+			y[j] = float32(int(b[2+j]&0xF)-8) * d
+			y[j+blockSize/2] = float32(int(b[2+j]>>4)-8) * d
+		}
+	}
+	return out[:n]
+}
+
+// dequantizeGGMLQ8_0 expands ggml Q8_0 blocks to n float32 values.
+func dequantizeGGMLQ8_0(data []byte, n int) []float32 {
+	nb := len(data) / ggmlQ8_0Bytes
+	out := make([]float32, nb*blockSize)
+	for bi := range nb {
+		b := data[bi*ggmlQ8_0Bytes : (bi+1)*ggmlQ8_0Bytes]
+		d := fp16Value(binary.LittleEndian.Uint16(b))
+		for j := range blockSize {
+			out[bi*blockSize+j] = float32(int8(b[2+j])) * d
+		}
+	}
+	return out[:n]
+}
+
+// GGMLData returns the data of a ZMF tensor in the ggml layout. The
+// Q4_0 and Q8_0 encoders in this package pair adjacent values in each byte
+// and store a float32 Q8_0 scale; ggml pairs values j and j+16 and uses an
+// fp16 scale. The other formats already match ggml.
+func GGMLData(t *zmf.Tensor) []byte {
+	switch t.Dtype {
+	case zmf.Tensor_Q4_0:
+		nb := len(t.Data) / 18
+		out := make([]byte, nb*ggmlQ4_0Bytes)
+		for bi := range nb {
+			src := t.Data[bi*18 : (bi+1)*18]
+			dst := out[bi*ggmlQ4_0Bytes : (bi+1)*ggmlQ4_0Bytes]
+			copy(dst, src[:2])
+			nibble := func(k int) byte { return src[2+k/2] >> (4 * (k % 2)) & 0xF }
+			for j := range blockSize / 2 {
+				dst[2+j] = nibble(j) | nibble(j+blockSize/2)<<4
+			}
+		}
+		return out
+	case zmf.Tensor_Q8_0:
+		nb := len(t.Data) / 36
+		out := make([]byte, nb*ggmlQ8_0Bytes)
+		for bi := range nb {
+			src := t.Data[bi*36 : (bi+1)*36]
+			dst := out[bi*ggmlQ8_0Bytes : (bi+1)*ggmlQ8_0Bytes]
+			d := decodeFloat32(src[:4])[0]
+			binary.LittleEndian.PutUint16(dst, fp16Bits(d))
+			copy(dst[2:], src[4:])
+		}
+		return out
+	}
+	return t.Data
+}
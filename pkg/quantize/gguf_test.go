@@ -0,0 +1,222 @@
+package quantize
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+	sharedgguf "github.com/zerfoo/ztensor/gguf"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+func TestGGMLBlocks(t *testing.T) {
+	vals := randomWeights(4*blockSize, 21)
+
+	q4 := &zmf.Tensor{Dtype: zmf.Tensor_Q4_0, Data: encodeQ4Blocks(vals)}
+	want := dequantizeQ4Blocks(q4.Data, len(vals))
+	got := dequantizeGGMLQ4_0(GGMLData(q4), len(vals))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Q4_0 value %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	q8 := &zmf.Tensor{Dtype: zmf.Tensor_Q8_0, Data: encodeQ8Blocks(vals)}
+	data := GGMLData(q8)
+	if len(data) != 4*ggmlQ8_0Bytes {
+		t.Fatalf("Q8_0 got %d bytes, want %d", len(data), 4*ggmlQ8_0Bytes)
+	}
+	if e := relativeRMSE(vals, dequantizeGGMLQ8_0(data, len(vals))); e > 0.01 {
+		t.Errorf("Q8_0 relative RMSE %.4f too high", e)
+	}
+}
+
+func TestGGUF(t *testing.T) {
+	const rowLen = superBlockSize
+	weights := randomWeights(8*rowLen, 22)
+	q8 := GGMLData(&zmf.Tensor{Dtype: zmf.Tensor_Q8_0, Data: encodeQ8Blocks(weights)})
+	embd := bytes.Repeat([]byte{1}, 8*rowLen/blockSize*ggmlQ8_0Bytes)
+	tokens := gguf.Array{Type: gguf.MetaTypeString, Values: []any{"a", "b"}}
+
+	f := &gguf.File{
+		Metadata: []gguf.MetadataEntry{
+			{Key: "general.architecture", Type: gguf.MetaTypeString, Value: "llama"},
+			{Key: "general.file_type", Type: gguf.MetaTypeUint32, Value: uint32(1)},
+			{Key: "tokenizer.ggml.tokens", Type: gguf.MetaTypeArray, Value: tokens},
+		},
+		Tensors: []gguf.Tensor{
+			{Name: "token_embd.weight", Type: sharedgguf.TypeQ8_0, Shape: []int{8, rowLen}, Data: embd},
+			{Name: "blk.0.attn_q.weight", Type: sharedgguf.TypeF16, Shape: []int{8, rowLen}, Data: encodeF16(weights)},
+			{Name: "blk.0.ffn_down.weight", Type: sharedgguf.TypeQ8_0, Shape: []int{8, rowLen}, Data: q8},
+			{Name: "blk.0.attn_norm.weight", Type: sharedgguf.TypeF32, Shape: []int{rowLen}, Data: encodeFloat32(weights[:rowLen])},
+			{Name: "rope_ids", Type: gguf.TypeI32, Shape: []int{4}, Data: make([]byte, 16)},
+		},
+	}
+	report := &Report{}
+	if err := GGUF(f, Options{Type: Q4_K, Report: report}); err != nil {
+		t.Fatalf("GGUF: %v", err)
+	}
+
+	wantTypes := map[string]int{
+		"token_embd.weight":      sharedgguf.TypeQ8_0,
+		"blk.0.attn_q.weight":    gguf.TypeQ4_K,
+		"blk.0.ffn_down.weight":  gguf.TypeQ4_K,
+		"blk.0.attn_norm.weight": sharedgguf.TypeF32,
+		"rope_ids":               gguf.TypeI32,
+	}
+	for _, tensor := range f.Tensors {
+		if tensor.Type != wantTypes[tensor.Name] {
+			t.Errorf("%s type = %d, want %d", tensor.Name, tensor.Type, wantTypes[tensor.Name])
+		}
+	}
+	if !bytes.Equal(f.Tensors[0].Data, embd) {
+		t.Error("skipped Q8_0 tensor was not copied unchanged")
+	}
+	got := dequantizeQ4KBlocks(f.Tensors[2].Data, len(weights))
+	if e := relativeRMSE(weights, got); e > 0.15 {
+		t.Errorf("requantized ffn_down relative RMSE %.4f too high", e)
+	}
+	if len(report.Tensors) != 2 {
+		t.Errorf("report has %d tensors, want 2", len(report.Tensors))
+	}
+
+	if e, _ := f.Lookup("general.file_type"); e.Value != uint32(14) {
+		t.Errorf("general.file_type = %v, want 14", e.Value)
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	back, err := gguf.Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if e, ok := back.Lookup("tokenizer.ggml.tokens"); !ok || len(e.Value.(gguf.Array).Values) != 2 {
+		t.Errorf("tokenizer.ggml.tokens = %+v", e)
+	}
+}
+
+func TestGGUF_Q8_0Output(t *testing.T) {
+	weights := randomWeights(4*superBlockSize, 23)
+	f := &gguf.File{Tensors: []gguf.Tensor{
+		{Name: "blk.0.attn_k.weight", Type: sharedgguf.TypeF32, Shape: []int{4, superBlockSize}, Data: encodeFloat32(weights)},
+	}}
+	if err := GGUF(f, Options{Type: Q8_0}); err != nil {
+		t.Fatalf("GGUF: %v", err)
+	}
+	tensor := f.Tensors[0]
+	if tensor.Type != sharedgguf.TypeQ8_0 || len(tensor.Data) != len(weights)/blockSize*ggmlQ8_0Bytes {
+		t.Fatalf("got type %d with %d bytes", tensor.Type, len(tensor.Data))
+	}
+	if e, _ := f.Lookup("general.file_type"); e.Value != uint32(7) {
+		t.Errorf("general.file_type = %v, want 7", e.Value)
+	}
+	if e, _ := f.Lookup("general.quantization_version"); e.Value != gguf.QuantizationVersion {
+		t.Errorf("general.quantization_version = %v", e.Value)
+	}
+	if err := f.Write(&bytes.Buffer{}); err != nil {
+		t.Errorf("Write: %v", err)
+	}
+}
+
+func TestGGUF_F32RuleExpandsBlocks(t *testing.T) {
+	weights := randomWeights(4*superBlockSize, 25)
+	q8 := GGMLData(&zmf.Tensor{Dtype: zmf.Tensor_Q8_0, Data: encodeQ8Blocks(weights)})
+	f16 := encodeF16(weights)
+	f := &gguf.File{Tensors: []gguf.Tensor{
+		{Name: "blk.0.attn_k.weight", Type: sharedgguf.TypeQ8_0, Shape: []int{4, superBlockSize}, Data: q8},
+		{Name: "blk.0.attn_v.weight", Type: sharedgguf.TypeF16, Shape: []int{4, superBlockSize}, Data: f16},
+		{Name: "token_embd.weight", Type: sharedgguf.TypeQ8_0, Shape: []int{4, superBlockSize}, Data: q8},
+	}}
+	rule, err := ParseRule(`attn_[kv]=f32`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := GGUF(f, Options{Type: Q4_K, Rules: []Rule{rule}}); err != nil {
+		t.Fatalf("GGUF: %v", err)
+	}
+
+	k := f.Tensors[0]
+	if k.Type != sharedgguf.TypeF32 {
+		t.Fatalf("attn_k type = %d, want F32", k.Type)
+	}
+	if want := encodeFloat32(dequantizeGGMLQ8_0(q8, len(weights))); !bytes.Equal(k.Data, want) {
+		t.Error("attn_k does not hold the dequantized Q8_0 values")
+	}
+	// An f32 rule keeps float sources as they are, and the skipped
+	// embedding keeps its quantized data.
+	if v := f.Tensors[1]; v.Type != sharedgguf.TypeF16 || !bytes.Equal(v.Data, f16) {
+		t.Errorf("attn_v type = %d, want the F16 tensor unchanged", v.Type)
+	}
+	if e := f.Tensors[2]; e.Type != sharedgguf.TypeQ8_0 || !bytes.Equal(e.Data, q8) {
+		t.Errorf("token_embd type = %d, want the Q8_0 tensor unchanged", e.Type)
+	}
+}
+
+// TestGGUF_RaggedRows checks that tensors whose rows are not whole blocks
+// are left as they are, which ggml requires for every block type.
+func TestGGUF_RaggedRows(t *testing.T) {
+	for _, qt := range []QuantType{Q4_0, Q8_0, Q4_1, Q5_0, Q5_1, Q4_K} {
+		t.Run(string(qt), func(t *testing.T) {
+			data := encodeFloat32(randomWeights(64*48, 24))
+			f := &gguf.File{Tensors: []gguf.Tensor{
+				{Name: "blk.0.attn_v.weight", Type: sharedgguf.TypeF32, Shape: []int{64, 48}, Data: data},
+			}}
+			if err := GGUF(f, Options{Type: qt}); err != nil {
+				t.Fatalf("GGUF: %v", err)
+			}
+			if tensor := f.Tensors[0]; tensor.Type != sharedgguf.TypeF32 || !bytes.Equal(tensor.Data, data) {
+				t.Errorf("got type %d with %d bytes, want the F32 tensor unchanged", tensor.Type, len(tensor.Data))
+			}
+		})
+	}
+}
+
+func TestFileType(t *testing.T) {
+	for qt := range formats {
+		if _, ok := FileType(qt); !ok {
+			t.Errorf("no file type for %s", qt)
+		}
+	}
+	for qt := range presets {
+		if _, ok := FileType(qt); !ok {
+			t.Errorf("no file type for preset %s", qt)
+		}
+	}
+	if ft, _ := FileType(Q4_K_M); ft != 15 {
+		t.Errorf("Q4_K_M file type = %d, want 15", ft)
+	}
+}
+
+func TestSetFileType(t *testing.T) {
+	tensors := []gguf.Tensor{
+		{Name: "blk.0.ffn_down.weight", Type: gguf.TypeQ4_K, Shape: []int{8, superBlockSize}},
+		{Name: "output.weight", Type: gguf.TypeQ6_K, Shape: []int{2, superBlockSize}},
+		{Name: "output_norm.weight", Type: sharedgguf.TypeF32, Shape: []int{superBlockSize}},
+	}
+	tests := []struct {
+		qt   QuantType
+		want uint32
+	}{
+		{"", 14},
+		{Q4_K, 14},
+		{Q4_K_M, 15},
+		{Q4_K_S, 14},
+		{Q5_K_M, 14}, // Q5_K is not what most weights are
+		{Q8_0, 14},
+	}
+	for _, tt := range tests {
+		entries := SetFileType(nil, tensors, tt.qt)
+		got := map[string]any{}
+		for _, e := range entries {
+			got[e.Key] = e.Value
+		}
+		if got["general.file_type"] != tt.want {
+			t.Errorf("%q: general.file_type = %v, want %d", tt.qt, got["general.file_type"], tt.want)
+		}
+		if got["general.quantization_version"] != gguf.QuantizationVersion {
+			t.Errorf("%q: general.quantization_version = %v", tt.qt, got["general.quantization_version"])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package quantize
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// Imatrix holds per-column importance values, typically mean squared
+// activations collected on calibration data, keyed by GGUF tensor name.
+// Each entry has one value per input column of the tensor, or one per
+// column per expert for stacked MoE tensors.
+type Imatrix map[string][]float32
+
+// maxImatrixName bounds entry name lengths to reject corrupt files early.
+const maxImatrixName = 1 << 16
+
+// LoadImatrix reads an importance matrix file written by llama.cpp's
+// llama-imatrix tool.
+func LoadImatrix(path string) (Imatrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open imatrix: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	im, err := ReadImatrix(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return im, nil
+}
+
+// ReadImatrix decodes the llama.cpp imatrix .dat format: an int32 entry
+// count followed by, per entry, the name length and name, the number of
+// calls and values, and the accumulated float32 values. Values are divided
+// by the call count to yield means. Trailing dataset information is ignored.
+func ReadImatrix(r io.Reader) (Imatrix, error) {
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("read imatrix entry count: %w", err)
+	}
+	if count <= 0 {
+		return nil, fmt.Errorf("invalid imatrix entry count %d", count)
+	}
+
+	im := make(Imatrix, count)
+	for i := range int(count) {
+		var nameLen int32
+		if err := binary.Read(r, binary.LittleEndian, &nameLen); err != nil {
+			return nil, fmt.Errorf("read imatrix entry %d: %w", i, err)
+		}
+		if nameLen <= 0 || nameLen > maxImatrixName {
+			return nil, fmt.Errorf("imatrix entry %d: invalid name length %d", i, nameLen)
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, fmt.Errorf("read imatrix entry %d name: %w", i, err)
+		}
+
+		var hdr struct{ NCall, NVal int32 }
+		if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+			return nil, fmt.Errorf("read imatrix entry %q: %w", name, err)
+		}
+		if hdr.NVal <= 0 {
+			return nil, fmt.Errorf("imatrix entry %q: invalid value count %d", name, hdr.NVal)
+		}
+		vals := make([]float32, hdr.NVal)
+		if err := binary.Read(r, binary.LittleEndian, vals); err != nil {
+			return nil, fmt.Errorf("read imatrix entry %q values: %w", name, err)
+		}
+		if hdr.NCall > 0 {
+			for j := range vals {
+				vals[j] /= float32(hdr.NCall)
+			}
+		}
+		im[string(name)] = vals
+	}
+	return im, nil
+}
+
+// lookup returns the importance values for a source tensor name, trying its
+// GGUF name first.
+func (im Imatrix) lookup(name string) ([]float32, bool) {
+	if v, ok := im[gguf.MapTensorName(name)]; ok {
+		return v, true
+	}
+	v, ok := im[name]
+	return v, ok
+}
+
+// encodeWithImportance encodes x row by row with f.encodeWeighted. rowLen is
+// the length of one row. imp holds one value per column, or one per column
+// per expert, in which case consecutive row groups use consecutive slices.
+func encodeWithImportance(f format, x []float32, rowLen int, imp []float32) ([]byte, error) {
+	if len(imp)%rowLen != 0 {
+		return nil, fmt.Errorf("imatrix has %d values, want a multiple of row length %d", len(imp), rowLen)
+	}
+	rows := len(x) / rowLen
+	groups := len(imp) / rowLen
+	if rows%groups != 0 {
+		return nil, fmt.Errorf("imatrix has %d groups of %d values, which do not divide %d rows", groups, rowLen, rows)
+	}
+	rowsPerGroup := rows / groups
+	for i, v := range imp {
+		if v < 0 || math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, fmt.Errorf("imatrix value %d is invalid: %v", i, v)
+		}
+	}
+
+	var out []byte
+	for r := range rows {
+		g := r / rowsPerGroup
+		out = append(out, f.encodeWeighted(x[r*rowLen:(r+1)*rowLen], imp[g*rowLen:(g+1)*rowLen])...)
+	}
+	return out, nil
+}
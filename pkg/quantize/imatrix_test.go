@@ -0,0 +1,195 @@
+package quantize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+)
+
+type imatrixEntry struct {
+	name  string
+	ncall int32
+	vals  []float32
+}
+
+// encodeImatrix writes entries in llama.cpp's imatrix .dat layout.
+func encodeImatrix(entries []imatrixEntry) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, int32(len(entries)))
+	for _, e := range entries {
+		_ = binary.Write(&buf, binary.LittleEndian, int32(len(e.name)))
+		buf.WriteString(e.name)
+		_ = binary.Write(&buf, binary.LittleEndian, e.ncall)
+		_ = binary.Write(&buf, binary.LittleEndian, int32(len(e.vals)))
+		_ = binary.Write(&buf, binary.LittleEndian, e.vals)
+	}
+	// Trailing last-call count and dataset name, as written by llama-imatrix.
+	_ = binary.Write(&buf, binary.LittleEndian, int32(10))
+	_ = binary.Write(&buf, binary.LittleEndian, int32(4))
+	buf.WriteString("wiki")
+	return buf.Bytes()
+}
+
+// skewedImportance returns column importances where one column in eight
+// dominates, as with outlier activation channels.
+func skewedImportance(n int, seed int64) []float32 {
+	rng := rand.New(rand.NewSource(seed))
+	imp := make([]float32, n)
+	for i := range imp {
+		imp[i] = 0.5 + rng.Float32()
+		if i%8 == 3 {
+			imp[i] *= 200
+		}
+	}
+	return imp
+}
+
+// weightedSquaredError returns the importance-weighted squared error of got
+// over rows of len(imp) values.
+func weightedSquaredError(want, got, imp []float32) float64 {
+	var sum float64
+	for i := range want {
+		d := float64(want[i] - got[i])
+		sum += float64(imp[i%len(imp)]) * d * d
+	}
+	return sum
+}
+
+func TestReadImatrix(t *testing.T) {
+	data := encodeImatrix([]imatrixEntry{
+		{"blk.0.attn_q.weight", 4, []float32{4, 8, 12}},
+		{"output.weight", 0, []float32{1, 2}},
+	})
+	im, err := ReadImatrix(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadImatrix: %v", err)
+	}
+	if len(im) != 2 {
+		t.Fatalf("got %d entries, want 2", len(im))
+	}
+	want := []float32{1, 2, 3}
+	for i, v := range im["blk.0.attn_q.weight"] {
+		if v != want[i] {
+			t.Errorf("attn_q[%d] = %v, want %v", i, v, want[i])
+		}
+	}
+	if got := im["output.weight"]; len(got) != 2 || got[1] != 2 {
+		t.Errorf("output = %v, want [1 2]", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "imatrix.dat")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadImatrix(path); err != nil {
+		t.Errorf("LoadImatrix: %v", err)
+	}
+}
+
+func TestReadImatrix_Invalid(t *testing.T) {
+	valid := encodeImatrix([]imatrixEntry{{"a", 1, []float32{1, 2, 3, 4}}})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"zero entries", []byte{0, 0, 0, 0}},
+		{"truncated name", valid[:6]},
+		{"truncated values", valid[:20]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadImatrix(bytes.NewReader(tt.data)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestWeightedEncodersReduceWeightedError(t *testing.T) {
+	tests := []struct {
+		name     string
+		encode   func([]float32) []byte
+		weighted func(src, imp []float32) []byte
+		decode   func([]byte, int) []float32
+	}{
+		{"Q4_0", encodeQ4Blocks, encodeQ4BlocksWeighted, dequantizeQ4Blocks},
+		{"Q2_K", encodeQ2KBlocks, encodeQ2KBlocksWeighted, dequantizeQ2KBlocks},
+		{"Q3_K", encodeQ3KBlocks, encodeQ3KBlocksWeighted, dequantizeQ3KBlocks},
+		{"Q4_K", encodeQ4KBlocks, encodeQ4KBlocksWeighted, dequantizeQ4KBlocks},
+		{"Q5_K", encodeQ5KBlocks, encodeQ5KBlocksWeighted, dequantizeQ5KBlocks},
+		{"Q6_K", encodeQ6KBlocks, encodeQ6KBlocksWeighted, dequantizeQ6KBlocks},
+	}
+
+	const rowLen, rows = 2 * superBlockSize, 16
+	vals := randomWeights(rowLen*rows, 7)
+	imp := skewedImportance(rowLen, 8)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := tt.decode(tt.encode(vals), len(vals))
+			var weighted []float32
+			for r := range rows {
+				row := vals[r*rowLen : (r+1)*rowLen]
+				weighted = append(weighted, tt.decode(tt.weighted(row, imp), rowLen)...)
+			}
+
+			plainErr := weightedSquaredError(vals, plain, imp)
+			weightedErr := weightedSquaredError(vals, weighted, imp)
+			if weightedErr >= plainErr {
+				t.Errorf("weighted error %.4g not below unweighted %.4g", weightedErr, plainErr)
+			}
+		})
+	}
+}
+
+func TestModelWithOptions_Imatrix(t *testing.T) {
+	const rowLen = superBlockSize
+	vals := randomWeights(8*rowLen, 9)
+	newModel := func() *zmf.Model {
+		return &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{
+			"model.layers.0.mlp.down_proj.weight": {
+				Dtype: zmf.Tensor_FLOAT32,
+				Shape: []int64{8, rowLen},
+				Data:  makeFloat32Bytes(vals),
+			},
+		}}}
+	}
+	const name = "model.layers.0.mlp.down_proj.weight"
+
+	plain := newModel()
+	if err := Model(plain, Q4_K); err != nil {
+		t.Fatal(err)
+	}
+
+	weighted := newModel()
+	im := Imatrix{"blk.0.ffn_down.weight": skewedImportance(rowLen, 10)}
+	if err := ModelWithOptions(weighted, Options{Type: Q4_K, Imatrix: im}); err != nil {
+		t.Fatalf("ModelWithOptions: %v", err)
+	}
+	w := weighted.Graph.Parameters[name]
+	if w.Dtype != DtypeQ4_K || len(w.Data) != 8*q4KBlockBytes {
+		t.Fatalf("got dtype %v, %d bytes", w.Dtype, len(w.Data))
+	}
+	if bytes.Equal(w.Data, plain.Graph.Parameters[name].Data) {
+		t.Error("imatrix had no effect on the encoded tensor")
+	}
+
+	// Two experts' worth of importance splits the rows into two groups.
+	stacked := newModel()
+	im = Imatrix{"blk.0.ffn_down.weight": skewedImportance(2*rowLen, 11)}
+	if err := ModelWithOptions(stacked, Options{Type: Q4_K, Imatrix: im}); err != nil {
+		t.Errorf("per-expert imatrix: %v", err)
+	}
+
+	bad := newModel()
+	im = Imatrix{"blk.0.ffn_down.weight": make([]float32, rowLen-1)}
+	if err := ModelWithOptions(bad, Options{Type: Q4_K, Imatrix: im}); err == nil {
+		t.Error("expected error for mismatched imatrix length")
+	}
+}
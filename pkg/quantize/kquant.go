@@ -0,0 +1,993 @@
+package quantize
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/zerfoo/float16"
+)
+
+// superBlockSize is the number of values in one K-quant super-block (QK_K).
+const superBlockSize = 256
+
+// kScaleSize is the size of the packed 6-bit scale/min table used by Q3_K,
+// Q4_K and Q5_K super-blocks.
+const kScaleSize = 12
+
+// Byte sizes of one super-block for each K-quant format. The layouts match
+// ggml's block_q*_K structs so the output loads without repacking.
+const (
+	q2KBlockBytes = superBlockSize/16 + superBlockSize/4 + 2 + 2                // scales, qs, d, dmin
+	q3KBlockBytes = superBlockSize/8 + superBlockSize/4 + kScaleSize + 2        // hmask, qs, scales, d
+	q4KBlockBytes = 2 + 2 + kScaleSize + superBlockSize/2                       // d, dmin, scales, qs
+	q5KBlockBytes = 2 + 2 + kScaleSize + superBlockSize/8 + superBlockSize/2    // d, dmin, scales, qh, qs
+	q6KBlockBytes = superBlockSize/2 + superBlockSize/4 + superBlockSize/16 + 2 // ql, qh, scales, d
+)
+
+// groupMaxEps is the magnitude below which a group is treated as all zeros.
+const groupMaxEps = 1e-15
+
+// nearestInt rounds to the nearest integer, ties to even, like ggml's nearest_int.
+func nearestInt(f float32) int {
+	return int(math.RoundToEven(float64(f)))
+}
+
+func fp16Bits(f float32) uint16 {
+	return float32ToFloat16Bits(f)
+}
+
+func fp16Value(b uint16) float32 {
+	return float16.Float16(b).ToFloat32()
+}
+
+func abs32(f float32) float32 {
+	return float32(math.Abs(float64(f)))
+}
+
+// blockImportance returns the importance values of super-block bi, or nil
+// when imp is nil.
+func blockImportance(imp []float32, bi int) []float32 {
+	if imp == nil {
+		return nil
+	}
+	return imp[bi*superBlockSize : (bi+1)*superBlockSize]
+}
+
+// superBlocks splits src into zero-padded super-blocks of superBlockSize values.
+func superBlocks(src []float32) [][]float32 {
+	nBlocks := (len(src) + superBlockSize - 1) / superBlockSize
+	out := make([][]float32, nBlocks)
+	for bi := range nBlocks {
+		start := bi * superBlockSize
+		end := start + superBlockSize
+		if end <= len(src) {
+			out[bi] = src[start:end]
+			continue
+		}
+		x := make([]float32, superBlockSize)
+		copy(x, src[start:])
+		out[bi] = x
+	}
+	return out
+}
+
+// makeQKX2Quants finds an asymmetric scale and min for x minimizing the
+// weighted error, returning the scale and the (negated) min. L receives the
+// chosen levels in [0, nmax]. Port of ggml's make_qkx2_quants.
+func makeQKX2Quants(nmax int, x, weights []float32, L, laux []uint8, rmin, rdelta float32, nstep int, useMAD bool) (scale, theMin float32) {
+	n := len(x)
+	lo, hi := x[0], x[0]
+	sumW := weights[0]
+	sumX := sumW * x[0]
+	for i := 1; i < n; i++ {
+		if x[i] < lo {
+			lo = x[i]
+		}
+		if x[i] > hi {
+			hi = x[i]
+		}
+		w := weights[i]
+		sumW += w
+		sumX += w * x[i]
+	}
+	if lo > 0 {
+		lo = 0
+	}
+	if hi == lo {
+		for i := range n {
+			L[i] = 0
+		}
+		return 0, -lo
+	}
+
+	errOf := func(diff float32) float32 {
+		if useMAD {
+			return abs32(diff)
+		}
+		return diff * diff
+	}
+
+	iscale := float32(nmax) / (hi - lo)
+	scale = 1 / iscale
+	var bestError float32
+	for i := range n {
+		l := clampInt(nearestInt(iscale*(x[i]-lo)), 0, nmax)
+		L[i] = uint8(l)
+		bestError += weights[i] * errOf(scale*float32(l)+lo-x[i])
+	}
+	if nstep < 1 {
+		return scale, -lo
+	}
+
+	for is := 0; is <= nstep; is++ {
+		iscale = (rmin + rdelta*float32(is) + float32(nmax)) / (hi - lo)
+		var sumL, sumL2, sumXL float32
+		for i := range n {
+			l := clampInt(nearestInt(iscale*(x[i]-lo)), 0, nmax)
+			laux[i] = uint8(l)
+			w := weights[i]
+			fl := float32(l)
+			sumL += w * fl
+			sumL2 += w * fl * fl
+			sumXL += w * fl * x[i]
+		}
+		D := sumW*sumL2 - sumL*sumL
+		if D <= 0 {
+			continue
+		}
+		thisScale := (sumW*sumXL - sumX*sumL) / D
+		thisMin := (sumL2*sumX - sumL*sumXL) / D
+		if thisMin > 0 {
+			thisMin = 0
+			thisScale = sumXL / sumL2
+		}
+		var curError float32
+		for i := range n {
+			curError += weights[i] * errOf(thisScale*float32(laux[i])+thisMin-x[i])
+		}
+		if curError < bestError {
+			copy(L[:n], laux[:n])
+			bestError = curError
+			scale = thisScale
+			lo = thisMin
+		}
+	}
+	return scale, -lo
+}
+
+// makeQ3Quants picks a symmetric scale for x with levels in [-nmax, nmax-1],
+// refined by coordinate descent on the x²-weighted error. L receives the
+// levels offset by nmax. Port of ggml's make_q3_quants with do_rmse set.
+func makeQ3Quants(nmax int, x []float32, L []int8) float32 {
+	var maxV, amax float32
+	for _, v := range x {
+		if av := abs32(v); av > amax {
+			amax = av
+			maxV = v
+		}
+	}
+	if amax < groupMaxEps {
+		for i := range x {
+			L[i] = 0
+		}
+		return 0
+	}
+	iscale := -float32(nmax) / maxV
+	var sumLX, sumL2 float32
+	for i, v := range x {
+		l := clampInt(nearestInt(iscale*v), -nmax, nmax-1)
+		L[i] = int8(l)
+		w := v * v
+		sumLX += w * v * float32(l)
+		sumL2 += w * float32(l*l)
+	}
+	for range 5 {
+		changed := 0
+		for i, v := range x {
+			w := v * v
+			li := float32(L[i])
+			slx := sumLX - w*v*li
+			if slx <= 0 {
+				continue
+			}
+			sl2 := sumL2 - w*li*li
+			newL := clampInt(nearestInt(v*sl2/slx), -nmax, nmax-1)
+			if newL == int(L[i]) {
+				continue
+			}
+			slx += w * v * float32(newL)
+			sl2 += w * float32(newL*newL)
+			if sl2 > 0 && slx*slx*sumL2 > sumLX*sumLX*sl2 {
+				L[i] = int8(newL)
+				sumLX = slx
+				sumL2 = sl2
+				changed++
+			}
+		}
+		if changed == 0 {
+			break
+		}
+	}
+	for i := range x {
+		L[i] += int8(nmax)
+	}
+	return sumLX / sumL2
+}
+
+// makeQXQuants picks a symmetric scale for x with levels in [-nmax, nmax-1]
+// by searching around nmax/max with weighted error. L receives the levels
+// offset by nmax. Weights default to x² when qw is nil. Port of ggml's
+// make_qx_quants with rmse_type 1.
+func makeQXQuants(nmax int, x []float32, L []int8, qw []float32) float32 {
+	weight := func(i int) float32 {
+		if qw != nil {
+			return qw[i]
+		}
+		return x[i] * x[i]
+	}
+	var maxV, amax float32
+	for _, v := range x {
+		if av := abs32(v); av > amax {
+			amax = av
+			maxV = v
+		}
+	}
+	if amax < groupMaxEps {
+		for i := range x {
+			L[i] = 0
+		}
+		return 0
+	}
+	iscale := -float32(nmax) / maxV
+	var sumLX, sumL2 float32
+	for i, v := range x {
+		l := clampInt(nearestInt(iscale*v), -nmax, nmax-1)
+		L[i] = int8(l + nmax)
+		w := weight(i)
+		sumLX += w * v * float32(l)
+		sumL2 += w * float32(l*l)
+	}
+	var scale float32
+	if sumL2 != 0 {
+		scale = sumLX / sumL2
+	}
+	best := scale * sumLX
+	for is := -9; is <= 9; is++ {
+		if is == 0 {
+			continue
+		}
+		iscale = -(float32(nmax) + 0.1*float32(is)) / maxV
+		sumLX, sumL2 = 0, 0
+		for i, v := range x {
+			l := clampInt(nearestInt(iscale*v), -nmax, nmax-1)
+			w := weight(i)
+			sumLX += w * v * float32(l)
+			sumL2 += w * float32(l*l)
+		}
+		if sumL2 > 0 && sumLX*sumLX > best*sumL2 {
+			for i, v := range x {
+				L[i] = int8(nmax + clampInt(nearestInt(iscale*v), -nmax, nmax-1))
+			}
+			scale = sumLX / sumL2
+			best = scale * sumLX
+		}
+	}
+	return scale
+}
+
+// makeQPQuants quantizes the non-negative values x to levels in [0, nmax]
+// minimizing the weighted error, and returns the scale. It is used to pack
+// sub-block scales and mins when an importance matrix is in play. Port of
+// ggml's make_qp_quants.
+func makeQPQuants(nmax int, x []float32, L []uint8, w []float32) float32 {
+	var maxV float32
+	for _, v := range x {
+		maxV = max(maxV, v)
+	}
+	if maxV == 0 {
+		for i := range x {
+			L[i] = 0
+		}
+		return 0
+	}
+	level := func(iscale, v float32) int {
+		return clampInt(nearestInt(iscale*v), 0, nmax)
+	}
+
+	iscale := float32(nmax) / maxV
+	var bestMSE float32
+	for i, v := range x {
+		diff := v - float32(level(iscale, v))/iscale
+		bestMSE += w[i] * diff * diff
+	}
+	for is := -4; is <= 4; is++ {
+		if is == 0 {
+			continue
+		}
+		iscaleIs := (0.1*float32(is) + float32(nmax)) / maxV
+		var mse float32
+		for i, v := range x {
+			diff := v - float32(level(iscaleIs, v))/iscaleIs
+			mse += w[i] * diff * diff
+		}
+		if mse < bestMSE {
+			bestMSE = mse
+			iscale = iscaleIs
+		}
+	}
+
+	var sumLX, sumL2 float32
+	for i, v := range x {
+		l := level(iscale, v)
+		L[i] = uint8(l)
+		sumLX += w[i] * v * float32(l)
+		sumL2 += w[i] * float32(l*l)
+	}
+	for range 5 {
+		changed := 0
+		for i, v := range x {
+			li := float32(L[i])
+			slx := sumLX - w[i]*v*li
+			sl2 := sumL2 - w[i]*li*li
+			if slx <= 0 || sl2 <= 0 {
+				continue
+			}
+			newL := clampInt(nearestInt(v*sl2/slx), 0, nmax)
+			if newL == int(L[i]) {
+				continue
+			}
+			slx += w[i] * v * float32(newL)
+			sl2 += w[i] * float32(newL*newL)
+			if slx*slx*sumL2 > sumLX*sumLX*sl2 {
+				L[i] = uint8(newL)
+				sumLX = slx
+				sumL2 = sl2
+				changed++
+			}
+		}
+		if changed == 0 {
+			break
+		}
+	}
+	if sumL2 == 0 {
+		return 0
+	}
+	return sumLX / sumL2
+}
+
+// sumSquares returns the sum of x².
+func sumSquares(x []float32) float32 {
+	var s float32
+	for _, v := range x {
+		s += v * v
+	}
+	return s
+}
+
+// importanceWeights fills w with the per-value weights ggml derives from an
+// importance matrix: imp[i] * sqrt(sigma2 + x[i]²).
+func importanceWeights(w, x, imp []float32, sigma2 float32) {
+	for i, v := range x {
+		w[i] = imp[i] * float32(math.Sqrt(float64(sigma2+v*v)))
+	}
+}
+
+// scaleMinK4 unpacks the 6-bit scale and min of sub-block j from the packed
+// 12-byte table shared by Q4_K and Q5_K.
+func scaleMinK4(j int, q []byte) (sc, m uint8) {
+	if j < 4 {
+		return q[j] & 63, q[j+4] & 63
+	}
+	sc = (q[j+4] & 0xF) | ((q[j-4] >> 6) << 4)
+	m = (q[j+4] >> 4) | ((q[j] >> 6) << 4)
+	return sc, m
+}
+
+// packScaleMinK4 writes the 6-bit scale and min of sub-block j into the
+// packed 12-byte table. The table must start zeroed.
+func packScaleMinK4(j int, ls, lm uint8, q []byte) {
+	if j < 4 {
+		q[j] = ls
+		q[j+4] = lm
+		return
+	}
+	q[j+4] = (ls & 0xF) | ((lm & 0xF) << 4)
+	q[j-4] |= (ls >> 4) << 6
+	q[j] |= (lm >> 4) << 6
+}
+
+// q3KScale unpacks the signed 6-bit scale of sub-block j from a Q3_K scale table.
+func q3KScale(j int, scales []byte) int {
+	var lo byte
+	if j < 8 {
+		lo = scales[j] & 0xF
+	} else {
+		lo = scales[j-8] >> 4
+	}
+	hi := (scales[8+j%4] >> (2 * (j / 4))) & 3
+	return int(lo|hi<<4) - 32
+}
+
+// encodeQ2KBlocks converts float32 values to Q2_K super-block bytes.
+// Q2_K: 16 4-bit scale/min pairs + 64 bytes of 2-bit quants + float16 d and
+// dmin = 84 bytes per 256 values.
+func encodeQ2KBlocks(src []float32) []byte {
+	return encodeQ2KBlocksWeighted(src, nil)
+}
+
+// encodeQ2KBlocksWeighted is encodeQ2KBlocks with scales and mins chosen to
+// minimize the error weighted by imp, one importance value per element of
+// src. A nil imp selects the unweighted search.
+func encodeQ2KBlocksWeighted(src, imp []float32) []byte {
+	blocks := superBlocks(src)
+	out := make([]byte, len(blocks)*q2KBlockBytes)
+
+	var (
+		L       [superBlockSize]uint8
+		laux    [16]uint8
+		weights [16]float32
+		sw      [superBlockSize / 16]float32
+		Ls, Lm  [superBlockSize / 16]uint8
+		mins    [superBlockSize / 16]float32
+		scales  [superBlockSize / 16]float32
+	)
+	const q4Scale = 15
+
+	for bi, x := range blocks {
+		b := out[bi*q2KBlockBytes : (bi+1)*q2KBlockBytes]
+		sc := b[:16]
+		qs := b[16:80]
+
+		var d, dmin uint16
+		if imp != nil {
+			qw := blockImportance(imp, bi)
+			sigma2 := sumSquares(x) / superBlockSize
+			for j := range superBlockSize / 16 {
+				importanceWeights(weights[:], x[16*j:16*j+16], qw[16*j:], sigma2)
+				sw[j] = 0
+				for _, w := range weights {
+					sw[j] += w
+				}
+				scales[j], mins[j] = makeQKX2Quants(3, x[16*j:16*j+16], weights[:], L[16*j:16*j+16], laux[:], -0.9, 0.05, 36, false)
+			}
+			d = fp16Bits(makeQPQuants(q4Scale, scales[:], Ls[:], sw[:]))
+			dmin = fp16Bits(makeQPQuants(q4Scale, mins[:], Lm[:], sw[:]))
+			for j := range superBlockSize / 16 {
+				sc[j] = Ls[j] | Lm[j]<<4
+			}
+		} else {
+			var maxScale, maxMin float32
+			for j := range superBlockSize / 16 {
+				for l := range 16 {
+					weights[l] = abs32(x[16*j+l])
+				}
+				scales[j], mins[j] = makeQKX2Quants(3, x[16*j:16*j+16], weights[:], L[16*j:16*j+16], laux[:], -0.5, 0.1, 15, true)
+				maxScale = max(maxScale, scales[j])
+				maxMin = max(maxMin, mins[j])
+			}
+			if maxScale > 0 {
+				iscale := q4Scale / maxScale
+				for j := range superBlockSize / 16 {
+					sc[j] = uint8(nearestInt(iscale * scales[j]))
+				}
+				d = fp16Bits(maxScale / q4Scale)
+			}
+			if maxMin > 0 {
+				iscale := q4Scale / maxMin
+				for j := range superBlockSize / 16 {
+					sc[j] |= uint8(nearestInt(iscale*mins[j])) << 4
+				}
+				dmin = fp16Bits(maxMin / q4Scale)
+			}
+		}
+		binary.LittleEndian.PutUint16(b[80:], d)
+		binary.LittleEndian.PutUint16(b[82:], dmin)
+
+		for j := range superBlockSize / 16 {
+			dl := fp16Value(d) * float32(sc[j]&0xF)
+			if dl == 0 {
+				continue
+			}
+			dm := fp16Value(dmin) * float32(sc[j]>>4)
+			for ii := range 16 {
+				L[16*j+ii] = uint8(clampInt(nearestInt((x[16*j+ii]+dm)/dl), 0, 3))
+			}
+		}
+
+		for j := 0; j < superBlockSize; j += 128 {
+			for l := range 32 {
+				qs[j/4+l] = L[j+l] | L[j+l+32]<<2 | L[j+l+64]<<4 | L[j+l+96]<<6
+			}
+		}
+	}
+	return out
+}
+
+// dequantizeQ2KBlocks expands Q2_K super-blocks back to n float32 values.
+func dequantizeQ2KBlocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/q2KBlockBytes*superBlockSize)
+	for bi := range len(data) / q2KBlockBytes {
+		b := data[bi*q2KBlockBytes : (bi+1)*q2KBlockBytes]
+		sc := b[:16]
+		d := fp16Value(binary.LittleEndian.Uint16(b[80:]))
+		dmin := fp16Value(binary.LittleEndian.Uint16(b[82:]))
+		y := out[bi*superBlockSize:]
+		is := 0
+		for half := range 2 {
+			q := b[16+half*32:]
+			shift := 0
+			for range 4 {
+				for _, off := range [2]int{0, 16} {
+					dl := d * float32(sc[is]&0xF)
+					ml := dmin * float32(sc[is]>>4)
+					is++
+					for l := range 16 {
+						y[l] = dl*float32((q[off+l]>>shift)&3) - ml
+					}
+					y = y[16:]
+				}
+				shift += 2
+			}
+		}
+	}
+	return out[:n]
+}
+
+// encodeQ3KBlocks converts float32 values to Q3_K super-block bytes.
+// Q3_K: 32-byte high-bit mask + 64 bytes of low 2 bits + 12 bytes of 6-bit
+// scales + float16 d = 110 bytes per 256 values.
+func encodeQ3KBlocks(src []float32) []byte {
+	return encodeQ3KBlocksWeighted(src, nil)
+}
+
+// encodeQ3KBlocksWeighted is encodeQ3KBlocks with scales chosen to minimize
+// the error weighted by imp, one importance value per element of src. A nil
+// imp selects the unweighted search.
+func encodeQ3KBlocksWeighted(src, imp []float32) []byte {
+	blocks := superBlocks(src)
+	out := make([]byte, len(blocks)*q3KBlockBytes)
+
+	var (
+		L       [superBlockSize]int8
+		Ls      [superBlockSize / 16]int8
+		weights [16]float32
+		sw      [superBlockSize / 16]float32
+		scales  [superBlockSize / 16]float32
+	)
+	packScale := func(sc []byte, j, l int) {
+		if j < 8 {
+			sc[j] |= uint8(l & 0xF)
+		} else {
+			sc[j-8] |= uint8(l&0xF) << 4
+		}
+		sc[8+j%4] |= uint8(l>>4) << (2 * (j / 4))
+	}
+
+	for bi, x := range blocks {
+		b := out[bi*q3KBlockBytes : (bi+1)*q3KBlockBytes]
+		hmask := b[:32]
+		qs := b[32:96]
+		sc := b[96:108]
+
+		var d uint16
+		if imp != nil {
+			qw := blockImportance(imp, bi)
+			sigma2 := 2 * sumSquares(x) / superBlockSize
+			for j := range superBlockSize / 16 {
+				importanceWeights(weights[:], x[16*j:16*j+16], qw[16*j:], sigma2)
+				sw[j] = 0
+				for _, w := range weights {
+					sw[j] += w
+				}
+				scales[j] = makeQXQuants(4, x[16*j:16*j+16], L[16*j:16*j+16], weights[:])
+			}
+			d = fp16Bits(makeQXQuants(32, scales[:], Ls[:], sw[:]))
+			for j := range superBlockSize / 16 {
+				packScale(sc, j, int(Ls[j]))
+			}
+		} else {
+			var maxScale, amax float32
+			for j := range superBlockSize / 16 {
+				scales[j] = makeQ3Quants(4, x[16*j:16*j+16], L[16*j:16*j+16])
+				if s := abs32(scales[j]); s > amax {
+					amax = s
+					maxScale = scales[j]
+				}
+			}
+			if maxScale != 0 {
+				iscale := -32 / maxScale
+				for j := range superBlockSize / 16 {
+					packScale(sc, j, clampInt(nearestInt(iscale*scales[j]), -32, 31)+32)
+				}
+				d = fp16Bits(1 / iscale)
+			}
+		}
+		binary.LittleEndian.PutUint16(b[108:], d)
+
+		for j := range superBlockSize / 16 {
+			dl := fp16Value(d) * float32(q3KScale(j, sc))
+			if dl == 0 {
+				continue
+			}
+			for ii := range 16 {
+				L[16*j+ii] = int8(clampInt(nearestInt(x[16*j+ii]/dl), -4, 3) + 4)
+			}
+		}
+
+		// The high bit of the first 32 quants goes into bit 0 of hmask,
+		// the next 32 into bit 1, and so on.
+		for j := range superBlockSize {
+			if L[j] > 3 {
+				hmask[j%32] |= 1 << (j / 32)
+				L[j] -= 4
+			}
+		}
+		for j := 0; j < superBlockSize; j += 128 {
+			for l := range 32 {
+				qs[j/4+l] = uint8(L[j+l]) | uint8(L[j+l+32])<<2 | uint8(L[j+l+64])<<4 | uint8(L[j+l+96])<<6
+			}
+		}
+	}
+	return out
+}
+
+// dequantizeQ3KBlocks expands Q3_K super-blocks back to n float32 values.
+func dequantizeQ3KBlocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/q3KBlockBytes*superBlockSize)
+	for bi := range len(data) / q3KBlockBytes {
+		b := data[bi*q3KBlockBytes : (bi+1)*q3KBlockBytes]
+		hmask := b[:32]
+		sc := b[96:108]
+		d := fp16Value(binary.LittleEndian.Uint16(b[108:]))
+		y := out[bi*superBlockSize:]
+		is := 0
+		m := uint8(1)
+		for half := range 2 {
+			q := b[32+half*32:]
+			shift := 0
+			for range 4 {
+				for _, off := range [2]int{0, 16} {
+					dl := d * float32(q3KScale(is, sc))
+					is++
+					for l := range 16 {
+						v := int((q[off+l] >> shift) & 3)
+						if hmask[off+l]&m == 0 {
+							v -= 4
+						}
+						y[l] = dl * float32(v)
+					}
+					y = y[16:]
+				}
+				shift += 2
+				m <<= 1
+			}
+		}
+	}
+	return out[:n]
+}
+
+// quantizeK4Scales runs the shared Q4_K/Q5_K first pass: per 32-value
+// sub-block scale and min search, then packing into d, dmin and the 6-bit
+// table. With a non-nil imp the search minimizes the importance-weighted
+// error. It returns the float16 bits of d and dmin.
+func quantizeK4Scales(x, imp []float32, nmax int, rmin float32, nstep int, L []uint8, sc []byte) (d, dmin uint16) {
+	var (
+		laux    [32]uint8
+		weights [32]float32
+		sw      [superBlockSize / 32]float32
+		Ls, Lm  [superBlockSize / 32]uint8
+		mins    [superBlockSize / 32]float32
+		scales  [superBlockSize / 32]float32
+	)
+	if imp != nil {
+		sigma2 := 2 * sumSquares(x) / superBlockSize
+		for j := range superBlockSize / 32 {
+			sub := x[32*j : 32*j+32]
+			importanceWeights(weights[:], sub, imp[32*j:], sigma2)
+			sw[j] = 0
+			for _, w := range weights {
+				sw[j] += w
+			}
+			scales[j], mins[j] = makeQKX2Quants(nmax, sub, weights[:], L[32*j:32*j+32], laux[:], -0.9, 0.05, 36, false)
+		}
+		d = fp16Bits(makeQPQuants(63, scales[:], Ls[:], sw[:]))
+		dmin = fp16Bits(makeQPQuants(63, mins[:], Lm[:], sw[:]))
+		for j := range superBlockSize / 32 {
+			packScaleMinK4(j, Ls[j], Lm[j], sc)
+		}
+	} else {
+		var maxScale, maxMin float32
+		for j := range superBlockSize / 32 {
+			sub := x[32*j : 32*j+32]
+			avX := float32(math.Sqrt(float64(sumSquares(sub) / 32)))
+			for l, v := range sub {
+				weights[l] = avX + abs32(v)
+			}
+			scales[j], mins[j] = makeQKX2Quants(nmax, sub, weights[:], L[32*j:32*j+32], laux[:], rmin, 0.1, nstep, false)
+			maxScale = max(maxScale, scales[j])
+			maxMin = max(maxMin, mins[j])
+		}
+
+		var invScale, invMin float32
+		if maxScale > 0 {
+			invScale = 63 / maxScale
+		}
+		if maxMin > 0 {
+			invMin = 63 / maxMin
+		}
+		for j := range superBlockSize / 32 {
+			ls := uint8(min(63, nearestInt(invScale*scales[j])))
+			lm := uint8(min(63, nearestInt(invMin*mins[j])))
+			packScaleMinK4(j, ls, lm, sc)
+		}
+		d = fp16Bits(maxScale / 63)
+		dmin = fp16Bits(maxMin / 63)
+	}
+
+	for j := range superBlockSize / 32 {
+		s, m := scaleMinK4(j, sc)
+		dl := fp16Value(d) * float32(s)
+		if dl == 0 {
+			continue
+		}
+		dm := fp16Value(dmin) * float32(m)
+		for ii := range 32 {
+			L[32*j+ii] = uint8(clampInt(nearestInt((x[32*j+ii]+dm)/dl), 0, nmax))
+		}
+	}
+	return d, dmin
+}
+
+// encodeQ4KBlocks converts float32 values to Q4_K super-block bytes.
+// Q4_K: float16 d and dmin + 12 bytes of 6-bit scales/mins + 128 bytes of
+// 4-bit quants = 144 bytes per 256 values.
+func encodeQ4KBlocks(src []float32) []byte {
+	return encodeQ4KBlocksWeighted(src, nil)
+}
+
+// encodeQ4KBlocksWeighted is encodeQ4KBlocks with scales and mins chosen to
+// minimize the error weighted by imp, one importance value per element of
+// src. A nil imp selects the unweighted search.
+func encodeQ4KBlocksWeighted(src, imp []float32) []byte {
+	blocks := superBlocks(src)
+	out := make([]byte, len(blocks)*q4KBlockBytes)
+	var L [superBlockSize]uint8
+
+	for bi, x := range blocks {
+		b := out[bi*q4KBlockBytes : (bi+1)*q4KBlockBytes]
+		d, dmin := quantizeK4Scales(x, blockImportance(imp, bi), 15, -1, 20, L[:], b[4:16])
+		binary.LittleEndian.PutUint16(b[0:], d)
+		binary.LittleEndian.PutUint16(b[2:], dmin)
+
+		q := b[16:]
+		for j := 0; j < superBlockSize; j += 64 {
+			for l := range 32 {
+				q[l] = L[j+l] | L[j+l+32]<<4
+			}
+			q = q[32:]
+		}
+	}
+	return out
+}
+
+// dequantizeQ4KBlocks expands Q4_K super-blocks back to n float32 values.
+func dequantizeQ4KBlocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/q4KBlockBytes*superBlockSize)
+	for bi := range len(data) / q4KBlockBytes {
+		b := data[bi*q4KBlockBytes : (bi+1)*q4KBlockBytes]
+		d := fp16Value(binary.LittleEndian.Uint16(b[0:]))
+		dmin := fp16Value(binary.LittleEndian.Uint16(b[2:]))
+		sc := b[4:16]
+		q := b[16:]
+		y := out[bi*superBlockSize:]
+		for is := 0; is < superBlockSize/32; is += 2 {
+			s1, m1 := scaleMinK4(is, sc)
+			s2, m2 := scaleMinK4(is+1, sc)
+			d1, min1 := d*float32(s1), dmin*float32(m1)
+			d2, min2 := d*float32(s2), dmin*float32(m2)
+			for l := range 32 {
+				y[l] = d1*float32(q[l]&0xF) - min1
+				y[l+32] = d2*float32(q[l]>>4) - min2
+			}
+			q = q[32:]
+			y = y[64:]
+		}
+	}
+	return out[:n]
+}
+
+// encodeQ5KBlocks converts float32 values to Q5_K super-block bytes.
+// Q5_K: float16 d and dmin + 12 bytes of 6-bit scales/mins + 32 bytes of high
+// bits + 128 bytes of low 4 bits = 176 bytes per 256 values.
+func encodeQ5KBlocks(src []float32) []byte {
+	return encodeQ5KBlocksWeighted(src, nil)
+}
+
+// encodeQ5KBlocksWeighted is encodeQ5KBlocks with scales and mins chosen to
+// minimize the error weighted by imp, one importance value per element of
+// src. A nil imp selects the unweighted search.
+func encodeQ5KBlocksWeighted(src, imp []float32) []byte {
+	blocks := superBlocks(src)
+	out := make([]byte, len(blocks)*q5KBlockBytes)
+	var L [superBlockSize]uint8
+
+	for bi, x := range blocks {
+		b := out[bi*q5KBlockBytes : (bi+1)*q5KBlockBytes]
+		d, dmin := quantizeK4Scales(x, blockImportance(imp, bi), 31, -0.5, 15, L[:], b[4:16])
+		binary.LittleEndian.PutUint16(b[0:], d)
+		binary.LittleEndian.PutUint16(b[2:], dmin)
+
+		qh := b[16:48]
+		ql := b[48:]
+		m1, m2 := uint8(1), uint8(2)
+		for n := 0; n < superBlockSize; n += 64 {
+			for j := range 32 {
+				l1 := L[n+j]
+				if l1 > 15 {
+					l1 -= 16
+					qh[j] |= m1
+				}
+				l2 := L[n+j+32]
+				if l2 > 15 {
+					l2 -= 16
+					qh[j] |= m2
+				}
+				ql[j] = l1 | l2<<4
+			}
+			m1 <<= 2
+			m2 <<= 2
+			ql = ql[32:]
+		}
+	}
+	return out
+}
+
+// dequantizeQ5KBlocks expands Q5_K super-blocks back to n float32 values.
+func dequantizeQ5KBlocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/q5KBlockBytes*superBlockSize)
+	for bi := range len(data) / q5KBlockBytes {
+		b := data[bi*q5KBlockBytes : (bi+1)*q5KBlockBytes]
+		d := fp16Value(binary.LittleEndian.Uint16(b[0:]))
+		dmin := fp16Value(binary.LittleEndian.Uint16(b[2:]))
+		sc := b[4:16]
+		qh := b[16:48]
+		ql := b[48:]
+		y := out[bi*superBlockSize:]
+		u1, u2 := uint8(1), uint8(2)
+		for is := 0; is < superBlockSize/32; is += 2 {
+			s1, m1 := scaleMinK4(is, sc)
+			s2, m2 := scaleMinK4(is+1, sc)
+			d1, min1 := d*float32(s1), dmin*float32(m1)
+			d2, min2 := d*float32(s2), dmin*float32(m2)
+			for l := range 32 {
+				lo := float32(ql[l] & 0xF)
+				if qh[l]&u1 != 0 {
+					lo += 16
+				}
+				hi := float32(ql[l] >> 4)
+				if qh[l]&u2 != 0 {
+					hi += 16
+				}
+				y[l] = d1*lo - min1
+				y[l+32] = d2*hi - min2
+			}
+			ql = ql[32:]
+			y = y[64:]
+			u1 <<= 2
+			u2 <<= 2
+		}
+	}
+	return out[:n]
+}
+
+// encodeQ6KBlocks converts float32 values to Q6_K super-block bytes.
+// Q6_K: 128 bytes of low 4 bits + 64 bytes of high 2 bits + 16 int8 scales +
+// float16 d = 210 bytes per 256 values.
+func encodeQ6KBlocks(src []float32) []byte {
+	return encodeQ6KBlocksWeighted(src, nil)
+}
+
+// encodeQ6KBlocksWeighted is encodeQ6KBlocks with sub-block scales chosen to
+// minimize the error weighted by imp, one importance value per element of
+// src. A nil imp selects the unweighted search.
+func encodeQ6KBlocksWeighted(src, imp []float32) []byte {
+	blocks := superBlocks(src)
+	out := make([]byte, len(blocks)*q6KBlockBytes)
+
+	var (
+		L       [superBlockSize]int8
+		weights [16]float32
+		scales  [superBlockSize / 16]float32
+	)
+
+	for bi, x := range blocks {
+		b := out[bi*q6KBlockBytes : (bi+1)*q6KBlockBytes]
+		qw := blockImportance(imp, bi)
+		var sigma2 float32
+		if qw != nil {
+			sigma2 = sumSquares(x) / superBlockSize
+		}
+
+		var maxScale, maxAbsScale float32
+		for ib := range superBlockSize / 16 {
+			var w []float32
+			if qw != nil {
+				importanceWeights(weights[:], x[16*ib:16*ib+16], qw[16*ib:], sigma2)
+				w = weights[:]
+			}
+			scales[ib] = makeQXQuants(32, x[16*ib:16*ib+16], L[16*ib:16*ib+16], w)
+			if s := abs32(scales[ib]); s > maxAbsScale {
+				maxAbsScale = s
+				maxScale = scales[ib]
+			}
+		}
+		if maxAbsScale < groupMaxEps {
+			continue // all-zero block; out is already zeroed
+		}
+
+		sc := b[192:208]
+		iscale := -128 / maxScale
+		d := fp16Bits(1 / iscale)
+		binary.LittleEndian.PutUint16(b[208:], d)
+		for ib := range superBlockSize / 16 {
+			sc[ib] = byte(int8(min(127, nearestInt(iscale*scales[ib]))))
+		}
+		for j := range superBlockSize / 16 {
+			dl := fp16Value(d) * float32(int8(sc[j]))
+			if dl == 0 {
+				continue
+			}
+			for ii := range 16 {
+				L[16*j+ii] = int8(clampInt(nearestInt(x[16*j+ii]/dl), -32, 31) + 32)
+			}
+		}
+
+		ql := b[:128]
+		qh := b[128:192]
+		for j := 0; j < superBlockSize; j += 128 {
+			for l := range 32 {
+				q1 := uint8(L[j+l]) & 0xF
+				q2 := uint8(L[j+l+32]) & 0xF
+				q3 := uint8(L[j+l+64]) & 0xF
+				q4 := uint8(L[j+l+96]) & 0xF
+				ql[l] = q1 | q3<<4
+				ql[l+32] = q2 | q4<<4
+				qh[l] = uint8(L[j+l])>>4 | (uint8(L[j+l+32])>>4)<<2 | (uint8(L[j+l+64])>>4)<<4 | (uint8(L[j+l+96])>>4)<<6
+			}
+			ql = ql[64:]
+			qh = qh[32:]
+		}
+	}
+	return out
+}
+
+// dequantizeQ6KBlocks expands Q6_K super-blocks back to n float32 values.
+func dequantizeQ6KBlocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/q6KBlockBytes*superBlockSize)
+	for bi := range len(data) / q6KBlockBytes {
+		b := data[bi*q6KBlockBytes : (bi+1)*q6KBlockBytes]
+		ql := b[:128]
+		qh := b[128:192]
+		sc := b[192:208]
+		d := fp16Value(binary.LittleEndian.Uint16(b[208:]))
+		y := out[bi*superBlockSize:]
+		for half := range 2 {
+			s := sc[half*8:]
+			for l := range 32 {
+				is := l / 16
+				q1 := int(ql[l]&0xF|((qh[l]>>0)&3)<<4) - 32
+				q2 := int(ql[l+32]&0xF|((qh[l]>>2)&3)<<4) - 32
+				q3 := int(ql[l]>>4|((qh[l]>>4)&3)<<4) - 32
+				q4 := int(ql[l+32]>>4|((qh[l]>>6)&3)<<4) - 32
+				y[l] = d * float32(int8(s[is])) * float32(q1)
+				y[l+32] = d * float32(int8(s[is+2])) * float32(q2)
+				y[l+64] = d * float32(int8(s[is+4])) * float32(q3)
+				y[l+96] = d * float32(int8(s[is+6])) * float32(q4)
+			}
+			ql = ql[64:]
+			qh = qh[32:]
+			y = y[128:]
+		}
+	}
+	return out[:n]
+}
@@ -0,0 +1,143 @@
+package quantize
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+)
+
+// randomWeights returns n deterministic normally distributed values.
+func randomWeights(n int, seed int64) []float32 {
+	rng := rand.New(rand.NewSource(seed))
+	vals := make([]float32, n)
+	for i := range vals {
+		vals[i] = float32(rng.NormFloat64() * 0.02)
+	}
+	return vals
+}
+
+// relativeRMSE returns the RMS reconstruction error divided by the RMS of want.
+func relativeRMSE(want, got []float32) float64 {
+	var errSum, sigSum float64
+	for i := range want {
+		d := float64(want[i] - got[i])
+		errSum += d * d
+		sigSum += float64(want[i]) * float64(want[i])
+	}
+	return math.Sqrt(errSum / sigSum)
+}
+
+func TestKQuantRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		encode     func([]float32) []byte
+		decode     func([]byte, int) []float32
+		blockBytes int
+		maxRelErr  float64
+	}{
+		{"Q2_K", encodeQ2KBlocks, dequantizeQ2KBlocks, 84, 0.35},
+		{"Q3_K", encodeQ3KBlocks, dequantizeQ3KBlocks, 110, 0.20},
+		{"Q4_K", encodeQ4KBlocks, dequantizeQ4KBlocks, 144, 0.09},
+		{"Q5_K", encodeQ5KBlocks, dequantizeQ5KBlocks, 176, 0.045},
+		{"Q6_K", encodeQ6KBlocks, dequantizeQ6KBlocks, 210, 0.025},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 4 * superBlockSize
+			vals := randomWeights(n, 1)
+
+			data := tt.encode(vals)
+			if len(data) != 4*tt.blockBytes {
+				t.Fatalf("encoded length = %d, want %d", len(data), 4*tt.blockBytes)
+			}
+
+			got := tt.decode(data, n)
+			if len(got) != n {
+				t.Fatalf("decoded length = %d, want %d", len(got), n)
+			}
+			if rel := relativeRMSE(vals, got); rel > tt.maxRelErr {
+				t.Errorf("relative RMSE = %.4f, want <= %.4f", rel, tt.maxRelErr)
+			}
+		})
+	}
+}
+
+func TestKQuantZeroBlock(t *testing.T) {
+	decoders := map[string]struct {
+		encode func([]float32) []byte
+		decode func([]byte, int) []float32
+	}{
+		"Q2_K": {encodeQ2KBlocks, dequantizeQ2KBlocks},
+		"Q3_K": {encodeQ3KBlocks, dequantizeQ3KBlocks},
+		"Q4_K": {encodeQ4KBlocks, dequantizeQ4KBlocks},
+		"Q5_K": {encodeQ5KBlocks, dequantizeQ5KBlocks},
+		"Q6_K": {encodeQ6KBlocks, dequantizeQ6KBlocks},
+	}
+	for name, c := range decoders {
+		t.Run(name, func(t *testing.T) {
+			got := c.decode(c.encode(make([]float32, superBlockSize)), superBlockSize)
+			for i, v := range got {
+				if v != 0 {
+					t.Fatalf("value %d = %v, want 0", i, v)
+				}
+			}
+		})
+	}
+}
+
+func TestQuantizeModel_KQuants(t *testing.T) {
+	tests := []struct {
+		qt         QuantType
+		dtype      zmf.Tensor_DataType
+		blockBytes int
+	}{
+		{Q2_K, DtypeQ2_K, 84},
+		{Q3_K, DtypeQ3_K, 110},
+		{Q4_K, DtypeQ4_K, 144},
+		{Q5_K, DtypeQ5_K, 176},
+		{Q6_K, DtypeQ6_K, 210},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.qt), func(t *testing.T) {
+			vals := randomWeights(8*superBlockSize, 2)
+			model := &zmf.Model{
+				Graph: &zmf.Graph{
+					Parameters: map[string]*zmf.Tensor{
+						"model.layers.0.mlp.down_proj.weight": {
+							Dtype: zmf.Tensor_FLOAT32,
+							Shape: []int64{8, superBlockSize},
+							Data:  makeFloat32Bytes(vals),
+						},
+						// Rows of 96 values are not whole super-blocks.
+						"model.layers.0.mlp.up_proj.weight": {
+							Dtype: zmf.Tensor_FLOAT32,
+							Shape: []int64{64, 96},
+							Data:  makeFloat32Bytes(randomWeights(64*96, 3)),
+						},
+					},
+				},
+			}
+
+			if err := Model(model, tt.qt); err != nil {
+				t.Fatalf("Model(%s): %v", tt.qt, err)
+			}
+
+			w := model.Graph.Parameters["model.layers.0.mlp.down_proj.weight"]
+			if w.Dtype != tt.dtype {
+				t.Errorf("dtype = %v, want %v", w.Dtype, tt.dtype)
+			}
+			if len(w.Data) != 8*tt.blockBytes {
+				t.Errorf("data len = %d, want %d", len(w.Data), 8*tt.blockBytes)
+			}
+
+			ragged := model.Graph.Parameters["model.layers.0.mlp.up_proj.weight"]
+			if ragged.Dtype != zmf.Tensor_FLOAT32 {
+				t.Errorf("ragged tensor dtype = %v, want FLOAT32", ragged.Dtype)
+			}
+		})
+	}
+}
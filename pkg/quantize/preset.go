@@ -0,0 +1,158 @@
+package quantize
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// Mixed-precision presets. Each starts from a base K-quant type and raises
+// precision for the tensors most sensitive to quantization error: the output
+// projection, attention values and the FFN down projection. The "M" (medium)
+// presets spend more bits than the "S" (small) ones, concentrated in the
+// first and last blocks. The recipes follow llama_tensor_get_type in
+// llama.cpp for the presets of the same names, without its special cases for
+// Falcon, mixture-of-experts and 70B models.
+const (
+	Q3_K_S QuantType = "q3_k_s"
+	Q3_K_M QuantType = "q3_k_m"
+	Q4_K_S QuantType = "q4_k_s"
+	Q4_K_M QuantType = "q4_k_m"
+	Q5_K_S QuantType = "q5_k_s"
+	Q5_K_M QuantType = "q5_k_m"
+)
+
+// preset assigns a quantization type per tensor.
+type preset struct {
+	base QuantType
+	// pick returns the type for a tensor given its GGUF role (the name
+	// without the "blk.N." prefix and ".weight" suffix), its block index
+	// (-1 outside the blocks) and the number of blocks. An empty result
+	// selects base.
+	pick func(role string, layer, layers int) QuantType
+}
+
+var presets = map[QuantType]preset{
+	Q3_K_S: {base: Q3_K, pick: func(role string, _, _ int) QuantType {
+		if role == "output" {
+			return Q6_K
+		}
+		return ""
+	}},
+	Q3_K_M: {base: Q3_K, pick: func(role string, layer, layers int) QuantType {
+		switch role {
+		case "output":
+			return Q6_K
+		case "attn_v":
+			if layer < 2 {
+				return Q5_K
+			}
+			return Q4_K
+		case "attn_qkv", "attn_output":
+			return Q4_K
+		case "ffn_down":
+			if layer < layers/16 {
+				return Q5_K
+			}
+			return Q4_K
+		}
+		return ""
+	}},
+	Q4_K_S: {base: Q4_K, pick: func(role string, layer, layers int) QuantType {
+		switch role {
+		case "output":
+			return Q6_K
+		case "attn_v":
+			if layer < 4 {
+				return Q5_K
+			}
+		case "ffn_down":
+			if layer < layers/8 {
+				return Q5_K
+			}
+		}
+		return ""
+	}},
+	Q4_K_M: {base: Q4_K, pick: func(role string, layer, layers int) QuantType {
+		switch role {
+		case "output":
+			return Q6_K
+		case "attn_qkv":
+			return Q5_K
+		case "attn_v", "ffn_down":
+			if useMoreBits(layer, layers) {
+				return Q6_K
+			}
+		}
+		return ""
+	}},
+	Q5_K_S: {base: Q5_K, pick: func(role string, _, _ int) QuantType {
+		if role == "output" {
+			return Q6_K
+		}
+		return ""
+	}},
+	Q5_K_M: {base: Q5_K, pick: func(role string, layer, layers int) QuantType {
+		switch role {
+		case "output", "attn_qkv":
+			return Q6_K
+		case "attn_v", "ffn_down":
+			if useMoreBits(layer, layers) {
+				return Q6_K
+			}
+		}
+		return ""
+	}},
+}
+
+// useMoreBits reports whether a block gets the higher-precision type: the
+// first and last eighth of the blocks, and every third block in between.
+func useMoreBits(layer, layers int) bool {
+	return layer < layers/8 || layer >= 7*layers/8 || (layer-layers/8)%3 == 2
+}
+
+// blockPattern matches GGUF block tensor names and captures the block index
+// and the remainder of the name.
+var blockPattern = regexp.MustCompile(`^blk\.(\d+)\.(.+)$`)
+
+// tensorRole splits a GGUF tensor name into its role and block index.
+// Tensors outside the repeated blocks report a block index of -1.
+func tensorRole(ggufName string) (role string, layer int) {
+	layer = -1
+	role = ggufName
+	if m := blockPattern.FindStringSubmatch(ggufName); m != nil {
+		layer, _ = strconv.Atoi(m[1])
+		role = m[2]
+	}
+	return strings.TrimSuffix(role, ".weight"), layer
+}
+
+// blockCount returns the number of repeated blocks among the named tensors.
+func blockCount(names []string) int {
+	layers := 0
+	for _, name := range names {
+		if _, layer := tensorRole(gguf.MapTensorName(name)); layer+1 > layers {
+			layers = layer + 1
+		}
+	}
+	return layers
+}
+
+// resolve returns the quantization type of every named tensor under the
+// preset for a model with the given number of blocks. Names are mapped to
+// GGUF conventions with gguf.MapTensorName first, so the same rules apply to
+// every architecture.
+func (p preset) resolve(names []string, layers int) map[string]QuantType {
+	types := make(map[string]QuantType, len(names))
+	for _, name := range names {
+		role, layer := tensorRole(gguf.MapTensorName(name))
+		qt := p.pick(role, layer, layers)
+		if qt == "" {
+			qt = p.base
+		}
+		types[name] = qt
+	}
+	return types
+}
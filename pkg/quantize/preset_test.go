@@ -0,0 +1,124 @@
+package quantize
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+)
+
+// llamaNames returns the HuggingFace weight names of a Llama-style model
+// with the given number of layers.
+func llamaNames(layers int) []string {
+	names := []string{"lm_head.weight"}
+	for i := range layers {
+		for _, suffix := range []string{
+			"self_attn.q_proj.weight",
+			"self_attn.k_proj.weight",
+			"self_attn.v_proj.weight",
+			"self_attn.o_proj.weight",
+			"mlp.gate_proj.weight",
+			"mlp.up_proj.weight",
+			"mlp.down_proj.weight",
+		} {
+			names = append(names, fmt.Sprintf("model.layers.%d.%s", i, suffix))
+		}
+	}
+	return names
+}
+
+func TestPresetResolve(t *testing.T) {
+	tests := []struct {
+		preset QuantType
+		name   string
+		want   QuantType
+	}{
+		{Q4_K_M, "lm_head.weight", Q6_K},
+		{Q4_K_M, "model.layers.0.self_attn.v_proj.weight", Q6_K},
+		{Q4_K_M, "model.layers.0.mlp.down_proj.weight", Q6_K},
+		{Q4_K_M, "model.layers.15.mlp.down_proj.weight", Q6_K},
+		{Q4_K_M, "model.layers.4.self_attn.v_proj.weight", Q6_K},
+		{Q4_K_M, "model.layers.5.self_attn.v_proj.weight", Q4_K},
+		{Q4_K_M, "model.layers.5.self_attn.q_proj.weight", Q4_K},
+		{Q4_K_M, "model.layers.0.mlp.up_proj.weight", Q4_K},
+		{Q4_K_S, "lm_head.weight", Q6_K},
+		{Q4_K_S, "model.layers.1.self_attn.v_proj.weight", Q5_K},
+		{Q4_K_S, "model.layers.3.self_attn.v_proj.weight", Q5_K}, // the first 4 blocks, not the first eighth
+		{Q4_K_S, "model.layers.4.self_attn.v_proj.weight", Q4_K},
+		{Q4_K_S, "model.layers.1.mlp.down_proj.weight", Q5_K},
+		{Q4_K_S, "model.layers.2.mlp.down_proj.weight", Q4_K},
+		{Q4_K_S, "model.layers.15.mlp.down_proj.weight", Q4_K},
+		{Q5_K_M, "model.layers.14.self_attn.v_proj.weight", Q6_K},
+		{Q5_K_M, "model.layers.6.mlp.down_proj.weight", Q5_K},
+		{Q5_K_S, "lm_head.weight", Q6_K},
+		{Q5_K_S, "model.layers.0.self_attn.v_proj.weight", Q5_K},
+		{Q5_K_S, "model.layers.0.mlp.down_proj.weight", Q5_K},
+		{Q5_K_S, "model.layers.15.mlp.down_proj.weight", Q5_K},
+		{Q3_K_M, "model.layers.0.self_attn.v_proj.weight", Q5_K},
+		{Q3_K_M, "model.layers.8.self_attn.v_proj.weight", Q4_K},
+		{Q3_K_M, "model.layers.8.self_attn.o_proj.weight", Q4_K},
+		{Q3_K_M, "model.layers.0.mlp.down_proj.weight", Q5_K},
+		{Q3_K_M, "model.layers.6.mlp.down_proj.weight", Q4_K},
+		{Q3_K_M, "model.layers.8.mlp.gate_proj.weight", Q3_K},
+		{Q3_K_S, "lm_head.weight", Q6_K},
+		{Q3_K_S, "model.layers.0.self_attn.v_proj.weight", Q3_K},
+		{Q3_K_S, "model.layers.0.mlp.down_proj.weight", Q3_K},
+	}
+
+	names := llamaNames(16)
+	for _, tt := range tests {
+		t.Run(string(tt.preset)+"/"+tt.name, func(t *testing.T) {
+			got := presets[tt.preset].resolve(names, blockCount(names))[tt.name]
+			if got != tt.want {
+				t.Errorf("type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresetResolve_BERTNames(t *testing.T) {
+	names := []string{
+		"bert.encoder.layer.0.attention.self.value.weight",
+		"bert.encoder.layer.5.attention.self.value.weight",
+		"bert.encoder.layer.11.attention.self.value.weight",
+	}
+	types := presets[Q4_K_S].resolve(names, blockCount(names))
+	if got := types[names[0]]; got != Q5_K {
+		t.Errorf("layer 0 attn_v = %q, want %q", got, Q5_K)
+	}
+	if got := types[names[1]]; got != Q4_K {
+		t.Errorf("layer 5 attn_v = %q, want %q", got, Q4_K)
+	}
+}
+
+func TestQuantizeModel_Preset(t *testing.T) {
+	params := map[string]*zmf.Tensor{}
+	for i, name := range llamaNames(8) {
+		params[name] = &zmf.Tensor{
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{4, superBlockSize},
+			Data:  makeFloat32Bytes(randomWeights(4*superBlockSize, int64(i))),
+		}
+	}
+	model := &zmf.Model{Graph: &zmf.Graph{Parameters: params}}
+
+	if err := Model(model, Q4_K_M); err != nil {
+		t.Fatalf("Model(q4_k_m): %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		dtype zmf.Tensor_DataType
+	}{
+		{"lm_head.weight", DtypeQ6_K},
+		{"model.layers.0.self_attn.v_proj.weight", DtypeQ6_K},
+		{"model.layers.2.self_attn.v_proj.weight", DtypeQ4_K},
+		{"model.layers.7.mlp.down_proj.weight", DtypeQ6_K},
+		{"model.layers.3.mlp.up_proj.weight", DtypeQ4_K},
+	}
+	for _, tt := range tests {
+		if got := params[tt.name].Dtype; got != tt.dtype {
+			t.Errorf("%s dtype = %v, want %v", tt.name, got, tt.dtype)
+		}
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/zerfoo/float16"
 	"github.com/zerfoo/zmf"
 )
 
@@ -15,79 +19,299 @@ type QuantType string
 const (
 	Q4_0 QuantType = "q4_0"
 	Q8_0 QuantType = "q8_0"
+	Q4_1 QuantType = "q4_1"
+	Q5_0 QuantType = "q5_0"
+	Q5_1 QuantType = "q5_1"
+	Q2_K QuantType = "q2_k"
+	Q3_K QuantType = "q3_k"
+	Q4_K QuantType = "q4_k"
+	Q5_K QuantType = "q5_k"
+	Q6_K QuantType = "q6_k"
+
+	// F16 stores a tensor as IEEE half precision. F32 leaves it unchanged
+	// and is only meaningful as a Rule target.
+	F16 QuantType = "f16"
+	F32 QuantType = "f32"
+)
+
+// ZMF has no data types for the K-quant and legacy Q4_1/Q5_x layouts. Protobuf enums are open, so
+// these values travel through a zmf.Model unchanged; they start well above
+// the upstream range to leave room for future zmf additions.
+const (
+	DtypeQ2_K zmf.Tensor_DataType = 100 + iota
+	DtypeQ3_K
+	DtypeQ4_K
+	DtypeQ5_K
+	DtypeQ6_K
+	DtypeQ4_1
+	DtypeQ5_0
+	DtypeQ5_1
 )
 
 const blockSize = 32
 
-// Model quantizes all FLOAT32 parameter tensors in the given ZMF model in-place.
+// format describes how tensors are encoded for one QuantType.
+type format struct {
+	dtype zmf.Tensor_DataType
+	// rowAlign, when non-zero, is the number of elements every row must be
+	// a multiple of. Tensors with ragged rows keep their original type.
+	rowAlign int
+	encode   func([]float32) []byte
+	// encodeWeighted, when set, encodes whole rows using per-element
+	// importance values from an importance matrix.
+	encodeWeighted func(src, imp []float32) []byte
+	decode         func(data []byte, n int) []float32
+	// blockValues values are stored in each blockBytes-byte block; partial
+	// blocks are zero-padded.
+	blockValues, blockBytes int
+	// bits and group describe the quantization grid for error reports:
+	// values are stored with bits bits and share a scale in groups of
+	// group values. bits is zero for float formats.
+	bits, group int
+}
+
+var formats = map[QuantType]format{
+	Q4_0: {dtype: zmf.Tensor_Q4_0, rowAlign: blockSize, encode: encodeQ4Blocks, encodeWeighted: encodeQ4BlocksWeighted, decode: dequantizeQ4Blocks, blockValues: blockSize, blockBytes: 18, bits: 4, group: blockSize},
+	Q8_0: {dtype: zmf.Tensor_Q8_0, rowAlign: blockSize, encode: encodeQ8Blocks, decode: dequantizeQ8Blocks, blockValues: blockSize, blockBytes: 36, bits: 8, group: blockSize},
+	Q4_1: {dtype: DtypeQ4_1, rowAlign: blockSize, encode: encodeQ4_1Blocks, decode: dequantizeQ4_1Blocks, blockValues: blockSize, blockBytes: 20, bits: 4, group: blockSize},
+	Q5_0: {dtype: DtypeQ5_0, rowAlign: blockSize, encode: encodeQ5_0Blocks, decode: dequantizeQ5_0Blocks, blockValues: blockSize, blockBytes: 22, bits: 5, group: blockSize},
+	Q5_1: {dtype: DtypeQ5_1, rowAlign: blockSize, encode: encodeQ5_1Blocks, decode: dequantizeQ5_1Blocks, blockValues: blockSize, blockBytes: 24, bits: 5, group: blockSize},
+	Q2_K: {dtype: DtypeQ2_K, rowAlign: superBlockSize, encode: encodeQ2KBlocks, encodeWeighted: encodeQ2KBlocksWeighted, decode: dequantizeQ2KBlocks, blockValues: superBlockSize, blockBytes: q2KBlockBytes, bits: 2, group: 16},
+	Q3_K: {dtype: DtypeQ3_K, rowAlign: superBlockSize, encode: encodeQ3KBlocks, encodeWeighted: encodeQ3KBlocksWeighted, decode: dequantizeQ3KBlocks, blockValues: superBlockSize, blockBytes: q3KBlockBytes, bits: 3, group: 16},
+	Q4_K: {dtype: DtypeQ4_K, rowAlign: superBlockSize, encode: encodeQ4KBlocks, encodeWeighted: encodeQ4KBlocksWeighted, decode: dequantizeQ4KBlocks, blockValues: superBlockSize, blockBytes: q4KBlockBytes, bits: 4, group: 32},
+	Q5_K: {dtype: DtypeQ5_K, rowAlign: superBlockSize, encode: encodeQ5KBlocks, encodeWeighted: encodeQ5KBlocksWeighted, decode: dequantizeQ5KBlocks, blockValues: superBlockSize, blockBytes: q5KBlockBytes, bits: 5, group: 32},
+	Q6_K: {dtype: DtypeQ6_K, rowAlign: superBlockSize, encode: encodeQ6KBlocks, encodeWeighted: encodeQ6KBlocksWeighted, decode: dequantizeQ6KBlocks, blockValues: superBlockSize, blockBytes: q6KBlockBytes, bits: 6, group: 16},
+	F16:  {dtype: zmf.Tensor_FLOAT16, encode: encodeF16, decode: decodeF16, blockValues: 1, blockBytes: 2},
+}
+
+// Options configures ModelWithOptions.
+type Options struct {
+	// Type is the default block format or mixed-precision preset. When
+	// empty, only tensors matched by Rules are quantized.
+	Type QuantType
+	// Rules override Type and the built-in skip heuristics per tensor. The
+	// first matching rule wins.
+	Rules []Rule
+	// Imatrix, when set, weights the scale search of Q4_0 and the K-quants
+	// by per-column importance. Tensors without an entry are quantized
+	// unweighted.
+	Imatrix Imatrix
+	// Report, when set, receives per-tensor quantization error statistics
+	// and the reasons tensors were left unquantized.
+	Report *Report
+	// Jobs is the number of tensors quantized concurrently. Zero or less
+	// uses runtime.GOMAXPROCS(0). The output does not depend on Jobs.
+	Jobs int
+}
+
+// Model quantizes all floating-point (FLOAT32, FLOAT16, BFLOAT16 and
+// FLOAT64) parameter tensors in the given ZMF model in-place.
+// qt is either a single block format or a mixed-precision preset such as
+// Q4_K_M. Block formats only apply to tensors whose rows are whole blocks
+// (32 values, or 256-value super-blocks for the K-quants); other tensors keep
+// their original type.
 func Model(m *zmf.Model, qt QuantType) error {
+	return ModelWithOptions(m, Options{Type: qt})
+}
+
+// ModelWithOptions is like Model but also applies per-tensor override rules.
+func ModelWithOptions(m *zmf.Model, opts Options) error {
 	if m.Graph == nil {
 		return fmt.Errorf("model has no graph")
 	}
 
-	var encode func([]float32) ([]byte, zmf.Tensor_DataType)
+	types, skipped, err := plan(m, opts)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Each tensor is encoded independently of the others, so the results do
+	// not depend on the number of workers or the order they finish in.
+	results := make([]encodedTensor, len(names))
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, len(names)) {
+		wg.Go(func() {
+			for i := range next {
+				name := names[i]
+				results[i] = quantizeTensor(name, types[name], m.Graph.Parameters[name], opts)
+			}
+		})
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
 
-	switch qt {
-	case Q4_0:
-		encode = func(f32 []float32) ([]byte, zmf.Tensor_DataType) {
-			return encodeQ4Blocks(f32), zmf.Tensor_Q4_0
+	for i, name := range names {
+		r := results[i]
+		if r.err != nil {
+			return fmt.Errorf("quantize %s: %w", name, r.err)
 		}
-	case Q8_0:
-		encode = func(f32 []float32) ([]byte, zmf.Tensor_DataType) {
-			return encodeQ8Blocks(f32), zmf.Tensor_Q8_0
+		m.Graph.Parameters[name] = r.tensor
+		if opts.Report != nil {
+			opts.Report.Tensors = append(opts.Report.Tensors, r.report)
 		}
-	default:
-		return fmt.Errorf("unsupported quantization type: %q", qt)
 	}
 
+	if opts.Report != nil {
+		opts.Report.finish(skipped)
+	}
+	return nil
+}
+
+// encodedTensor is the outcome of quantizing one tensor.
+type encodedTensor struct {
+	tensor *zmf.Tensor
+	report TensorReport
+	err    error
+}
+
+// quantizeTensor encodes t as tq. It only reads shared state, so it may run
+// concurrently for different tensors.
+func quantizeTensor(name string, tq QuantType, t *zmf.Tensor, opts Options) encodedTensor {
+	f := formats[tq]
+	f32, _ := decodeParameter(t)
+	data, err := encodeTensor(f, name, t, f32, opts.Imatrix)
+	if err != nil {
+		return encodedTensor{err: err}
+	}
+	r := encodedTensor{tensor: &zmf.Tensor{
+		Dtype: f.dtype,
+		Shape: t.Shape,
+		Data:  data,
+	}}
+	if opts.Report != nil {
+		r.report = tensorReport(name, tq, f, f32, data)
+	}
+	return r
+}
+
+// encodeTensor encodes the values of t, weighting by its importance matrix
+// entry when the format supports it.
+func encodeTensor(f format, name string, t *zmf.Tensor, f32 []float32, im Imatrix) ([]byte, error) {
+	if f.encodeWeighted == nil {
+		return f.encode(f32), nil
+	}
+	imp, ok := im.lookup(name)
+	if !ok {
+		return f.encode(f32), nil
+	}
+	return encodeWithImportance(f, f32, int(t.Shape[len(t.Shape)-1]), imp)
+}
+
+// plan returns the quantization type for every parameter that should be
+// quantized, and the reason each other parameter is kept as is.
+func plan(m *zmf.Model, opts Options) (types map[string]QuantType, skipped map[string]string, err error) {
+	qt := opts.Type
+	p, isPreset := presets[qt]
+	if _, ok := formats[qt]; qt != "" && !ok && !isPreset {
+		return nil, nil, fmt.Errorf("unsupported quantization type: %q", qt)
+	}
+	for _, r := range opts.Rules {
+		if !validRuleType(r.Type) {
+			return nil, nil, fmt.Errorf("unsupported quantization type in rule %q: %q", r.Pattern, r.Type)
+		}
+	}
+
+	var all, names []string
+	overrides := make(map[string]QuantType)
+	skipped = make(map[string]string)
 	for name, t := range m.Graph.Parameters {
-		if t.Dtype != zmf.Tensor_FLOAT32 {
+		if !isFloatDtype(t.Dtype) {
+			skipped[name] = fmt.Sprintf("%s data is not a float type", t.Dtype)
+			continue
+		}
+		all = append(all, name)
+		if rt, ok := matchRule(opts.Rules, name); ok {
+			if rt == F32 {
+				skipped[name] = "kept by rule"
+			} else {
+				overrides[name] = rt
+			}
 			continue
 		}
-		if skipQuantize(name, t) {
+		if qt == "" {
+			skipped[name] = "no quantization type selected"
 			continue
 		}
+		if reason := skipReason(name, t); reason != "" {
+			skipped[name] = reason
+			continue
+		}
+		names = append(names, name)
+	}
 
-		f32 := decodeFloat32(t.Data)
-		data, dtype := encode(f32)
-		m.Graph.Parameters[name] = &zmf.Tensor{
-			Dtype: dtype,
-			Shape: t.Shape,
-			Data:  data,
+	if isPreset {
+		types = p.resolve(names, blockCount(all))
+	} else {
+		types = make(map[string]QuantType, len(names)+len(overrides))
+		for _, name := range names {
+			types[name] = qt
 		}
 	}
+	for name, rt := range overrides {
+		types[name] = rt
+	}
 
-	return nil
+	// A scalar has no rows, and ggml cannot load a tensor whose rows are not
+	// whole blocks, so neither is quantized even when a rule selects it.
+	for name, tq := range types {
+		t := m.Graph.Parameters[name]
+		switch f := formats[tq]; {
+		case len(t.Shape) == 0:
+			skipped[name] = "scalar"
+		case f.rowAlign > 0 && t.Shape[len(t.Shape)-1]%int64(f.rowAlign) != 0:
+			skipped[name] = fmt.Sprintf("row length %d is not a multiple of %d for %s", t.Shape[len(t.Shape)-1], f.rowAlign, tq)
+		default:
+			continue
+		}
+		delete(types, name)
+	}
+	return types, skipped, nil
 }
 
 // minQuantElements is the minimum number of elements for a tensor to be quantized.
 // Tensors with fewer elements (e.g., norm weights, bias vectors) lose too much
-// precision from block quantization and are kept as float32.
+// precision from block quantization and keep their original type.
 const minQuantElements = 1024
 
-// skipQuantize returns true for tensors that should NOT be quantized.
-// Norm weights, bias vectors, and small tensors are kept in float32.
-func skipQuantize(name string, t *zmf.Tensor) bool {
+// skipReason explains why a tensor should NOT be quantized, or returns ""
+// if it should be. Norm weights, bias vectors, embeddings and small tensors
+// keep their original precision.
+func skipReason(name string, t *zmf.Tensor) string {
 	lower := strings.ToLower(name)
 
 	// Skip normalization weights (RMSNorm, LayerNorm).
 	if strings.Contains(lower, "norm") {
-		return true
+		return "normalization weight"
 	}
 	// Skip bias tensors.
 	if strings.HasSuffix(lower, ".bias") || strings.HasSuffix(lower, "_bias") {
-		return true
+		return "bias"
 	}
 	// Skip embedding tables (used in Gather, dequantization happens per-lookup).
-	if strings.Contains(lower, "embed") {
-		return true
+	// GGUF files name them token_embd, position_embd and so on.
+	if strings.Contains(lower, "embed") || strings.Contains(lower, "embd") {
+		return "embedding table"
 	}
 
 	// Skip 1D tensors (bias-like, per-channel scales, gate factors).
 	// Q4_0 block quantization with 32 values per block produces
 	// unacceptable error for 1D vectors where values vary significantly.
 	if len(t.Shape) <= 1 {
-		return true
+		return "1-D tensor"
 	}
 
 	// Skip small tensors.
@@ -95,7 +319,53 @@ func skipQuantize(name string, t *zmf.Tensor) bool {
 	for _, d := range t.Shape {
 		numElements *= int(d)
 	}
-	return numElements < minQuantElements
+	if numElements < minQuantElements {
+		return fmt.Sprintf("%d elements, fewer than %d", numElements, minQuantElements)
+	}
+	return ""
+}
+
+// isFloatDtype reports whether decodeParameter can read tensors of type dt.
+func isFloatDtype(dt zmf.Tensor_DataType) bool {
+	switch dt {
+	case zmf.Tensor_FLOAT32, zmf.Tensor_FLOAT16, zmf.Tensor_BFLOAT16, zmf.Tensor_FLOAT64:
+		return true
+	}
+	return false
+}
+
+// decodeParameter returns the values of a floating-point tensor as float32.
+// It reports false for other data types.
+func decodeParameter(t *zmf.Tensor) ([]float32, bool) {
+	switch t.Dtype {
+	case zmf.Tensor_FLOAT32:
+		return decodeFloat32(t.Data), true
+	case zmf.Tensor_FLOAT16:
+		return decodeF16(t.Data, len(t.Data)/2), true
+	case zmf.Tensor_BFLOAT16:
+		return decodeBF16(t.Data, len(t.Data)/2), true
+	case zmf.Tensor_FLOAT64:
+		return decodeFloat64(t.Data, len(t.Data)/8), true
+	}
+	return nil, false
+}
+
+// decodeBF16 expands little-endian bfloat16 values.
+func decodeBF16(data []byte, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float16.BFloat16FromBits(binary.LittleEndian.Uint16(data[i*2:])).ToFloat32()
+	}
+	return out
+}
+
+// decodeFloat64 narrows little-endian float64 values to float32.
+func decodeFloat64(data []byte, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:])))
+	}
+	return out
 }
 
 func decodeFloat32(b []byte) []float32 {
@@ -107,6 +377,15 @@ func decodeFloat32(b []byte) []float32 {
 	return f32
 }
 
+// encodeFloat32 stores values as little-endian float32.
+func encodeFloat32(vals []float32) []byte {
+	out := make([]byte, 0, len(vals)*4)
+	for _, v := range vals {
+		out = binary.LittleEndian.AppendUint32(out, math.Float32bits(v))
+	}
+	return out
+}
+
 func clampInt(v, lo, hi int) int {
 	if v < lo {
 		return lo
@@ -166,6 +445,62 @@ func encodeQ4Blocks(src []float32) []byte {
 	return out
 }
 
+// encodeQ4BlocksWeighted is encodeQ4Blocks with each block's scale chosen to
+// minimize the error weighted by imp, one importance value per element of
+// src, as in ggml's imatrix path for Q4_0. src must be a whole row of full
+// blocks.
+func encodeQ4BlocksWeighted(src, imp []float32) []byte {
+	nBlocks := len(src) / blockSize
+	out := make([]byte, nBlocks*18)
+	sigma2 := sumSquares(src) / float32(len(src))
+
+	var (
+		L       [blockSize]int8
+		weights [blockSize]float32
+	)
+	for bi := range nBlocks {
+		x := src[bi*blockSize : (bi+1)*blockSize]
+		importanceWeights(weights[:], x, imp[bi*blockSize:], sigma2)
+		// L holds levels offset by 8, matching the nibble encoding below.
+		scale := makeQXQuants(8, x, L[:], weights[:])
+		binary.LittleEndian.PutUint16(out[bi*18:], float32ToFloat16Bits(scale))
+		for j := 0; j < blockSize; j += 2 {
+			out[bi*18+2+j/2] = byte(L[j]) | byte(L[j+1])<<4
+		}
+	}
+	return out
+}
+
+// dequantizeQ4Blocks expands blocks written by encodeQ4Blocks back to n
+// float32 values.
+func dequantizeQ4Blocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/18*blockSize)
+	for bi := range len(data) / 18 {
+		b := data[bi*18 : (bi+1)*18]
+		scale := fp16Value(binary.LittleEndian.Uint16(b))
+		for j := 0; j < blockSize; j += 2 {
+			q := b[2+j/2]
+			out[bi*blockSize+j] = float32(int(q&0xF)-8) * scale
+			out[bi*blockSize+j+1] = float32(int(q>>4)-8) * scale
+		}
+	}
+	return out[:n]
+}
+
+// dequantizeQ8Blocks expands blocks written by encodeQ8Blocks back to n
+// float32 values.
+func dequantizeQ8Blocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/36*blockSize)
+	for bi := range len(data) / 36 {
+		b := data[bi*36 : (bi+1)*36]
+		scale := math.Float32frombits(binary.LittleEndian.Uint32(b))
+		for j := range blockSize {
+			out[bi*blockSize+j] = float32(int8(b[4+j])) * scale
+		}
+	}
+	return out[:n]
+}
+
 // encodeQ8Blocks converts float32 values to Q8_0 block bytes.
 // Q8_0: 4-byte float32 scale + 32-byte int8 data = 36 bytes per 32 values.
 func encodeQ8Blocks(src []float32) []byte {
@@ -208,18 +543,235 @@ func encodeQ8Blocks(src []float32) []byte {
 	return out
 }
 
-// float32ToFloat16Bits converts a float32 to IEEE 754 half-precision bits.
+// blockMinMax returns the minimum and maximum of block bi of src, treating
+// values past the end of src as zero.
+func blockMinMax(src []float32, bi int) (lo, hi float32) {
+	offset := bi * blockSize
+	lo, hi = float32(math.Inf(1)), float32(math.Inf(-1))
+	for j := range blockSize {
+		var v float32
+		if offset+j < len(src) {
+			v = src[offset+j]
+		}
+		lo = min(lo, v)
+		hi = max(hi, v)
+	}
+	return lo, hi
+}
+
+// blockValue returns value j of block bi of src, or zero past the end.
+func blockValue(src []float32, bi, j int) float32 {
+	if idx := bi*blockSize + j; idx < len(src) {
+		return src[idx]
+	}
+	return 0
+}
+
+// encodeQ4_1Blocks converts float32 values to Q4_1 block bytes.
+// Q4_1: float16 scale + float16 min + 16-byte packed 4-bit data = 20 bytes
+// per 32 values. Value j shares a byte with value j+16, as in ggml.
+func encodeQ4_1Blocks(src []float32) []byte {
+	nBlocks := (len(src) + blockSize - 1) / blockSize
+	out := make([]byte, nBlocks*20)
+
+	for bi := range nBlocks {
+		b := out[bi*20 : (bi+1)*20]
+		lo, hi := blockMinMax(src, bi)
+		d := (hi - lo) / 15
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+		binary.LittleEndian.PutUint16(b[0:], fp16Bits(d))
+		binary.LittleEndian.PutUint16(b[2:], fp16Bits(lo))
+
+		for j := range blockSize / 2 {
+			q0 := min(15, int((blockValue(src, bi, j)-lo)*id+0.5))
+			q1 := min(15, int((blockValue(src, bi, j+blockSize/2)-lo)*id+0.5))
+			b[4+j] = byte(q0) | byte(q1)<<4
+		}
+	}
+	return out
+}
+
+// dequantizeQ4_1Blocks expands Q4_1 blocks back to n float32 values.
+func dequantizeQ4_1Blocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/20*blockSize)
+	for bi := range len(data) / 20 {
+		b := data[bi*20 : (bi+1)*20]
+		d := fp16Value(binary.LittleEndian.Uint16(b[0:]))
+		m := fp16Value(binary.LittleEndian.Uint16(b[2:]))
+		y := out[bi*blockSize:]
+		for j := range blockSize / 2 {
+			y[j] = float32(b[4+j]&0xF)*d + m
+			y[j+blockSize/2] = float32(b[4+j]>>4)*d + m
+		}
+	}
+	return out[:n]
+}
+
+// encodeQ5_0Blocks converts float32 values to Q5_0 block bytes.
+// Q5_0: float16 scale + 4-byte high-bit mask + 16-byte packed low 4 bits =
+// 22 bytes per 32 values, with levels centred on 16.
+func encodeQ5_0Blocks(src []float32) []byte {
+	nBlocks := (len(src) + blockSize - 1) / blockSize
+	out := make([]byte, nBlocks*22)
+
+	for bi := range nBlocks {
+		b := out[bi*22 : (bi+1)*22]
+		var amax, maxV float32
+		for j := range blockSize {
+			v := blockValue(src, bi, j)
+			if av := abs32(v); av > amax {
+				amax = av
+				maxV = v
+			}
+		}
+		d := maxV / -16
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+		binary.LittleEndian.PutUint16(b[0:], fp16Bits(d))
+
+		var qh uint32
+		for j := range blockSize / 2 {
+			q0 := min(31, int(int8(blockValue(src, bi, j)*id+16.5)))
+			q1 := min(31, int(int8(blockValue(src, bi, j+blockSize/2)*id+16.5)))
+			b[6+j] = byte(q0&0xF) | byte(q1&0xF)<<4
+			qh |= uint32(q0>>4&1) << j
+			qh |= uint32(q1>>4&1) << (j + blockSize/2)
+		}
+		binary.LittleEndian.PutUint32(b[2:], qh)
+	}
+	return out
+}
+
+// dequantizeQ5_0Blocks expands Q5_0 blocks back to n float32 values.
+func dequantizeQ5_0Blocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/22*blockSize)
+	for bi := range len(data) / 22 {
+		b := data[bi*22 : (bi+1)*22]
+		d := fp16Value(binary.LittleEndian.Uint16(b[0:]))
+		qh := binary.LittleEndian.Uint32(b[2:])
+		y := out[bi*blockSize:]
+		for j := range blockSize / 2 {
+			q0 := int(b[6+j]&0xF) | int(qh>>j&1)<<4
+			q1 := int(b[6+j]>>4) | int(qh>>(j+blockSize/2)&1)<<4
+			y[j] = float32(q0-16) * d
+			y[j+blockSize/2] = float32(q1-16) * d
+		}
+	}
+	return out[:n]
+}
+
+// encodeQ5_1Blocks converts float32 values to Q5_1 block bytes.
+// Q5_1: float16 scale + float16 min + 4-byte high-bit mask + 16-byte packed
+// low 4 bits = 24 bytes per 32 values.
+func encodeQ5_1Blocks(src []float32) []byte {
+	nBlocks := (len(src) + blockSize - 1) / blockSize
+	out := make([]byte, nBlocks*24)
+
+	for bi := range nBlocks {
+		b := out[bi*24 : (bi+1)*24]
+		lo, hi := blockMinMax(src, bi)
+		d := (hi - lo) / 31
+		var id float32
+		if d != 0 {
+			id = 1 / d
+		}
+		binary.LittleEndian.PutUint16(b[0:], fp16Bits(d))
+		binary.LittleEndian.PutUint16(b[2:], fp16Bits(lo))
+
+		var qh uint32
+		for j := range blockSize / 2 {
+			q0 := int((blockValue(src, bi, j)-lo)*id + 0.5)
+			q1 := int((blockValue(src, bi, j+blockSize/2)-lo)*id + 0.5)
+			b[8+j] = byte(q0&0xF) | byte(q1&0xF)<<4
+			qh |= uint32(q0>>4&1) << j
+			qh |= uint32(q1>>4&1) << (j + blockSize/2)
+		}
+		binary.LittleEndian.PutUint32(b[4:], qh)
+	}
+	return out
+}
+
+// dequantizeQ5_1Blocks expands Q5_1 blocks back to n float32 values.
+func dequantizeQ5_1Blocks(data []byte, n int) []float32 {
+	out := make([]float32, len(data)/24*blockSize)
+	for bi := range len(data) / 24 {
+		b := data[bi*24 : (bi+1)*24]
+		d := fp16Value(binary.LittleEndian.Uint16(b[0:]))
+		m := fp16Value(binary.LittleEndian.Uint16(b[2:]))
+		qh := binary.LittleEndian.Uint32(b[4:])
+		y := out[bi*blockSize:]
+		for j := range blockSize / 2 {
+			q0 := int(b[8+j]&0xF) | int(qh>>j&1)<<4
+			q1 := int(b[8+j]>>4) | int(qh>>(j+blockSize/2)&1)<<4
+			y[j] = float32(q0)*d + m
+			y[j+blockSize/2] = float32(q1)*d + m
+		}
+	}
+	return out[:n]
+}
+
+// encodeF16 stores values as little-endian IEEE half precision.
+func encodeF16(src []float32) []byte {
+	out := make([]byte, len(src)*2)
+	for i, v := range src {
+		binary.LittleEndian.PutUint16(out[i*2:], fp16Bits(v))
+	}
+	return out
+}
+
+// decodeF16 expands little-endian IEEE half-precision values.
+func decodeF16(data []byte, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = fp16Value(binary.LittleEndian.Uint16(data[i*2:]))
+	}
+	return out
+}
+
+// float32ToFloat16Bits converts a float32 to IEEE 754 half-precision bits,
+// rounding to nearest even. Magnitudes below the smallest normal half become
+// subnormals, those that round above the largest finite half become
+// infinity, and NaNs stay NaN.
 func float32ToFloat16Bits(f float32) uint16 {
 	b := math.Float32bits(f)
-	sign := (b >> 16) & 0x8000
-	exp := int((b>>23)&0xFF) - 127 + 15
-	frac := b & 0x7FFFFF
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23) & 0xFF
+	man := b & 0x7FFFFF
 
-	if exp <= 0 {
-		return uint16(sign) // flush to zero
+	if exp == 0xFF {
+		if man != 0 {
+			return sign | 0x7E00
+		}
+		return sign | 0x7C00
+	}
+	e := exp - 127 + 15
+	if e >= 31 {
+		return sign | 0x7C00
+	}
+
+	// Keep the top 10 mantissa bits of a normal half, or for a subnormal
+	// the bits worth at least 2^-24, and round on the bits shifted out. A
+	// carry out of the mantissa correctly bumps the exponent.
+	var q, shift uint32
+	if e > 0 {
+		q, shift = uint32(e)<<10|man>>13, 13
+		man &= 1<<13 - 1
+	} else {
+		if e < -10 {
+			return sign // below half the smallest subnormal
+		}
+		man |= 1 << 23 // float32 subnormals are far below this range
+		shift = uint32(14 - e)
+		q = man >> shift
+		man &= 1<<shift - 1
 	}
-	if exp >= 31 {
-		return uint16(sign | 0x7C00) // infinity
+	if half := uint32(1) << (shift - 1); man > half || man == half && q&1 == 1 {
+		q++
 	}
-	return uint16(sign | uint32(exp)<<10 | (frac >> 13))
+	return sign | uint16(q)
 }
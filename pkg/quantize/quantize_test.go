@@ -1,10 +1,16 @@
 package quantize
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"math"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/zerfoo/float16"
 	"github.com/zerfoo/zmf"
 )
 
@@ -248,3 +254,235 @@ func TestQuantizeModel_CompressionRatio(t *testing.T) {
 		t.Errorf("compression ratio = %.1fx, want >= 6x", ratio)
 	}
 }
+
+func TestLegacyBlockRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		encode     func([]float32) []byte
+		decode     func([]byte, int) []float32
+		blockBytes int
+		// maxStep bounds the per-value error as a fraction of the block's
+		// absolute maximum.
+		maxStep   float64
+		maxRelErr float64
+	}{
+		{"Q4_1", encodeQ4_1Blocks, dequantizeQ4_1Blocks, 20, 2.0 / 30, 0.12},
+		{"Q5_0", encodeQ5_0Blocks, dequantizeQ5_0Blocks, 22, 1.0 / 16, 0.08},
+		{"Q5_1", encodeQ5_1Blocks, dequantizeQ5_1Blocks, 24, 2.0 / 62, 0.06},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := 1024
+			vals := randomWeights(n, 4)
+
+			data := tt.encode(vals)
+			if len(data) != n/blockSize*tt.blockBytes {
+				t.Fatalf("encoded length = %d, want %d", len(data), n/blockSize*tt.blockBytes)
+			}
+			got := tt.decode(data, n)
+			if len(got) != n {
+				t.Fatalf("decoded length = %d, want %d", len(got), n)
+			}
+
+			for bi := range n / blockSize {
+				var amax float64
+				for j := range blockSize {
+					amax = math.Max(amax, math.Abs(float64(vals[bi*blockSize+j])))
+				}
+				// Allow for float16 rounding of the stored scale and min.
+				bound := amax * (tt.maxStep + 0.002)
+				for j := range blockSize {
+					i := bi*blockSize + j
+					if e := math.Abs(float64(got[i] - vals[i])); e > bound {
+						t.Fatalf("value %d: error %g exceeds bound %g", i, e, bound)
+					}
+				}
+			}
+			if rel := relativeRMSE(vals, got); rel > tt.maxRelErr {
+				t.Errorf("relative RMSE = %.4f, want <= %.4f", rel, tt.maxRelErr)
+			}
+		})
+	}
+}
+
+func TestQuantizeModel_LegacyTypes(t *testing.T) {
+	tests := []struct {
+		qt         QuantType
+		dtype      zmf.Tensor_DataType
+		blockBytes int
+	}{
+		{Q4_0, zmf.Tensor_Q4_0, 18},
+		{Q8_0, zmf.Tensor_Q8_0, 36},
+		{Q4_1, DtypeQ4_1, 20},
+		{Q5_0, DtypeQ5_0, 22},
+		{Q5_1, DtypeQ5_1, 24},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.qt), func(t *testing.T) {
+			model := &zmf.Model{
+				Graph: &zmf.Graph{
+					Parameters: map[string]*zmf.Tensor{
+						"model.layers.0.self_attn.k_proj.weight": {
+							Dtype: zmf.Tensor_FLOAT32,
+							Shape: []int64{32, 32},
+							Data:  makeFloat32Bytes(randomWeights(1024, 5)),
+						},
+						// Rows of 48 values are not whole blocks, although
+						// the tensor as a whole is.
+						"model.layers.0.self_attn.v_proj.weight": {
+							Dtype: zmf.Tensor_FLOAT32,
+							Shape: []int64{64, 48},
+							Data:  makeFloat32Bytes(randomWeights(64*48, 6)),
+						},
+					},
+				},
+			}
+			var report Report
+			if err := ModelWithOptions(model, Options{Type: tt.qt, Report: &report}); err != nil {
+				t.Fatalf("ModelWithOptions(%s): %v", tt.qt, err)
+			}
+			w := model.Graph.Parameters["model.layers.0.self_attn.k_proj.weight"]
+			if w.Dtype != tt.dtype {
+				t.Errorf("dtype = %v, want %v", w.Dtype, tt.dtype)
+			}
+			if len(w.Data) != 32*tt.blockBytes {
+				t.Errorf("data len = %d, want %d", len(w.Data), 32*tt.blockBytes)
+			}
+
+			ragged := model.Graph.Parameters["model.layers.0.self_attn.v_proj.weight"]
+			if ragged.Dtype != zmf.Tensor_FLOAT32 {
+				t.Errorf("ragged tensor dtype = %v, want FLOAT32", ragged.Dtype)
+			}
+			want := fmt.Sprintf("row length 48 is not a multiple of 32 for %s", tt.qt)
+			if len(report.Skipped) != 1 || report.Skipped[0].Reason != want {
+				t.Errorf("skipped = %+v, want one with reason %q", report.Skipped, want)
+			}
+		})
+	}
+}
+
+func TestQuantizeModel_FloatSources(t *testing.T) {
+	vals := randomWeights(1024, 16)
+	tests := []struct {
+		name  string
+		dtype zmf.Tensor_DataType
+		data  []byte
+		tol   float64
+	}{
+		{"FLOAT16", zmf.Tensor_FLOAT16, encodeF16(vals), 0.01},
+		{"BFLOAT16", zmf.Tensor_BFLOAT16, func() []byte {
+			b := make([]byte, len(vals)*2)
+			for i, v := range vals {
+				binary.LittleEndian.PutUint16(b[i*2:], float16.BFloat16FromFloat32(v).Bits())
+			}
+			return b
+		}(), 0.02},
+		{"FLOAT64", zmf.Tensor_FLOAT64, func() []byte {
+			b := make([]byte, len(vals)*8)
+			for i, v := range vals {
+				binary.LittleEndian.PutUint64(b[i*8:], math.Float64bits(float64(v)))
+			}
+			return b
+		}(), 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{
+				"model.layers.0.mlp.gate_proj.weight": {Dtype: tt.dtype, Shape: []int64{32, 32}, Data: tt.data},
+				"model.layers.0.post_attention_layernorm.weight": {
+					Dtype: tt.dtype,
+					Shape: []int64{32},
+					Data:  tt.data[:len(tt.data)/32],
+				},
+				"position_ids": {Dtype: zmf.Tensor_INT64, Shape: []int64{4}, Data: make([]byte, 32)},
+			}}}
+			var report Report
+			if err := ModelWithOptions(model, Options{Type: Q8_0, Report: &report}); err != nil {
+				t.Fatalf("ModelWithOptions: %v", err)
+			}
+
+			w := model.Graph.Parameters["model.layers.0.mlp.gate_proj.weight"]
+			if w.Dtype != zmf.Tensor_Q8_0 {
+				t.Fatalf("dtype = %v, want Q8_0", w.Dtype)
+			}
+			if rel := relativeRMSE(vals, dequantizeQ8Blocks(w.Data, len(vals))); rel > tt.tol {
+				t.Errorf("relative RMSE = %.4f, want <= %.4f", rel, tt.tol)
+			}
+			if norm := model.Graph.Parameters["model.layers.0.post_attention_layernorm.weight"]; norm.Dtype != tt.dtype {
+				t.Errorf("norm dtype = %v, want unchanged %v", norm.Dtype, tt.dtype)
+			}
+
+			reasons := map[string]string{}
+			for _, s := range report.Skipped {
+				reasons[s.Name] = s.Reason
+			}
+			if reasons["position_ids"] != "INT64 data is not a float type" {
+				t.Errorf("position_ids reason = %q", reasons["position_ids"])
+			}
+			if reasons["model.layers.0.post_attention_layernorm.weight"] != "normalization weight" {
+				t.Errorf("norm reason = %q", reasons["model.layers.0.post_attention_layernorm.weight"])
+			}
+		})
+	}
+}
+
+func TestModelWithOptions_JobsDeterministic(t *testing.T) {
+	const rowLen = superBlockSize
+	names := llamaNames(4)
+	newModel := func() *zmf.Model {
+		params := make(map[string]*zmf.Tensor, len(names))
+		for i, name := range names {
+			params[name] = &zmf.Tensor{
+				Dtype: zmf.Tensor_FLOAT32,
+				Shape: []int64{4, rowLen},
+				Data:  makeFloat32Bytes(randomWeights(4*rowLen, int64(40+i))),
+			}
+		}
+		return &zmf.Model{Graph: &zmf.Graph{Parameters: params}}
+	}
+	im := Imatrix{"blk.1.ffn_down.weight": skewedImportance(rowLen, 41)}
+	rules := []Rule{{Pattern: regexp.MustCompile(`attn_k`), Type: Q8_0}}
+
+	run := func(jobs int) (*zmf.Model, *Report) {
+		m := newModel()
+		report := &Report{}
+		opts := Options{Type: Q4_K_M, Rules: rules, Imatrix: im, Report: report, Jobs: jobs}
+		if err := ModelWithOptions(m, opts); err != nil {
+			t.Fatalf("jobs=%d: %v", jobs, err)
+		}
+		return m, report
+	}
+
+	serial, serialReport := run(1)
+	for _, jobs := range []int{2, 8, 0} {
+		parallel, parallelReport := run(jobs)
+		for name, want := range serial.Graph.Parameters {
+			got := parallel.Graph.Parameters[name]
+			if got.Dtype != want.Dtype || !bytes.Equal(got.Data, want.Data) {
+				t.Errorf("jobs=%d: %s differs from serial output", jobs, name)
+			}
+		}
+		if !reflect.DeepEqual(parallelReport, serialReport) {
+			t.Errorf("jobs=%d: report differs from serial report", jobs)
+		}
+	}
+}
+
+func TestModelWithOptions_JobsError(t *testing.T) {
+	model := &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{}}}
+	for i := range 6 {
+		model.Graph.Parameters[fmt.Sprintf("model.layers.%d.mlp.down_proj.weight", i)] = &zmf.Tensor{
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{4, superBlockSize},
+			Data:  makeFloat32Bytes(randomWeights(4*superBlockSize, int64(i))),
+		}
+	}
+	im := Imatrix{"blk.3.ffn_down.weight": make([]float32, 7)}
+	err := ModelWithOptions(model, Options{Type: Q4_K, Imatrix: im, Jobs: 4})
+	if err == nil || !strings.Contains(err.Error(), "model.layers.3.mlp.down_proj.weight") {
+		t.Errorf("error = %v, want one naming layer 3", err)
+	}
+}
@@ -0,0 +1,150 @@
+package quantize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// Report collects quantization error statistics for each quantized tensor
+// and the reason each remaining parameter was left unquantized. Pass
+// one in Options.Report to have ModelWithOptions fill it.
+type Report struct {
+	Tensors []TensorReport  `json:"tensors"`
+	Skipped []SkippedTensor `json:"skipped"`
+}
+
+// TensorReport describes the reconstruction error of one quantized tensor.
+type TensorReport struct {
+	Name     string    `json:"name"`
+	Type     QuantType `json:"type"`
+	Elements int       `json:"elements"`
+	// RMSE and MaxAbsError compare the dequantized values with the
+	// original values.
+	RMSE        float64 `json:"rmse"`
+	MaxAbsError float64 `json:"max_abs_error"`
+	// SQNR is the signal-to-quantization-noise ratio in decibels. It is
+	// +Inf for lossless tensors and is written to JSON as null.
+	SQNR float64 `json:"sqnr_db"`
+	// ClippedBlocks is the share of scale groups in which at least one
+	// value lies more than half a quantization step outside the range of
+	// the group's reconstructed values.
+	ClippedBlocks float64 `json:"clipped_block_share"`
+}
+
+// SkippedTensor names a parameter that was not quantized.
+type SkippedTensor struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// MarshalJSON encodes an infinite SQNR as null, since JSON has no infinity.
+func (t TensorReport) MarshalJSON() ([]byte, error) {
+	type plain TensorReport
+	out := struct {
+		plain
+		SQNR *float64 `json:"sqnr_db"`
+	}{plain: plain(t)}
+	if !math.IsInf(t.SQNR, 0) {
+		out.SQNR = &t.SQNR
+	}
+	return json.Marshal(out)
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the report as aligned text tables.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENSOR\tTYPE\tELEMENTS\tRMSE\tMAX ABS ERR\tSQNR (dB)\tCLIPPED BLOCKS")
+	for _, t := range r.Tensors {
+		sqnr := "inf"
+		if !math.IsInf(t.SQNR, 0) {
+			sqnr = fmt.Sprintf("%.2f", t.SQNR)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.6g\t%.6g\t%s\t%.2f%%\n",
+			t.Name, t.Type, t.Elements, t.RMSE, t.MaxAbsError, sqnr, 100*t.ClippedBlocks)
+	}
+	if len(r.Skipped) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "NOT QUANTIZED\tREASON")
+		for _, s := range r.Skipped {
+			fmt.Fprintf(tw, "%s\t%s\n", s.Name, s.Reason)
+		}
+	}
+	return tw.Flush()
+}
+
+// tensorReport computes the error statistics of one encoded tensor.
+func tensorReport(name string, qt QuantType, f format, want []float32, data []byte) TensorReport {
+	got := f.decode(data, len(want))
+	tr := TensorReport{Name: name, Type: qt, Elements: len(want)}
+
+	var noise, signal float64
+	for i, v := range want {
+		d := float64(got[i]) - float64(v)
+		noise += d * d
+		signal += float64(v) * float64(v)
+		tr.MaxAbsError = math.Max(tr.MaxAbsError, math.Abs(d))
+	}
+	if len(want) > 0 {
+		tr.RMSE = math.Sqrt(noise / float64(len(want)))
+	}
+	switch {
+	case noise == 0:
+		tr.SQNR = math.Inf(1)
+	case signal == 0:
+		tr.SQNR = math.Inf(-1)
+	default:
+		tr.SQNR = 10 * math.Log10(signal/noise)
+	}
+	tr.ClippedBlocks = clippedShare(want, got, f.bits, f.group)
+	return tr
+}
+
+// finish records the tensors left unquantized and sorts both lists by name.
+func (r *Report) finish(skipped map[string]string) {
+	for name, reason := range skipped {
+		r.Skipped = append(r.Skipped, SkippedTensor{Name: name, Reason: reason})
+	}
+	sort.Slice(r.Tensors, func(i, j int) bool { return r.Tensors[i].Name < r.Tensors[j].Name })
+	sort.Slice(r.Skipped, func(i, j int) bool { return r.Skipped[i].Name < r.Skipped[j].Name })
+}
+
+// clippedShare returns the share of groups of group values in which some
+// original value lies more than half a step outside the reconstructed range.
+// The step is estimated from that range and the number of levels 2^bits.
+func clippedShare(want, got []float32, bits, group int) float64 {
+	if bits == 0 || group == 0 || len(want) == 0 {
+		return 0
+	}
+	groups := (len(want) + group - 1) / group
+	clipped := 0
+	for g := range groups {
+		lo, hi := g*group, min((g+1)*group, len(want))
+		gmin, gmax := got[lo], got[lo]
+		for _, v := range got[lo:hi] {
+			gmin = min(gmin, v)
+			gmax = max(gmax, v)
+		}
+		if gmax == gmin {
+			continue
+		}
+		half := (gmax - gmin) / float32(int(1)<<bits-1) / 2
+		for _, v := range want[lo:hi] {
+			if v > gmax+half || v < gmin-half {
+				clipped++
+				break
+			}
+		}
+	}
+	return float64(clipped) / float64(groups)
+}
@@ -0,0 +1,140 @@
+package quantize
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+)
+
+func TestModelWithOptions_Report(t *testing.T) {
+	exact := make([]float32, 32*32)
+	for i := range exact {
+		exact[i] = float32(i%16) / 4
+	}
+	model := &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{
+		"model.layers.0.self_attn.q_proj.weight": {
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{32, 32},
+			Data:  makeFloat32Bytes(randomWeights(1024, 12)),
+		},
+		"model.layers.0.self_attn.v_proj.weight": {
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{32, 32},
+			Data:  makeFloat32Bytes(exact),
+		},
+		"model.layers.0.input_layernorm.weight": {
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{32},
+			Data:  makeFloat32Bytes(randomWeights(32, 13)),
+		},
+		"small": {
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{4, 8},
+			Data:  makeFloat32Bytes(randomWeights(32, 14)),
+		},
+	}}}
+
+	rule, err := ParseRule(`attn_v=f16`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := ModelWithOptions(model, Options{Type: Q4_0, Rules: []Rule{rule}, Report: &report}); err != nil {
+		t.Fatalf("ModelWithOptions: %v", err)
+	}
+
+	if len(report.Tensors) != 2 {
+		t.Fatalf("got %d tensor reports, want 2", len(report.Tensors))
+	}
+	q := report.Tensors[0]
+	if q.Name != "model.layers.0.self_attn.q_proj.weight" || q.Type != Q4_0 || q.Elements != 1024 {
+		t.Errorf("unexpected report %+v", q)
+	}
+	if q.RMSE <= 0 || q.MaxAbsError < q.RMSE || q.SQNR < 10 || math.IsInf(q.SQNR, 0) {
+		t.Errorf("implausible Q4_0 statistics %+v", q)
+	}
+	if v := report.Tensors[1]; v.Type != F16 || !math.IsInf(v.SQNR, 1) || v.RMSE != 0 {
+		t.Errorf("f16 of exactly representable values: %+v", v)
+	}
+
+	wantSkipped := map[string]string{
+		"model.layers.0.input_layernorm.weight": "normalization weight",
+		"small":                                 "32 elements, fewer than 1024",
+	}
+	if len(report.Skipped) != len(wantSkipped) {
+		t.Fatalf("skipped = %+v", report.Skipped)
+	}
+	for _, s := range report.Skipped {
+		if wantSkipped[s.Name] != s.Reason {
+			t.Errorf("%s reason = %q, want %q", s.Name, s.Reason, wantSkipped[s.Name])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded struct {
+		Tensors []map[string]any `json:"tensors"`
+		Skipped []SkippedTensor  `json:"skipped"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("report JSON does not parse: %v", err)
+	}
+	if decoded.Tensors[1]["sqnr_db"] != nil {
+		t.Errorf("lossless sqnr_db = %v, want null", decoded.Tensors[1]["sqnr_db"])
+	}
+
+	buf.Reset()
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	for _, want := range []string{"SQNR (dB)", "q_proj", "inf", "NOT QUANTIZED", "normalization weight"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestReport_RaggedKQuant(t *testing.T) {
+	model := &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{
+		"model.layers.0.mlp.up_proj.weight": {
+			Dtype: zmf.Tensor_FLOAT32,
+			Shape: []int64{64, 96},
+			Data:  makeFloat32Bytes(randomWeights(64*96, 15)),
+		},
+	}}}
+	var report Report
+	if err := ModelWithOptions(model, Options{Type: Q4_K, Report: &report}); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Skipped) != 1 || !strings.Contains(report.Skipped[0].Reason, "not a multiple of 256") {
+		t.Errorf("skipped = %+v", report.Skipped)
+	}
+}
+
+func TestClippedShare(t *testing.T) {
+	tests := []struct {
+		name string
+		want []float32
+		got  []float32
+		bits int
+		out  float64
+	}{
+		{"within range", []float32{-1, 0.5, 1.05}, []float32{-1, 0.5, 1}, 4, 0},
+		{"clipped high", []float32{-1, 0.5, 1.5}, []float32{-1, 0.5, 1}, 4, 1},
+		{"clipped low", []float32{-2, 0.5, 1}, []float32{-1, 0.5, 1}, 4, 1},
+		{"float format", []float32{-2, 0.5, 1}, []float32{-1, 0.5, 1}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clippedShare(tt.want, tt.got, tt.bits, 32); got != tt.out {
+				t.Errorf("clippedShare = %v, want %v", got, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package quantize
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/zerfoo/zonnx/pkg/gguf"
+)
+
+// Rule overrides the quantization type of every tensor whose name matches
+// Pattern. Patterns are tried against both the source tensor name and its
+// GGUF name. A matching rule takes precedence over the default type and the
+// built-in skip heuristics; Type F32 keeps the tensor unchanged.
+type Rule struct {
+	Pattern *regexp.Regexp
+	Type    QuantType
+}
+
+// ParseRule parses a rule of the form "regex=type", for example
+// "token_embd=q8_0" or `blk\.0\.attn_v=f16`.
+func ParseRule(s string) (Rule, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return Rule{}, fmt.Errorf("invalid quantization rule %q: want regex=type", s)
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid quantization rule %q: %w", s, err)
+	}
+	qt := QuantType(strings.ToLower(strings.TrimSpace(s[i+1:])))
+	if !validRuleType(qt) {
+		return Rule{}, fmt.Errorf("invalid quantization rule %q: unsupported type %q", s, qt)
+	}
+	return Rule{Pattern: re, Type: qt}, nil
+}
+
+// ParseRules reads one rule per line. Blank lines and lines starting with
+// '#' are ignored.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := ParseRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read quantization rules: %w", err)
+	}
+	return rules, nil
+}
+
+// validRuleType reports whether qt can be the target of a rule. Presets
+// choose types per tensor and so cannot be.
+func validRuleType(qt QuantType) bool {
+	_, ok := formats[qt]
+	return ok || qt == F32
+}
+
+// matchRule returns the type of the first rule matching the tensor name.
+func matchRule(rules []Rule, name string) (QuantType, bool) {
+	if len(rules) == 0 {
+		return "", false
+	}
+	ggufName := gguf.MapTensorName(name)
+	for _, r := range rules {
+		if r.Pattern.MatchString(name) || r.Pattern.MatchString(ggufName) {
+			return r.Type, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,204 @@
+package quantize
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/zerfoo/zmf"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		pattern string
+		qt      QuantType
+		wantErr bool
+	}{
+		{in: "token_embd=q8_0", pattern: "token_embd", qt: Q8_0},
+		{in: `blk\.0\.attn_v=F16`, pattern: `blk\.0\.attn_v`, qt: F16},
+		{in: "a=b=q4_k", pattern: "a=b", qt: Q4_K},
+		{in: "norm=f32", pattern: "norm", qt: F32},
+		{in: "no-type", wantErr: true},
+		{in: "=q4_0", wantErr: true},
+		{in: "([=q4_0", wantErr: true},
+		{in: "attn=q9_9", wantErr: true},
+		{in: "attn=q4_k_m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := ParseRule(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule: %v", err)
+			}
+			if r.Pattern.String() != tt.pattern || r.Type != tt.qt {
+				t.Errorf("got %q=%q, want %q=%q", r.Pattern, r.Type, tt.pattern, tt.qt)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	src := `
+# keep embeddings at 8 bits
+token_embd=q8_0
+
+attn_v=q6_k
+`
+	rules, err := ParseRules(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+	if rules[1].Type != Q6_K {
+		t.Errorf("rule 1 type = %q, want %q", rules[1].Type, Q6_K)
+	}
+
+	if _, err := ParseRules(strings.NewReader("ok=q4_0\nbad")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error = %v, want line 2 error", err)
+	}
+}
+
+func TestModelWithOptions_Rules(t *testing.T) {
+	newModel := func() *zmf.Model {
+		tensor := func(shape ...int64) *zmf.Tensor {
+			n := int64(1)
+			for _, d := range shape {
+				n *= d
+			}
+			return &zmf.Tensor{
+				Dtype: zmf.Tensor_FLOAT32,
+				Shape: shape,
+				Data:  makeFloat32Bytes(randomWeights(int(n), n)),
+			}
+		}
+		return &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{
+			"model.embed_tokens.weight":              tensor(64, 32),
+			"model.layers.0.self_attn.q_proj.weight": tensor(32, 32),
+			"model.layers.0.self_attn.v_proj.weight": tensor(32, 32),
+			"model.layers.0.mlp.up_proj.weight":      tensor(32, 32),
+			"model.layers.0.input_layernorm.weight":  tensor(32),
+		}}}
+	}
+	mustRule := func(s string) Rule {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name  string
+		opts  Options
+		wants map[string]zmf.Tensor_DataType
+	}{
+		{
+			name: "rules override default and skip heuristics",
+			opts: Options{Type: Q4_0, Rules: []Rule{
+				mustRule("token_embd=q8_0"),
+				mustRule(`attn_v\.weight$=f16`),
+				mustRule("up_proj=f32"),
+				mustRule("attn_norm=q4_0"),
+			}},
+			wants: map[string]zmf.Tensor_DataType{
+				"model.embed_tokens.weight":              zmf.Tensor_Q8_0,
+				"model.layers.0.self_attn.q_proj.weight": zmf.Tensor_Q4_0,
+				"model.layers.0.self_attn.v_proj.weight": zmf.Tensor_FLOAT16,
+				"model.layers.0.mlp.up_proj.weight":      zmf.Tensor_FLOAT32,
+				"model.layers.0.input_layernorm.weight":  zmf.Tensor_Q4_0,
+			},
+		},
+		{
+			name: "first matching rule wins",
+			opts: Options{Type: Q8_0, Rules: []Rule{
+				mustRule("q_proj=f32"),
+				mustRule("blk=q4_0"),
+			}},
+			wants: map[string]zmf.Tensor_DataType{
+				"model.layers.0.self_attn.q_proj.weight": zmf.Tensor_FLOAT32,
+				"model.layers.0.self_attn.v_proj.weight": zmf.Tensor_Q4_0,
+				"model.embed_tokens.weight":              zmf.Tensor_FLOAT32,
+			},
+		},
+		{
+			name: "rules only",
+			opts: Options{Rules: []Rule{mustRule("v_proj=q8_0")}},
+			wants: map[string]zmf.Tensor_DataType{
+				"model.layers.0.self_attn.v_proj.weight": zmf.Tensor_Q8_0,
+				"model.layers.0.self_attn.q_proj.weight": zmf.Tensor_FLOAT32,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newModel()
+			if err := ModelWithOptions(m, tt.opts); err != nil {
+				t.Fatalf("ModelWithOptions: %v", err)
+			}
+			for name, want := range tt.wants {
+				if got := m.Graph.Parameters[name].Dtype; got != want {
+					t.Errorf("%s dtype = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestModelWithOptions_RuleSkipsRaggedRows(t *testing.T) {
+	m := &zmf.Model{Graph: &zmf.Graph{Parameters: map[string]*zmf.Tensor{
+		"scale":  {Dtype: zmf.Tensor_FLOAT32, Data: makeFloat32Bytes([]float32{2})},
+		"odd":    {Dtype: zmf.Tensor_FLOAT32, Shape: []int64{10, 3}, Data: makeFloat32Bytes(randomWeights(30, 30))},
+		"ragged": {Dtype: zmf.Tensor_FLOAT32, Shape: []int64{64, 48}, Data: makeFloat32Bytes(randomWeights(64*48, 31))},
+		"weight": {Dtype: zmf.Tensor_FLOAT32, Shape: []int64{32, 32}, Data: makeFloat32Bytes(randomWeights(1024, 1024))},
+	}}}
+	r, err := ParseRule(".*=q8_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &Report{}
+	if err := ModelWithOptions(m, Options{Rules: []Rule{r}, Report: report}); err != nil {
+		t.Fatalf("ModelWithOptions: %v", err)
+	}
+	if got := m.Graph.Parameters["weight"].Dtype; got != zmf.Tensor_Q8_0 {
+		t.Errorf("weight dtype = %v, want Q8_0", got)
+	}
+	reasons := map[string]string{}
+	for _, s := range report.Skipped {
+		reasons[s.Name] = s.Reason
+	}
+	for name, want := range map[string]string{
+		"scale":  "scalar",
+		"odd":    "row length 3 is not a multiple of 32",
+		"ragged": "row length 48 is not a multiple of 32",
+	} {
+		if got := m.Graph.Parameters[name].Dtype; got != zmf.Tensor_FLOAT32 {
+			t.Errorf("%s dtype = %v, want FLOAT32", name, got)
+		}
+		if !strings.Contains(reasons[name], want) {
+			t.Errorf("%s skip reason = %q, want %q", name, reasons[name], want)
+		}
+	}
+}
+
+func TestEncodeF16(t *testing.T) {
+	want := []float32{0, 1, -2, 0.5}
+	data := encodeF16(want)
+	if len(data) != 2*len(want) {
+		t.Fatalf("encoded length = %d, want %d", len(data), 2*len(want))
+	}
+	for i := range want {
+		if got := fp16Value(binary.LittleEndian.Uint16(data[i*2:])); got != want[i] {
+			t.Errorf("value %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"math"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/zerfoo/float16"
+	"github.com/zerfoo/zonnx/pkg/quantize"
 )
 
 // TensorInfo describes a single tensor stored in a SafeTensors file.
@@ -36,6 +38,10 @@ type File struct {
 	tensors    map[string]TensorInfo
 	names      []string
 	dataOffset int64 // byte offset where tensor data begins (8 + headerLen)
+
+	// FP8 scale names and companions, from FP8Companions.
+	scales     map[string]string
+	companions map[string]bool
 }
 
 // Open opens a SafeTensors file and parses its header.
@@ -101,11 +107,19 @@ func parse(f *os.File) (*File, error) {
 	}
 	sort.Strings(names)
 
+	dtypes := make(map[string]string, len(tensors))
+	for name, info := range tensors {
+		dtypes[name] = info.Dtype
+	}
+	scales, companions := FP8Companions(dtypes)
+
 	return &File{
 		f:          f,
 		tensors:    tensors,
 		names:      names,
 		dataOffset: int64(8 + headerLen),
+		scales:     scales,
+		companions: companions,
 	}, nil
 }
 
@@ -143,7 +157,8 @@ func (sf *File) ReadTensor(name string) ([]byte, error) {
 }
 
 // ReadFloat32 reads a tensor and returns its data as a []float32 slice.
-// Supported dtypes: F32, F16, BF16.
+// Supported dtypes: F32, F16, BF16, F8_E4M3, F8_E5M2. FP8 values are
+// returned unscaled; see ReadScaledFloat32.
 func (sf *File) ReadFloat32(name string) ([]float32, error) {
 	info, ok := sf.tensors[name]
 	if !ok {
@@ -162,11 +177,94 @@ func (sf *File) ReadFloat32(name string) ([]float32, error) {
 		return decodeF16(raw)
 	case "BF16":
 		return decodeBF16(raw)
+	case "F8_E4M3":
+		return quantize.DecodeFP8(quantize.FP8E4M3FN, raw)
+	case "F8_E5M2":
+		return quantize.DecodeFP8(quantize.FP8E5M2, raw)
 	default:
 		return nil, fmt.Errorf("safetensors: unsupported dtype %q for ReadFloat32", info.Dtype)
 	}
 }
 
+// fp8ScaleSuffixes name the scale FP8 checkpoints store beside a weight:
+// <weight>_scale_inv in DeepSeek-style releases, <weight>_scale in
+// compressed-tensors checkpoints. Both multiply the FP8 values.
+var fp8ScaleSuffixes = []string{"_scale_inv", "_scale"}
+
+// FP8Companions returns, for each FP8 tensor of a checkpoint whose dtypes
+// are given by tensor name, the name of its scale tensor, and the set of
+// tensors that only serve FP8 weights: the scales and the static activation
+// scales (<module>.input_scale) of modules with an FP8 weight. Converters
+// fold the scales into the weights and do not write the companions.
+func FP8Companions(dtypes map[string]string) (scales map[string]string, companions map[string]bool) {
+	scales = make(map[string]string)
+	companions = make(map[string]bool)
+	for name, dtype := range dtypes {
+		if dtype != "F8_E4M3" && dtype != "F8_E5M2" {
+			continue
+		}
+		for _, suffix := range fp8ScaleSuffixes {
+			if _, ok := dtypes[name+suffix]; ok {
+				scales[name] = name + suffix
+				companions[name+suffix] = true
+				break
+			}
+		}
+		if prefix, ok := strings.CutSuffix(name, ".weight"); ok {
+			if _, ok := dtypes[prefix+".input_scale"]; ok {
+				companions[prefix+".input_scale"] = true
+			}
+		}
+	}
+	return scales, companions
+}
+
+// ScaleName returns the name of the scale tensor stored beside the FP8
+// tensor name, if it has one.
+func (sf *File) ScaleName(name string) (string, bool) {
+	scale, ok := sf.scales[name]
+	return scale, ok
+}
+
+// IsScale reports whether name is the scale tensor of an FP8 tensor.
+func (sf *File) IsScale(name string) bool {
+	for _, suffix := range fp8ScaleSuffixes {
+		if base, ok := strings.CutSuffix(name, suffix); ok && sf.scales[base] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFP8Companion reports whether name only serves FP8 weights: a scale or
+// the input_scale of a module with an FP8 weight. See FP8Companions.
+func (sf *File) IsFP8Companion(name string) bool {
+	return sf.companions[name]
+}
+
+// ReadScaledFloat32 is like ReadFloat32 but multiplies FP8 tensors by their
+// scale tensor, which holds one scale, one per row, or one per block of
+// block[0] x block[1] values. When block is nil, the block size is inferred
+// from the scale shape.
+func (sf *File) ReadScaledFloat32(name string, block []int) ([]float32, error) {
+	vals, err := sf.ReadFloat32(name)
+	if err != nil {
+		return nil, err
+	}
+	scaleName, ok := sf.ScaleName(name)
+	if !ok {
+		return vals, nil
+	}
+	scales, err := sf.ReadFloat32(scaleName)
+	if err != nil {
+		return nil, err
+	}
+	if err := quantize.ApplyScales(vals, sf.tensors[name].Shape, scales, sf.tensors[scaleName].Shape, block); err != nil {
+		return nil, fmt.Errorf("safetensors: tensor %q: %w", name, err)
+	}
+	return vals, nil
+}
+
 // Close closes the underlying file.
 func (sf *File) Close() error {
 	return sf.f.Close()
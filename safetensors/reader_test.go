@@ -271,6 +271,98 @@ func TestReadTensorRaw(t *testing.T) {
 	}
 }
 
+func TestReadFP8(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.safetensors")
+
+	// E4M3: 0x38 = 1, 0x40 = 2, 0xC8 = -4. E5M2: 0x3C = 1, 0xC0 = -2.
+	writeSafeTensors(t, path, []struct {
+		Name  string
+		Dtype string
+		Shape []int
+		Data  []byte
+	}{
+		{Name: "w", Dtype: "F8_E4M3", Shape: []int{2, 2}, Data: []byte{0x38, 0x40, 0xC8, 0x38}},
+		{Name: "w_scale_inv", Dtype: "F32", Shape: []int{2, 1}, Data: float32Bytes(0.5, 3)},
+		{Name: "e5", Dtype: "F8_E5M2", Shape: []int{2}, Data: []byte{0x3C, 0xC0}},
+	})
+
+	sf, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sf.Close()
+
+	raw, err := sf.ReadFloat32("w")
+	if err != nil {
+		t.Fatalf("ReadFloat32: %v", err)
+	}
+	scaled, err := sf.ReadScaledFloat32("w", nil)
+	if err != nil {
+		t.Fatalf("ReadScaledFloat32: %v", err)
+	}
+	e5, err := sf.ReadScaledFloat32("e5", nil)
+	if err != nil {
+		t.Fatalf("ReadScaledFloat32: %v", err)
+	}
+	wantRaw := []float32{1, 2, -4, 1}
+	wantScaled := []float32{0.5, 1, -12, 3}
+	for i := range wantRaw {
+		if raw[i] != wantRaw[i] || scaled[i] != wantScaled[i] {
+			t.Errorf("[%d] = %v scaled %v, want %v scaled %v", i, raw[i], scaled[i], wantRaw[i], wantScaled[i])
+		}
+	}
+	if e5[0] != 1 || e5[1] != -2 {
+		t.Errorf("e5 = %v, want [1 -2]", e5)
+	}
+
+	if !sf.IsScale("w_scale_inv") || sf.IsScale("w") || sf.IsScale("e5") {
+		t.Error("IsScale should only report w_scale_inv")
+	}
+	if name, ok := sf.ScaleName("w"); !ok || name != "w_scale_inv" {
+		t.Errorf("ScaleName(w) = %q, %v", name, ok)
+	}
+}
+
+func TestFP8Companions(t *testing.T) {
+	scales, companions := FP8Companions(map[string]string{
+		"a.weight":           "F8_E4M3",
+		"a.weight_scale_inv": "F32",
+		"a.input_scale":      "F32",
+		"b.weight":           "F8_E5M2",
+		"b.weight_scale":     "F32",
+		"c.weight":           "BF16",
+		"c.weight_scale":     "F32",
+		"c.input_scale":      "F32",
+		"d.weight":           "F8_E4M3",
+		"d.weight_scale_inv": "F32",
+		"d.weight_scale":     "F32",
+		"e.weight_scale_inv": "F32",
+	})
+	wantScales := map[string]string{
+		"a.weight": "a.weight_scale_inv",
+		"b.weight": "b.weight_scale",
+		"d.weight": "d.weight_scale_inv",
+	}
+	if len(scales) != len(wantScales) {
+		t.Errorf("scales = %v, want %v", scales, wantScales)
+	}
+	for name, want := range wantScales {
+		if scales[name] != want {
+			t.Errorf("scale of %s = %q, want %q", name, scales[name], want)
+		}
+	}
+	wantCompanions := []string{"a.weight_scale_inv", "a.input_scale", "b.weight_scale", "d.weight_scale_inv"}
+	if len(companions) != len(wantCompanions) {
+		t.Errorf("companions = %v, want %v", companions, wantCompanions)
+	}
+	for _, name := range wantCompanions {
+		if !companions[name] {
+			t.Errorf("%s is not a companion", name)
+		}
+	}
+}
+
 func TestErrorEmptyFile(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "empty.safetensors")